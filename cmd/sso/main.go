@@ -1,13 +1,21 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"os/signal"
 	"sso/internal/app"
 	"sso/internal/config"
+	"sso/internal/lib/secret"
+	"strings"
 	"syscall"
+	"time"
 )
 
 const (
@@ -16,51 +24,278 @@ const (
 	envProd  = "prod"
 )
 
+// Values of config.LogConfig.
+const (
+	logOutputStdout = "stdout"
+	logOutputStderr = "stderr"
+
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// Commands run instead of serving, e.g. sso migrate --config=./config/local.yaml.
+// Without a command the server is started, --check only sets it up and exits.
+const (
+	// commandMigrate applies migrations of the storage.
+	commandMigrate = "migrate"
+	// commandCreateAdmin registers the first admin, e.g.
+	// sso create-admin --config=./config/local.yaml --email=admin@example.com.
+	commandCreateAdmin = "create-admin"
+)
+
 func main() {
+	var command string
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		command = os.Args[1]
+		// Flags follow the command, they are parsed by config.Load.
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
+	var (
+		admin adminFlags
+		check bool
+	)
+	switch command {
+	case "":
+		flag.BoolVar(&check, "check", false,
+			"set up the app from config, connect to storage and exit with 0 if it is ready to serve or 1 otherwise")
+	case commandMigrate:
+	case commandCreateAdmin:
+		admin.register(flag.CommandLine)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q, expected %s or %s\n", command, commandMigrate, commandCreateAdmin)
+		os.Exit(2)
+	}
+
 	ctx := context.Background()
 
 	ctx, cancel := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGINT)
 	defer cancel()
 
-	cfg := config.MustLoad()
+	// Flags, --check among them, are parsed by config.Load.
+	cfg, err := config.Load()
+	if err != nil {
+		if !check {
+			panic(err.Error())
+		}
 
-	log := setupLogger(cfg.Env)
+		// A broken config fails the check like anything else instead of panicking.
+		fmt.Fprintf(os.Stderr, "check failed: %s\n", err)
+		os.Exit(1)
+	}
 
-	log.Info("starting app", slog.Any("cfg", cfg))
+	log, err := setupLogger(cfg.Env, cfg.Log)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to set up logger: %s\n", err)
+		os.Exit(1)
+	}
 
-	application := app.New(log, cfg.GRPC.Port, cfg.StoragePath, cfg.TokenTTl)
+	switch command {
+	case commandMigrate:
+		if err := app.Migrate(ctx, log, cfg); err != nil {
+			log.Error("failed to migrate storage", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		return
+	case commandCreateAdmin:
+		if err := createAdmin(ctx, log, cfg, admin); err != nil {
+			log.Error("failed to create admin", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if check {
+		if err := app.Check(ctx, log, cfg); err != nil {
+			log.Error("check failed", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		log.Info("check passed", cfg.Summary()...)
+
+		return
+	}
+
+	log.Info("starting app", cfg.Summary()...)
+	log.Debug("config", slog.Any("cfg", cfg))
+
+	application, err := app.New(log, cfg)
+	if err != nil {
+		log.Error("failed to init application", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
 
 	go func() {
 		application.GROCSrv.MustRun()
 	}()
 
+	if application.HTTPSrv != nil {
+		go func() {
+			application.HTTPSrv.MustRun()
+		}()
+	}
+
+	if application.GatewaySrv != nil {
+		go func() {
+			application.GatewaySrv.MustRun()
+		}()
+	}
+
+	// SIGHUP reloads the signing key, so it can be rotated without restart.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			if err := application.ReloadKeys(); err != nil {
+				log.Error("failed to reload keys", slog.String("error", err.Error()))
+				continue
+			}
+
+			log.Info("keys reloaded")
+		}
+	}()
+
 	<-ctx.Done()
 
 	log.Info("stopping application", slog.String("signal", ctx.Err().Error()))
 
+	// The gateway calls the gRPC server, so it is stopped first.
+	if application.GatewaySrv != nil {
+		application.GatewaySrv.Stop()
+	}
+
 	application.GROCSrv.Stop()
 
+	if application.HTTPSrv != nil {
+		application.HTTPSrv.Stop()
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+
+	if err := application.ShutdownEvents(shutdownCtx); err != nil {
+		log.Error("failed to deliver events", slog.String("error", err.Error()))
+	}
+
+	if err := application.ShutdownTracing(shutdownCtx); err != nil {
+		log.Error("failed to flush traces", slog.String("error", err.Error()))
+	}
+
 	log.Info("application Stopped")
 
 }
 
-func setupLogger(env string) *slog.Logger {
-	var log *slog.Logger
+// adminFlags are flags of the create-admin command.
+type adminFlags struct {
+	email    string
+	password string
+	force    bool
+}
+
+func (f *adminFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.email, "email", "", "email of the admin")
+	fs.StringVar(&f.password, "password", "", "password of the admin, read from stdin if empty")
+	fs.BoolVar(&f.force, "force", false, "create the admin even if there already is one")
+}
+
+// createAdmin runs the create-admin command.
+func createAdmin(ctx context.Context, log *slog.Logger, cfg *config.Config, f adminFlags) error {
+	if f.email == "" {
+		return errors.New("--email is required")
+	}
+
+	// A password given as a flag ends up in shell history and the process list.
+	if f.password == "" {
+		fmt.Fprint(os.Stderr, "password: ")
+
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("read password: %w", err)
+		}
+
+		f.password = strings.TrimRight(line, "\r\n")
+	}
+
+	if _, err := app.CreateAdmin(ctx, log, cfg, f.email, secret.Password(f.password), f.force); err != nil {
+		if errors.Is(err, app.ErrAdminExists) {
+			return fmt.Errorf("%w, use --force to create another one", err)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// setupLogger creates the logger of the service. Its level and,
+// unless set in cfg, its format depend on env.
+//
+// The logger is never nil, even for an unknown env or format,
+// as app.New and everything below it log unconditionally.
+func setupLogger(env string, cfg config.LogConfig) (*slog.Logger, error) {
+	out, err := logOutput(cfg.Output)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		level  slog.Level
+		format string
+	)
 
 	switch env {
 	case envLocal:
-		log = slog.New(
-			slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}),
-		)
+		level, format = slog.LevelDebug, logFormatText
 	case envDev:
-		log = slog.New(
-			slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}),
-		)
+		level, format = slog.LevelDebug, logFormatJSON
 	case envProd:
-		log = slog.New(
-			slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}),
-		)
+		level, format = slog.LevelInfo, logFormatText
+	default:
+		// Config validation rejects unknown envs, this is only a safety net
+		// so a new env doesn't crash the service with a nil logger.
+		level, format = slog.LevelInfo, logFormatJSON
+	}
+
+	if cfg.Format != "" {
+		format = cfg.Format
+	}
+
+	log := slog.New(newLogHandler(out, format, &slog.HandlerOptions{Level: level}))
+
+	if env != envLocal && env != envDev && env != envProd {
+		log.Warn("unknown env, logging at info level", slog.String("env", env))
+	}
+
+	return log, nil
+}
+
+// newLogHandler returns a handler writing in given format,
+// anything but text is written as JSON.
+func newLogHandler(out io.Writer, format string, opts *slog.HandlerOptions) slog.Handler {
+	if format == logFormatText {
+		return slog.NewTextHandler(out, opts)
+	}
+
+	return slog.NewJSONHandler(out, opts)
+}
+
+// logOutput opens the destination of logs, output is stdout, stderr or a file path.
+// The file is appended to and stays open until the process exits.
+func logOutput(output string) (io.Writer, error) {
+	switch output {
+	case "", logOutputStdout:
+		return os.Stdout, nil
+	case logOutputStderr:
+		return os.Stderr, nil
+	}
+
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open log file: %w", err)
 	}
 
-	return log
+	return f, nil
 }
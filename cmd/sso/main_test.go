@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sso/internal/config"
+	"strings"
+	"testing"
+)
+
+func TestSetupLoggerUnknownEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sso.log")
+
+	log, err := setupLogger("staging", config.LogConfig{Output: path})
+	if err != nil {
+		t.Fatalf("setup logger: %v", err)
+	}
+	if log == nil {
+		t.Fatal("got nil logger")
+	}
+
+	log.Debug("debug message")
+	log.Info("info message")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read log: %v", err)
+	}
+
+	// The fallback logs JSON at info level, after a warning about the env.
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want the warning and the info message:\n%s", len(lines), data)
+	}
+	for _, line := range lines {
+		if !json.Valid([]byte(line)) {
+			t.Fatalf("line is not JSON: %s", line)
+		}
+	}
+	if !strings.Contains(lines[0], "unknown env") {
+		t.Fatalf("first line isn't a warning about the env: %s", lines[0])
+	}
+}
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/config"
+	"sso/internal/lib/secret"
+)
+
+// ErrAdminExists is returned by CreateAdmin if there already is an admin.
+var ErrAdminExists = errors.New("admin already exists")
+
+// CreateAdmin registers a user with given credentials and makes them an admin,
+// so a fresh deployment can be managed without access to the database.
+//
+// Unless force is set, it refuses with ErrAdminExists if there already is an admin.
+// The password must satisfy the password policy. The email of the admin is
+// considered verified, as it is given by the operator.
+func CreateAdmin(
+	ctx context.Context,
+	log *slog.Logger,
+	cfg *config.Config,
+	email string,
+	password secret.Password,
+	force bool,
+) (int64, error) {
+	const op = "app.CreateAdmin"
+
+	storage, err := newStorage(log, cfg)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = storage.Close() }()
+
+	if cfg.MigrateOnStart {
+		if err := migrate(ctx, log, storage); err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if !force {
+		exists, err := storage.HasAdmin(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if exists {
+			return 0, fmt.Errorf("%s: %w", op, ErrAdminExists)
+		}
+	}
+
+	hasher, err := newHasher(log, cfg.Password)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	adminCfg := *cfg
+	adminCfg.Verification.Required = false
+
+	// No events are published, the command would exit before delivering them.
+	userID, _, err := newAuthService(log, &adminCfg, storage, storage, hasher, nil, nil, nil).RegisterNewUser(ctx, email, password, false)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := storage.SetAdmin(ctx, int64(userID), true); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("admin created", slog.Int64("user_id", int64(userID)))
+
+	return int64(userID), nil
+}
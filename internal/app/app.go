@@ -1,35 +1,838 @@
 package app
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"log/slog"
+	"net/http"
+	"os"
 	grpcapp "sso/internal/app/grpc"
+	httpapp "sso/internal/app/http"
+	"sso/internal/config"
+	authgrpc "sso/internal/grps/auth"
+	"sso/internal/http/cors"
+	"sso/internal/http/gateway"
+	"sso/internal/http/jwks"
+	httpoauth "sso/internal/http/oauth"
+	"sso/internal/lib/backoff"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/mail"
+	"sso/internal/lib/maintenance"
+	"sso/internal/lib/metrics"
+	"sso/internal/lib/oauth"
+	"sso/internal/lib/passkey"
+	"sso/internal/lib/password"
+	"sso/internal/lib/ratelimit"
+	"sso/internal/lib/tracing"
 	"sso/internal/services/auth"
+	"sso/internal/storage"
+	"sso/internal/storage/appcache"
+	"sso/internal/storage/dualwrite"
+	"sso/internal/storage/postgres"
+	"sso/internal/storage/rolecache"
 	"sso/internal/storage/sqlite"
 	"time"
 )
 
+const (
+	envLocal = "local"
+	envDev   = "dev"
+	envProd  = "prod"
+)
+
 type App struct {
 	GROCSrv *grpcapp.App
+	// HTTPSrv is nil if HTTP server is disabled in config.
+	HTTPSrv *httpapp.App
+	// GatewaySrv serves a part of the API as HTTP/JSON, it is nil if the gateway is disabled in config.
+	GatewaySrv *httpapp.App
+
+	storage Storage
+
+	keys *jwt.KeySet
+	// keySource provides the RS256 signing key on reload.
+	keySource jwt.SecretProvider
+
+	shutdownTracing func(context.Context) error
+	// events is empty if publishing of events is disabled in config.
+	events publishers
+}
+
+// Apps reads and saves apps, it is the storage or a cache in front of it.
+type Apps interface {
+	auth.AppProvider
+	auth.AppSaver
+}
+
+// Storage is implemented by every storage backend.
+type Storage interface {
+	auth.UserSaver
+	auth.UserProvider
+	auth.AppProvider
+	auth.AppSaver
+	auth.TokenRevoker
+	auth.RefreshTokenStore
+	auth.SessionStore
+	auth.OneTimeTokenStore
+	auth.IdentityStore
+	auth.OAuthStateStore
+	auth.PasskeyStore
+	auth.PasskeyChallengeStore
+	auth.RoleProvider
+	auth.Transactor
+	grpcapp.Pinger
+	// Migrate applies migrations that haven't been applied yet and returns their versions.
+	Migrate(ctx context.Context) ([]int, error)
+	SetAdmin(ctx context.Context, userID int64, isAdmin bool) error
+	// HasAdmin reports whether there is at least one admin.
+	HasAdmin(ctx context.Context) (bool, error)
+	Close() error
+}
+
+// cachedRoles is the storage with admins and roles of users read through a cache.
+type cachedRoles struct {
+	Storage
+	cache *rolecache.Cache
+}
+
+func (s cachedRoles) IsAdmin(ctx context.Context, userID int64) (bool, error) {
+	return s.cache.IsAdmin(ctx, userID)
+}
+
+func (s cachedRoles) UserRoles(ctx context.Context, userID int64, appID int) ([]string, error) {
+	return s.cache.UserRoles(ctx, userID, appID)
+}
+
+func (s cachedRoles) AssignRole(ctx context.Context, userID int64, appID int, role string) error {
+	return s.cache.AssignRole(ctx, userID, appID, role)
+}
+
+func (s cachedRoles) DeleteUser(ctx context.Context, userID int64) error {
+	return s.cache.DeleteUser(ctx, userID)
+}
+
+func (s cachedRoles) SoftDeleteUser(ctx context.Context, userID int64, deletedAt time.Time) error {
+	return s.cache.SoftDeleteUser(ctx, userID, deletedAt)
 }
 
 func New(
 	log *slog.Logger,
-	grpcPort int,
-	storagePath string,
-	tokenTTL time.Duration,
-) *App {
-	// TO DO: инициализировать хранилище (storage)
-	storage, err := sqlite.New(storagePath)
+	cfg *config.Config,
+) (*App, error) {
+	const op = "app.New"
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Endpoint, cfg.Tracing.Insecure)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	hasher, err := newHasher(log, cfg.Password)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if cfg.Env == envProd && cfg.Password.Algorithm == password.AlgBcrypt &&
+		!cfg.Password.BcryptCalibrate && cfg.Password.BcryptCost < bcrypt.DefaultCost {
+		log.Warn("bcrypt cost is below recommended minimum",
+			slog.Int("cost", cfg.Password.BcryptCost),
+			slog.Int("recommended", bcrypt.DefaultCost),
+		)
+	}
+
+	storage, err := newStorage(log, cfg)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := ping(storage); err != nil {
+		_ = storage.Close()
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if cfg.MigrateOnStart {
+		if err := migrate(context.Background(), log, storage); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	keys, keySource, err := newKeySet(cfg.JWT)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m := metrics.New()
+
+	var apps Apps = storage
+	if cfg.AppCache.TTL > 0 {
+		apps = appcache.New(storage, cfg.AppCache.TTL, cfg.AppCache.Size, m)
+	}
+
+	var users Storage = storage
+	if cfg.RoleCache.TTL > 0 {
+		users = cachedRoles{
+			Storage: storage,
+			cache:   rolecache.New(storage, cfg.RoleCache.TTL, cfg.RoleCache.Size, m),
+		}
+	}
+
+	events, err := newPublishers(log, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var publisher auth.EventPublisher
+	if len(events) > 0 {
+		publisher = events
+	}
+
+	rp, err := relyingParty(cfg.Passkeys)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	authService := newAuthService(log, cfg, users, apps, hasher, keys, rp, publisher)
+
+	var loginLimiter authgrpc.LoginLimiter
+	if cfg.RateLimit.Attempts > 0 {
+		loginLimiter = ratelimit.New(cfg.RateLimit.Attempts, cfg.RateLimit.Window)
+	}
+
+	listeners, err := grpcListeners(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	mode := maintenance.New(cfg.Maintenance.Enabled)
+	mode.Watch(func(enabled bool) {
+		log.Warn("maintenance mode changed", slog.Bool("enabled", enabled))
+	})
+
+	grpcApp := grpcapp.New(
+		log,
+		authService,
+		loginLimiter,
+		m,
+		storage,
+		grpcapp.Config{
+			Listeners:         listeners,
+			HealthInterval:    cfg.GRPC.HealthCheckInterval,
+			ShutdownTimeout:   cfg.GRPC.ShutdownTimeout,
+			Timeout:           cfg.GRPC.Timeout,
+			MethodTimeouts:    cfg.GRPC.MethodTimeouts,
+			MaxRecvMsgSize:    cfg.GRPC.MaxRecvMsgSize,
+			MaxEmailLength:    cfg.GRPC.MaxEmailLength,
+			MaxPasswordLength: cfg.GRPC.MaxPasswordLength,
+			Gateway:           cfg.Gateway.Port != 0,
+
+			Maintenance:           mode,
+			MaintenanceRetryAfter: cfg.Maintenance.RetryAfter,
+		},
+	)
+
+	var gatewayApp *httpapp.App
+	if cfg.Gateway.Port != 0 {
+		conn, err := grpcApp.LocalConn()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		handler, err := gateway.New(conn, gateway.Config{
+			TokenCookie: tokenCookie(cfg.Gateway.TokenCookie),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if len(cfg.Gateway.CORS.AllowedOrigins) > 0 {
+			handler = cors.Handler(cors.Config{
+				AllowedOrigins:   cfg.Gateway.CORS.AllowedOrigins,
+				AllowedMethods:   cfg.Gateway.CORS.AllowedMethods,
+				AllowedHeaders:   cfg.Gateway.CORS.AllowedHeaders,
+				ExposedHeaders:   cfg.Gateway.CORS.ExposedHeaders,
+				MaxAge:           cfg.Gateway.CORS.MaxAge,
+				AllowCredentials: cfg.Gateway.CORS.AllowCredentials,
+			}, handler)
+		}
+
+		gatewayApp = httpapp.New(log, cfg.Gateway.Port, handler)
 	}
 
-	// init auth service (auth)
-	authService := auth.New(log, storage, storage, storage, tokenTTL)
+	var httpApp *httpapp.App
+	if cfg.HTTP.Port != 0 {
+		mux := http.NewServeMux()
 
-	grpcApp := grpcapp.New(log, grpcPort, authService)
+		var keyProvider jwks.KeyProvider
+		if keys != nil {
+			keyProvider = keys
+		}
+		jwks.Register(mux, log, keyProvider)
+		m.Register(mux)
+		if cfg.OAuth.Google.ClientID != "" || cfg.OAuth.GitHub.ClientID != "" || len(cfg.OAuth.Providers) > 0 {
+			httpoauth.Register(mux, log, authService, httpoauth.Config{
+				StateTTL:    cfg.OAuth.StateTTL,
+				Insecure:    cfg.OAuth.Insecure,
+				Maintenance: mode,
+				RetryAfter:  cfg.Maintenance.RetryAfter,
+			})
+		}
+
+		httpApp = httpapp.New(log, cfg.HTTP.Port, mux)
+	}
 
 	return &App{
-		GROCSrv: grpcApp,
+		GROCSrv:    grpcApp,
+		HTTPSrv:    httpApp,
+		GatewaySrv: gatewayApp,
+		storage:    storage,
+		keys:       keys,
+		keySource:  keySource,
+
+		shutdownTracing: shutdownTracing,
+		events:          events,
+	}, nil
+}
+
+// ShutdownTracing flushes spans that haven't been exported yet.
+func (a *App) ShutdownTracing(ctx context.Context) error {
+	return a.shutdownTracing(ctx)
+}
+
+// ShutdownEvents delivers events that are still pending.
+func (a *App) ShutdownEvents(ctx context.Context) error {
+	return a.events.Close(ctx)
+}
+
+// Check creates the app like New, which connects to the storage and pings it
+// and sets up the servers, then releases it without serving anything.
+// It is run by the --check flag, so deployment pipelines catch misconfiguration
+// before a rollout. Migrations are not applied even if migrate_on_start is set.
+func Check(ctx context.Context, log *slog.Logger, cfg *config.Config) error {
+	const op = "app.Check"
+
+	checked := *cfg
+	checked.MigrateOnStart = false
+
+	a, err := New(log, &checked)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.ShutdownEvents(ctx); err != nil {
+		log.Warn("failed to close event publishers", slog.String("error", err.Error()))
+	}
+
+	if err := a.ShutdownTracing(ctx); err != nil {
+		log.Warn("failed to shut down tracing", slog.String("error", err.Error()))
+	}
+
+	if err := a.storage.Close(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ReloadKeys reads the RS256 signing key from its source again and, if it has
+// changed, makes it the current signing key.
+//
+// Previous keys keep verifying already issued tokens and stay published in JWKS
+// for the key retention period.
+// Does nothing for HS256.
+func (a *App) ReloadKeys() error {
+	const op = "app.ReloadKeys"
+
+	if a.keys == nil {
+		return nil
+	}
+
+	key, err := jwt.LoadRSAPrivateKey(context.Background(), a.keySource)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !key.Equal(a.keys.Current()) {
+		a.keys.Rotate(key)
+	}
+
+	return nil
+}
+
+// Migrate applies migrations of the storage in cfg that haven't been applied yet.
+// It is run by the migrate command, so migrations can be applied before
+// instances of the new version are started.
+func Migrate(ctx context.Context, log *slog.Logger, cfg *config.Config) error {
+	const op = "app.Migrate"
+
+	storage, err := newStorage(log, cfg)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = storage.Close() }()
+
+	if err := migrate(ctx, log, storage); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func migrate(ctx context.Context, log *slog.Logger, storage Storage) error {
+	applied, err := storage.Migrate(ctx)
+	if len(applied) > 0 {
+		log.Info("migrations applied", slog.Any("versions", applied))
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(applied) == 0 {
+		log.Info("storage is up to date")
+	}
+
+	return nil
+}
+
+func storagePool(cfg config.StoragePoolConfig) storage.PoolConfig {
+	return storage.PoolConfig{
+		MaxOpenConns:    cfg.MaxOpenConns,
+		MaxIdleConns:    cfg.MaxIdleConns,
+		ConnMaxLifetime: cfg.ConnMaxLifetime,
+	}
+}
+
+func storageRetry(cfg config.StorageRetryConfig) storage.RetryConfig {
+	return storage.RetryConfig{
+		Attempts:   cfg.Attempts,
+		Backoff:    cfg.Backoff,
+		MaxBackoff: cfg.MaxBackoff,
+	}
+}
+
+func newHasher(log *slog.Logger, cfg config.PasswordConfig) (auth.PasswordHasher, error) {
+	cost := cfg.BcryptCost
+	if cfg.Algorithm == password.AlgBcrypt && cfg.BcryptCalibrate {
+		cost = password.CalibrateCost(cfg.BcryptTarget)
+
+		log.Info("bcrypt cost calibrated",
+			slog.Int("cost", cost),
+			slog.Duration("target", cfg.BcryptTarget),
+		)
+	}
+
+	return password.NewHasher(cfg.Algorithm, cost, password.Argon2idParams{
+		Memory:  cfg.Argon2Memory,
+		Time:    cfg.Argon2Time,
+		Threads: cfg.Argon2Threads,
+	})
+}
+
+// newAuthService creates the auth service with settings from cfg.
+// keys and rp may be nil, see auth.Config.
+func newAuthService(
+	log *slog.Logger,
+	cfg *config.Config,
+	storage Storage,
+	apps Apps,
+	hasher auth.PasswordHasher,
+	keys *jwt.KeySet,
+	rp auth.RelyingParty,
+	events auth.EventPublisher,
+) *auth.Auth {
+	sender := mail.New(log, mail.Config{
+		Host:         cfg.Mail.Host,
+		Port:         cfg.Mail.Port,
+		Username:     cfg.Mail.Username,
+		Password:     cfg.Mail.Password,
+		From:         cfg.Mail.From,
+		VerifyURL:    cfg.Mail.VerifyURL,
+		ResetURL:     cfg.Mail.ResetURL,
+		MagicLinkURL: cfg.Mail.MagicLinkURL,
+	})
+
+	stores := auth.Stores{
+		UserSaver:         storage,
+		UserProvider:      storage,
+		AppProvider:       apps,
+		AppSaver:          apps,
+		TokenRevoker:      storage,
+		RefreshTokens:     storage,
+		Sessions:          storage,
+		OneTimeTokens:     storage,
+		Identities:        storage,
+		OAuthStates:       storage,
+		Passkeys:          storage,
+		PasskeyChallenges: storage,
+		Roles:             storage,
+		Tx:                storage,
+	}
+
+	return auth.New(
+		log,
+		stores,
+		sender,
+		auth.Config{
+			TokenTTL:   cfg.TokenTTl,
+			Issuer:     cfg.JWT.Issuer,
+			Leeway:     cfg.JWT.Leeway,
+			RefreshTTL: cfg.RefreshTTL,
+			Keys:       keys,
+			Lockout: auth.Lockout{
+				Attempts: cfg.Lockout.Attempts,
+				Duration: cfg.Lockout.Duration,
+			},
+			LoginBackoff: loginBackoff(cfg.LoginBackoff),
+			PasswordPolicy: password.Policy{
+				MinLength:     cfg.Password.MinLength,
+				RequireUpper:  cfg.Password.RequireUpper,
+				RequireLower:  cfg.Password.RequireLower,
+				RequireDigit:  cfg.Password.RequireDigit,
+				RequireSymbol: cfg.Password.RequireSymbol,
+				RejectCommon:  cfg.Password.RejectCommon,
+			},
+			PasswordHistory:     cfg.Password.History,
+			PasswordMaxAge:      cfg.Password.MaxAge,
+			RequireVerification: cfg.Verification.Required,
+			VerificationTTL:     cfg.Verification.TokenTTL,
+			PasswordResetTTL:    cfg.PasswordReset.TokenTTL,
+			MagicLinkTTL:        cfg.MagicLink.TokenTTL,
+			SoftDelete:          cfg.SoftDelete,
+
+			CaseSensitiveLocalPart: cfg.CaseSensitiveEmails,
+			BlockExpiredPasswords:  cfg.Password.ExpiredAction == config.PasswordExpiredBlock,
+			DefaultRole: auth.DefaultRole{
+				AppID: cfg.DefaultRole.AppID,
+				Role:  cfg.DefaultRole.Role,
+			},
+			Hasher: hasher,
+			Events: events,
+
+			IdentityProviders: identityProviders(cfg.OAuth),
+			OAuthStateTTL:     cfg.OAuth.StateTTL,
+			OIDCLogins:        oidcLogins(cfg.OAuth),
+
+			RelyingParty:   rp,
+			PasskeyTimeout: cfg.Passkeys.Timeout,
+
+			TokenExchange: tokenExchange(cfg.TokenExchange),
+
+			Scopes:     appScopes(cfg.Scopes),
+			DenyScopes: cfg.Scopes.DeniedAction == config.ScopesDeniedDeny,
+		},
+	)
+}
+
+// identityProviders returns providers users can sign in with by cfg, by name.
+func identityProviders(cfg config.OAuthConfig) map[string]auth.IdentityProvider {
+	providers := make(map[string]auth.IdentityProvider)
+
+	if cfg.Google.ClientID != "" {
+		providers[config.ProviderGoogle] = oauth.NewGoogle(oauth.GoogleConfig{
+			ClientID:     cfg.Google.ClientID,
+			ClientSecret: cfg.Google.ClientSecret,
+			RedirectURL:  cfg.Google.RedirectURL,
+		})
+	}
+
+	if cfg.GitHub.ClientID != "" {
+		providers[config.ProviderGitHub] = oauth.NewGitHub(oauth.GitHubConfig{
+			ClientID:     cfg.GitHub.ClientID,
+			ClientSecret: cfg.GitHub.ClientSecret,
+			RedirectURL:  cfg.GitHub.RedirectURL,
+			Scopes:       cfg.GitHub.Scopes,
+		})
+	}
+
+	for name, p := range cfg.Providers {
+		scopes := p.Scopes
+		if len(scopes) == 0 {
+			scopes = []string{"openid", "email", "profile"}
+		}
+
+		providers[name] = oauth.NewOIDC(oauth.OIDCConfig{
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  p.RedirectURL,
+			Scopes:       scopes,
+		})
+	}
+
+	return providers
+}
+
+// oidcLogins returns providers LoginWithOIDC accepts codes of by cfg, by name.
+func oidcLogins(cfg config.OAuthConfig) map[string]auth.OIDCLogin {
+	logins := make(map[string]auth.OIDCLogin)
+
+	for name, p := range cfg.Providers {
+		if p.AppID == 0 {
+			continue
+		}
+
+		logins[name] = auth.OIDCLogin{
+			AppID:        p.AppID,
+			RedirectURIs: p.RedirectURIs,
+		}
+	}
+
+	return logins
+}
+
+// tokenExchange returns audiences of apps allowed to exchange tokens by app id.
+func tokenExchange(cfg config.TokenExchangeConfig) map[int][]int {
+	clients := make(map[int][]int, len(cfg.Clients))
+
+	for _, c := range cfg.Clients {
+		clients[c.AppID] = c.Audiences
+	}
+
+	return clients
+}
+
+// appScopes returns scopes users can request by app id.
+func appScopes(cfg config.ScopesConfig) map[int]auth.AppScopes {
+	scopes := make(map[int]auth.AppScopes, len(cfg.Apps))
+
+	for _, a := range cfg.Apps {
+		scopes[a.AppID] = auth.AppScopes{
+			Scopes: a.Scopes,
+			Roles:  a.Roles,
+		}
+	}
+
+	return scopes
+}
+
+// relyingParty returns the relying party of passkeys by cfg, nil if passkeys are disabled.
+func relyingParty(cfg config.PasskeysConfig) (auth.RelyingParty, error) {
+	if cfg.RPID == "" {
+		return nil, nil
+	}
+
+	return passkey.New(passkey.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: cfg.RPDisplayName,
+		RPOrigins:     cfg.RPOrigins,
+		Timeout:       cfg.Timeout,
+	})
+}
+
+// loginBackoff returns the backoff of logins by cfg, nil if it is disabled.
+func loginBackoff(cfg config.LoginBackoffConfig) auth.LoginBackoff {
+	if cfg.BaseDelay == 0 {
+		return nil
+	}
+
+	return backoff.New(backoff.Config{
+		Base:       cfg.BaseDelay,
+		Max:        cfg.MaxDelay,
+		Factor:     cfg.Factor,
+		ResetAfter: cfg.ResetAfter,
+	})
+}
+
+// newStorage creates storage by cfg.StoragePath. If dual writes are enabled,
+// writes are mirrored to the storage by cfg.DualWrite.SecondaryPath.
+func newStorage(log *slog.Logger, cfg *config.Config) (Storage, error) {
+	pool := storagePool(cfg.StoragePool)
+	retry := storageRetry(cfg.StorageRetry)
+
+	primary, err := openStorage(cfg.StoragePath, cfg.StorageReplicaPath, pool, retry)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.DualWrite.SecondaryPath == "" {
+		return primary, nil
+	}
+
+	secondary, err := openStorage(cfg.DualWrite.SecondaryPath, "", pool, retry)
+	if err != nil {
+		_ = primary.Close()
+
+		return nil, fmt.Errorf("secondary storage: %w", err)
+	}
+
+	log.Info("dual writes enabled", slog.Bool("strict", cfg.DualWrite.Strict))
+
+	return dualwrite.New(log, primary, secondary, cfg.DualWrite.Strict), nil
+}
+
+// openStorage opens storage by path.
+//
+// Postgres connection strings (postgres:// or postgresql://) open a postgres storage,
+// anything else is treated as a path to sqlite database file.
+func openStorage(path string, replicaPath string, pool storage.PoolConfig, retry storage.RetryConfig) (dualwrite.Secondary, error) {
+	if config.IsPostgres(path) {
+		storage, err := postgres.New(path, replicaPath, pool, retry)
+		if err != nil {
+			return nil, err
+		}
+
+		return storage, nil
+	}
+
+	storage, err := sqlite.New(path, pool, retry)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage, nil
+}
+
+// startupPingTimeout is how long the storage has to answer on start.
+const startupPingTimeout = 5 * time.Second
+
+// ping checks that the storage is reachable, so the service fails on start
+// instead of serving errors when the database is down or misconfigured.
+func ping(pinger grpcapp.Pinger) error {
+	ctx, cancel := context.WithTimeout(context.Background(), startupPingTimeout)
+	defer cancel()
+
+	return pinger.Ping(ctx)
+}
+
+// newKeySet loads the RS256 signing key if it is enabled in cfg,
+// the returned provider is used to load the key again on reload.
+//
+// Returns nil key set for HS256, so tokens are signed with app secrets.
+func newKeySet(cfg config.JWTConfig) (*jwt.KeySet, jwt.SecretProvider, error) {
+	switch cfg.Algorithm {
+	case jwt.AlgHS256:
+		return nil, nil, nil
+	case jwt.AlgRS256:
+		ref := cfg.PrivateKeyPath
+		if cfg.KeySource == jwt.SecretSourceEnv {
+			ref = cfg.PrivateKeyEnv
+		}
+
+		source, err := jwt.NewSecretProvider(cfg.KeySource, ref)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		key, err := jwt.LoadRSAPrivateKey(context.Background(), source)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return jwt.NewKeySet(key, cfg.KeyRetention), source, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported jwt algorithm: %q", cfg.Algorithm)
+	}
+}
+
+// grpcListeners returns the public gRPC listener and, if grpc.admin.port is set,
+// the admin one serving methods only admins can call instead of the public one.
+func grpcListeners(cfg *config.Config) ([]grpcapp.Listener, error) {
+	creds, err := newServerCredentials(cfg.Env, cfg.GRPC.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	services := []string{grpcapp.ServiceAuth, grpcapp.ServiceHealth}
+	// Reflection exposes the whole API, so it is for debugging only.
+	if cfg.Env == envLocal || cfg.Env == envDev {
+		services = append(services, grpcapp.ServiceReflection)
+	}
+
+	public := grpcapp.Listener{
+		Name:     "public",
+		Port:     cfg.GRPC.Port,
+		Creds:    creds,
+		Services: services,
+	}
+
+	if cfg.GRPC.Admin.Port == 0 {
+		return []grpcapp.Listener{public}, nil
+	}
+
+	adminCreds, err := newServerCredentials(cfg.Env, cfg.GRPC.Admin.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("admin: %w", err)
+	}
+
+	adminMethods := authgrpc.AdminMethods()
+	public.Interceptors = []grpc.UnaryServerInterceptor{authgrpc.Methods(adminMethods, true)}
+
+	admin := grpcapp.Listener{
+		Name:         "admin",
+		Port:         cfg.GRPC.Admin.Port,
+		Creds:        adminCreds,
+		Services:     services,
+		Interceptors: []grpc.UnaryServerInterceptor{authgrpc.Methods(adminMethods, false)},
+	}
+
+	return []grpcapp.Listener{public, admin}, nil
+}
+
+// newServerCredentials loads TLS credentials of the gRPC server.
+//
+// Returns nil credentials, i.e. plaintext, if no certificate is configured,
+// but only in the local env, so a misconfigured deployment refuses to start.
+func newServerCredentials(env string, cfg config.TLSConfig) (credentials.TransportCredentials, error) {
+	if cfg.CertPath == "" && cfg.KeyPath == "" {
+		if env != envLocal {
+			return nil, fmt.Errorf("tls is required in %q env", env)
+		}
+
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.CertPath, cfg.KeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if cfg.ClientCAPath != "" {
+		pem, err := os.ReadFile(cfg.ClientCAPath)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.ClientCAPath)
+		}
+
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// tokenCookie returns the gateway cookie of cfg, it is disabled if cfg is.
+func tokenCookie(cfg config.TokenCookieConfig) gateway.TokenCookie {
+	if !cfg.Enabled {
+		return gateway.TokenCookie{}
+	}
+
+	sameSite := http.SameSiteStrictMode
+	switch cfg.SameSite {
+	case config.SameSiteLax:
+		sameSite = http.SameSiteLaxMode
+	case config.SameSiteNone:
+		sameSite = http.SameSiteNoneMode
+	}
+
+	return gateway.TokenCookie{
+		Name:     cfg.Name,
+		Domain:   cfg.Domain,
+		Path:     cfg.Path,
+		Secure:   !cfg.Insecure,
+		SameSite: sameSite,
+		OmitBody: cfg.OmitBody,
 	}
 }
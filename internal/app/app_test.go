@@ -0,0 +1,55 @@
+package app
+
+import (
+	"context"
+	"github.com/ilyakaznacheev/cleanenv"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"sso/internal/config"
+	"testing"
+)
+
+// checkConfig returns the example config shipped with the service with storage at storagePath.
+func checkConfig(t *testing.T, storagePath string) *config.Config {
+	t.Helper()
+
+	var cfg config.Config
+	if err := cleanenv.ReadConfig("../../config/local.yaml", &cfg); err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+
+	cfg.StoragePath = storagePath
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("invalid config: %v", err)
+	}
+
+	return &cfg
+}
+
+func TestCheck(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+
+	tests := []struct {
+		name        string
+		storagePath string
+		wantErr     bool
+	}{
+		{"sqlite storage", filepath.Join(dir, "sso.db"), false},
+		{"storage in missing directory", filepath.Join(dir, "missing", "sso.db"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Check(context.Background(), log, checkConfig(t, tt.storagePath))
+			if tt.wantErr && err == nil {
+				t.Fatal("check passed, want an error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("check failed: %v", err)
+			}
+		})
+	}
+}
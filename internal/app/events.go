@@ -0,0 +1,72 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sso/internal/config"
+	"sso/internal/domain/models"
+	"sso/internal/lib/eventbus"
+	"sso/internal/lib/webhook"
+)
+
+// eventPublisher publishes events in the background, Close delivers
+// the ones that are still pending.
+type eventPublisher interface {
+	Publish(ctx context.Context, event models.Event) error
+	Close(ctx context.Context) error
+}
+
+// publishers sends every event to all publishers enabled in config.
+type publishers []eventPublisher
+
+// newPublishers starts publishers of events enabled in cfg, it returns none if all are disabled.
+func newPublishers(log *slog.Logger, cfg *config.Config) (publishers, error) {
+	var p publishers
+
+	if cfg.Webhook.URL != "" {
+		p = append(p, webhook.New(log, webhook.Config{
+			URL:       cfg.Webhook.URL,
+			Secret:    cfg.Webhook.Secret,
+			Timeout:   cfg.Webhook.Timeout,
+			Attempts:  cfg.Webhook.Attempts,
+			Backoff:   cfg.Webhook.Backoff,
+			QueueSize: cfg.Webhook.QueueSize,
+		}))
+	}
+
+	if cfg.EventBus.URL != "" {
+		bus, err := eventbus.New(log, eventbus.Config{
+			URL:        cfg.EventBus.URL,
+			Subject:    cfg.EventBus.Subject,
+			BufferSize: cfg.EventBus.BufferSize,
+		})
+		if err != nil {
+			_ = p.Close(context.Background())
+
+			return nil, err
+		}
+
+		p = append(p, bus)
+	}
+
+	return p, nil
+}
+
+func (p publishers) Publish(ctx context.Context, event models.Event) error {
+	var errs []error
+	for _, publisher := range p {
+		errs = append(errs, publisher.Publish(ctx, event))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p publishers) Close(ctx context.Context) error {
+	var errs []error
+	for _, publisher := range p {
+		errs = append(errs, publisher.Close(ctx))
+	}
+
+	return errors.Join(errs...)
+}
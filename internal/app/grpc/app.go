@@ -1,71 +1,359 @@
 package grpcapp
 
 import (
+	"context"
 	"fmt"
+	ssov1 "github.com/roxxxiey/protos/gen/go/sso"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/test/bufconn"
 	"log/slog"
 	"net"
+	"slices"
 	authgrpc "sso/internal/grps/auth"
+	"sso/internal/grps/interceptors"
+	"sso/internal/lib/maintenance"
+	"sso/internal/lib/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type App struct {
-	log        *slog.Logger
-	gRPCServer *grpc.Server
+	log     *slog.Logger
+	servers []*server
+	health  *healthChecker
+
+	// inFlight is the number of requests being handled.
+	inFlight        *atomic.Int64
+	shutdownTimeout time.Duration
+
+	// local serves the API in-process to the HTTP gateway, see LocalConn.
+	// It is nil unless Config.Gateway is set.
+	local         *grpc.Server
+	localListener *bufconn.Listener
+}
+
+// server is a gRPC server of a Listener.
+type server struct {
+	name       string
 	port       int
+	gRPCServer *grpc.Server
 }
 
-// New creates new gRPC server app.
+// Services a listener can serve, see Listener.Services.
+const (
+	ServiceAuth       = "auth"
+	ServiceHealth     = "health"
+	ServiceReflection = "reflection"
+)
+
+// Listener is a gRPC server on a port of its own, e.g. the public API on one port
+// and methods only admins can call on another one reachable only internally.
+// Listeners share the services and the interceptors of Config.
+type Listener struct {
+	// Name tells listeners apart in logs, e.g. "public".
+	Name string
+	Port int
+	// Creds enables TLS, if nil the listener accepts plaintext.
+	Creds credentials.TransportCredentials
+	// Services are registered on the listener, e.g. ServiceAuth.
+	// Reflection exposes the whole API, so it is for debugging only.
+	Services []string
+	// Interceptors run after the shared ones, before calls are authenticated,
+	// e.g. authgrpc.Methods to serve only some methods of the Auth service.
+	Interceptors []grpc.UnaryServerInterceptor
+}
+
+// Config holds settings of the gRPC servers.
+type Config struct {
+	// Listeners are run by Run, each with a server of its own.
+	Listeners []Listener
+	// HealthInterval is how often the health service pings storage.
+	HealthInterval time.Duration
+	// ShutdownTimeout is how long in-flight requests may take to finish on Stop.
+	ShutdownTimeout time.Duration
+	// Timeout is the default deadline of a request, zero disables it.
+	Timeout time.Duration
+	// MethodTimeouts overrides Timeout for Auth methods by name, e.g. "Login".
+	MethodTimeouts map[string]time.Duration
+	// MaxRecvMsgSize is the largest request in bytes the server accepts,
+	// zero keeps the gRPC default of 4MB.
+	MaxRecvMsgSize int
+	// MaxEmailLength and MaxPasswordLength bound emails and passwords in requests,
+	// in bytes, zero disables a limit.
+	MaxEmailLength    int
+	MaxPasswordLength int
+	// Gateway serves the API in-process to the HTTP gateway, see LocalConn.
+	Gateway bool
+	// Maintenance refuses logins and registrations while it is on and is toggled
+	// by SetMaintenance. If nil, there is no maintenance mode.
+	Maintenance *maintenance.Mode
+	// MaintenanceRetryAfter is when clients are told to retry calls refused during maintenance.
+	MaintenanceRetryAfter time.Duration
+}
+
+// New creates new gRPC server app with a server for every listener of cfg.
+//
+// The health service reports SERVING while pinger succeeds and maintenance is off.
 func New(
 	log *slog.Logger,
-	port int,
 	authService authgrpc.Auth,
+	loginLimiter authgrpc.LoginLimiter,
+	m *metrics.Metrics,
+	pinger Pinger,
+	cfg Config,
 ) *App {
-	gRPCServer := grpc.NewServer()
+	inFlight := &atomic.Int64{}
+	appMetrics := interceptors.AppMetrics(m)
 
-	authgrpc.Register(gRPCServer, authService)
+	// A nil *maintenance.Mode must become a nil interface, not one holding a nil pointer.
+	var mode authgrpc.MaintenanceMode
+	if cfg.Maintenance != nil {
+		mode = cfg.Maintenance
+	}
+
+	var opts []grpc.ServerOption
+	if cfg.MaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(cfg.MaxRecvMsgSize))
+	}
+
+	// The interceptors are shared by all servers, except for the one
+	// telling where the client is and the ones of listeners.
+	chain := func(clientInfo grpc.UnaryServerInterceptor, extra ...grpc.UnaryServerInterceptor) grpc.ServerOption {
+		chain := []grpc.UnaryServerInterceptor{
+			interceptors.InFlight(inFlight),
+			interceptors.RequestID(),
+			clientInfo,
+			interceptors.Logging(log),
+			interceptors.Metrics(m),
+			appMetrics,
+			interceptors.Timeout(cfg.Timeout, fullMethodNames(cfg.MethodTimeouts)),
+			interceptors.Recovery(log, nil),
+		}
+		chain = append(chain, extra...)
+		chain = append(chain,
+			authgrpc.Maintenance(mode, authgrpc.MaintenanceMethods, cfg.MaintenanceRetryAfter),
+			interceptors.Limits(interceptors.FieldLimits{
+				MaxEmailLength:    cfg.MaxEmailLength,
+				MaxPasswordLength: cfg.MaxPasswordLength,
+			}),
+			authgrpc.Authenticate(authService, authgrpc.Policies),
+			authgrpc.Authorize(authService, authgrpc.Policies),
+		)
+
+		return grpc.ChainUnaryInterceptor(chain...)
+	}
+
+	healthChecker := newHealthChecker(log, pinger, cfg.HealthInterval, cfg.Maintenance)
+
+	servers := make([]*server, 0, len(cfg.Listeners))
+	for _, l := range cfg.Listeners {
+		listenerOpts := slices.Clone(opts)
+		if l.Creds != nil {
+			listenerOpts = append(listenerOpts, grpc.Creds(l.Creds))
+		}
+
+		gRPCServer := grpc.NewServer(append(listenerOpts,
+			grpc.StatsHandler(otelgrpc.NewServerHandler()),
+			chain(interceptors.ClientInfo(), l.Interceptors...),
+		)...)
+
+		if slices.Contains(l.Services, ServiceAuth) {
+			authgrpc.Register(gRPCServer, authService, loginLimiter, mode)
+		}
+		if slices.Contains(l.Services, ServiceHealth) {
+			healthpb.RegisterHealthServer(gRPCServer, healthChecker.server)
+		}
+		if slices.Contains(l.Services, ServiceReflection) {
+			reflection.Register(gRPCServer)
+		}
+
+		servers = append(servers, &server{
+			name:       l.Name,
+			port:       l.Port,
+			gRPCServer: gRPCServer,
+		})
+	}
+
+	// The local server is only reachable in-process, so it needs no TLS
+	// and can trust the client address forwarded by the gateway.
+	var (
+		local         *grpc.Server
+		localListener *bufconn.Listener
+	)
+	if cfg.Gateway {
+		var localOpts []grpc.ServerOption
+		if cfg.MaxRecvMsgSize > 0 {
+			localOpts = append(localOpts, grpc.MaxRecvMsgSize(cfg.MaxRecvMsgSize))
+		}
+
+		local = grpc.NewServer(append(localOpts,
+			grpc.StatsHandler(otelgrpc.NewServerHandler()),
+			chain(interceptors.ForwardedClientInfo()),
+		)...)
+		authgrpc.Register(local, authService, loginLimiter, mode)
+
+		localListener = bufconn.Listen(localBufferSize)
+	}
 
 	return &App{
-		log:        log,
-		gRPCServer: gRPCServer,
-		port:       port,
+		log:     log,
+		servers: servers,
+		health:  healthChecker,
+
+		inFlight:        inFlight,
+		shutdownTimeout: cfg.ShutdownTimeout,
+
+		local:         local,
+		localListener: localListener,
 	}
 }
 
+// localBufferSize is the size of in-memory connection buffers of the local server.
+const localBufferSize = 1 << 20
+
+// LocalConn connects to the local server, which serves the whole Auth service
+// in-process with the shared interceptors only. Config.Gateway must be set.
+func (a *App) LocalConn() (*grpc.ClientConn, error) {
+	return grpc.NewClient("passthrough:///local",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return a.localListener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+}
+
 func (a *App) MustRun() {
 	if err := a.Run(); err != nil {
 		panic(err)
 	}
 }
 
+// Run listens on ports of all listeners and serves them until Stop is called.
+// It returns once all servers have stopped, or the first error of a server.
 func (a *App) Run() error {
 	const op = "grpcapp.Run"
 
-	log := a.log.With(
-		slog.String("op", op),
-		slog.Int("port", a.port),
-	)
+	log := a.log.With(slog.String("op", op))
 
-	l, err := net.Listen("tcp", fmt.Sprintf(":%d", a.port))
+	// Every port is taken before serving any, so a taken port fails the start as a whole.
+	listeners := make([]net.Listener, 0, len(a.servers))
+	for _, s := range a.servers {
+		l, err := net.Listen("tcp", fmt.Sprintf(":%d", s.port))
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
 
-	if err != nil {
-		return fmt.Errorf("%s: %w", op, err)
+			return fmt.Errorf("%s: listener %s: %w", op, s.name, err)
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	go a.health.run()
+
+	if a.local != nil {
+		go func() {
+			if err := a.local.Serve(a.localListener); err != nil {
+				log.Error("local gRPC server failed", slog.String("error", err.Error()))
+			}
+		}()
 	}
 
-	log.Info("GRPC Server is running", slog.String("addr", l.Addr().String()))
+	errs := make(chan error, len(a.servers))
+	for i, s := range a.servers {
+		l := listeners[i]
+
+		log.Info("GRPC Server is running",
+			slog.String("listener", s.name),
+			slog.String("addr", l.Addr().String()),
+		)
+
+		go func() {
+			if err := s.gRPCServer.Serve(l); err != nil {
+				errs <- fmt.Errorf("%s: listener %s: %w", op, s.name, err)
+
+				return
+			}
 
-	if err := a.gRPCServer.Serve(l); err != nil {
-		return fmt.Errorf("%s: %w", op, err)
+			errs <- nil
+		}()
+	}
+
+	for range a.servers {
+		if err := <-errs; err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// Stop GRPC server
+// Stop GRPC servers
+//
+// Stop waits for in-flight requests of all listeners to finish, if they don't
+// finish within the shutdown timeout, their connections are closed.
 func (a *App) Stop() {
 	const op = "grpcapp.Stop"
 
-	a.log.With(slog.String("op", op)).
-		Info("stopping gRPC srever", slog.Int("port", a.port))
+	log := a.log.With(slog.String("op", op))
+
+	log.Info("stopping gRPC srever", slog.Int64("in_flight", a.inFlight.Load()))
+
+	a.health.shutdown()
+
+	var wg sync.WaitGroup
+	for _, s := range a.servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.gRPCServer.GracefulStop()
+		}()
+	}
+	if a.local != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.local.GracefulStop()
+		}()
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(a.shutdownTimeout):
+		log.Warn("requests didn't finish in time, forcing stop",
+			slog.Duration("timeout", a.shutdownTimeout),
+			slog.Int64("in_flight", a.inFlight.Load()),
+		)
+
+		for _, s := range a.servers {
+			s.gRPCServer.Stop()
+		}
+		if a.local != nil {
+			a.local.Stop()
+		}
+	}
+}
+
+// fullMethodNames keys timeouts of Auth methods by their full gRPC name,
+// e.g. "Login" becomes "/auth.Auth/Login".
+func fullMethodNames(timeouts map[string]time.Duration) map[string]time.Duration {
+	full := make(map[string]time.Duration, len(timeouts))
+	for method, timeout := range timeouts {
+		full["/"+ssov1.Auth_ServiceDesc.ServiceName+"/"+method] = timeout
+	}
 
-	a.gRPCServer.GracefulStop()
+	return full
 }
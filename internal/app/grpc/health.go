@@ -0,0 +1,115 @@
+package grpcapp
+
+import (
+	"context"
+	ssov1 "github.com/roxxxiey/protos/gen/go/sso"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"log/slog"
+	"sso/internal/lib/maintenance"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Pinger checks that a dependency, e.g. the database, is reachable.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// healthChecker pings storage in the background and reports
+// the server as SERVING only while storage is reachable and maintenance is off.
+// NOT_SERVING during maintenance takes the instance out of load balancing,
+// the process keeps serving calls that still reach it.
+type healthChecker struct {
+	log      *slog.Logger
+	server   *health.Server
+	pinger   Pinger
+	interval time.Duration
+
+	reachable   atomic.Bool
+	maintenance atomic.Bool
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// newHealthChecker creates a checker, mode may be nil if there is no maintenance mode.
+func newHealthChecker(log *slog.Logger, pinger Pinger, interval time.Duration, mode *maintenance.Mode) *healthChecker {
+	server := health.NewServer()
+
+	c := &healthChecker{
+		log:      log,
+		server:   server,
+		pinger:   pinger,
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+
+	// Not serving until storage has been reached at least once.
+	c.setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+
+	if mode != nil {
+		mode.Watch(func(enabled bool) {
+			c.maintenance.Store(enabled)
+			c.update()
+		})
+		c.maintenance.Store(mode.Enabled())
+	}
+
+	return c
+}
+
+// run checks storage every interval until shutdown is called.
+func (c *healthChecker) run() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	c.check()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.check()
+		}
+	}
+}
+
+func (c *healthChecker) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.interval)
+	defer cancel()
+
+	err := c.pinger.Ping(ctx)
+	if err != nil {
+		c.log.Error("storage is unreachable", "error", err)
+	}
+
+	c.reachable.Store(err == nil)
+	c.update()
+}
+
+// update reports the status by the last check and maintenance mode.
+func (c *healthChecker) update() {
+	if !c.reachable.Load() || c.maintenance.Load() {
+		c.setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+
+		return
+	}
+
+	c.setStatus(healthpb.HealthCheckResponse_SERVING)
+}
+
+func (c *healthChecker) setStatus(status healthpb.HealthCheckResponse_ServingStatus) {
+	c.server.SetServingStatus("", status)
+	c.server.SetServingStatus(ssov1.Auth_ServiceDesc.ServiceName, status)
+}
+
+// shutdown stops the checks and reports NOT_SERVING from now on.
+func (c *healthChecker) shutdown() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+		c.server.Shutdown()
+	})
+}
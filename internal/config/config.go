@@ -1,41 +1,528 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"github.com/ilyakaznacheev/cleanenv"
 	"os"
+	"strings"
 	"time"
 )
 
+// Config of the service, read from a YAML file.
+//
+// Every field can be overridden by an environment variable named after its
+// path in the file, prefixed with SSO_, e.g. SSO_STORAGE_PATH or SSO_GRPC_TLS_CERT_PATH.
+// Environment variables take precedence over the file.
 type Config struct {
-	Env         string        `yaml:"env" env-default:"local"`
-	StoragePath string        `yaml:"storage_path" env-required:"true"`
-	TokenTTl    time.Duration `yaml:"token_ttl" env-required:"true"`
-	GRPC        GRPCConfig    `yaml:"grpc"`
+	// Env is one of local, dev or prod, anything else is rejected by Validate.
+	// It sets the default log level and format, and only local allows plaintext gRPC.
+	Env         string `yaml:"env" env:"SSO_ENV" env-default:"local"`
+	StoragePath string `yaml:"storage_path" env:"SSO_STORAGE_PATH" env-required:"true"`
+	// StorageReplicaPath is a postgres connection string of a read replica,
+	// which serves reads of apps, admins and user lists. Users are read from StoragePath
+	// for logins, so they see the latest passwords and lockouts. Empty reads everything from StoragePath.
+	StorageReplicaPath string `yaml:"storage_replica_path" env:"SSO_STORAGE_REPLICA_PATH"`
+	// MigrateOnStart applies migrations of the storage on start, otherwise
+	// they are applied by the migrate command.
+	MigrateOnStart bool          `yaml:"migrate_on_start" env:"SSO_MIGRATE_ON_START"`
+	TokenTTl       time.Duration `yaml:"token_ttl" env:"SSO_TOKEN_TTL" env-required:"true"`
+	RefreshTTL     time.Duration `yaml:"refresh_token_ttl" env:"SSO_REFRESH_TOKEN_TTL"`
+	// SoftDelete keeps data of deleted users and only marks them as deleted.
+	SoftDelete bool `yaml:"soft_delete" env:"SSO_SOFT_DELETE"`
+	// CaseSensitiveEmails keeps case of the part of emails before @,
+	// by default whole emails are lowercased.
+	CaseSensitiveEmails bool                `yaml:"case_sensitive_emails" env:"SSO_CASE_SENSITIVE_EMAILS"`
+	Log                 LogConfig           `yaml:"log" env-prefix:"SSO_LOG_"`
+	StoragePool         StoragePoolConfig   `yaml:"storage_pool" env-prefix:"SSO_STORAGE_POOL_"`
+	StorageRetry        StorageRetryConfig  `yaml:"storage_retry" env-prefix:"SSO_STORAGE_RETRY_"`
+	DualWrite           DualWriteConfig     `yaml:"dual_write" env-prefix:"SSO_DUAL_WRITE_"`
+	AppCache            AppCacheConfig      `yaml:"app_cache" env-prefix:"SSO_APP_CACHE_"`
+	RoleCache           RoleCacheConfig     `yaml:"role_cache" env-prefix:"SSO_ROLE_CACHE_"`
+	GRPC                GRPCConfig          `yaml:"grpc" env-prefix:"SSO_GRPC_"`
+	HTTP                HTTPConfig          `yaml:"http" env-prefix:"SSO_HTTP_"`
+	Gateway             GatewayConfig       `yaml:"gateway" env-prefix:"SSO_GATEWAY_"`
+	JWT                 JWTConfig           `yaml:"jwt" env-prefix:"SSO_JWT_"`
+	RateLimit           RateLimitConfig     `yaml:"rate_limit" env-prefix:"SSO_RATE_LIMIT_"`
+	Lockout             LockoutConfig       `yaml:"lockout" env-prefix:"SSO_LOCKOUT_"`
+	LoginBackoff        LoginBackoffConfig  `yaml:"login_backoff" env-prefix:"SSO_LOGIN_BACKOFF_"`
+	Maintenance         MaintenanceConfig   `yaml:"maintenance" env-prefix:"SSO_MAINTENANCE_"`
+	DefaultRole         DefaultRoleConfig   `yaml:"default_role" env-prefix:"SSO_DEFAULT_ROLE_"`
+	Password            PasswordConfig      `yaml:"password" env-prefix:"SSO_PASSWORD_"`
+	Verification        VerificationConfig  `yaml:"verification" env-prefix:"SSO_VERIFICATION_"`
+	PasswordReset       PasswordResetConfig `yaml:"password_reset" env-prefix:"SSO_PASSWORD_RESET_"`
+	MagicLink           MagicLinkConfig     `yaml:"magic_link" env-prefix:"SSO_MAGIC_LINK_"`
+	OAuth               OAuthConfig         `yaml:"oauth" env-prefix:"SSO_OAUTH_"`
+	Passkeys            PasskeysConfig      `yaml:"passkeys" env-prefix:"SSO_PASSKEYS_"`
+	TokenExchange       TokenExchangeConfig `yaml:"token_exchange"`
+	Scopes              ScopesConfig        `yaml:"scopes" env-prefix:"SSO_SCOPES_"`
+	Mail                MailConfig          `yaml:"mail" env-prefix:"SSO_MAIL_"`
+	Webhook             WebhookConfig       `yaml:"webhook" env-prefix:"SSO_WEBHOOK_"`
+	EventBus            EventBusConfig      `yaml:"event_bus" env-prefix:"SSO_EVENT_BUS_"`
+	Tracing             TracingConfig       `yaml:"tracing" env-prefix:"SSO_TRACING_"`
+}
+
+// LogConfig configures where and how logs are written.
+// Unset fields default by env: text in local and prod, JSON in dev, all to stdout.
+type LogConfig struct {
+	// Output is stdout, stderr or a path of a file logs are appended to.
+	Output string `yaml:"output" env:"OUTPUT"`
+	// Format is either text or json.
+	Format string `yaml:"format" env:"FORMAT"`
+}
+
+// StoragePoolConfig tunes the database connection pool.
+//
+// Defaults suit a single instance against a small postgres server,
+// max_open_conns of all instances together must stay below max_connections of the server.
+type StoragePoolConfig struct {
+	MaxOpenConns int `yaml:"max_open_conns" env:"MAX_OPEN_CONNS" env-default:"25"`
+	// MaxIdleConns should be close to MaxOpenConns, otherwise connections
+	// are closed and reopened under steady load.
+	MaxIdleConns int `yaml:"max_idle_conns" env:"MAX_IDLE_CONNS" env-default:"25"`
+	// ConnMaxLifetime recycles connections, 0 keeps them forever.
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env:"CONN_MAX_LIFETIME" env-default:"5m"`
+}
+
+// StorageRetryConfig retries reads of users, apps and roles which failed
+// with a transient error, e.g. a dropped connection, with exponential backoff.
+type StorageRetryConfig struct {
+	// Attempts is how many times a read is tried in total, 1 disables retries.
+	Attempts   int           `yaml:"attempts" env:"ATTEMPTS" env-default:"3"`
+	Backoff    time.Duration `yaml:"backoff" env:"BACKOFF" env-default:"50ms"`
+	MaxBackoff time.Duration `yaml:"max_backoff" env:"MAX_BACKOFF" env-default:"1s"`
+}
+
+// DualWriteConfig mirrors writes to a second storage, e.g. to move from sqlite
+// to postgres: existing data is backfilled into the secondary storage while
+// new writes go to both, and the paths are swapped on cutover.
+// Reads are always served by storage_path.
+type DualWriteConfig struct {
+	// SecondaryPath is a sqlite path or postgres connection string, empty disables dual writes.
+	SecondaryPath string `yaml:"secondary_path" env:"SECONDARY_PATH"`
+	// Strict fails requests if a write to the secondary storage fails,
+	// by default such failures are only logged.
+	Strict bool `yaml:"strict" env:"STRICT"`
+}
+
+// AppCacheConfig caches apps in memory, as every login reads its app.
+// Apps deleted by other instances are served until their entries expire.
+// Caching is disabled when TTL is 0.
+type AppCacheConfig struct {
+	TTL time.Duration `yaml:"ttl" env:"TTL" env-default:"1m"`
+	// Size is how many apps are cached at most.
+	Size int `yaml:"size" env:"SIZE" env-default:"1000"`
+}
+
+// RoleCacheConfig caches whether users are admins and their roles in memory,
+// as they are checked on every authorization. Roles changed by other instances
+// or the create-admin command are served until the entries expire, so TTL should be short.
+// Caching is disabled when TTL is 0.
+type RoleCacheConfig struct {
+	TTL time.Duration `yaml:"ttl" env:"TTL" env-default:"5s"`
+	// Size is how many users and apps are cached at most.
+	Size int `yaml:"size" env:"SIZE" env-default:"10000"`
 }
 
 type GRPCConfig struct {
-	Port    int           `yaml:"port"`
-	Timeout time.Duration `yaml:"timeout"`
+	Port int `yaml:"port" env:"PORT"`
+	// Timeout is the default deadline of a request, unless the client sets a shorter one.
+	// Zero disables it.
+	Timeout time.Duration `yaml:"timeout" env:"TIMEOUT"`
+	// MethodTimeouts overrides Timeout for methods by name, e.g. Login which
+	// hashes passwords needs more time than IsAdmin. Zero disables the deadline of the method.
+	MethodTimeouts map[string]time.Duration `yaml:"method_timeouts" env:"METHOD_TIMEOUTS"`
+	TLS            TLSConfig                `yaml:"tls" env-prefix:"TLS_"`
+	// HealthCheckInterval is how often storage is pinged to report health.
+	HealthCheckInterval time.Duration `yaml:"health_check_interval" env:"HEALTH_CHECK_INTERVAL" env-default:"10s"`
+	// ShutdownTimeout is how long in-flight requests may take to finish on shutdown.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" env-default:"10s"`
+	// MaxRecvMsgSize is the largest request in bytes the server accepts.
+	MaxRecvMsgSize int `yaml:"max_recv_msg_size" env:"MAX_RECV_MSG_SIZE" env-default:"65536"`
+	// MaxEmailLength and MaxPasswordLength bound emails and passwords in requests, in bytes.
+	// Emails are at most 254 bytes long anyway, the longest address SMTP allows.
+	// bcrypt ignores bytes of a password past 72, so longer passwords
+	// are rejected rather than silently truncated.
+	MaxEmailLength    int             `yaml:"max_email_length" env:"MAX_EMAIL_LENGTH" env-default:"254"`
+	MaxPasswordLength int             `yaml:"max_password_length" env:"MAX_PASSWORD_LENGTH" env-default:"72"`
+	Admin             GRPCAdminConfig `yaml:"admin" env-prefix:"ADMIN_"`
+}
+
+// GRPCAdminConfig moves methods only admins can call, e.g. CreateApp and SetMaintenance,
+// off grpc.port to a port of their own, which is meant to be reachable only internally.
+// They are served on grpc.port when Port is 0.
+type GRPCAdminConfig struct {
+	Port int `yaml:"port" env:"PORT"`
+	// TLS of the admin port, plaintext is only allowed in the local env as for grpc.port.
+	TLS TLSConfig `yaml:"tls" env-prefix:"TLS_"`
+}
+
+// TLSConfig configures TLS of a gRPC port.
+//
+// Without CertPath and KeyPath the server listens in plaintext,
+// which is only allowed in the local env.
+type TLSConfig struct {
+	CertPath string `yaml:"cert_path" env:"CERT_PATH"`
+	KeyPath  string `yaml:"key_path" env:"KEY_PATH"`
+	// ClientCAPath enables mutual TLS: clients must present a certificate signed by one of these CAs.
+	ClientCAPath string `yaml:"client_ca_path" env:"CLIENT_CA_PATH"`
+}
+
+// HTTPConfig configures the HTTP server serving JWKS, metrics and sign ins with identity providers.
+// The server is disabled when Port is 0.
+type HTTPConfig struct {
+	Port int `yaml:"port" env:"PORT"`
+}
+
+// GatewayConfig serves Register, Login and IsAdmin as HTTP/JSON endpoints,
+// e.g. POST /v1/login, for clients that can't speak gRPC.
+type GatewayConfig struct {
+	// Port is the port of the gateway, 0 disables it.
+	Port        int               `yaml:"port" env:"PORT"`
+	CORS        CORSConfig        `yaml:"cors" env-prefix:"CORS_"`
+	TokenCookie TokenCookieConfig `yaml:"token_cookie" env-prefix:"TOKEN_COOKIE_"`
+}
+
+// TokenCookieConfig makes Login of the gateway set the access token as an HttpOnly cookie,
+// so pages don't keep it where scripts can read it. The cookie expires with the token.
+type TokenCookieConfig struct {
+	Enabled bool   `yaml:"enabled" env:"ENABLED"`
+	Name    string `yaml:"name" env:"NAME" env-default:"sso_token"`
+	// Domain is empty for a host-only cookie.
+	Domain string `yaml:"domain" env:"DOMAIN"`
+	Path   string `yaml:"path" env:"PATH" env-default:"/"`
+	// Insecure sends the cookie over plain HTTP too, it is rejected in prod.
+	Insecure bool `yaml:"insecure" env:"INSECURE"`
+	// SameSite is strict, lax or none, none can't be used with Insecure.
+	SameSite string `yaml:"same_site" env:"SAME_SITE" env-default:"strict"`
+	// OmitBody removes the token from the JSON body, so it is only sent in the cookie.
+	OmitBody bool `yaml:"omit_body" env:"OMIT_BODY"`
+}
+
+// CORSConfig lets pages of other origins, e.g. a SPA, call the gateway from browsers.
+// CORS is disabled when AllowedOrigins is empty.
+type CORSConfig struct {
+	// AllowedOrigins are origins like https://app.example.com, "*" allows any origin
+	// and is rejected in prod.
+	AllowedOrigins []string `yaml:"allowed_origins" env:"ALLOWED_ORIGINS"`
+	AllowedMethods []string `yaml:"allowed_methods" env:"ALLOWED_METHODS" env-default:"GET,POST"`
+	AllowedHeaders []string `yaml:"allowed_headers" env:"ALLOWED_HEADERS" env-default:"Content-Type,Authorization,X-Request-Id"`
+	// ExposedHeaders are response headers pages may read.
+	ExposedHeaders []string `yaml:"exposed_headers" env:"EXPOSED_HEADERS" env-default:"Grpc-Metadata-X-Request-Id"`
+	// MaxAge is how long browsers cache answers to preflight requests.
+	MaxAge time.Duration `yaml:"max_age" env:"MAX_AGE" env-default:"10m"`
+	// AllowCredentials lets pages send cookies, e.g. the token cookie, it can't be used with "*".
+	AllowCredentials bool `yaml:"allow_credentials" env:"ALLOW_CREDENTIALS"`
+}
+
+type JWTConfig struct {
+	// Algorithm is either HS256 (signed with the app secret) or RS256 (signed with the private key).
+	Algorithm string `yaml:"algorithm" env:"ALGORITHM" env-default:"HS256"`
+	// Issuer is the iss claim of tokens, it should differ between environments
+	// so tokens of one can't be replayed against another.
+	Issuer string `yaml:"issuer" env:"ISSUER" env-default:"sso"`
+	// Leeway tolerates clock drift between servers when validating exp, nbf and iat.
+	Leeway time.Duration `yaml:"leeway" env:"LEEWAY" env-default:"30s"`
+	// KeySource is where the RS256 private key is read from:
+	// "file" reads PrivateKeyPath, "env" reads the variable named PrivateKeyEnv.
+	KeySource      string `yaml:"key_source" env:"KEY_SOURCE" env-default:"file"`
+	PrivateKeyPath string `yaml:"private_key_path" env:"PRIVATE_KEY_PATH"`
+	PrivateKeyEnv  string `yaml:"private_key_env" env:"PRIVATE_KEY_ENV"`
+	// KeyRetention is how long replaced RS256 keys keep verifying tokens,
+	// it must be at least the longest lifetime of access tokens. 0 keeps them forever.
+	KeyRetention time.Duration `yaml:"key_retention" env:"KEY_RETENTION"`
+}
+
+// RateLimitConfig limits failed logins per email and per client IP.
+// Limiting is disabled when Attempts is 0.
+type RateLimitConfig struct {
+	Attempts int           `yaml:"attempts" env:"ATTEMPTS"`
+	Window   time.Duration `yaml:"window" env:"WINDOW" env-default:"15m"`
+}
+
+// LockoutConfig locks an account after consecutive failed logins.
+// Lockout is disabled when Attempts is 0.
+type LockoutConfig struct {
+	Attempts int           `yaml:"attempts" env:"ATTEMPTS"`
+	Duration time.Duration `yaml:"duration" env:"DURATION" env-default:"30m"`
+}
+
+// LoginBackoffConfig delays logins per email after consecutive failed logins,
+// the delay grows by Factor with every failure up to MaxDelay.
+// Backoff is disabled when BaseDelay is 0.
+type LoginBackoffConfig struct {
+	BaseDelay time.Duration `yaml:"base_delay" env:"BASE_DELAY"`
+	MaxDelay  time.Duration `yaml:"max_delay" env:"MAX_DELAY" env-default:"10s"`
+	Factor    float64       `yaml:"factor" env:"FACTOR" env-default:"2"`
+	// ResetAfter is how long failures are remembered after the last one.
+	ResetAfter time.Duration `yaml:"reset_after" env:"RESET_AFTER" env-default:"15m"`
+}
+
+// MaintenanceConfig is the maintenance mode the service starts in, it can be
+// toggled at runtime with SetMaintenance. During maintenance logins and registrations
+// are refused and health reports NOT_SERVING, issued tokens keep working.
+type MaintenanceConfig struct {
+	Enabled bool `yaml:"enabled" env:"ENABLED"`
+	// RetryAfter is when clients are told to retry refused calls.
+	RetryAfter time.Duration `yaml:"retry_after" env:"RETRY_AFTER" env-default:"30s"`
+}
+
+// OAuthConfig lets users sign in with their accounts at identity providers,
+// the endpoints are served by the HTTP server. A provider is disabled when its client ID is empty.
+type OAuthConfig struct {
+	// StateTTL is how long users have to sign in at the provider.
+	StateTTL time.Duration `yaml:"state_ttl" env:"STATE_TTL" env-default:"10m"`
+	// Insecure sends the state cookie over plain HTTP too, it is rejected in prod.
+	Insecure bool              `yaml:"insecure" env:"INSECURE"`
+	Google   OAuthClientConfig `yaml:"google" env-prefix:"GOOGLE_"`
+	GitHub   GitHubConfig      `yaml:"github" env-prefix:"GITHUB_"`
+	// Providers are other OpenID Connect providers, e.g. Okta, by name,
+	// the name is used in paths of the endpoints. They can only be set in the config file.
+	Providers map[string]OIDCProviderConfig `yaml:"providers"`
+}
+
+// GitHubConfig is the OAuth app of the service at GitHub.
+type GitHubConfig struct {
+	ClientID     string `yaml:"client_id" env:"CLIENT_ID"`
+	ClientSecret string `yaml:"client_secret" env:"CLIENT_SECRET"`
+	// RedirectURL is the callback endpoint, e.g. https://sso.example.com/oauth/github/callback,
+	// it must be the callback URL of the OAuth app.
+	RedirectURL string `yaml:"redirect_url" env:"REDIRECT_URL"`
+	// Scopes must include user:email, so the verified email of the account can be read.
+	Scopes []string `yaml:"scopes" env:"SCOPES" env-default:"read:user,user:email"`
+}
+
+// OIDCProviderConfig is an OpenID Connect provider, its endpoints and signing keys
+// are discovered from Issuer.
+type OIDCProviderConfig struct {
+	Issuer       string `yaml:"issuer"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	RedirectURL  string `yaml:"redirect_url"`
+	// Scopes default to openid, email and profile.
+	Scopes []string `yaml:"scopes"`
+	// AppID is the app LoginWithOIDC issues tokens for, 0 disables LoginWithOIDC for the provider.
+	AppID int `yaml:"app_id"`
+	// RedirectURIs are the redirect URIs clients calling LoginWithOIDC got codes for.
+	RedirectURIs []string `yaml:"redirect_uris"`
+}
+
+// OAuthClientConfig is the client of the service registered at an identity provider.
+type OAuthClientConfig struct {
+	ClientID     string `yaml:"client_id" env:"CLIENT_ID"`
+	ClientSecret string `yaml:"client_secret" env:"CLIENT_SECRET"`
+	// RedirectURL is the callback endpoint, e.g. https://sso.example.com/oauth/google/callback,
+	// it must be registered at the provider.
+	RedirectURL string `yaml:"redirect_url" env:"REDIRECT_URL"`
+}
+
+// PasskeysConfig lets users register passkeys and log in with them, the service
+// being the WebAuthn relying party. Passkeys are disabled when RPID is empty.
+type PasskeysConfig struct {
+	// RPID is the domain passkeys are bound to, e.g. example.com.
+	// Changing it makes every registered passkey unusable.
+	RPID string `yaml:"rp_id" env:"RP_ID"`
+	// RPDisplayName is shown to users by their authenticators.
+	RPDisplayName string `yaml:"rp_display_name" env:"RP_DISPLAY_NAME" env-default:"SSO"`
+	// RPOrigins are origins of the pages passkeys are used on, e.g. https://login.example.com,
+	// their hosts must be RPID or its subdomains.
+	RPOrigins []string `yaml:"rp_origins" env:"RP_ORIGINS"`
+	// Timeout is how long users have to answer their authenticator.
+	Timeout time.Duration `yaml:"timeout" env:"TIMEOUT" env-default:"5m"`
+}
+
+// TokenExchangeConfig lists apps that may exchange tokens of users for tokens
+// of other apps, e.g. a service calling another one on behalf of the user.
+// It can only be set in the config file.
+type TokenExchangeConfig struct {
+	Clients []TokenExchangeClientConfig `yaml:"clients"`
+}
+
+// TokenExchangeClientConfig lets tokens issued for the app with AppID be exchanged
+// for tokens of the apps with ids in Audiences, by the app itself authenticated with its secret.
+type TokenExchangeClientConfig struct {
+	AppID     int   `yaml:"app_id"`
+	Audiences []int `yaml:"audiences"`
+}
+
+// ScopesConfig lists scopes users can request at login, per app.
+type ScopesConfig struct {
+	// DeniedAction is what happens when a login requests a scope the user can't have:
+	// drop leaves it out of the token, deny refuses the login.
+	DeniedAction string `yaml:"denied_action" env:"DENIED_ACTION" env-default:"drop"`
+	// Apps can only be set in the config file.
+	Apps []AppScopesConfig `yaml:"apps"`
+}
+
+// AppScopesConfig is scopes users of the app with AppID can request.
+type AppScopesConfig struct {
+	AppID int `yaml:"app_id"`
+	// Scopes are granted to every user of the app.
+	Scopes []string `yaml:"scopes"`
+	// Roles grant scopes to users with the role in the app, by role name.
+	Roles map[string][]string `yaml:"roles"`
+}
+
+// DefaultRoleConfig is the role every new user gets in the app with AppID.
+// No role is assigned when Role is empty.
+type DefaultRoleConfig struct {
+	AppID int    `yaml:"app_id" env:"APP_ID"`
+	Role  string `yaml:"role" env:"ROLE"`
+}
+
+// PasswordConfig is the password strength policy applied to new passwords.
+type PasswordConfig struct {
+	MinLength     int  `yaml:"min_length" env:"MIN_LENGTH" env-default:"8"`
+	RequireUpper  bool `yaml:"require_upper" env:"REQUIRE_UPPER"`
+	RequireLower  bool `yaml:"require_lower" env:"REQUIRE_LOWER"`
+	RequireDigit  bool `yaml:"require_digit" env:"REQUIRE_DIGIT"`
+	RequireSymbol bool `yaml:"require_symbol" env:"REQUIRE_SYMBOL"`
+	RejectCommon  bool `yaml:"reject_common" env:"REJECT_COMMON"`
+	// History is how many last passwords of a user, the current one included,
+	// can't be set again. 0 allows any reuse.
+	History int `yaml:"history" env:"HISTORY"`
+	// MaxAge is how long a password is valid before it must be changed, 0 means forever.
+	MaxAge time.Duration `yaml:"max_age" env:"MAX_AGE"`
+	// ExpiredAction is what happens on login with an expired password:
+	// "warn" logs the user in and flags the password as expired, "block" refuses the login.
+	ExpiredAction string `yaml:"expired_action" env:"EXPIRED_ACTION" env-default:"warn"`
+	// Algorithm hashes new passwords, either bcrypt or argon2id.
+	// Existing hashes of both algorithms are verified regardless.
+	Algorithm string `yaml:"algorithm" env:"ALGORITHM" env-default:"bcrypt"`
+	// BcryptCost is the cost of bcrypt hashes, from 4 to 31.
+	// Every step doubles the time to hash a password.
+	BcryptCost int `yaml:"bcrypt_cost" env:"BCRYPT_COST" env-default:"10"`
+	// BcryptCalibrate picks the bcrypt cost on start instead of BcryptCost,
+	// so hashing a password takes about BcryptTarget on the current hardware.
+	BcryptCalibrate bool          `yaml:"bcrypt_calibrate" env:"BCRYPT_CALIBRATE"`
+	BcryptTarget    time.Duration `yaml:"bcrypt_target" env:"BCRYPT_TARGET" env-default:"250ms"`
+	// Argon2Memory is the memory of argon2id hashes in KiB.
+	Argon2Memory  uint32 `yaml:"argon2_memory" env:"ARGON2_MEMORY" env-default:"19456"`
+	Argon2Time    uint32 `yaml:"argon2_time" env:"ARGON2_TIME" env-default:"2"`
+	Argon2Threads uint8  `yaml:"argon2_threads" env:"ARGON2_THREADS" env-default:"1"`
+}
+
+// VerificationConfig configures email verification of new users.
+type VerificationConfig struct {
+	// Required makes new users verify their email before they can log in.
+	Required bool          `yaml:"required" env:"REQUIRED"`
+	TokenTTL time.Duration `yaml:"token_ttl" env:"TOKEN_TTL" env-default:"24h"`
+}
+
+// PasswordResetConfig configures password reset tokens.
+type PasswordResetConfig struct {
+	TokenTTL time.Duration `yaml:"token_ttl" env:"TOKEN_TTL" env-default:"1h"`
+}
+
+// MagicLinkConfig configures tokens of links to log in without a password.
+type MagicLinkConfig struct {
+	// TokenTTL should be short, whoever gets the email can log in with the link.
+	TokenTTL time.Duration `yaml:"token_ttl" env:"TOKEN_TTL" env-default:"15m"`
+}
+
+// MailConfig configures SMTP server emails are sent through.
+// If Host is empty, emails are only logged.
+type MailConfig struct {
+	Host         string `yaml:"host" env:"HOST"`
+	Port         int    `yaml:"port" env:"PORT" env-default:"587"`
+	Username     string `yaml:"username" env:"USERNAME"`
+	Password     string `yaml:"password" env:"PASSWORD"`
+	From         string `yaml:"from" env:"FROM"`
+	VerifyURL    string `yaml:"verify_url" env:"VERIFY_URL"`
+	ResetURL     string `yaml:"reset_url" env:"RESET_URL"`
+	MagicLinkURL string `yaml:"magic_link_url" env:"MAGIC_LINK_URL"`
+}
+
+// WebhookConfig posts events of users, e.g. registrations, to an HTTP endpoint.
+// Webhooks are disabled when URL is empty.
+type WebhookConfig struct {
+	URL string `yaml:"url" env:"URL"`
+	// Secret signs request bodies with HMAC-SHA256, empty sends them unsigned.
+	Secret string `yaml:"secret" env:"SECRET"`
+	// Timeout bounds a single delivery attempt.
+	Timeout time.Duration `yaml:"timeout" env:"TIMEOUT" env-default:"5s"`
+	// Attempts is how many times an event is sent in total before it is dropped.
+	Attempts int           `yaml:"attempts" env:"ATTEMPTS" env-default:"5"`
+	Backoff  time.Duration `yaml:"backoff" env:"BACKOFF" env-default:"1s"`
+	// QueueSize is how many events may wait for delivery, events beyond it are dropped.
+	QueueSize int `yaml:"queue_size" env:"QUEUE_SIZE" env-default:"1000"`
+}
+
+// EventBusConfig publishes events of users to NATS, events are published
+// to subjects "<subject>.<type>", e.g. "sso.events.user.registered".
+// Publishing is disabled when URL is empty.
+type EventBusConfig struct {
+	// URL is the address of the broker, e.g. nats://localhost:4222.
+	URL     string `yaml:"url" env:"URL"`
+	Subject string `yaml:"subject" env:"SUBJECT" env-default:"sso.events"`
+	// BufferSize is how many bytes of events are kept while the broker is unreachable.
+	BufferSize int `yaml:"buffer_size" env:"BUFFER_SIZE" env-default:"8388608"`
+}
+
+// TracingConfig configures export of OpenTelemetry traces.
+// Tracing is a no-op when Endpoint is empty.
+type TracingConfig struct {
+	// Endpoint is the host:port of an OTLP/gRPC collector.
+	Endpoint string `yaml:"endpoint" env:"ENDPOINT"`
+	Insecure bool   `yaml:"insecure" env:"INSECURE"`
+}
+
+// IsPostgres reports whether storagePath is a postgres connection string,
+// otherwise it is a path to sqlite database file.
+func IsPostgres(storagePath string) bool {
+	return strings.HasPrefix(storagePath, "postgres://") || strings.HasPrefix(storagePath, "postgresql://")
 }
 
 func MustLoad() *Config {
+	cfg, err := Load()
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return cfg
+}
+
+// Load reads the config file given by the --config flag or CONFIG_PATH,
+// applies overrides from the environment and validates the result.
+func Load() (*Config, error) {
 	path := fetchConfigPath()
 	if path == "" {
-		panic("config file not exist")
+		return nil, errors.New("config file not exist")
 	}
 
 	if _, err := os.Stat(path); os.IsNotExist(err) {
-		panic("config file not exist: " + path)
+		return nil, fmt.Errorf("config file not exist: %s", path)
 	}
 
 	var cfg Config
 
+	unsetEmptyEnv(envPrefix)
+
 	if err := cleanenv.ReadConfig(path, &cfg); err != nil {
-		panic("failed to read config: " + err.Error())
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s:\n%w", path, err)
 	}
 
-	return &cfg
+	return &cfg, nil
+}
+
+// envPrefix is the prefix of environment variables overriding the config.
+const envPrefix = "SSO_"
+
+// unsetEmptyEnv unsets empty environment variables with given prefix,
+// so an empty variable, e.g. left over in a container spec,
+// doesn't clobber the value from the config file.
+func unsetEmptyEnv(prefix string) {
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(key, prefix) && value == "" {
+			_ = os.Unsetenv(key)
+		}
+	}
 }
 
 func fetchConfigPath() string {
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"log/slog"
+	"net/url"
+)
+
+// redacted replaces secrets in logged config.
+const redacted = "REDACTED"
+
+// LogValue logs the config with secrets redacted: the mail password,
+// the webhook secret, OAuth client secrets and passwords in postgres connection strings and broker URLs.
+func (c *Config) LogValue() slog.Value {
+	// plain has the fields of Config but not its methods, so it is logged as a struct.
+	type plain Config
+
+	cp := plain(*c)
+	cp.StoragePath = redactURL(cp.StoragePath)
+	cp.StorageReplicaPath = redactURL(cp.StorageReplicaPath)
+	cp.DualWrite.SecondaryPath = redactURL(cp.DualWrite.SecondaryPath)
+	cp.EventBus.URL = redactUserinfo(cp.EventBus.URL)
+	if cp.Mail.Password != "" {
+		cp.Mail.Password = redacted
+	}
+	if cp.Webhook.Secret != "" {
+		cp.Webhook.Secret = redacted
+	}
+	if cp.OAuth.Google.ClientSecret != "" {
+		cp.OAuth.Google.ClientSecret = redacted
+	}
+	if cp.OAuth.GitHub.ClientSecret != "" {
+		cp.OAuth.GitHub.ClientSecret = redacted
+	}
+	if len(cp.OAuth.Providers) > 0 {
+		// The map is shared with c, so it is copied before secrets are redacted.
+		providers := make(map[string]OIDCProviderConfig, len(cp.OAuth.Providers))
+		for name, p := range cp.OAuth.Providers {
+			p.ClientSecret = redacted
+			providers[name] = p
+		}
+		cp.OAuth.Providers = providers
+	}
+
+	return slog.AnyValue(cp)
+}
+
+// Summary returns attributes describing how the service runs,
+// they are logged on start instead of the whole config.
+func (c *Config) Summary() []any {
+	storage := "sqlite"
+	if IsPostgres(c.StoragePath) {
+		storage = "postgres"
+	}
+
+	return []any{
+		slog.String("env", c.Env),
+		slog.Int("grpc_port", c.GRPC.Port),
+		slog.Int("grpc_admin_port", c.GRPC.Admin.Port),
+		slog.Int("http_port", c.HTTP.Port),
+		slog.Int("gateway_port", c.Gateway.Port),
+		slog.String("storage", storage),
+		slog.Bool("storage_replica", c.StorageReplicaPath != ""),
+		slog.Bool("dual_write", c.DualWrite.SecondaryPath != ""),
+		slog.Bool("webhook", c.Webhook.URL != ""),
+		slog.Bool("event_bus", c.EventBus.URL != ""),
+		slog.Bool("tls", c.GRPC.TLS.CertPath != ""),
+		slog.Bool("maintenance", c.Maintenance.Enabled),
+		slog.Bool("google_sign_in", c.OAuth.Google.ClientID != ""),
+		slog.Bool("github_sign_in", c.OAuth.GitHub.ClientID != ""),
+		slog.Int("oidc_providers", len(c.OAuth.Providers)),
+		slog.Bool("passkeys", c.Passkeys.RPID != ""),
+		slog.Int("token_exchange_clients", len(c.TokenExchange.Clients)),
+		slog.Int("scope_apps", len(c.Scopes.Apps)),
+		slog.String("jwt_algorithm", c.JWT.Algorithm),
+		slog.String("password_algorithm", c.Password.Algorithm),
+	}
+}
+
+// redactURL hides the password in a connection string, other paths are returned as is.
+func redactURL(path string) string {
+	if !IsPostgres(path) {
+		return path
+	}
+
+	return redactUserinfo(path)
+}
+
+// redactUserinfo hides the password in a URL, e.g. of a broker.
+func redactUserinfo(rawURL string) string {
+	if rawURL == "" {
+		return rawURL
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		// An unparsable string may still contain a password.
+		return redacted
+	}
+
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+
+	// Passwords may also be given as a query parameter.
+	if q := u.Query(); q.Has("password") {
+		q.Set("password", redacted)
+		u.RawQuery = q.Encode()
+	}
+
+	return u.String()
+}
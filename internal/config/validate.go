@@ -0,0 +1,364 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	ssov1 "github.com/roxxxiey/protos/gen/go/sso"
+	"net/url"
+	"slices"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/mail"
+	"sso/internal/lib/password"
+	"strings"
+	"time"
+)
+
+const (
+	envLocal = "local"
+	envDev   = "dev"
+	envProd  = "prod"
+)
+
+// Values of PasswordConfig.ExpiredAction.
+const (
+	PasswordExpiredWarn  = "warn"
+	PasswordExpiredBlock = "block"
+)
+
+// Values of ScopesConfig.DeniedAction.
+const (
+	ScopesDeniedDrop = "drop"
+	ScopesDeniedDeny = "deny"
+)
+
+// Values of TokenCookieConfig.SameSite.
+const (
+	SameSiteStrict = "strict"
+	SameSiteLax    = "lax"
+	SameSiteNone   = "none"
+)
+
+// Names of identity providers configured by their own sections of OAuthConfig.
+const (
+	ProviderGoogle = "google"
+	ProviderGitHub = "github"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// Validate checks that the config makes sense, so misconfiguration is
+// reported on start instead of causing confusing failures at runtime.
+// All problems found are returned together.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Env == envLocal || c.Env == envDev || c.Env == envProd,
+		"env must be one of %s, %s, %s, got %q", envLocal, envDev, envProd, c.Env)
+	check(c.Log.Format == "" || c.Log.Format == logFormatText || c.Log.Format == logFormatJSON,
+		"log.format must be %s or %s, got %q", logFormatText, logFormatJSON, c.Log.Format)
+	check(c.StoragePath != "", "storage_path is required")
+	check(c.StorageReplicaPath == "" || IsPostgres(c.StoragePath) && IsPostgres(c.StorageReplicaPath),
+		"storage_replica_path is only supported with postgres, both paths must be postgres connection strings")
+	check(c.DualWrite.SecondaryPath == "" || c.DualWrite.SecondaryPath != c.StoragePath,
+		"dual_write.secondary_path must differ from storage_path")
+	check(c.TokenTTl > 0, "token_ttl must be positive, got %s", c.TokenTTl)
+	check(c.RefreshTTL >= 0, "refresh_token_ttl must not be negative, got %s", c.RefreshTTL)
+
+	check(c.StoragePool.MaxOpenConns > 0, "storage_pool.max_open_conns must be positive, got %d", c.StoragePool.MaxOpenConns)
+	check(c.StoragePool.MaxIdleConns >= 0 && c.StoragePool.MaxIdleConns <= c.StoragePool.MaxOpenConns,
+		"storage_pool.max_idle_conns must be in 0-%d (max_open_conns), got %d", c.StoragePool.MaxOpenConns, c.StoragePool.MaxIdleConns)
+	check(c.StoragePool.ConnMaxLifetime >= 0,
+		"storage_pool.conn_max_lifetime must not be negative, got %s", c.StoragePool.ConnMaxLifetime)
+	check(c.StorageRetry.Attempts > 0, "storage_retry.attempts must be positive, got %d", c.StorageRetry.Attempts)
+	if c.StorageRetry.Attempts > 1 {
+		check(c.StorageRetry.Backoff > 0, "storage_retry.backoff must be positive, got %s", c.StorageRetry.Backoff)
+		check(c.StorageRetry.MaxBackoff >= c.StorageRetry.Backoff,
+			"storage_retry.max_backoff must be at least %s (backoff), got %s", c.StorageRetry.Backoff, c.StorageRetry.MaxBackoff)
+	}
+
+	check(validPort(c.GRPC.Port), "grpc.port must be in 1-65535, got %d", c.GRPC.Port)
+	check(c.GRPC.Timeout >= 0, "grpc.timeout must not be negative, got %s", c.GRPC.Timeout)
+	for method, timeout := range c.GRPC.MethodTimeouts {
+		check(isAuthMethod(method), "grpc.method_timeouts: unknown method %q", method)
+		check(timeout >= 0, "grpc.method_timeouts.%s must not be negative, got %s", method, timeout)
+	}
+	check(c.GRPC.HealthCheckInterval > 0, "grpc.health_check_interval must be positive, got %s", c.GRPC.HealthCheckInterval)
+	check(c.GRPC.ShutdownTimeout >= 0, "grpc.shutdown_timeout must not be negative, got %s", c.GRPC.ShutdownTimeout)
+	check(c.GRPC.MaxRecvMsgSize > 0, "grpc.max_recv_msg_size must be positive, got %d", c.GRPC.MaxRecvMsgSize)
+	check(c.GRPC.MaxEmailLength > 0 && c.GRPC.MaxEmailLength <= mail.MaxAddressLength,
+		"grpc.max_email_length must be in 1-%d, got %d", mail.MaxAddressLength, c.GRPC.MaxEmailLength)
+	check(c.GRPC.MaxPasswordLength >= c.Password.MinLength,
+		"grpc.max_password_length must be at least password.min_length, got %d", c.GRPC.MaxPasswordLength)
+	check(c.Password.Algorithm != password.AlgBcrypt || c.GRPC.MaxPasswordLength <= password.MaxBcryptLength,
+		"grpc.max_password_length must be at most %d with bcrypt, got %d", password.MaxBcryptLength, c.GRPC.MaxPasswordLength)
+	check((c.GRPC.TLS.CertPath == "") == (c.GRPC.TLS.KeyPath == ""),
+		"grpc.tls.cert_path and grpc.tls.key_path must be set together")
+	check(c.GRPC.TLS.ClientCAPath == "" || c.GRPC.TLS.CertPath != "",
+		"grpc.tls.client_ca_path requires grpc.tls.cert_path and grpc.tls.key_path")
+	check(c.GRPC.Admin.Port == 0 || validPort(c.GRPC.Admin.Port), "grpc.admin.port must be in 1-65535 or 0, got %d", c.GRPC.Admin.Port)
+	check(c.GRPC.Admin.Port == 0 || c.GRPC.Admin.Port != c.GRPC.Port, "grpc.admin.port and grpc.port must differ, both are %d", c.GRPC.Port)
+	check((c.GRPC.Admin.TLS.CertPath == "") == (c.GRPC.Admin.TLS.KeyPath == ""),
+		"grpc.admin.tls.cert_path and grpc.admin.tls.key_path must be set together")
+	check(c.GRPC.Admin.TLS.ClientCAPath == "" || c.GRPC.Admin.TLS.CertPath != "",
+		"grpc.admin.tls.client_ca_path requires grpc.admin.tls.cert_path and grpc.admin.tls.key_path")
+
+	check(c.HTTP.Port == 0 || validPort(c.HTTP.Port), "http.port must be in 1-65535 or 0, got %d", c.HTTP.Port)
+	check(c.HTTP.Port == 0 || c.HTTP.Port != c.GRPC.Port && c.HTTP.Port != c.GRPC.Admin.Port,
+		"http.port must differ from grpc.port and grpc.admin.port, got %d", c.HTTP.Port)
+	check(c.Gateway.Port == 0 || validPort(c.Gateway.Port), "gateway.port must be in 1-65535 or 0, got %d", c.Gateway.Port)
+	check(c.Gateway.Port == 0 || c.Gateway.Port != c.GRPC.Port && c.Gateway.Port != c.GRPC.Admin.Port && c.Gateway.Port != c.HTTP.Port,
+		"gateway.port must differ from grpc.port, grpc.admin.port and http.port, got %d", c.Gateway.Port)
+	for _, origin := range c.Gateway.CORS.AllowedOrigins {
+		if origin == "*" {
+			check(c.Env != envProd, "gateway.cors.allowed_origins must list origins explicitly in %s, got *", envProd)
+			check(!c.Gateway.CORS.AllowCredentials, "gateway.cors.allowed_origins must list origins explicitly with allow_credentials, got *")
+			continue
+		}
+		check(validOrigin(origin), "gateway.cors.allowed_origins must be like https://example.com, got %q", origin)
+	}
+	check(c.Gateway.CORS.MaxAge >= 0, "gateway.cors.max_age must not be negative, got %s", c.Gateway.CORS.MaxAge)
+	if cookie := c.Gateway.TokenCookie; cookie.Enabled {
+		check(cookie.Name != "", "gateway.token_cookie.name is required")
+		check(cookie.SameSite == SameSiteStrict || cookie.SameSite == SameSiteLax || cookie.SameSite == SameSiteNone,
+			"gateway.token_cookie.same_site must be %s, %s or %s, got %q", SameSiteStrict, SameSiteLax, SameSiteNone, cookie.SameSite)
+		check(!cookie.Insecure || cookie.SameSite != SameSiteNone, "gateway.token_cookie.insecure can't be used with same_site %s", SameSiteNone)
+		check(!cookie.Insecure || c.Env != envProd, "gateway.token_cookie.insecure is not allowed in %s", envProd)
+	}
+
+	switch c.JWT.Algorithm {
+	case "HS256":
+	case "RS256":
+		switch c.JWT.KeySource {
+		case "file":
+			check(c.JWT.PrivateKeyPath != "", "jwt.private_key_path is required for RS256")
+		case "env":
+			check(c.JWT.PrivateKeyEnv != "", "jwt.private_key_env is required for RS256")
+		default:
+			errs = append(errs, fmt.Errorf("jwt.key_source must be file or env, got %q", c.JWT.KeySource))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("jwt.algorithm must be HS256 or RS256, got %q", c.JWT.Algorithm))
+	}
+
+	check(c.JWT.Issuer != "", "jwt.issuer is required")
+	check(c.JWT.Leeway >= 0, "jwt.leeway must not be negative, got %s", c.JWT.Leeway)
+	check(c.JWT.KeyRetention >= 0, "jwt.key_retention must not be negative, got %s", c.JWT.KeyRetention)
+	check(c.JWT.KeyRetention == 0 || c.JWT.KeyRetention >= c.TokenTTl,
+		"jwt.key_retention must be at least token_ttl, got %s", c.JWT.KeyRetention)
+
+	check(c.RateLimit.Attempts >= 0, "rate_limit.attempts must not be negative, got %d", c.RateLimit.Attempts)
+	check(c.RateLimit.Attempts == 0 || c.RateLimit.Window > 0, "rate_limit.window must be positive, got %s", c.RateLimit.Window)
+	check(c.Lockout.Attempts >= 0, "lockout.attempts must not be negative, got %d", c.Lockout.Attempts)
+	check(c.Lockout.Attempts == 0 || c.Lockout.Duration > 0, "lockout.duration must be positive, got %s", c.Lockout.Duration)
+	if b := c.LoginBackoff; b.BaseDelay != 0 {
+		check(b.BaseDelay > 0, "login_backoff.base_delay must not be negative, got %s", b.BaseDelay)
+		check(b.MaxDelay >= b.BaseDelay, "login_backoff.max_delay must be at least base_delay %s, got %s", b.BaseDelay, b.MaxDelay)
+		check(b.Factor >= 1, "login_backoff.factor must be at least 1, got %g", b.Factor)
+		check(b.ResetAfter > 0, "login_backoff.reset_after must be positive, got %s", b.ResetAfter)
+		loginTimeout := c.GRPC.Timeout
+		if t, ok := c.GRPC.MethodTimeouts["Login"]; ok {
+			loginTimeout = t
+		}
+		check(b.MaxDelay < loginTimeout, "login_backoff.max_delay must be less than the timeout of Login %s, got %s", loginTimeout, b.MaxDelay)
+	}
+
+	check(c.Maintenance.RetryAfter > 0, "maintenance.retry_after must be positive, got %s", c.Maintenance.RetryAfter)
+
+	check(c.AppCache.TTL >= 0, "app_cache.ttl must not be negative, got %s", c.AppCache.TTL)
+	check(c.AppCache.TTL == 0 || c.AppCache.Size > 0, "app_cache.size must be positive, got %d", c.AppCache.Size)
+	check(c.RoleCache.TTL >= 0, "role_cache.ttl must not be negative, got %s", c.RoleCache.TTL)
+	check(c.RoleCache.TTL == 0 || c.RoleCache.Size > 0, "role_cache.size must be positive, got %d", c.RoleCache.Size)
+
+	check(c.DefaultRole.Role == "" || c.DefaultRole.AppID > 0,
+		"default_role.app_id must be positive when default_role.role is set, got %d", c.DefaultRole.AppID)
+
+	check(c.Password.MinLength > 0, "password.min_length must be positive, got %d", c.Password.MinLength)
+	check(c.Password.History >= 0, "password.history must not be negative, got %d", c.Password.History)
+	check(c.Password.MaxAge >= 0, "password.max_age must not be negative, got %s", c.Password.MaxAge)
+	check(c.Password.ExpiredAction == PasswordExpiredWarn || c.Password.ExpiredAction == PasswordExpiredBlock,
+		"password.expired_action must be %s or %s, got %q", PasswordExpiredWarn, PasswordExpiredBlock, c.Password.ExpiredAction)
+	switch c.Password.Algorithm {
+	case "bcrypt":
+		check(c.Password.BcryptCost >= 4 && c.Password.BcryptCost <= 31,
+			"password.bcrypt_cost must be in 4-31, got %d", c.Password.BcryptCost)
+		check(!c.Password.BcryptCalibrate || c.Password.BcryptTarget > 0 && c.Password.BcryptTarget <= maxBcryptTarget,
+			"password.bcrypt_target must be in (0, %s], got %s", maxBcryptTarget, c.Password.BcryptTarget)
+	case "argon2id":
+		check(c.Password.Argon2Memory > 0, "password.argon2_memory must be positive")
+		check(c.Password.Argon2Time > 0, "password.argon2_time must be positive")
+		check(c.Password.Argon2Threads > 0, "password.argon2_threads must be positive")
+	default:
+		errs = append(errs, fmt.Errorf("password.algorithm must be bcrypt or argon2id, got %q", c.Password.Algorithm))
+	}
+
+	check(c.Verification.TokenTTL > 0, "verification.token_ttl must be positive, got %s", c.Verification.TokenTTL)
+	check(c.PasswordReset.TokenTTL > 0, "password_reset.token_ttl must be positive, got %s", c.PasswordReset.TokenTTL)
+	check(c.MagicLink.TokenTTL > 0 && c.MagicLink.TokenTTL <= maxMagicLinkTTL,
+		"magic_link.token_ttl must be in (0, %s], got %s", maxMagicLinkTTL, c.MagicLink.TokenTTL)
+
+	if google := c.OAuth.Google; google.ClientID != "" {
+		check(google.ClientSecret != "", "oauth.google.client_secret is required when oauth.google.client_id is set")
+		check(validHTTPURL(google.RedirectURL), "oauth.google.redirect_url must be an http or https URL, got %q", google.RedirectURL)
+	}
+	if gh := c.OAuth.GitHub; gh.ClientID != "" {
+		check(gh.ClientSecret != "", "oauth.github.client_secret is required when oauth.github.client_id is set")
+		check(validHTTPURL(gh.RedirectURL), "oauth.github.redirect_url must be an http or https URL, got %q", gh.RedirectURL)
+		check(slices.Contains(gh.Scopes, "user:email") || slices.Contains(gh.Scopes, "user"),
+			"oauth.github.scopes must include user:email to read verified emails")
+	}
+	for name, p := range c.OAuth.Providers {
+		check(validProviderName(name), "oauth.providers names must be lowercase letters, digits, - and _, got %q", name)
+		check(name != ProviderGoogle && name != ProviderGitHub, "oauth.providers.%s is reserved, use oauth.%s", name, name)
+		check(validHTTPURL(p.Issuer), "oauth.providers.%s.issuer must be an http or https URL, got %q", name, p.Issuer)
+		check(p.ClientID != "" && p.ClientSecret != "", "oauth.providers.%s.client_id and client_secret are required", name)
+		check(validHTTPURL(p.RedirectURL), "oauth.providers.%s.redirect_url must be an http or https URL, got %q", name, p.RedirectURL)
+		check(len(p.Scopes) == 0 || slices.Contains(p.Scopes, "openid"), "oauth.providers.%s.scopes must include openid", name)
+		check(p.AppID >= 0, "oauth.providers.%s.app_id must not be negative, got %d", name, p.AppID)
+		check(p.AppID == 0 || len(p.RedirectURIs) > 0, "oauth.providers.%s.redirect_uris are required with app_id", name)
+		for _, uri := range p.RedirectURIs {
+			check(uri != "", "oauth.providers.%s.redirect_uris must not be empty", name)
+		}
+	}
+	if c.OAuth.Google.ClientID != "" || c.OAuth.GitHub.ClientID != "" || len(c.OAuth.Providers) > 0 {
+		check(c.HTTP.Port != 0, "http.port is required for oauth")
+		check(c.OAuth.StateTTL > 0, "oauth.state_ttl must be positive, got %s", c.OAuth.StateTTL)
+		check(!c.OAuth.Insecure || c.Env != envProd, "oauth.insecure is not allowed in %s", envProd)
+	}
+
+	if p := c.Passkeys; p.RPID != "" {
+		check(p.RPDisplayName != "", "passkeys.rp_display_name is required when passkeys.rp_id is set")
+		check(len(p.RPOrigins) > 0, "passkeys.rp_origins are required when passkeys.rp_id is set")
+		for _, origin := range p.RPOrigins {
+			check(validOrigin(origin) && onDomain(origin, p.RPID),
+				"passkeys.rp_origins must be like https://%s, on it or its subdomains, got %q", p.RPID, origin)
+		}
+		check(p.Timeout > 0, "passkeys.timeout must be positive, got %s", p.Timeout)
+	}
+
+	clients := make(map[int]bool, len(c.TokenExchange.Clients))
+	for _, client := range c.TokenExchange.Clients {
+		check(client.AppID > 0, "token_exchange.clients app_id must be positive, got %d", client.AppID)
+		check(!clients[client.AppID], "token_exchange.clients app_id %d is listed more than once", client.AppID)
+		clients[client.AppID] = true
+
+		check(len(client.Audiences) > 0, "token_exchange.clients audiences of app %d are required", client.AppID)
+		for _, audience := range client.Audiences {
+			check(audience > 0 && audience != client.AppID,
+				"token_exchange.clients audiences of app %d must be ids of other apps, got %d", client.AppID, audience)
+		}
+	}
+
+	check(c.Scopes.DeniedAction == ScopesDeniedDrop || c.Scopes.DeniedAction == ScopesDeniedDeny,
+		"scopes.denied_action must be %s or %s, got %q", ScopesDeniedDrop, ScopesDeniedDeny, c.Scopes.DeniedAction)
+	scopeApps := make(map[int]bool, len(c.Scopes.Apps))
+	for _, app := range c.Scopes.Apps {
+		check(app.AppID > 0, "scopes.apps app_id must be positive, got %d", app.AppID)
+		check(!scopeApps[app.AppID], "scopes.apps app_id %d is listed more than once", app.AppID)
+		scopeApps[app.AppID] = true
+
+		for _, scope := range app.Scopes {
+			check(jwt.ValidScope(scope), "scopes.apps scopes of app %d must be printable without spaces, got %q", app.AppID, scope)
+		}
+		for role, scopes := range app.Roles {
+			for _, scope := range scopes {
+				check(jwt.ValidScope(scope),
+					"scopes.apps roles.%s of app %d must be printable without spaces, got %q", role, app.AppID, scope)
+			}
+		}
+	}
+
+	if c.Mail.Host != "" {
+		check(validPort(c.Mail.Port), "mail.port must be in 1-65535, got %d", c.Mail.Port)
+		check(c.Mail.From != "", "mail.from is required when mail.host is set")
+	}
+
+	if c.Webhook.URL != "" {
+		check(validHTTPURL(c.Webhook.URL), "webhook.url must be an http or https URL, got %q", c.Webhook.URL)
+		check(c.Webhook.Timeout > 0, "webhook.timeout must be positive, got %s", c.Webhook.Timeout)
+		check(c.Webhook.Attempts > 0, "webhook.attempts must be positive, got %d", c.Webhook.Attempts)
+		check(c.Webhook.Attempts == 1 || c.Webhook.Backoff > 0, "webhook.backoff must be positive, got %s", c.Webhook.Backoff)
+		check(c.Webhook.QueueSize > 0, "webhook.queue_size must be positive, got %d", c.Webhook.QueueSize)
+	}
+
+	if c.EventBus.URL != "" {
+		check(validSubject(c.EventBus.Subject),
+			"event_bus.subject must be dot separated tokens without wildcards, got %q", c.EventBus.Subject)
+		check(c.EventBus.BufferSize > 0, "event_bus.buffer_size must be positive, got %d", c.EventBus.BufferSize)
+	}
+
+	return errors.Join(errs...)
+}
+
+// maxBcryptTarget keeps calibration on start short, see password.CalibrateCost.
+const maxBcryptTarget = 5 * time.Second
+
+// maxMagicLinkTTL keeps magic links short-lived, emails are read long after they are sent.
+const maxMagicLinkTTL = time.Hour
+
+// validSubject reports whether s is a NATS subject events can be published under.
+func validSubject(s string) bool {
+	for _, token := range strings.Split(s, ".") {
+		if token == "" || token == "*" || token == ">" || strings.ContainsAny(token, " \t\r\n") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validOrigin reports whether s is an origin as browsers send it: scheme, host and port only.
+func validOrigin(s string) bool {
+	u, err := url.Parse(s)
+
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" &&
+		u.Path == "" && u.RawQuery == "" && u.Fragment == "" && u.User == nil
+}
+
+// onDomain reports whether the host of origin is domain or its subdomain,
+// browsers refuse passkeys of domain on other origins.
+func onDomain(origin string, domain string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	host := u.Hostname()
+
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// validProviderName reports whether s can name an identity provider, it is used in paths.
+func validProviderName(s string) bool {
+	return s != "" && !strings.ContainsFunc(s, func(r rune) bool {
+		return (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' && r != '_'
+	})
+}
+
+func validHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+// isAuthMethod reports whether the Auth service has a method with given name.
+func isAuthMethod(name string) bool {
+	for _, m := range ssov1.Auth_ServiceDesc.Methods {
+		if m.MethodName == name {
+			return true
+		}
+	}
+
+	return false
+}
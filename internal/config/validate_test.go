@@ -0,0 +1,87 @@
+package config
+
+import (
+	"github.com/ilyakaznacheev/cleanenv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validConfig returns the example config shipped with the service, it must be valid.
+func validConfig(t *testing.T) *Config {
+	t.Helper()
+
+	var cfg Config
+	if err := cleanenv.ReadConfig("../../config/local.yaml", &cfg); err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("example config is invalid: %v", err)
+	}
+
+	return &cfg
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   string
+	}{
+		{"unknown env", func(c *Config) { c.Env = "staging" }, "env must be one of"},
+		{"no storage path", func(c *Config) { c.StoragePath = "" }, "storage_path is required"},
+		{"zero token ttl", func(c *Config) { c.TokenTTl = 0 }, "token_ttl must be positive"},
+		{"negative token ttl", func(c *Config) { c.TokenTTl = -time.Minute }, "token_ttl must be positive"},
+		{"negative refresh ttl", func(c *Config) { c.RefreshTTL = -time.Minute }, "refresh_token_ttl must not be negative"},
+		{"zero max open conns", func(c *Config) { c.StoragePool.MaxOpenConns = 0 }, "storage_pool.max_open_conns must be positive"},
+		{"more idle than open conns", func(c *Config) { c.StoragePool.MaxIdleConns = c.StoragePool.MaxOpenConns + 1 }, "storage_pool.max_idle_conns must be in"},
+		{"zero port", func(c *Config) { c.GRPC.Port = 0 }, "grpc.port must be in 1-65535"},
+		{"port out of range", func(c *Config) { c.GRPC.Port = 65536 }, "grpc.port must be in 1-65535"},
+		{"negative timeout", func(c *Config) { c.GRPC.Timeout = -time.Second }, "grpc.timeout must not be negative"},
+		{"any cors origin in prod", func(c *Config) {
+			c.Env = envProd
+			c.Gateway.CORS.AllowedOrigins = []string{"*"}
+		}, "gateway.cors.allowed_origins must list origins explicitly in prod"},
+		{"cors origin with path", func(c *Config) {
+			c.Gateway.CORS.AllowedOrigins = []string{"https://app.example.com/login"}
+		}, "gateway.cors.allowed_origins must be like https://example.com"},
+		{"unknown jwt algorithm", func(c *Config) { c.JWT.Algorithm = "none" }, "jwt.algorithm must be HS256 or RS256"},
+		{"no issuer", func(c *Config) { c.JWT.Issuer = "" }, "jwt.issuer is required"},
+		{"zero max email length", func(c *Config) { c.GRPC.MaxEmailLength = 0 }, "grpc.max_email_length must be in 1-254"},
+		{"max email length beyond SMTP", func(c *Config) { c.GRPC.MaxEmailLength = 300 }, "grpc.max_email_length must be in 1-254"},
+		{"bcrypt cost out of range", func(c *Config) { c.Password.BcryptCost = 32 }, "password.bcrypt_cost must be in 4-31"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig(t)
+			tt.modify(cfg)
+
+			err := cfg.Validate()
+			if err == nil {
+				t.Fatal("invalid config passed validation")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error %q doesn't mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	cfg := validConfig(t)
+	cfg.StoragePath = ""
+	cfg.GRPC.Port = 0
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("invalid config passed validation")
+	}
+
+	for _, want := range []string{"storage_path", "grpc.port"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't mention %s", err, want)
+		}
+	}
+}
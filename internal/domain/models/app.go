@@ -1,7 +1,16 @@
 package models
 
+import "time"
+
 type App struct {
-	ID     int
-	Name   string
+	ID   int
+	Name string
+	// Secret is the key HS256 tokens of the app are signed with.
 	Secret string
+	// SecretHash is the hash of the secret the app authenticates with.
+	// It is empty for apps added to the database by hand.
+	SecretHash []byte
+	// TokenTTL is the lifetime of access tokens issued for the app,
+	// 0 means the service default is used.
+	TokenTTL time.Duration
 }
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Types of events.
+const (
+	EventUserRegistered = "user.registered"
+	EventUserLoggedIn   = "user.logged_in"
+	EventUserDeleted    = "user.deleted"
+)
+
+// Event tells other services about something that happened to a user,
+// e.g. so they can provision or remove the account on their side.
+type Event struct {
+	// ID is unique per event, events may be delivered more than once
+	// and receivers drop the ones they have seen.
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	UserID int64  `json:"user_id"`
+	// AppID is the app the user logged into, 0 for events not tied to an app.
+	AppID int       `json:"app_id"`
+	Time  time.Time `json:"time"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Identity is an account of the user at an external identity provider, e.g. Google.
+type Identity struct {
+	// Provider is the name of the provider in config, e.g. "google".
+	Provider string
+	// Subject is the id of the account at the provider, it never changes.
+	Subject string
+	// Email is the email of the account, it may change at the provider.
+	Email         string
+	EmailVerified bool
+}
+
+// OAuthState is a sign in with an identity provider that has been started,
+// it is consumed when the provider redirects the user back.
+// Only the hash of the state sent to the provider is stored.
+type OAuthState struct {
+	StateHash string
+	Provider  string
+	AppID     int
+	// UserID is the user linking the identity to their account, 0 for sign ins.
+	UserID int64
+	// Nonce is echoed in the ID token, so ID tokens of other sign ins are rejected.
+	Nonce string
+	// Verifier is the PKCE code verifier, so only this service can exchange the code.
+	Verifier  string
+	ExpiresAt time.Time
+}
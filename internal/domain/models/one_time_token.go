@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Purposes of one-time tokens.
+const (
+	PurposeEmailVerification = "email_verification"
+	PurposePasswordReset     = "password_reset"
+	PurposeEmailChange       = "email_change"
+	PurposeMagicLink         = "magic_link"
+)
+
+// OneTimeToken is a single-use token sent to the user, e.g. to verify email.
+// Only the hash of the token is stored.
+type OneTimeToken struct {
+	TokenHash string
+	UserID    int64
+	Purpose   string
+	// Email is the new email of the user for email change tokens
+	// and the email the link was sent to for magic link tokens.
+	Email     string
+	ExpiresAt time.Time
+}
@@ -0,0 +1,58 @@
+package models
+
+import "time"
+
+// Purposes of passkey challenges, see PasskeyChallenge.
+const (
+	PurposePasskeyRegistration = "passkey_registration"
+	PurposePasskeyLogin        = "passkey_login"
+)
+
+// Passkey is a WebAuthn credential of the user, e.g. kept by a phone or a security key.
+// The private key never leaves the authenticator, only its public key is stored.
+type Passkey struct {
+	// ID is the credential id generated by the authenticator.
+	ID     []byte
+	UserID int64
+	// PublicKey is COSE encoded, assertions of the authenticator are verified with it.
+	PublicKey       []byte
+	AttestationType string
+	// Transports are how the authenticator can be reached, e.g. "usb" or "internal".
+	Transports []string
+	// AAGUID identifies the model of the authenticator, it is zero for most passkeys.
+	AAGUID []byte
+	// SignCount is the signature counter of the last assertion. Authenticators increase it
+	// on every assertion, a counter that goes back means the credential has been cloned.
+	SignCount uint32
+	// BackupEligible is whether the passkey can be synced to other devices, it never changes.
+	BackupEligible bool
+	// BackupState is whether the passkey is synced, as of the last assertion.
+	BackupState bool
+	CreatedAt   time.Time
+	// LastUsedAt is zero until the passkey is used to log in.
+	LastUsedAt time.Time
+}
+
+// PasskeyAssertion is a verified login with a passkey.
+type PasskeyAssertion struct {
+	// Passkey is the passkey of the login as stored before it.
+	Passkey Passkey
+	// SignCount is the signature counter the authenticator reported.
+	SignCount   uint32
+	BackupState bool
+}
+
+// PasskeyChallenge is a registration or login with a passkey that has been started,
+// it is consumed when the client sends the response of the authenticator.
+// Only the hash of the id sent to the client is stored.
+type PasskeyChallenge struct {
+	IDHash  string
+	Purpose string
+	// UserID is the user registering a passkey, 0 for logins.
+	UserID int64
+	// AppID is the app of the login, 0 for registrations.
+	AppID int
+	// Session is the state of the WebAuthn ceremony, it holds the challenge the authenticator signs.
+	Session   []byte
+	ExpiresAt time.Time
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+type RefreshToken struct {
+	TokenHash string
+	UserID    int64
+	AppID     int
+	// SessionID is the session the token was issued for, 0 for tokens issued before sessions.
+	SessionID int64
+	// Scopes were granted at login, access tokens issued by the refresh token carry them
+	// as long as the user still may have them.
+	Scopes    []string
+	ExpiresAt time.Time
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Session is a login of a user on a device. It lasts as long as refresh tokens
+// issued by the login can be used and ends when it is revoked.
+type Session struct {
+	ID     int64
+	UserID int64
+	AppID  int
+	// IP is the address of the client, empty if it is unknown,
+	// e.g. when the client connected over a unix socket.
+	IP        string
+	UserAgent string
+	CreatedAt time.Time
+	// LastUsedAt is when the session was last refreshed, or created.
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+}
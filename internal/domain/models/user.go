@@ -1,7 +1,19 @@
 package models
 
+import "time"
+
 type User struct {
 	ID       int64
 	Email    string
 	PassHash []byte
+	Verified bool
+	// FailedLogins is the number of consecutive failed logins.
+	FailedLogins int
+	// LockedUntil is zero if the account has never been locked.
+	LockedUntil time.Time
+	// TokenVersion is embedded into access tokens of the user,
+	// tokens with an older version are rejected, see auth.LogoutAll.
+	TokenVersion int64
+	// PasswordChangedAt is when the password was set, rehashing doesn't change it.
+	PasswordChangedAt time.Time
 }
@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"slices"
+	"sso/internal/lib/caller"
+	"strings"
+)
+
+// authorizationHeader carries the access token of the caller, e.g. "Bearer <token>".
+const authorizationHeader = "authorization"
+
+// bearerToken returns the access token from the authorization metadata.
+func bearerToken(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	values := md.Get(authorizationHeader)
+	if len(values) == 0 {
+		return "", false
+	}
+
+	token, ok := strings.CutPrefix(values[0], "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+
+	return token, true
+}
+
+// Policy is who may call a protected method, besides presenting a valid access token.
+// A zero Policy lets any authenticated user call the method.
+type Policy struct {
+	// Admin lets admins call the method.
+	Admin bool
+	// Self lets the user the request is about, i.e. its user_id field, call the method.
+	Self bool
+	// Role lets users having the role in the app of their token call the method.
+	Role string
+}
+
+// Policies of protected methods, Authenticate rejects calls of them without
+// a valid access token and Authorize enforces the policy.
+// Login, Register and other methods used before the user has a token stay public,
+// as does ExchangeToken, whose caller authenticates as an app with its secret instead.
+var Policies = map[string]Policy{
+	"/auth.Auth/CreateApp":      {Admin: true},
+	"/auth.Auth/DeleteApp":      {Admin: true},
+	"/auth.Auth/ListUsers":      {Admin: true},
+	"/auth.Auth/AreAdmins":      {Admin: true},
+	"/auth.Auth/IsAdmin":        {Admin: true, Self: true},
+	"/auth.Auth/DeleteUser":     {Admin: true, Self: true},
+	"/auth.Auth/GetUser":        {Admin: true, Self: true},
+	"/auth.Auth/ListSessions":   {Admin: true, Self: true},
+	"/auth.Auth/RevokeSession":  {Admin: true, Self: true},
+	"/auth.Auth/LogoutAll":      {Admin: true, Self: true},
+	"/auth.Auth/UnlockUser":     {Admin: true},
+	"/auth.Auth/UserRoles":      {Admin: true, Self: true},
+	"/auth.Auth/HasRole":        {Admin: true, Self: true},
+	"/auth.Auth/ChangeEmail":    {},
+	"/auth.Auth/SetMaintenance": {Admin: true},
+
+	"/auth.Auth/BeginPasskeyRegistration":  {},
+	"/auth.Auth/FinishPasskeyRegistration": {},
+}
+
+// userRequest is a request about a user, see Policy.Self.
+type userRequest interface {
+	GetUserId() int64
+}
+
+// allows reports whether the policy lets c make req without being an admin.
+func (p Policy) allows(c caller.Caller, req any) bool {
+	if p == (Policy{}) {
+		return true
+	}
+
+	if p.Self {
+		if r, ok := req.(userRequest); ok && r.GetUserId() == c.UserID {
+			return true
+		}
+	}
+
+	return p.Role != "" && slices.Contains(c.Roles, p.Role)
+}
+
+// Authenticate returns an interceptor that, for methods having a policy, validates the bearer
+// access token of the call and puts its user into the context, see caller.FromContext.
+// Calls without a valid token are rejected with Unauthenticated.
+// Other methods are passed through untouched.
+func Authenticate(auth Auth, policies map[string]Policy) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		if _, ok := policies[info.FullMethod]; !ok {
+			return handler(ctx, req)
+		}
+
+		token, ok := bearerToken(ctx)
+		if !ok {
+			return nil, reasonError(codes.Unauthenticated, reasonTokenRequired, "access token is required")
+		}
+
+		userID, appID, _, err := auth.ValidateToken(ctx, token, 0)
+		if err != nil {
+			return nil, serviceError(err)
+		}
+
+		roles, err := auth.UserRoles(ctx, userID, appID)
+		if err != nil {
+			return nil, serviceError(err)
+		}
+
+		ctx = caller.NewContext(ctx, caller.Caller{
+			UserID: userID,
+			AppID:  appID,
+			Roles:  roles,
+		})
+
+		return handler(ctx, req)
+	}
+}
+
+// Authorize returns an interceptor enforcing policies of methods, calls not allowed
+// by the policy of their method are rejected with PermissionDenied.
+// It must follow Authenticate, which puts the caller into the context.
+func Authorize(auth Auth, policies map[string]Policy) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		policy, ok := policies[info.FullMethod]
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		c, ok := caller.FromContext(ctx)
+		if !ok {
+			return nil, reasonError(codes.Unauthenticated, reasonTokenRequired, "access token is required")
+		}
+
+		if policy.allows(c, req) {
+			return handler(ctx, req)
+		}
+
+		if !policy.Admin {
+			if policy.Role != "" {
+				return nil, reasonError(codes.PermissionDenied, reasonRoleRequired, "role "+policy.Role+" is required")
+			}
+
+			return nil, status.Error(codes.PermissionDenied, "permission denied")
+		}
+
+		isAdmin, err := auth.IsAdmin(ctx, uint64(c.UserID))
+		if err != nil {
+			return nil, serviceError(err)
+		}
+
+		if !isAdmin {
+			return nil, reasonError(codes.PermissionDenied, reasonAdminRequired, "admin privileges are required")
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// authenticate returns the id of the user whose access token the caller presented.
+// The method must have a policy, so the token has already been validated.
+func (s *serverAPI) authenticate(ctx context.Context) (int64, error) {
+	c, ok := caller.FromContext(ctx)
+	if !ok {
+		return 0, reasonError(codes.Unauthenticated, reasonTokenRequired, "access token is required")
+	}
+
+	return c.UserID, nil
+}
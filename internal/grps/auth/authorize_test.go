@@ -0,0 +1,91 @@
+package auth
+
+import (
+	ssov1 "github.com/roxxxiey/protos/gen/go/sso"
+	"sso/internal/lib/caller"
+	"testing"
+)
+
+func TestUnlockUserIsAdminOnly(t *testing.T) {
+	p, ok := Policies["/auth.Auth/UnlockUser"]
+	if !ok {
+		t.Fatal("UnlockUser has no policy, it is callable without a token")
+	}
+
+	if p != (Policy{Admin: true}) {
+		t.Fatalf("got policy %+v, want admins only", p)
+	}
+
+	// Users can't unlock themselves, a lock must hold until it expires or an admin lifts it.
+	if p.allows(caller.Caller{UserID: 1}, &ssov1.UnlockUserRequest{UserId: 1}) {
+		t.Fatal("policy lets a non-admin unlock")
+	}
+}
+
+func TestRoleLookupsAreSelfOrAdmin(t *testing.T) {
+	tests := []struct {
+		method string
+		self   any
+		other  any
+	}{
+		{"/auth.Auth/UserRoles", &ssov1.UserRolesRequest{UserId: 1, AppId: 1}, &ssov1.UserRolesRequest{UserId: 2, AppId: 1}},
+		{"/auth.Auth/HasRole", &ssov1.HasRoleRequest{UserId: 1, AppId: 1, Role: "admin"}, &ssov1.HasRoleRequest{UserId: 2, AppId: 1, Role: "admin"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			p, ok := Policies[tt.method]
+			if !ok {
+				t.Fatal("method has no policy, roles of any user are readable without a token")
+			}
+
+			if p != (Policy{Admin: true, Self: true}) {
+				t.Fatalf("got policy %+v, want the user themselves or admins", p)
+			}
+
+			c := caller.Caller{UserID: 1}
+			if !p.allows(c, tt.self) {
+				t.Fatal("policy doesn't let users read their own roles")
+			}
+			// Others are left to the admin check.
+			if p.allows(c, tt.other) {
+				t.Fatal("policy lets a non-admin read roles of another user")
+			}
+		})
+	}
+}
+
+func TestAreAdminsIsAdminOnly(t *testing.T) {
+	p, ok := Policies["/auth.Auth/AreAdmins"]
+	if !ok {
+		t.Fatal("AreAdmins has no policy, admin status of any users is readable without a token")
+	}
+
+	if p != (Policy{Admin: true}) {
+		t.Fatalf("got policy %+v, want admins only", p)
+	}
+
+	// Asking about oneself in a batch doesn't help.
+	if p.allows(caller.Caller{UserID: 1}, &ssov1.AreAdminsRequest{UserIds: []int64{1}}) {
+		t.Fatal("policy lets a non-admin look up admins")
+	}
+}
+
+func TestIsAdminIsSelfOrAdmin(t *testing.T) {
+	p, ok := Policies["/auth.Auth/IsAdmin"]
+	if !ok {
+		t.Fatal("IsAdmin has no policy, admin status of any user is readable without a token")
+	}
+
+	if p != (Policy{Admin: true, Self: true}) {
+		t.Fatalf("got policy %+v, want the user themselves or admins", p)
+	}
+
+	c := caller.Caller{UserID: 1}
+	if !p.allows(c, &ssov1.IsAdminRequest{UserId: 1}) {
+		t.Fatal("policy doesn't let users check themselves")
+	}
+	if p.allows(c, &ssov1.IsAdminRequest{UserId: 2}) {
+		t.Fatal("policy lets a non-admin check another user")
+	}
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"sso/internal/services/auth"
+)
+
+// errorDomain is the domain of ErrorInfo details returned by the service.
+const errorDomain = "sso"
+
+// Reasons of ErrorInfo details, clients should switch on them rather than on messages.
+const (
+	reasonInvalidCredentials  = "INVALID_CREDENTIALS"
+	reasonInvalidAppSecret    = "INVALID_APP_SECRET"
+	reasonAccountLocked       = "ACCOUNT_LOCKED"
+	reasonEmailNotVerified    = "EMAIL_NOT_VERIFIED"
+	reasonTooManyAttempts     = "TOO_MANY_ATTEMPTS"
+	reasonUserExists          = "USER_EXISTS"
+	reasonEmailTaken          = "EMAIL_TAKEN"
+	reasonUserNotFound        = "USER_NOT_FOUND"
+	reasonAppExists           = "APP_EXISTS"
+	reasonAppNotFound         = "APP_NOT_FOUND"
+	reasonInvalidToken        = "INVALID_TOKEN"
+	reasonInvalidRefreshToken = "INVALID_REFRESH_TOKEN"
+	reasonTokenRequired       = "TOKEN_REQUIRED"
+	reasonAdminRequired       = "ADMIN_REQUIRED"
+	reasonRoleRequired        = "ROLE_REQUIRED"
+	reasonSessionNotFound     = "SESSION_NOT_FOUND"
+	reasonPasswordExpired     = "PASSWORD_EXPIRED"
+
+	reasonAccountLinkRequired      = "ACCOUNT_LINK_REQUIRED"
+	reasonIdentityLinked           = "IDENTITY_LINKED"
+	reasonIdentityEmailNotVerified = "IDENTITY_EMAIL_NOT_VERIFIED"
+
+	reasonPasskeysDisabled = "PASSKEYS_DISABLED"
+	reasonInvalidPasskey   = "INVALID_PASSKEY"
+	reasonPasskeyCloned    = "PASSKEY_CLONED"
+
+	reasonExchangeNotAllowed = "EXCHANGE_NOT_ALLOWED"
+	reasonScopeNotAllowed    = "SCOPE_NOT_ALLOWED"
+)
+
+// reasons of service errors, errors without one are returned without ErrorInfo.
+var reasons = map[*auth.Error]string{
+	auth.ErrInvalidCredentials: reasonInvalidCredentials,
+	auth.ErrInvalidAppSecret:   reasonInvalidAppSecret,
+	auth.ErrAccountLocked:      reasonAccountLocked,
+	auth.ErrEmailNotVerified:   reasonEmailNotVerified,
+	auth.ErrUserExists:         reasonUserExists,
+	auth.ErrUserNotFound:       reasonUserNotFound,
+	auth.ErrAppExists:          reasonAppExists,
+	auth.ErrAppNotFound:        reasonAppNotFound,
+	auth.ErrInvalidToken:       reasonInvalidToken,
+	auth.ErrInvalidRefresh:     reasonInvalidRefreshToken,
+	auth.ErrSessionNotFound:    reasonSessionNotFound,
+	auth.ErrPasswordExpired:    reasonPasswordExpired,
+
+	auth.ErrAccountLinkRequired:      reasonAccountLinkRequired,
+	auth.ErrIdentityLinked:           reasonIdentityLinked,
+	auth.ErrIdentityEmailNotVerified: reasonIdentityEmailNotVerified,
+
+	auth.ErrPasskeysDisabled: reasonPasskeysDisabled,
+	auth.ErrInvalidPasskey:   reasonInvalidPasskey,
+	auth.ErrPasskeyCloned:    reasonPasskeyCloned,
+
+	auth.ErrExchangeNotAllowed: reasonExchangeNotAllowed,
+	auth.ErrScopeNotAllowed:    reasonScopeNotAllowed,
+}
+
+// serviceError converts an error of the Auth service to a gRPC error,
+// the code is chosen by the kind of the error.
+//
+// Internal errors are not described to the client, they are logged by the service.
+func serviceError(err error) error {
+	var e *auth.Error
+	if !errors.As(err, &e) {
+		return status.Error(codes.Internal, "internal error")
+	}
+
+	code := codeOf(e.Kind())
+	if code == codes.Internal {
+		return status.Error(codes.Internal, "internal error")
+	}
+
+	reason, ok := reasons[e]
+	if !ok {
+		return status.Error(code, e.Error())
+	}
+
+	return reasonError(code, reason, e.Error())
+}
+
+// codeOf returns the gRPC code for errors of the kind.
+func codeOf(kind auth.Kind) codes.Code {
+	switch kind {
+	case auth.KindInvalidArgument:
+		return codes.InvalidArgument
+	case auth.KindNotFound:
+		return codes.NotFound
+	case auth.KindAlreadyExists:
+		return codes.AlreadyExists
+	case auth.KindUnauthenticated:
+		return codes.Unauthenticated
+	case auth.KindPermissionDenied:
+		return codes.PermissionDenied
+	case auth.KindFailedPrecondition:
+		return codes.FailedPrecondition
+	default:
+		return codes.Internal
+	}
+}
+
+// fieldError returns an InvalidArgument error with a BadRequest detail
+// naming the invalid field of the request, so clients can point at it.
+// field is the name of the field in the proto message, e.g. "email".
+func fieldError(field string, description string) error {
+	return withDetails(status.New(codes.InvalidArgument, description), &errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: field, Description: description},
+		},
+	})
+}
+
+// emailTakenError tells the client that the email in field is registered to another user,
+// with both an ErrorInfo and a BadRequest detail, so forms can show it next to the field.
+func emailTakenError(field string) error {
+	const msg = "email is already taken"
+
+	return withDetails(status.New(codes.AlreadyExists, msg),
+		&errdetails.ErrorInfo{
+			Reason:   reasonEmailTaken,
+			Domain:   errorDomain,
+			Metadata: map[string]string{"field": field},
+		},
+		&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: field, Description: msg},
+			},
+		},
+	)
+}
+
+// reasonError returns an error with an ErrorInfo detail carrying reason.
+func reasonError(code codes.Code, reason string, msg string) error {
+	return withDetails(status.New(code, msg), &errdetails.ErrorInfo{
+		Reason: reason,
+		Domain: errorDomain,
+	})
+}
+
+// withDetails attaches details to st, if that fails the error is returned without them.
+func withDetails(st *status.Status, details ...protoadapt.MessageV1) error {
+	detailed, err := st.WithDetails(details...)
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
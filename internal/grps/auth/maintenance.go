@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"context"
+	ssov1 "github.com/roxxxiey/protos/gen/go/sso"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"math"
+	"strconv"
+	"time"
+)
+
+// reasonMaintenance is the reason of calls refused during maintenance.
+const reasonMaintenance = "MAINTENANCE"
+
+// retryAfterHeader tells clients in seconds when to retry a call refused during maintenance,
+// the gateway sends it as the Grpc-Metadata-Retry-After header.
+const retryAfterHeader = "retry-after"
+
+// MaintenanceMode is whether maintenance is on, see maintenance.Mode.
+type MaintenanceMode interface {
+	Enabled() bool
+	// Set turns maintenance on or off and reports whether it was on before.
+	Set(enabled bool) (was bool)
+}
+
+// MaintenanceMethods are refused during maintenance, they start new sessions or create users.
+// Other methods, e.g. Validate and IsAdmin, keep working, so issued tokens stay usable.
+//
+// Refresh and ExchangeToken issue tokens too, but they are allowed on purpose: they only
+// renew or narrow sessions started before maintenance, which would otherwise end for their
+// users in the middle of it. Revoked sessions and tokens still can't be renewed.
+var MaintenanceMethods = map[string]bool{
+	"/auth.Auth/Register":      true,
+	"/auth.Auth/Login":         true,
+	"/auth.Auth/LoginWithOIDC": true,
+
+	"/auth.Auth/SendMagicLink":      true,
+	"/auth.Auth/LoginWithMagicLink": true,
+
+	"/auth.Auth/BeginPasskeyLogin":  true,
+	"/auth.Auth/FinishPasskeyLogin": true,
+}
+
+// Maintenance returns an interceptor refusing calls of methods with UNAVAILABLE while mode is on.
+// The error has a RetryInfo detail with retryAfter, which is sent in the retry-after header as well.
+// If mode is nil, all calls are passed through.
+func Maintenance(mode MaintenanceMode, methods map[string]bool, retryAfter time.Duration) grpc.UnaryServerInterceptor {
+	seconds := strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		if mode == nil || !methods[info.FullMethod] || !mode.Enabled() {
+			return handler(ctx, req)
+		}
+
+		_ = grpc.SetHeader(ctx, metadata.Pairs(retryAfterHeader, seconds))
+
+		return nil, withDetails(status.New(codes.Unavailable, "service is under maintenance, try again shortly"),
+			&errdetails.ErrorInfo{
+				Reason: reasonMaintenance,
+				Domain: errorDomain,
+			},
+			&errdetails.RetryInfo{
+				RetryDelay: durationpb.New(retryAfter),
+			},
+		)
+	}
+}
+
+func (s *serverAPI) SetMaintenance(
+	_ context.Context,
+	req *ssov1.SetMaintenanceRequest,
+) (*ssov1.SetMaintenanceResponse, error) {
+	if s.maintenance == nil {
+		return nil, status.Error(codes.Unimplemented, "maintenance mode is not supported")
+	}
+
+	s.maintenance.Set(req.GetEnabled())
+
+	return &ssov1.SetMaintenanceResponse{
+		Enabled: req.GetEnabled(),
+	}, nil
+}
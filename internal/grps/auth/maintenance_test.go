@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	ssov1 "github.com/roxxxiey/protos/gen/go/sso"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sso/internal/lib/maintenance"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceToggle(t *testing.T) {
+	mode := maintenance.New(false)
+	s := &serverAPI{maintenance: mode}
+	interceptor := Maintenance(mode, MaintenanceMethods, 30*time.Second)
+
+	handler := func(ctx context.Context, req any) (any, error) { return "served", nil }
+	call := func(method string) error {
+		_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+
+	refused := []string{"/auth.Auth/Login", "/auth.Auth/Register"}
+	// Sessions started before maintenance go on, see MaintenanceMethods.
+	served := []string{"/auth.Auth/Validate", "/auth.Auth/IsAdmin", "/auth.Auth/Refresh", "/auth.Auth/ExchangeToken"}
+
+	for _, method := range append(refused, served...) {
+		if err := call(method); err != nil {
+			t.Fatalf("%s before maintenance: %v", method, err)
+		}
+	}
+
+	resp, err := s.SetMaintenance(context.Background(), &ssov1.SetMaintenanceRequest{Enabled: true})
+	if err != nil || !resp.GetEnabled() {
+		t.Fatalf("enable maintenance: %v, %v", resp, err)
+	}
+
+	for _, method := range refused {
+		st := status.Convert(call(method))
+		if st.Code() != codes.Unavailable {
+			t.Fatalf("%s during maintenance: got %v, want Unavailable", method, st.Err())
+		}
+
+		var retry *errdetails.RetryInfo
+		for _, d := range st.Details() {
+			if d, ok := d.(*errdetails.RetryInfo); ok {
+				retry = d
+			}
+		}
+		if retry == nil || retry.GetRetryDelay().AsDuration() != 30*time.Second {
+			t.Fatalf("%s during maintenance: got RetryInfo %v, want a delay of 30s", method, retry)
+		}
+	}
+	for _, method := range served {
+		if err := call(method); err != nil {
+			t.Fatalf("%s during maintenance: %v", method, err)
+		}
+	}
+
+	if _, err := s.SetMaintenance(context.Background(), &ssov1.SetMaintenanceRequest{Enabled: false}); err != nil {
+		t.Fatalf("disable maintenance: %v", err)
+	}
+
+	for _, method := range refused {
+		if err := call(method); err != nil {
+			t.Fatalf("%s after maintenance: %v", method, err)
+		}
+	}
+}
+
+func TestSetMaintenanceIsAdminOnly(t *testing.T) {
+	if p := Policies["/auth.Auth/SetMaintenance"]; p != (Policy{Admin: true}) {
+		t.Fatalf("got policy %+v, want admin only", p)
+	}
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"context"
+	ssov1 "github.com/roxxxiey/protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// AdminMethods returns methods only admins can call, by full name, e.g. CreateApp.
+// They can be served on a port of their own, see Methods.
+func AdminMethods() map[string]bool {
+	methods := make(map[string]bool)
+	for method, p := range Policies {
+		if p == (Policy{Admin: true}) {
+			methods[method] = true
+		}
+	}
+
+	return methods
+}
+
+// Methods returns an interceptor refusing calls of Auth methods not in methods with UNIMPLEMENTED,
+// as if the server didn't have them. If except is set, it refuses calls of methods in methods instead.
+// Calls of other services, e.g. health checks, are passed through.
+func Methods(methods map[string]bool, except bool) grpc.UnaryServerInterceptor {
+	prefix := "/" + ssov1.Auth_ServiceDesc.ServiceName + "/"
+
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		if !strings.HasPrefix(info.FullMethod, prefix) || methods[info.FullMethod] != except {
+			return handler(ctx, req)
+		}
+
+		return nil, status.Errorf(codes.Unimplemented, "method %s is not served on this port", info.FullMethod)
+	}
+}
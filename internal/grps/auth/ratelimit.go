@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"context"
+	"sso/internal/lib/clientinfo"
+	"strings"
+)
+
+// loginKeys are the limiter keys of a login attempt.
+type loginKeys struct {
+	email string
+	// ip is empty if the client address is unknown.
+	ip string
+}
+
+func loginLimitKeys(ctx context.Context, email string) loginKeys {
+	keys := loginKeys{
+		email: "email:" + strings.ToLower(strings.TrimSpace(email)),
+	}
+
+	if ip := clientinfo.FromContext(ctx).IP; ip != "" {
+		keys.ip = "ip:" + ip
+	}
+
+	return keys
+}
+
+func (s *serverAPI) allowLogin(keys loginKeys) bool {
+	if s.loginLimiter == nil {
+		return true
+	}
+
+	if !s.loginLimiter.Allow(keys.email) {
+		return false
+	}
+
+	return keys.ip == "" || s.loginLimiter.Allow(keys.ip)
+}
+
+func (s *serverAPI) failLogin(keys loginKeys) {
+	if s.loginLimiter == nil {
+		return
+	}
+
+	s.loginLimiter.Fail(keys.email)
+
+	if keys.ip != "" {
+		s.loginLimiter.Fail(keys.ip)
+	}
+}
+
+// resetLogin forgets failed attempts of the email after successful login.
+//
+// The ip counter is kept, so an attacker can't reset it by logging into their own account.
+func (s *serverAPI) resetLogin(keys loginKeys) {
+	if s.loginLimiter == nil {
+		return
+	}
+
+	s.loginLimiter.Reset(keys.email)
+}
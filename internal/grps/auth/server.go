@@ -2,33 +2,115 @@ package auth
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	ssov1 "github.com/roxxxiey/protos/gen/go/sso"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	"net/mail"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	libmail "sso/internal/lib/mail"
+	"sso/internal/lib/password"
+	"sso/internal/lib/secret"
+	"sso/internal/services/auth"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Auth interface {
 	Login(
 		ctx context.Context,
 		email string,
-		password string,
+		password secret.Password,
 		asppId int,
-	) (token string, err error)
+		appSecret string,
+		requestedScopes []string,
+	) (auth.LoginResult, error)
 	RegisterNewUser(
 		ctx context.Context,
 		email string,
-		password string,
-	) (user uint64, err error)
+		password secret.Password,
+		idempotent bool,
+	) (user uint64, existed bool, err error)
 	IsAdmin(ctx context.Context, userID uint64) (bool, error)
+	AreAdmins(ctx context.Context, userIDs []int64) (map[int64]bool, error)
+	Logout(ctx context.Context, token string) error
+	RefreshToken(
+		ctx context.Context,
+		refreshToken string,
+		appID int,
+	) (token string, newRefreshToken string, err error)
+	ValidateToken(
+		ctx context.Context,
+		token string,
+		expectedAppID int,
+	) (userID int64, appID int, expiresAt time.Time, err error)
+	ChangePassword(
+		ctx context.Context,
+		email string,
+		oldPassword secret.Password,
+		newPassword secret.Password,
+	) error
+	VerifyEmail(ctx context.Context, token string) error
+	RequestPasswordReset(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token string, newPassword secret.Password) error
+	UserRoles(ctx context.Context, userID int64, appID int) ([]string, error)
+	HasRole(ctx context.Context, userID int64, appID int, role string) (bool, error)
+	DeleteUser(ctx context.Context, userID int64) error
+	CreateApp(ctx context.Context, name string, secret string) (appID int, err error)
+	DeleteApp(ctx context.Context, appID int) error
+	GetUser(ctx context.Context, userID int64) (models.User, error)
+	ChangeEmail(ctx context.Context, userID int64, newEmail string) (pending bool, err error)
+	ListUsers(ctx context.Context, limit int, offset int) ([]models.User, error)
+	CountUsers(ctx context.Context) (int, error)
+	ListSessions(ctx context.Context, userID int64) ([]models.Session, error)
+	RevokeSession(ctx context.Context, userID int64, sessionID int64) error
+	LogoutAll(ctx context.Context, userID int64) error
+	UnlockUser(ctx context.Context, userID int64) error
+	VerifyPassword(ctx context.Context, email string, password secret.Password) (bool, error)
+	LoginWithOIDC(ctx context.Context, provider string, code string, redirectURI string) (token string, err error)
+	SendMagicLink(ctx context.Context, email string) error
+	LoginWithMagicLink(ctx context.Context, token string, appID int) (string, error)
+	BeginRegistration(ctx context.Context, userID int64) (options []byte, challengeID string, err error)
+	FinishRegistration(ctx context.Context, userID int64, challengeID string, response []byte) error
+	BeginLogin(ctx context.Context, appID int) (options []byte, challengeID string, err error)
+	FinishLogin(ctx context.Context, challengeID string, response []byte) (string, error)
+	ExchangeToken(
+		ctx context.Context,
+		clientID int,
+		clientSecret string,
+		subjectToken string,
+		audience string,
+	) (string, error)
+}
+
+// LoginLimiter throttles failed login attempts.
+type LoginLimiter interface {
+	Allow(key string) bool
+	Fail(key string)
+	Reset(key string)
 }
+
 type serverAPI struct {
 	ssov1.UnimplementedAuthServer
-	auth Auth
+	auth         Auth
+	loginLimiter LoginLimiter
+	maintenance  MaintenanceMode
 }
 
-func Register(gRPC *grpc.Server, auth Auth) {
-	ssov1.RegisterAuthServer(gRPC, &serverAPI{auth: auth})
+// Register registers the auth service on gRPC server.
+//
+// loginLimiter may be nil, in which case login attempts are not throttled.
+// maintenance may be nil, in which case SetMaintenance is unimplemented.
+func Register(gRPC *grpc.Server, auth Auth, loginLimiter LoginLimiter, maintenance MaintenanceMode) {
+	ssov1.RegisterAuthServer(gRPC, &serverAPI{
+		auth:         auth,
+		loginLimiter: loginLimiter,
+		maintenance:  maintenance,
+	})
 }
 
 const (
@@ -42,14 +124,42 @@ func (s *serverAPI) Login(
 	if err := validationLogin(req); err != nil {
 		return nil, err
 	}
-	// TODO: implement login via auth service
-	token, err := s.auth.Login(ctx, req.GetEmail(), req.GetPassword(), int(req.GetAppId()))
+
+	limitKeys := loginLimitKeys(ctx, req.GetEmail())
+	if !s.allowLogin(limitKeys) {
+		return nil, reasonError(codes.ResourceExhausted, reasonTooManyAttempts, "too many login attempts, try again later")
+	}
+
+	res, err := s.auth.Login(
+		ctx,
+		req.GetEmail(),
+		secret.Password(req.GetPassword()),
+		int(req.GetAppId()),
+		req.GetAppSecret(),
+		req.GetScopes(),
+	)
 	if err != nil {
-		//TODO: ...
-		return nil, status.Error(codes.Internal, "internal error")
+		if errors.Is(err, auth.ErrInvalidAppID) {
+			return nil, fieldError("app_id", "invalid app id")
+		}
+		// Wrong credentials and app secrets count towards the limit, locked accounts don't.
+		if auth.KindOf(err) == auth.KindUnauthenticated {
+			s.failLogin(limitKeys)
+		}
+
+		return nil, serviceError(err)
 	}
+
+	s.resetLogin(limitKeys)
+
 	return &ssov1.LoginResponse{
-		Token: token,
+		Token:        res.Token,
+		RefreshToken: res.RefreshToken,
+		UserId:       res.UserID,
+		ExpiresAt:    res.ExpiresAt.Unix(),
+
+		PasswordExpired: res.PasswordExpired,
+		Scopes:          res.Scopes,
 	}, nil
 }
 
@@ -61,14 +171,38 @@ func (s *serverAPI) Register(
 		return nil, err
 	}
 
-	userID, err := s.auth.RegisterNewUser(ctx, req.GetEmail(), req.GetPassword())
+	// Idempotent requests check the password of the existing user,
+	// so they are throttled like logins.
+	var limitKeys loginKeys
+	if req.GetIdempotent() {
+		limitKeys = loginLimitKeys(ctx, req.GetEmail())
+		if !s.allowLogin(limitKeys) {
+			return nil, reasonError(codes.ResourceExhausted, reasonTooManyAttempts, "too many attempts, try again later")
+		}
+	}
+
+	userID, existed, err := s.auth.RegisterNewUser(ctx, req.GetEmail(), secret.Password(req.GetPassword()), req.GetIdempotent())
 	if err != nil {
-		//TODO: ...
-		return nil, status.Error(codes.Internal, "internal error")
+		if errors.Is(err, auth.ErrWeakPassword) {
+			return nil, weakPasswordError("password", err)
+		}
+		if errors.Is(err, auth.ErrPasswordTooLong) {
+			return nil, fieldError("password", "password is too long")
+		}
+		if errors.Is(err, auth.ErrUserExists) {
+			if req.GetIdempotent() {
+				s.failLogin(limitKeys)
+			}
+
+			return nil, emailTakenError("email")
+		}
+
+		return nil, serviceError(err)
 	}
 
 	return &ssov1.RegisterResponse{
-		UserId: int64(userID),
+		UserId:  int64(userID),
+		Existed: existed,
 	}, nil
 
 }
@@ -78,43 +212,1032 @@ func (s *serverAPI) IsAdmin(
 	req *ssov1.IsAdminRequest,
 ) (*ssov1.IsAdminResponse, error) {
 	if err := validationIsAdmin(req); err != nil {
-		return nil, status.Error(codes.Internal, "internal error")
+		return nil, err
 	}
 
-	isAdmin, _ := s.auth.IsAdmin(ctx, uint64(req.GetUserId()))
+	isAdmin, err := s.auth.IsAdmin(ctx, uint64(req.GetUserId()))
+	if err != nil {
+		return nil, serviceError(err)
+	}
 
 	return &ssov1.IsAdminResponse{
 		IsAdmin: isAdmin,
 	}, nil
+}
 
+func (s *serverAPI) AreAdmins(
+	ctx context.Context,
+	req *ssov1.AreAdminsRequest,
+) (*ssov1.AreAdminsResponse, error) {
+	if err := validationAreAdmins(req); err != nil {
+		return nil, err
+	}
+
+	admins, err := s.auth.AreAdmins(ctx, req.GetUserIds())
+	if err != nil {
+		return nil, serviceError(err)
+	}
+
+	return &ssov1.AreAdminsResponse{
+		IsAdmin: admins,
+	}, nil
 }
 
-func validationLogin(req *ssov1.LoginRequest) error {
-	if req.GetEmail() == "" {
-		return status.Error(codes.InvalidArgument, "email is required")
+func (s *serverAPI) Logout(
+	ctx context.Context,
+	req *ssov1.LogoutRequest,
+) (*ssov1.LogoutResponse, error) {
+	if err := validationLogout(req); err != nil {
+		return nil, err
 	}
-	if req.GetPassword() == "" {
-		return status.Error(codes.InvalidArgument, "password is required")
+
+	if err := s.auth.Logout(ctx, req.GetToken()); err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			return nil, fieldError("token", "invalid token")
+		}
+
+		return nil, serviceError(err)
 	}
-	if req.GetAppId() == emptyValue {
-		return status.Error(codes.InvalidArgument, "appId is required")
+
+	return &ssov1.LogoutResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *serverAPI) Refresh(
+	ctx context.Context,
+	req *ssov1.RefreshRequest,
+) (*ssov1.RefreshResponse, error) {
+	if err := validationRefresh(req); err != nil {
+		return nil, err
 	}
-	return nil
+
+	token, refreshToken, err := s.auth.RefreshToken(ctx, req.GetRefreshToken(), int(req.GetAppId()))
+	if err != nil {
+		return nil, serviceError(err)
+	}
+
+	return &ssov1.RefreshResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+	}, nil
 }
 
-func validationRegister(req *ssov1.RegisterRequest) error {
-	if req.GetEmail() == "" {
-		return status.Error(codes.InvalidArgument, "email is required")
+func (s *serverAPI) Validate(
+	ctx context.Context,
+	req *ssov1.ValidateRequest,
+) (*ssov1.ValidateResponse, error) {
+	if err := validationValidate(req); err != nil {
+		return nil, err
 	}
-	if req.GetPassword() == "" {
-		return status.Error(codes.InvalidArgument, "password is required")
+
+	userID, appID, expiresAt, err := s.auth.ValidateToken(ctx, req.GetToken(), int(req.GetAppId()))
+	if err != nil {
+		return nil, serviceError(err)
 	}
-	return nil
+
+	return &ssov1.ValidateResponse{
+		UserId:    userID,
+		AppId:     int32(appID),
+		ExpiresAt: expiresAt.Unix(),
+	}, nil
 }
 
-func validationIsAdmin(req *ssov1.IsAdminRequest) error {
-	if req.GetUserId() == 0 {
-		return status.Error(codes.InvalidArgument, "userId is required")
+func (s *serverAPI) ChangePassword(
+	ctx context.Context,
+	req *ssov1.ChangePasswordRequest,
+) (*ssov1.ChangePasswordResponse, error) {
+	if err := validationChangePassword(req); err != nil {
+		return nil, err
+	}
+
+	// The old password is checked like in Login, so guessing it shares the limits of logins.
+	limitKeys := loginLimitKeys(ctx, req.GetEmail())
+	if !s.allowLogin(limitKeys) {
+		return nil, reasonError(codes.ResourceExhausted, reasonTooManyAttempts, "too many attempts, try again later")
+	}
+
+	err := s.auth.ChangePassword(ctx,
+		req.GetEmail(),
+		secret.Password(req.GetOldPassword()),
+		secret.Password(req.GetNewPassword()),
+	)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			s.failLogin(limitKeys)
+		}
+		if errors.Is(err, auth.ErrWeakPassword) {
+			return nil, weakPasswordError("new_password", err)
+		}
+		if errors.Is(err, auth.ErrPasswordTooLong) {
+			return nil, fieldError("new_password", "password is too long")
+		}
+		if errors.Is(err, auth.ErrPasswordReused) {
+			return nil, fieldError("new_password", "password was used recently")
+		}
+
+		return nil, serviceError(err)
+	}
+
+	s.resetLogin(limitKeys)
+
+	return &ssov1.ChangePasswordResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *serverAPI) VerifyEmail(
+	ctx context.Context,
+	req *ssov1.VerifyEmailRequest,
+) (*ssov1.VerifyEmailResponse, error) {
+	if err := validationVerifyEmail(req); err != nil {
+		return nil, err
+	}
+
+	if err := s.auth.VerifyEmail(ctx, req.GetToken()); err != nil {
+		if errors.Is(err, auth.ErrInvalidOneTimeToken) {
+			return nil, fieldError("token", "invalid or expired token")
+		}
+		if errors.Is(err, auth.ErrUserExists) {
+			return nil, reasonError(codes.AlreadyExists, reasonEmailTaken, "email is already taken")
+		}
+
+		return nil, serviceError(err)
+	}
+
+	return &ssov1.VerifyEmailResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *serverAPI) RequestPasswordReset(
+	ctx context.Context,
+	req *ssov1.RequestPasswordResetRequest,
+) (*ssov1.RequestPasswordResetResponse, error) {
+	if err := validationRequestPasswordReset(req); err != nil {
+		return nil, err
+	}
+
+	if err := s.auth.RequestPasswordReset(ctx, req.GetEmail()); err != nil {
+		return nil, serviceError(err)
+	}
+
+	return &ssov1.RequestPasswordResetResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *serverAPI) ResetPassword(
+	ctx context.Context,
+	req *ssov1.ResetPasswordRequest,
+) (*ssov1.ResetPasswordResponse, error) {
+	if err := validationResetPassword(req); err != nil {
+		return nil, err
+	}
+
+	if err := s.auth.ResetPassword(ctx, req.GetToken(), secret.Password(req.GetNewPassword())); err != nil {
+		if errors.Is(err, auth.ErrInvalidOneTimeToken) {
+			return nil, fieldError("token", "invalid or expired token")
+		}
+		if errors.Is(err, auth.ErrWeakPassword) {
+			return nil, weakPasswordError("new_password", err)
+		}
+		if errors.Is(err, auth.ErrPasswordTooLong) {
+			return nil, fieldError("new_password", "password is too long")
+		}
+		if errors.Is(err, auth.ErrPasswordReused) {
+			return nil, fieldError("new_password", "password was used recently")
+		}
+
+		return nil, serviceError(err)
+	}
+
+	return &ssov1.ResetPasswordResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *serverAPI) UserRoles(
+	ctx context.Context,
+	req *ssov1.UserRolesRequest,
+) (*ssov1.UserRolesResponse, error) {
+	if err := validationUserRoles(req); err != nil {
+		return nil, err
+	}
+
+	roles, err := s.auth.UserRoles(ctx, req.GetUserId(), int(req.GetAppId()))
+	if err != nil {
+		return nil, serviceError(err)
+	}
+
+	return &ssov1.UserRolesResponse{
+		Roles: roles,
+	}, nil
+}
+
+func (s *serverAPI) HasRole(
+	ctx context.Context,
+	req *ssov1.HasRoleRequest,
+) (*ssov1.HasRoleResponse, error) {
+	if err := validationHasRole(req); err != nil {
+		return nil, err
+	}
+
+	hasRole, err := s.auth.HasRole(ctx, req.GetUserId(), int(req.GetAppId()), req.GetRole())
+	if err != nil {
+		return nil, serviceError(err)
+	}
+
+	return &ssov1.HasRoleResponse{
+		HasRole: hasRole,
+	}, nil
+}
+
+func (s *serverAPI) DeleteUser(
+	ctx context.Context,
+	req *ssov1.DeleteUserRequest,
+) (*ssov1.DeleteUserResponse, error) {
+	if err := validationDeleteUser(req); err != nil {
+		return nil, err
+	}
+
+	if err := s.auth.DeleteUser(ctx, req.GetUserId()); err != nil {
+		return nil, serviceError(err)
+	}
+
+	return &ssov1.DeleteUserResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *serverAPI) CreateApp(
+	ctx context.Context,
+	req *ssov1.CreateAppRequest,
+) (*ssov1.CreateAppResponse, error) {
+	if err := validationCreateApp(req); err != nil {
+		return nil, err
+	}
+
+	appID, err := s.auth.CreateApp(ctx, req.GetName(), req.GetSecret())
+	if err != nil {
+		if errors.Is(err, auth.ErrSecretTooLong) {
+			return nil, fieldError("secret", "secret is too long")
+		}
+
+		return nil, serviceError(err)
+	}
+
+	return &ssov1.CreateAppResponse{
+		AppId: int32(appID),
+	}, nil
+}
+
+func (s *serverAPI) DeleteApp(
+	ctx context.Context,
+	req *ssov1.DeleteAppRequest,
+) (*ssov1.DeleteAppResponse, error) {
+	if err := validationDeleteApp(req); err != nil {
+		return nil, err
+	}
+
+	if err := s.auth.DeleteApp(ctx, int(req.GetAppId())); err != nil {
+		return nil, serviceError(err)
+	}
+
+	return &ssov1.DeleteAppResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *serverAPI) GetUser(
+	ctx context.Context,
+	req *ssov1.GetUserRequest,
+) (*ssov1.GetUserResponse, error) {
+	if err := validationGetUser(req); err != nil {
+		return nil, err
+	}
+
+	user, err := s.auth.GetUser(ctx, req.GetUserId())
+	if err != nil {
+		return nil, serviceError(err)
+	}
+
+	return &ssov1.GetUserResponse{
+		User: toUserResponse(user),
+	}, nil
+}
+
+func (s *serverAPI) ChangeEmail(
+	ctx context.Context,
+	req *ssov1.ChangeEmailRequest,
+) (*ssov1.ChangeEmailResponse, error) {
+	if err := validationChangeEmail(req); err != nil {
+		return nil, err
+	}
+
+	userID, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pending, err := s.auth.ChangeEmail(ctx, userID, req.GetNewEmail())
+	if err != nil {
+		if errors.Is(err, auth.ErrUserExists) {
+			return nil, emailTakenError("new_email")
+		}
+
+		return nil, serviceError(err)
+	}
+
+	return &ssov1.ChangeEmailResponse{
+		Pending: pending,
+	}, nil
+}
+
+func (s *serverAPI) ListUsers(
+	ctx context.Context,
+	req *ssov1.ListUsersRequest,
+) (*ssov1.ListUsersResponse, error) {
+	if err := validationListUsers(req); err != nil {
+		return nil, err
+	}
+
+	users, err := s.auth.ListUsers(ctx, int(req.GetLimit()), int(req.GetOffset()))
+	if err != nil {
+		return nil, serviceError(err)
+	}
+
+	total, err := s.auth.CountUsers(ctx)
+	if err != nil {
+		return nil, serviceError(err)
+	}
+
+	resp := &ssov1.ListUsersResponse{
+		Users: make([]*ssov1.User, 0, len(users)),
+		Total: int64(total),
+	}
+	for _, user := range users {
+		resp.Users = append(resp.Users, toUserResponse(user))
+	}
+
+	return resp, nil
+}
+
+func (s *serverAPI) ListSessions(
+	ctx context.Context,
+	req *ssov1.ListSessionsRequest,
+) (*ssov1.ListSessionsResponse, error) {
+	if err := validationListSessions(req); err != nil {
+		return nil, err
+	}
+
+	sessions, err := s.auth.ListSessions(ctx, req.GetUserId())
+	if err != nil {
+		return nil, serviceError(err)
+	}
+
+	resp := &ssov1.ListSessionsResponse{
+		Sessions: make([]*ssov1.Session, 0, len(sessions)),
+	}
+	for _, session := range sessions {
+		resp.Sessions = append(resp.Sessions, &ssov1.Session{
+			Id:         session.ID,
+			AppId:      int32(session.AppID),
+			Ip:         session.IP,
+			UserAgent:  session.UserAgent,
+			CreatedAt:  session.CreatedAt.Unix(),
+			LastUsedAt: session.LastUsedAt.Unix(),
+			ExpiresAt:  session.ExpiresAt.Unix(),
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *serverAPI) RevokeSession(
+	ctx context.Context,
+	req *ssov1.RevokeSessionRequest,
+) (*ssov1.RevokeSessionResponse, error) {
+	if err := validationRevokeSession(req); err != nil {
+		return nil, err
+	}
+
+	if err := s.auth.RevokeSession(ctx, req.GetUserId(), req.GetSessionId()); err != nil {
+		return nil, serviceError(err)
+	}
+
+	return &ssov1.RevokeSessionResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *serverAPI) LogoutAll(
+	ctx context.Context,
+	req *ssov1.LogoutAllRequest,
+) (*ssov1.LogoutAllResponse, error) {
+	if err := validationLogoutAll(req); err != nil {
+		return nil, err
+	}
+
+	if err := s.auth.LogoutAll(ctx, req.GetUserId()); err != nil {
+		return nil, serviceError(err)
+	}
+
+	return &ssov1.LogoutAllResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *serverAPI) UnlockUser(
+	ctx context.Context,
+	req *ssov1.UnlockUserRequest,
+) (*ssov1.UnlockUserResponse, error) {
+	if err := validationUnlockUser(req); err != nil {
+		return nil, err
+	}
+
+	if err := s.auth.UnlockUser(ctx, req.GetUserId()); err != nil {
+		return nil, serviceError(err)
+	}
+
+	return &ssov1.UnlockUserResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *serverAPI) VerifyPassword(
+	ctx context.Context,
+	req *ssov1.VerifyPasswordRequest,
+) (*ssov1.VerifyPasswordResponse, error) {
+	if err := validationVerifyPassword(req); err != nil {
+		return nil, err
+	}
+
+	// Verification guesses passwords as well as logins do, so it shares their limits.
+	limitKeys := loginLimitKeys(ctx, req.GetEmail())
+	if !s.allowLogin(limitKeys) {
+		return nil, reasonError(codes.ResourceExhausted, reasonTooManyAttempts, "too many attempts, try again later")
+	}
+
+	valid, err := s.auth.VerifyPassword(ctx, req.GetEmail(), secret.Password(req.GetPassword()))
+	if err != nil {
+		return nil, serviceError(err)
+	}
+
+	if !valid {
+		s.failLogin(limitKeys)
+	} else {
+		s.resetLogin(limitKeys)
+	}
+
+	return &ssov1.VerifyPasswordResponse{
+		Valid: valid,
+	}, nil
+}
+
+func (s *serverAPI) LoginWithOIDC(
+	ctx context.Context,
+	req *ssov1.LoginWithOIDCRequest,
+) (*ssov1.LoginWithOIDCResponse, error) {
+	if err := validationLoginWithOIDC(req); err != nil {
+		return nil, err
+	}
+
+	token, err := s.auth.LoginWithOIDC(ctx, req.GetProvider(), req.GetCode(), req.GetRedirectUri())
+	if err != nil {
+		if errors.Is(err, auth.ErrUnknownProvider) {
+			return nil, fieldError("provider", "unknown identity provider")
+		}
+		if errors.Is(err, auth.ErrInvalidRedirectURI) {
+			return nil, fieldError("redirect_uri", "redirect uri is not allowed")
+		}
+
+		return nil, serviceError(err)
+	}
+
+	return &ssov1.LoginWithOIDCResponse{
+		Token: token,
+	}, nil
+}
+
+func (s *serverAPI) SendMagicLink(
+	ctx context.Context,
+	req *ssov1.SendMagicLinkRequest,
+) (*ssov1.SendMagicLinkResponse, error) {
+	if err := validationSendMagicLink(req); err != nil {
+		return nil, err
+	}
+
+	if err := s.auth.SendMagicLink(ctx, req.GetEmail()); err != nil {
+		return nil, serviceError(err)
+	}
+
+	return &ssov1.SendMagicLinkResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *serverAPI) LoginWithMagicLink(
+	ctx context.Context,
+	req *ssov1.LoginWithMagicLinkRequest,
+) (*ssov1.LoginWithMagicLinkResponse, error) {
+	if err := validationLoginWithMagicLink(req); err != nil {
+		return nil, err
+	}
+
+	token, err := s.auth.LoginWithMagicLink(ctx, req.GetToken(), int(req.GetAppId()))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidAppID) {
+			return nil, fieldError("app_id", "invalid app id")
+		}
+
+		return nil, serviceError(err)
+	}
+
+	return &ssov1.LoginWithMagicLinkResponse{
+		Token: token,
+	}, nil
+}
+
+func (s *serverAPI) BeginPasskeyRegistration(
+	ctx context.Context,
+	_ *ssov1.BeginPasskeyRegistrationRequest,
+) (*ssov1.BeginPasskeyRegistrationResponse, error) {
+	userID, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	options, challengeID, err := s.auth.BeginRegistration(ctx, userID)
+	if err != nil {
+		return nil, serviceError(err)
+	}
+
+	return &ssov1.BeginPasskeyRegistrationResponse{
+		ChallengeId: challengeID,
+		Options:     string(options),
+	}, nil
+}
+
+func (s *serverAPI) FinishPasskeyRegistration(
+	ctx context.Context,
+	req *ssov1.FinishPasskeyRegistrationRequest,
+) (*ssov1.FinishPasskeyRegistrationResponse, error) {
+	if err := validationFinishPasskeyRegistration(req); err != nil {
+		return nil, err
+	}
+
+	userID, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.auth.FinishRegistration(ctx, userID, req.GetChallengeId(), []byte(req.GetCredential()))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidPasskeyChallenge) {
+			return nil, fieldError("challenge_id", "invalid or expired challenge")
+		}
+
+		return nil, serviceError(err)
+	}
+
+	return &ssov1.FinishPasskeyRegistrationResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *serverAPI) BeginPasskeyLogin(
+	ctx context.Context,
+	req *ssov1.BeginPasskeyLoginRequest,
+) (*ssov1.BeginPasskeyLoginResponse, error) {
+	if err := validationBeginPasskeyLogin(req); err != nil {
+		return nil, err
+	}
+
+	options, challengeID, err := s.auth.BeginLogin(ctx, int(req.GetAppId()))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidAppID) {
+			return nil, fieldError("app_id", "invalid app id")
+		}
+
+		return nil, serviceError(err)
+	}
+
+	return &ssov1.BeginPasskeyLoginResponse{
+		ChallengeId: challengeID,
+		Options:     string(options),
+	}, nil
+}
+
+func (s *serverAPI) FinishPasskeyLogin(
+	ctx context.Context,
+	req *ssov1.FinishPasskeyLoginRequest,
+) (*ssov1.FinishPasskeyLoginResponse, error) {
+	if err := validationFinishPasskeyLogin(req); err != nil {
+		return nil, err
+	}
+
+	token, err := s.auth.FinishLogin(ctx, req.GetChallengeId(), []byte(req.GetCredential()))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidPasskeyChallenge) {
+			return nil, fieldError("challenge_id", "invalid or expired challenge")
+		}
+
+		return nil, serviceError(err)
+	}
+
+	return &ssov1.FinishPasskeyLoginResponse{
+		Token: token,
+	}, nil
+}
+
+func (s *serverAPI) ExchangeToken(
+	ctx context.Context,
+	req *ssov1.ExchangeTokenRequest,
+) (*ssov1.ExchangeTokenResponse, error) {
+	if err := validationExchangeToken(req); err != nil {
+		return nil, err
+	}
+
+	token, err := s.auth.ExchangeToken(
+		ctx,
+		int(req.GetAppId()),
+		req.GetAppSecret(),
+		req.GetSubjectToken(),
+		req.GetAudience(),
+	)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidAppID) {
+			return nil, fieldError("app_id", "invalid app id")
+		}
+		if errors.Is(err, auth.ErrInvalidAudience) {
+			return nil, fieldError("audience", "audience must be the id of an app")
+		}
+
+		return nil, serviceError(err)
+	}
+
+	return &ssov1.ExchangeTokenResponse{
+		Token: token,
+	}, nil
+}
+
+// toUserResponse converts user to its API representation.
+func toUserResponse(user models.User) *ssov1.User {
+	resp := &ssov1.User{
+		Id:       user.ID,
+		Email:    user.Email,
+		Verified: user.Verified,
+	}
+
+	// The service zeroes expired locks by its clock.
+	if !user.LockedUntil.IsZero() {
+		resp.LockedUntil = user.LockedUntil.Unix()
+	}
+
+	return resp
+}
+
+// weakPasswordError tells the client which password rule was violated.
+// field is the name of the request field holding the password.
+func weakPasswordError(field string, err error) error {
+	var policyErr *password.PolicyError
+	if errors.As(err, &policyErr) {
+		return fieldError(field, "password "+policyErr.Reason)
+	}
+
+	return fieldError(field, "password is too weak")
+}
+
+// validationEmail checks that email is a bare address, e.g. user@example.com.
+// field is the name of the request field holding it.
+func validationEmail(field string, email string) error {
+	if email == "" {
+		return fieldError(field, "email is required")
+	}
+	// Longer input is rejected before it is parsed, grpc.max_email_length can't exceed it.
+	if len(email) > libmail.MaxAddressLength {
+		return fieldError(field, "email is too long")
+	}
+	if !utf8.ValidString(email) || strings.ContainsFunc(email, unicode.IsControl) {
+		return fieldError(field, "email is invalid")
+	}
+
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != strings.TrimSpace(email) {
+		return fieldError(field, "email is invalid")
+	}
+	return nil
+}
+
+// maxScopes is how many scopes a login can request.
+const maxScopes = 32
+
+func validationLogin(req *ssov1.LoginRequest) error {
+	if err := validationEmail("email", req.GetEmail()); err != nil {
+		return err
+	}
+	if req.GetPassword() == "" {
+		return fieldError("password", "password is required")
+	}
+	if req.GetAppId() == emptyValue {
+		return fieldError("app_id", "appId is required")
+	}
+	if req.GetAppSecret() == "" {
+		return fieldError("app_secret", "appSecret is required")
+	}
+	if len(req.GetScopes()) > maxScopes {
+		return fieldError("scopes", fmt.Sprintf("at most %d scopes can be requested", maxScopes))
+	}
+	for _, scope := range req.GetScopes() {
+		if !jwt.ValidScope(scope) {
+			return fieldError("scopes", fmt.Sprintf("invalid scope %q", scope))
+		}
+	}
+	return nil
+}
+
+func validationRegister(req *ssov1.RegisterRequest) error {
+	if err := validationEmail("email", req.GetEmail()); err != nil {
+		return err
+	}
+	if req.GetPassword() == "" {
+		return fieldError("password", "password is required")
+	}
+	return nil
+}
+
+func validationIsAdmin(req *ssov1.IsAdminRequest) error {
+	if req.GetUserId() == 0 {
+		return fieldError("user_id", "userId is required")
+	}
+	return nil
+}
+
+// maxAreAdminsBatch keeps the IN list of the storage query reasonable.
+const maxAreAdminsBatch = 500
+
+func validationAreAdmins(req *ssov1.AreAdminsRequest) error {
+	if len(req.GetUserIds()) == 0 {
+		return fieldError("user_ids", "userIds are required")
+	}
+	if len(req.GetUserIds()) > maxAreAdminsBatch {
+		return fieldError("user_ids", fmt.Sprintf("at most %d userIds are allowed", maxAreAdminsBatch))
+	}
+	for _, id := range req.GetUserIds() {
+		if id == 0 {
+			return fieldError("user_ids", "userIds must not contain 0")
+		}
+	}
+	return nil
+}
+
+func validationLogout(req *ssov1.LogoutRequest) error {
+	if req.GetToken() == "" {
+		return fieldError("token", "token is required")
+	}
+	return nil
+}
+
+func validationRefresh(req *ssov1.RefreshRequest) error {
+	if req.GetRefreshToken() == "" {
+		return fieldError("refresh_token", "refresh token is required")
+	}
+	if req.GetAppId() == emptyValue {
+		return fieldError("app_id", "appId is required")
+	}
+	return nil
+}
+
+func validationValidate(req *ssov1.ValidateRequest) error {
+	if req.GetToken() == "" {
+		return fieldError("token", "token is required")
+	}
+	return nil
+}
+
+func validationChangePassword(req *ssov1.ChangePasswordRequest) error {
+	if err := validationEmail("email", req.GetEmail()); err != nil {
+		return err
+	}
+	if req.GetOldPassword() == "" {
+		return fieldError("old_password", "old password is required")
+	}
+	if req.GetNewPassword() == "" {
+		return fieldError("new_password", "new password is required")
+	}
+	return nil
+}
+
+func validationVerifyEmail(req *ssov1.VerifyEmailRequest) error {
+	if req.GetToken() == "" {
+		return fieldError("token", "token is required")
+	}
+	return nil
+}
+
+func validationRequestPasswordReset(req *ssov1.RequestPasswordResetRequest) error {
+	if err := validationEmail("email", req.GetEmail()); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validationResetPassword(req *ssov1.ResetPasswordRequest) error {
+	if req.GetToken() == "" {
+		return fieldError("token", "token is required")
+	}
+
+	if req.GetNewPassword() == "" {
+		return fieldError("new_password", "new password is required")
+	}
+	return nil
+}
+
+func validationUserRoles(req *ssov1.UserRolesRequest) error {
+	if req.GetUserId() == 0 {
+		return fieldError("user_id", "userId is required")
+	}
+
+	if req.GetAppId() == emptyValue {
+		return fieldError("app_id", "app_id is required")
+	}
+	return nil
+}
+
+func validationHasRole(req *ssov1.HasRoleRequest) error {
+	if req.GetUserId() == 0 {
+		return fieldError("user_id", "userId is required")
+	}
+
+	if req.GetAppId() == emptyValue {
+		return fieldError("app_id", "app_id is required")
+	}
+
+	if req.GetRole() == "" {
+		return fieldError("role", "role is required")
+	}
+	return nil
+}
+
+func validationDeleteUser(req *ssov1.DeleteUserRequest) error {
+	if req.GetUserId() == 0 {
+		return fieldError("user_id", "userId is required")
+	}
+	return nil
+}
+
+// minAppSecretLength keeps app secrets hard to guess.
+const minAppSecretLength = 16
+
+func validationCreateApp(req *ssov1.CreateAppRequest) error {
+	if strings.TrimSpace(req.GetName()) == "" {
+		return fieldError("name", "name is required")
+	}
+	if req.GetSecret() == "" {
+		return fieldError("secret", "secret is required")
+	}
+	if len(req.GetSecret()) < minAppSecretLength {
+		return fieldError("secret", "secret is too short")
+	}
+	return nil
+}
+
+func validationDeleteApp(req *ssov1.DeleteAppRequest) error {
+	if req.GetAppId() == emptyValue {
+		return fieldError("app_id", "appId is required")
+	}
+	return nil
+}
+
+func validationGetUser(req *ssov1.GetUserRequest) error {
+	if req.GetUserId() == 0 {
+		return fieldError("user_id", "userId is required")
+	}
+	return nil
+}
+
+func validationChangeEmail(req *ssov1.ChangeEmailRequest) error {
+	return validationEmail("new_email", req.GetNewEmail())
+}
+
+func validationListUsers(req *ssov1.ListUsersRequest) error {
+	if req.GetLimit() < 0 {
+		return fieldError("limit", "limit must not be negative")
+	}
+	if req.GetOffset() < 0 {
+		return fieldError("offset", "offset must not be negative")
+	}
+	return nil
+}
+
+func validationListSessions(req *ssov1.ListSessionsRequest) error {
+	if req.GetUserId() == 0 {
+		return fieldError("user_id", "userId is required")
+	}
+	return nil
+}
+
+func validationRevokeSession(req *ssov1.RevokeSessionRequest) error {
+	if req.GetUserId() == 0 {
+		return fieldError("user_id", "userId is required")
+	}
+	if req.GetSessionId() == 0 {
+		return fieldError("session_id", "sessionId is required")
+	}
+	return nil
+}
+
+func validationLogoutAll(req *ssov1.LogoutAllRequest) error {
+	if req.GetUserId() == 0 {
+		return fieldError("user_id", "userId is required")
+	}
+	return nil
+}
+
+func validationUnlockUser(req *ssov1.UnlockUserRequest) error {
+	if req.GetUserId() == 0 {
+		return fieldError("user_id", "userId is required")
+	}
+	return nil
+}
+
+func validationVerifyPassword(req *ssov1.VerifyPasswordRequest) error {
+	if err := validationEmail("email", req.GetEmail()); err != nil {
+		return err
+	}
+	if req.GetPassword() == "" {
+		return fieldError("password", "password is required")
+	}
+	return nil
+}
+
+func validationLoginWithOIDC(req *ssov1.LoginWithOIDCRequest) error {
+	if req.GetProvider() == "" {
+		return fieldError("provider", "provider is required")
+	}
+	if req.GetCode() == "" {
+		return fieldError("code", "code is required")
+	}
+	if req.GetRedirectUri() == "" {
+		return fieldError("redirect_uri", "redirectUri is required")
+	}
+	return nil
+}
+
+func validationSendMagicLink(req *ssov1.SendMagicLinkRequest) error {
+	return validationEmail("email", req.GetEmail())
+}
+
+func validationLoginWithMagicLink(req *ssov1.LoginWithMagicLinkRequest) error {
+	if req.GetToken() == "" {
+		return fieldError("token", "token is required")
+	}
+	if req.GetAppId() == emptyValue {
+		return fieldError("app_id", "appId is required")
+	}
+	return nil
+}
+
+func validationFinishPasskeyRegistration(req *ssov1.FinishPasskeyRegistrationRequest) error {
+	if req.GetChallengeId() == "" {
+		return fieldError("challenge_id", "challengeId is required")
+	}
+	if req.GetCredential() == "" {
+		return fieldError("credential", "credential is required")
+	}
+	return nil
+}
+
+func validationBeginPasskeyLogin(req *ssov1.BeginPasskeyLoginRequest) error {
+	if req.GetAppId() == emptyValue {
+		return fieldError("app_id", "appId is required")
+	}
+	return nil
+}
+
+func validationFinishPasskeyLogin(req *ssov1.FinishPasskeyLoginRequest) error {
+	if req.GetChallengeId() == "" {
+		return fieldError("challenge_id", "challengeId is required")
+	}
+	if req.GetCredential() == "" {
+		return fieldError("credential", "credential is required")
+	}
+	return nil
+}
+
+func validationExchangeToken(req *ssov1.ExchangeTokenRequest) error {
+	if req.GetSubjectToken() == "" {
+		return fieldError("subject_token", "subjectToken is required")
+	}
+	if req.GetAudience() == "" {
+		return fieldError("audience", "audience is required")
+	}
+	if req.GetAppId() == emptyValue {
+		return fieldError("app_id", "appId is required")
+	}
+	if req.GetAppSecret() == "" {
+		return fieldError("app_secret", "appSecret is required")
 	}
 	return nil
 }
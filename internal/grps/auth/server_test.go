@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	ssov1 "github.com/roxxxiey/protos/gen/go/sso"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sso/internal/lib/secret"
+	"sso/internal/services/auth"
+	"testing"
+)
+
+// fakeAuth implements the methods a test sets, calling others panics.
+type fakeAuth struct {
+	Auth
+
+	changePassword func(ctx context.Context, email string, oldPassword, newPassword secret.Password) error
+	isAdmin        func(ctx context.Context, userID uint64) (bool, error)
+	register       func(ctx context.Context, email string, password secret.Password, idempotent bool) (uint64, bool, error)
+}
+
+func (f *fakeAuth) ChangePassword(ctx context.Context, email string, oldPassword, newPassword secret.Password) error {
+	return f.changePassword(ctx, email, oldPassword, newPassword)
+}
+
+func (f *fakeAuth) IsAdmin(ctx context.Context, userID uint64) (bool, error) {
+	return f.isAdmin(ctx, userID)
+}
+
+func (f *fakeAuth) RegisterNewUser(ctx context.Context, email string, password secret.Password, idempotent bool) (uint64, bool, error) {
+	return f.register(ctx, email, password, idempotent)
+}
+
+// fakeLimiter refuses keys with limit failures and records calls.
+type fakeLimiter struct {
+	limit    int
+	failures map[string]int
+	resets   []string
+}
+
+func newFakeLimiter(limit int) *fakeLimiter {
+	return &fakeLimiter{limit: limit, failures: make(map[string]int)}
+}
+
+func (l *fakeLimiter) Allow(key string) bool { return l.failures[key] < l.limit }
+func (l *fakeLimiter) Fail(key string)       { l.failures[key]++ }
+func (l *fakeLimiter) Reset(key string) {
+	delete(l.failures, key)
+	l.resets = append(l.resets, key)
+}
+
+func TestChangePasswordIsRateLimited(t *testing.T) {
+	calls := 0
+	a := &fakeAuth{changePassword: func(context.Context, string, secret.Password, secret.Password) error {
+		calls++
+
+		return fmt.Errorf("auth.ChangePassword: %w", auth.ErrInvalidCredentials)
+	}}
+	limiter := newFakeLimiter(2)
+	s := &serverAPI{auth: a, loginLimiter: limiter}
+
+	req := &ssov1.ChangePasswordRequest{Email: "user@example.com", OldPassword: "guess", NewPassword: "N3w!password"}
+
+	for i := 0; i < 2; i++ {
+		_, err := s.ChangePassword(context.Background(), req)
+		if status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("attempt %d: got %v, want Unauthenticated", i+1, err)
+		}
+	}
+
+	_, err := s.ChangePassword(context.Background(), req)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("attempt over the limit: got %v, want ResourceExhausted", err)
+	}
+	if calls != 2 {
+		t.Fatalf("service called %d times, want 2: attempts over the limit must not check the password", calls)
+	}
+}
+
+func TestChangePasswordResetsLimitOnSuccess(t *testing.T) {
+	a := &fakeAuth{changePassword: func(context.Context, string, secret.Password, secret.Password) error {
+		return nil
+	}}
+	limiter := newFakeLimiter(5)
+	limiter.failures["email:user@example.com"] = 3
+	s := &serverAPI{auth: a, loginLimiter: limiter}
+
+	req := &ssov1.ChangePasswordRequest{Email: "User@example.com", OldPassword: "Passw0rd!x", NewPassword: "N3w!password"}
+	if _, err := s.ChangePassword(context.Background(), req); err != nil {
+		t.Fatalf("change password: %v", err)
+	}
+
+	if limiter.failures["email:user@example.com"] != 0 {
+		t.Fatalf("failures of the email kept after a successful change: %d", limiter.failures["email:user@example.com"])
+	}
+}
+
+func TestIsAdminUserNotFound(t *testing.T) {
+	a := &fakeAuth{isAdmin: func(context.Context, uint64) (bool, error) {
+		return false, fmt.Errorf("auth.IsAdmin: %w", auth.ErrUserNotFound)
+	}}
+	s := &serverAPI{auth: a}
+
+	_, err := s.IsAdmin(context.Background(), &ssov1.IsAdminRequest{UserId: 42})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("got %v, want NotFound", err)
+	}
+}
+
+func TestIsAdminStorageError(t *testing.T) {
+	a := &fakeAuth{isAdmin: func(context.Context, uint64) (bool, error) {
+		return false, errors.New("auth.IsAdmin: connection refused")
+	}}
+	s := &serverAPI{auth: a}
+
+	resp, err := s.IsAdmin(context.Background(), &ssov1.IsAdminRequest{UserId: 1})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("got %v, want Internal", err)
+	}
+	// A failed check must not look like a "not admin" answer.
+	if resp != nil {
+		t.Fatalf("got response %v with the error", resp)
+	}
+}
+
+func TestRegisterEmailTaken(t *testing.T) {
+	a := &fakeAuth{register: func(context.Context, string, secret.Password, bool) (uint64, bool, error) {
+		return 0, false, fmt.Errorf("auth.RegisterNewUser: %w", auth.ErrUserExists)
+	}}
+	s := &serverAPI{auth: a}
+
+	_, err := s.Register(context.Background(), &ssov1.RegisterRequest{Email: "user@example.com", Password: "Passw0rd!x"})
+
+	st := status.Convert(err)
+	if st.Code() != codes.AlreadyExists {
+		t.Fatalf("got %v, want AlreadyExists", err)
+	}
+
+	var info *errdetails.ErrorInfo
+	var badRequest *errdetails.BadRequest
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			info = d
+		case *errdetails.BadRequest:
+			badRequest = d
+		}
+	}
+
+	if info == nil || info.GetReason() != reasonEmailTaken || info.GetMetadata()["field"] != "email" {
+		t.Fatalf("got ErrorInfo %v, want reason %s of field email", info, reasonEmailTaken)
+	}
+	if badRequest == nil || len(badRequest.GetFieldViolations()) != 1 || badRequest.GetFieldViolations()[0].GetField() != "email" {
+		t.Fatalf("got BadRequest %v, want a violation of field email", badRequest)
+	}
+}
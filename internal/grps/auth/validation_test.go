@@ -0,0 +1,136 @@
+package auth
+
+import (
+	ssov1 "github.com/roxxxiey/protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	libmail "sso/internal/lib/mail"
+	"sso/internal/services/auth"
+	"strings"
+	"testing"
+)
+
+// validationSeeds are emails and passwords the fuzz targets start from:
+// valid ones, odd unicode, invalid UTF-8 and inputs far over the limits.
+var validationSeeds = []struct {
+	email    string
+	password string
+}{
+	{"user@example.com", "Passw0rd!x"},
+	{"", ""},
+	{"not an email", "p"},
+	{"\"quoted local\"@example.com", "p"},
+	{"Name <user@example.com>", "p"},
+	{"usér@exämple.com", "пароль"},
+	{"user\u200b@example.com", "\u202epassword"},
+	{"user\x00@example.com", "pass\x00word"},
+	{"\xff\xfe@example.com", "\xc3\x28"},
+	{strings.Repeat("a", 1<<16) + "@example.com", strings.Repeat("p", 1<<16)},
+	{strings.Repeat("😀", 1000) + "@example.com", strings.Repeat("😀", 1000)},
+}
+
+// checkValidationError fails the test unless err is nil or an InvalidArgument status.
+func checkValidationError(t *testing.T, err error) {
+	t.Helper()
+
+	if err == nil {
+		return
+	}
+
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.InvalidArgument {
+		t.Fatalf("got %v, want nil or InvalidArgument", err)
+	}
+}
+
+func TestValidationEmail(t *testing.T) {
+	tests := []struct {
+		name  string
+		email string
+		valid bool
+	}{
+		{"bare address", "user@example.com", true},
+		{"mixed case", "User@X.com", true},
+		{"subdomain and plus", "first.last+tag@mail.example.com", true},
+		{"empty", "", false},
+		{"no at", "notanemail", false},
+		{"no domain", "a@", false},
+		{"no local part", "@b.com", false},
+		{"two ats", "a@b@c.com", false},
+		{"display name", "Name <user@example.com>", false},
+		{"spaces inside", "user name@example.com", false},
+		{"over-length", strings.Repeat("a", libmail.MaxAddressLength) + "@example.com", false},
+		{"control character", "user\n@example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validationEmail("email", tt.email)
+
+			if tt.valid {
+				if err != nil {
+					t.Fatalf("%q rejected: %v", tt.email, err)
+				}
+
+				return
+			}
+
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("%q: got %v, want InvalidArgument", tt.email, err)
+			}
+		})
+	}
+}
+
+func TestValidEmailIsNormalizedToLowercase(t *testing.T) {
+	const email = "User@X.com"
+
+	if err := validationEmail("email", email); err != nil {
+		t.Fatalf("%q rejected: %v", email, err)
+	}
+
+	// The service stores and looks up emails normalized, so the case doesn't matter.
+	if got := auth.NormalizeEmail(email, false); got != "user@x.com" {
+		t.Fatalf("normalized %q to %q, want user@x.com", email, got)
+	}
+}
+
+func FuzzValidateLogin(f *testing.F) {
+	for _, seed := range validationSeeds {
+		f.Add(seed.email, seed.password, int32(1), "secret", "profile")
+		f.Add(seed.email, seed.password, int32(0), "", "")
+	}
+	f.Add("user@example.com", "p", int32(-1), "secret", "bad scope\x00")
+
+	f.Fuzz(func(t *testing.T, email string, password string, appID int32, appSecret string, scope string) {
+		req := &ssov1.LoginRequest{
+			Email:     email,
+			Password:  password,
+			AppId:     appID,
+			AppSecret: appSecret,
+			Scopes:    strings.Split(scope, ","),
+		}
+
+		checkValidationError(t, validationLogin(req))
+	})
+}
+
+func FuzzValidateRegister(f *testing.F) {
+	for _, seed := range validationSeeds {
+		f.Add(seed.email, seed.password)
+	}
+
+	f.Fuzz(func(t *testing.T, email string, password string) {
+		checkValidationError(t, validationRegister(&ssov1.RegisterRequest{Email: email, Password: password}))
+	})
+}
+
+func FuzzValidateIsAdmin(f *testing.F) {
+	for _, id := range []int64{0, 1, -1, 1 << 62, -1 << 63} {
+		f.Add(id)
+	}
+
+	f.Fuzz(func(t *testing.T, userID int64) {
+		checkValidationError(t, validationIsAdmin(&ssov1.IsAdminRequest{UserId: userID}))
+	})
+}
@@ -0,0 +1,102 @@
+package interceptors
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"sso/internal/lib/metrics"
+	"strconv"
+	"sync"
+)
+
+const (
+	// NoApp labels requests of methods without an app id, e.g. Register,
+	// and requests which don't set it.
+	NoApp = "none"
+	// OtherApp labels requests of apps seen after MaxAppLabels other apps.
+	OtherApp = "other"
+)
+
+// MaxAppLabels bounds how many apps get their own label. App ids come from
+// clients, so without a bound random ids would create series without end.
+const MaxAppLabels = 1000
+
+// AppRequest is implemented by requests which carry an app id,
+// generated request types with an app_id field implement it.
+type AppRequest interface {
+	GetAppId() int32
+}
+
+// AppMetrics counts requests and records their size by app and method.
+// The app label is the app id of the request, see AppLabel.
+func AppMetrics(m *metrics.Metrics) grpc.UnaryServerInterceptor {
+	labels := newAppLabels(MaxAppLabels)
+
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		app := labels.label(req)
+
+		m.AppRequests.WithLabelValues(app, info.FullMethod).Inc()
+
+		if msg, ok := req.(proto.Message); ok {
+			m.AppRequestSize.
+				WithLabelValues(app, info.FullMethod).
+				Observe(float64(proto.Size(msg)))
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// AppLabel returns the app label of req: its app id, or NoApp
+// if req has no app id or it is not set.
+func AppLabel(req any) string {
+	r, ok := req.(AppRequest)
+	if !ok || r.GetAppId() == 0 {
+		return NoApp
+	}
+
+	return strconv.Itoa(int(r.GetAppId()))
+}
+
+// appLabels remembers apps that have their own label.
+// It is safe for concurrent use.
+type appLabels struct {
+	mu   sync.Mutex
+	max  int
+	seen map[string]struct{}
+}
+
+func newAppLabels(max int) *appLabels {
+	return &appLabels{
+		max:  max,
+		seen: make(map[string]struct{}),
+	}
+}
+
+// label returns AppLabel of req, or OtherApp once max apps have their own label.
+func (l *appLabels) label(req any) string {
+	app := AppLabel(req)
+	if app == NoApp {
+		return app
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.seen[app]; ok {
+		return app
+	}
+
+	if len(l.seen) >= l.max {
+		return OtherApp
+	}
+
+	l.seen[app] = struct{}{}
+
+	return app
+}
@@ -0,0 +1,82 @@
+package interceptors
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	ssov1 "github.com/roxxxiey/protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"sso/internal/lib/metrics"
+	"strconv"
+	"testing"
+)
+
+func TestAppLabel(t *testing.T) {
+	tests := []struct {
+		name string
+		req  any
+		want string
+	}{
+		{"app id", &ssov1.LoginRequest{AppId: 7}, "7"},
+		{"unset app id", &ssov1.LoginRequest{}, NoApp},
+		{"method without app id", &ssov1.RegisterRequest{Email: "user@example.com"}, NoApp},
+		{"not a request", nil, NoApp},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AppLabel(tt.req); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppMetrics(t *testing.T) {
+	m := metrics.New()
+	interceptor := AppMetrics(m)
+
+	handler := func(ctx context.Context, req any) (any, error) { return nil, nil }
+	call := func(method string, req any) {
+		_, _ = interceptor(context.Background(), req, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+	}
+
+	call("/auth.Auth/Login", &ssov1.LoginRequest{AppId: 1})
+	call("/auth.Auth/Login", &ssov1.LoginRequest{AppId: 1})
+	call("/auth.Auth/Login", &ssov1.LoginRequest{AppId: 2})
+	call("/auth.Auth/Register", &ssov1.RegisterRequest{Email: "user@example.com"})
+
+	for _, tt := range []struct {
+		app, method string
+		want        float64
+	}{
+		{"1", "/auth.Auth/Login", 2},
+		{"2", "/auth.Auth/Login", 1},
+		{NoApp, "/auth.Auth/Register", 1},
+		{NoApp, "/auth.Auth/Login", 0},
+	} {
+		if got := testutil.ToFloat64(m.AppRequests.WithLabelValues(tt.app, tt.method)); got != tt.want {
+			t.Errorf("requests of app %s to %s: got %g, want %g", tt.app, tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestAppLabelsAreBounded(t *testing.T) {
+	labels := newAppLabels(2)
+
+	for id := 1; id <= 2; id++ {
+		if got := labels.label(&ssov1.LoginRequest{AppId: int32(id)}); got != strconv.Itoa(id) {
+			t.Fatalf("app %d: got %q", id, got)
+		}
+	}
+
+	if got := labels.label(&ssov1.LoginRequest{AppId: 3}); got != OtherApp {
+		t.Fatalf("app over the limit: got %q, want %q", got, OtherApp)
+	}
+	// Apps seen before keep their labels.
+	if got := labels.label(&ssov1.LoginRequest{AppId: 1}); got != "1" {
+		t.Fatalf("app seen before: got %q, want 1", got)
+	}
+	if got := labels.label(&ssov1.RegisterRequest{}); got != NoApp {
+		t.Fatalf("request without app: got %q, want %q", got, NoApp)
+	}
+}
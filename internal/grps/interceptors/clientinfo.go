@@ -0,0 +1,134 @@
+package interceptors
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"sso/internal/lib/clientinfo"
+	"strings"
+)
+
+// userAgentHeader is the metadata key of the user agent of the client.
+const userAgentHeader = "user-agent"
+
+// Metadata keys the HTTP gateway forwards the address and user agent of its client in.
+const (
+	forwardedForHeader       = "x-forwarded-for"
+	forwardedUserAgentHeader = "grpcgateway-user-agent"
+)
+
+// maxUserAgentLength keeps stored user agents reasonably short.
+const maxUserAgentLength = 256
+
+// ClientInfo puts the address and user agent of the client into the context of the call,
+// see clientinfo.FromContext.
+func ClientInfo() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		ctx = clientinfo.NewContext(ctx, clientinfo.Info{
+			IP:        peerIP(ctx),
+			UserAgent: userAgent(ctx),
+		})
+
+		return handler(ctx, req)
+	}
+}
+
+// ForwardedClientInfo is ClientInfo for calls made by the HTTP gateway on behalf of its clients:
+// the address and user agent are the ones the gateway forwards in metadata.
+//
+// The gateway appends the address of its client to x-forwarded-for, so only the last entry
+// is used, the rest is sent by the client and can't be trusted.
+// It must only be used by servers that can't be reached other than through the gateway.
+func ForwardedClientInfo() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		ctx = clientinfo.NewContext(ctx, clientinfo.Info{
+			IP:        forwardedIP(ctx),
+			UserAgent: userAgentFrom(ctx, forwardedUserAgentHeader),
+		})
+
+		return handler(ctx, req)
+	}
+}
+
+func forwardedIP(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get(forwardedForHeader)
+	if len(values) == 0 {
+		return ""
+	}
+
+	last := values[len(values)-1]
+	if i := strings.LastIndexByte(last, ','); i >= 0 {
+		last = last[i+1:]
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(last))
+	if ip == nil {
+		return ""
+	}
+
+	return ip.String()
+}
+
+// peerIP returns the IP address of the peer of the call.
+// Peers connected over unix sockets have no address, so it is empty for them.
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	switch addr := p.Addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP.String()
+	case *net.UnixAddr:
+		return ""
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil || net.ParseIP(host) == nil {
+			return ""
+		}
+
+		return host
+	}
+}
+
+func userAgent(ctx context.Context) string {
+	return userAgentFrom(ctx, userAgentHeader)
+}
+
+// userAgentFrom returns the user agent sent in the key metadata, cut to maxUserAgentLength.
+func userAgentFrom(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+
+	ua := values[0]
+	if len(ua) > maxUserAgentLength {
+		ua = ua[:maxUserAgentLength]
+	}
+
+	return ua
+}
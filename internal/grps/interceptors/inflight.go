@@ -0,0 +1,22 @@
+package interceptors
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"sync/atomic"
+)
+
+// InFlight keeps count of unary calls that are being handled right now.
+func InFlight(count *atomic.Int64) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		count.Add(1)
+		defer count.Add(-1)
+
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,92 @@
+package interceptors
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"strings"
+)
+
+// FieldLimits bounds lengths of fields of requests in bytes, zero disables a limit.
+type FieldLimits struct {
+	MaxEmailLength    int
+	MaxPasswordLength int
+}
+
+// Limits rejects requests with an email or password longer than allowed
+// with InvalidArgument, before the handler hashes or stores anything.
+//
+// Fields are recognized by name: email and fields ending in _email are emails,
+// password and fields ending in _password are passwords.
+func Limits(l FieldLimits) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		if msg, ok := req.(proto.Message); ok {
+			if err := l.check(msg.ProtoReflect()); err != nil {
+				return nil, err
+			}
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+func (l FieldLimits) check(m protoreflect.Message) error {
+	var err error
+
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if fd.Kind() != protoreflect.StringKind || fd.IsList() || fd.IsMap() {
+			return true
+		}
+
+		name := string(fd.Name())
+
+		var (
+			limit int
+			what  string
+		)
+
+		switch {
+		case name == "email" || strings.HasSuffix(name, "_email"):
+			limit, what = l.MaxEmailLength, "email"
+		case name == "password" || strings.HasSuffix(name, "_password"):
+			limit, what = l.MaxPasswordLength, "password"
+		default:
+			return true
+		}
+
+		if limit > 0 && len(v.String()) > limit {
+			err = tooLongError(name, fmt.Sprintf("%s must be at most %d bytes long", what, limit))
+			return false
+		}
+
+		return true
+	})
+
+	return err
+}
+
+// tooLongError is InvalidArgument with a BadRequest detail naming the field.
+func tooLongError(field string, description string) error {
+	st := status.New(codes.InvalidArgument, description)
+
+	detailed, err := st.WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: field, Description: description},
+		},
+	})
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
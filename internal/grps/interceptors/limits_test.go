@@ -0,0 +1,67 @@
+package interceptors
+
+import (
+	"context"
+	ssov1 "github.com/roxxxiey/protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	limits := Limits(FieldLimits{MaxEmailLength: 254, MaxPasswordLength: 72})
+
+	// email returns an email n bytes long.
+	email := func(n int) string {
+		const domain = "@example.com"
+		return strings.Repeat("a", n-len(domain)) + domain
+	}
+
+	tests := []struct {
+		name string
+		req  any
+		code codes.Code
+	}{
+		{"email at limit", &ssov1.RegisterRequest{Email: email(254), Password: "p"}, codes.OK},
+		{"email over limit", &ssov1.RegisterRequest{Email: email(255), Password: "p"}, codes.InvalidArgument},
+		{"password at limit", &ssov1.RegisterRequest{Email: "a@b.c", Password: strings.Repeat("p", 72)}, codes.OK},
+		{"password over limit", &ssov1.RegisterRequest{Email: "a@b.c", Password: strings.Repeat("p", 73)}, codes.InvalidArgument},
+		{"new password over limit", &ssov1.ChangePasswordRequest{
+			Email:       "a@b.c",
+			OldPassword: "p",
+			NewPassword: strings.Repeat("p", 73),
+		}, codes.InvalidArgument},
+		{"multibyte password over limit in bytes", &ssov1.RegisterRequest{Email: "a@b.c", Password: strings.Repeat("я", 37)}, codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			handler := func(ctx context.Context, req any) (any, error) {
+				called = true
+				return req, nil
+			}
+
+			_, err := limits(context.Background(), tt.req, &grpc.UnaryServerInfo{FullMethod: "/auth.Auth/Register"}, handler)
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("got %v, want %s", err, tt.code)
+			}
+			if called != (tt.code == codes.OK) {
+				t.Fatalf("handler called: %t", called)
+			}
+		})
+	}
+}
+
+func TestLimitsDisabled(t *testing.T) {
+	limits := Limits(FieldLimits{})
+
+	req := &ssov1.RegisterRequest{Email: "a@b.c", Password: strings.Repeat("p", 1000)}
+	handler := func(ctx context.Context, req any) (any, error) { return req, nil }
+
+	if _, err := limits(context.Background(), req, &grpc.UnaryServerInfo{}, handler); err != nil {
+		t.Fatalf("zero limits rejected a request: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package interceptors
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"log/slog"
+	"sso/internal/lib/requestid"
+	"strings"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveFields are substrings of request field names whose values are never logged.
+var sensitiveFields = []string{"password", "token", "secret", "challenge", "credential"}
+
+// Logging logs every unary call with its method, duration, resulting code and
+// correlation ID, which is set by the RequestID interceptor running before it.
+//
+// Request fields that look like passwords, tokens, secrets or passkey challenges
+// and credentials are redacted.
+func Logging(log *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		start := time.Now()
+
+		resp, err := handler(ctx, req)
+
+		code := status.Code(err)
+
+		attrs := []any{
+			slog.String("method", info.FullMethod),
+			slog.String("request_id", requestid.FromContext(ctx)),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("code", code.String()),
+		}
+
+		if msg, ok := req.(proto.Message); ok {
+			attrs = append(attrs, slog.Any("request", redact(msg.ProtoReflect())))
+		}
+
+		if err != nil {
+			attrs = append(attrs, slog.String("error", status.Convert(err).Message()))
+		}
+
+		log.Info("request handled", attrs...)
+
+		return resp, err
+	}
+}
+
+// redact returns the fields of msg with sensitive values replaced.
+func redact(msg protoreflect.Message) map[string]any {
+	fields := make(map[string]any)
+
+	msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		name := string(fd.Name())
+
+		switch {
+		case isSensitive(name):
+			fields[name] = redacted
+		case fd.Kind() == protoreflect.MessageKind && !fd.IsList() && !fd.IsMap():
+			fields[name] = redact(v.Message())
+		default:
+			fields[name] = v.Interface()
+		}
+
+		return true
+	})
+
+	return fields
+}
+
+func isSensitive(name string) bool {
+	name = strings.ToLower(name)
+
+	for _, s := range sensitiveFields {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+
+	return false
+}
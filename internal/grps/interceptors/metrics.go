@@ -0,0 +1,65 @@
+package interceptors
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sso/internal/lib/metrics"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Metrics records latency of every unary call, and the result of
+// calls that have their own counters, e.g. logins.
+//
+// Results are gRPC codes in snake case, e.g. "ok" or "unauthenticated".
+func Metrics(m *metrics.Metrics) grpc.UnaryServerInterceptor {
+	results := map[string]*prometheus.CounterVec{
+		"/auth.Auth/Login":    m.Logins,
+		"/auth.Auth/Register": m.Registrations,
+		"/auth.Auth/Validate": m.TokenValidations,
+	}
+
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		start := time.Now()
+
+		resp, err := handler(ctx, req)
+
+		code := codeLabel(status.Code(err))
+
+		m.RequestDuration.
+			WithLabelValues(info.FullMethod, code).
+			Observe(time.Since(start).Seconds())
+
+		if counter, ok := results[info.FullMethod]; ok {
+			counter.WithLabelValues(code).Inc()
+		}
+
+		return resp, err
+	}
+}
+
+// codeLabel turns e.g. codes.ResourceExhausted into "resource_exhausted".
+func codeLabel(code codes.Code) string {
+	var b strings.Builder
+
+	for i, r := range code.String() {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
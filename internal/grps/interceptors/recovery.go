@@ -0,0 +1,49 @@
+package interceptors
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log/slog"
+	"runtime/debug"
+	"sso/internal/lib/requestid"
+)
+
+// RecoveryHandler turns a recovered panic into the error returned to the client.
+type RecoveryHandler func(ctx context.Context, p any) error
+
+// Recovery recovers from panics in unary handlers, so a single bad request
+// can't take the whole server down.
+//
+// The panic is logged with its stack trace and passed to handle,
+// if handle is nil the client gets codes.Internal.
+func Recovery(log *slog.Logger, handle RecoveryHandler) grpc.UnaryServerInterceptor {
+	if handle == nil {
+		handle = func(context.Context, any) error {
+			return status.Error(codes.Internal, "internal error")
+		}
+	}
+
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (resp any, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				log.Error("recovered from panic",
+					slog.String("method", info.FullMethod),
+					slog.String("request_id", requestid.FromContext(ctx)),
+					slog.Any("panic", p),
+					slog.String("stack", string(debug.Stack())),
+				)
+
+				resp, err = nil, handle(ctx, p)
+			}
+		}()
+
+		return handler(ctx, req)
+	}
+}
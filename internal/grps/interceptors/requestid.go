@@ -0,0 +1,50 @@
+package interceptors
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"sso/internal/lib/requestid"
+)
+
+// RequestIDHeader is the metadata key the correlation ID is read from and sent back in.
+const RequestIDHeader = "x-request-id"
+
+// RequestID puts the correlation ID of the call into its context, see requestid.FromContext.
+//
+// The ID is taken from the x-request-id metadata if the client sent a valid one,
+// otherwise a new UUID is generated. Either way it is sent back in the response header,
+// so clients can refer to it in bug reports.
+func RequestID() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		id := incomingRequestID(ctx)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+
+		ctx = requestid.NewContext(ctx, id)
+
+		_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, id))
+
+		return handler(ctx, req)
+	}
+}
+
+func incomingRequestID(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	ids := md.Get(RequestIDHeader)
+	if len(ids) == 0 {
+		return ""
+	}
+
+	return ids[0]
+}
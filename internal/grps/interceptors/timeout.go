@@ -0,0 +1,42 @@
+package interceptors
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// Timeout sets a deadline of d on requests, a shorter deadline set by
+// the client is kept. If d is zero, requests have no default deadline.
+// methods overrides d for methods by their full name, e.g. "/auth.Auth/Login",
+// so slow methods get more time than cheap ones.
+//
+// If the request fails after its context is done, the error is replaced
+// with DeadlineExceeded or Canceled, so clients don't see it as an internal error.
+func Timeout(d time.Duration, methods map[string]time.Duration) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		timeout := d
+		if t, ok := methods[info.FullMethod]; ok {
+			timeout = t
+		}
+
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		resp, err := handler(ctx, req)
+		if err != nil && ctx.Err() != nil {
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+
+		return resp, err
+	}
+}
@@ -0,0 +1,92 @@
+package interceptors
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+// slowHandler takes d to answer, it gives up with an internal error once ctx is done,
+// like a storage call would.
+func slowHandler(d time.Duration) grpc.UnaryHandler {
+	return func(ctx context.Context, req any) (any, error) {
+		select {
+		case <-time.After(d):
+			return "done", nil
+		case <-ctx.Done():
+			return nil, status.Error(codes.Internal, "query canceled")
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	timeout := Timeout(20*time.Millisecond, map[string]time.Duration{
+		"/auth.Auth/Login":   time.Second,
+		"/auth.Auth/IsAdmin": 5 * time.Millisecond,
+	})
+
+	tests := []struct {
+		name    string
+		method  string
+		handler time.Duration
+		code    codes.Code
+	}{
+		{"default timeout exceeded", "/auth.Auth/Register", 200 * time.Millisecond, codes.DeadlineExceeded},
+		{"within default timeout", "/auth.Auth/Register", 0, codes.OK},
+		{"longer override", "/auth.Auth/Login", 50 * time.Millisecond, codes.OK},
+		{"shorter override exceeded", "/auth.Auth/IsAdmin", 10 * time.Millisecond, codes.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := timeout(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, slowHandler(tt.handler))
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("got %v, want %s", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestTimeoutKeepsShorterClientDeadline(t *testing.T) {
+	timeout := Timeout(time.Second, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := timeout(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/auth.Auth/Login"}, slowHandler(time.Second))
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("got %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("handler ran %s, the deadline of the client was ignored", elapsed)
+	}
+}
+
+func TestTimeoutCanceledByClient(t *testing.T) {
+	timeout := Timeout(time.Second, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := timeout(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/auth.Auth/Login"}, slowHandler(time.Second))
+	if status.Code(err) != codes.Canceled {
+		t.Fatalf("got %v, want Canceled", err)
+	}
+}
+
+func TestTimeoutKeepsErrorsOfHandler(t *testing.T) {
+	timeout := Timeout(time.Second, nil)
+
+	handler := func(ctx context.Context, req any) (any, error) {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+
+	_, err := timeout(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/auth.Auth/IsAdmin"}, handler)
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("got %v, want NotFound", err)
+	}
+}
@@ -0,0 +1,121 @@
+// Package cors lets browsers call HTTP endpoints from pages of other origins.
+package cors
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AnyOrigin in Config.AllowedOrigins allows every origin.
+const AnyOrigin = "*"
+
+type Config struct {
+	// AllowedOrigins are origins pages may call from, e.g. "https://app.example.com".
+	// Requests from other origins are served without CORS headers, so browsers block them.
+	AllowedOrigins []string
+	// AllowedMethods are methods of requests browsers may send, e.g. POST.
+	AllowedMethods []string
+	// AllowedHeaders are headers browsers may send besides the simple ones, e.g. Authorization.
+	AllowedHeaders []string
+	// ExposedHeaders are response headers pages may read, e.g. the request id.
+	ExposedHeaders []string
+	// MaxAge is how long browsers may cache preflight responses, 0 leaves it to the browser.
+	MaxAge time.Duration
+	// AllowCredentials lets pages send cookies, e.g. the token cookie of the gateway.
+	// It must not be combined with AnyOrigin.
+	AllowCredentials bool
+}
+
+// Handler adds CORS headers to responses of next for allowed origins
+// and answers preflight requests itself.
+func Handler(cfg Config, next http.Handler) http.Handler {
+	allowedMethods := strings.Join(cfg.AllowedMethods, ", ")
+	allowedHeaders := make([]string, 0, len(cfg.AllowedHeaders))
+	for _, h := range cfg.AllowedHeaders {
+		allowedHeaders = append(allowedHeaders, http.CanonicalHeaderKey(h))
+	}
+	exposedHeaders := strings.Join(cfg.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Responses differ by origin, so caches must not share them between origins.
+		w.Header().Add("Vary", "Origin")
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if !allowedOrigin(cfg.AllowedOrigins, origin) {
+			if preflight {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if cfg.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if exposedHeaders != "" {
+				w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
+			}
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+		// A preflight of a method or headers that aren't allowed is answered
+		// without the allow headers, so the browser doesn't send the request.
+		if !slices.Contains(cfg.AllowedMethods, r.Header.Get("Access-Control-Request-Method")) ||
+			!allowedRequestHeaders(allowedHeaders, r.Header.Get("Access-Control-Request-Headers")) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", allowedMethods)
+		if len(allowedHeaders) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(allowedHeaders, ", "))
+		}
+		if cfg.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", maxAge)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func allowedOrigin(allowed []string, origin string) bool {
+	return slices.Contains(allowed, AnyOrigin) || slices.Contains(allowed, origin)
+}
+
+// allowedRequestHeaders reports whether all headers of a preflight request are allowed,
+// requested is the comma separated Access-Control-Request-Headers.
+func allowedRequestHeaders(allowed []string, requested string) bool {
+	for _, h := range strings.Split(requested, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+
+		if !slices.Contains(allowed, http.CanonicalHeaderKey(h)) {
+			return false
+		}
+	}
+
+	return true
+}
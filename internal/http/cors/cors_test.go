@@ -0,0 +1,151 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const spa = "https://app.example.com"
+
+func newTestHandler() (http.Handler, *int) {
+	calls := 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+	})
+
+	return Handler(Config{
+		AllowedOrigins:   []string{spa},
+		AllowedMethods:   []string{http.MethodPost},
+		AllowedHeaders:   []string{"authorization", "Content-Type"},
+		ExposedHeaders:   []string{"X-Request-Id"},
+		MaxAge:           10 * time.Minute,
+		AllowCredentials: true,
+	}, next), &calls
+}
+
+func preflight(origin string, method string, headers string) *http.Request {
+	r := httptest.NewRequest(http.MethodOptions, "/v1/login", nil)
+	r.Header.Set("Origin", origin)
+	r.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		r.Header.Set("Access-Control-Request-Headers", headers)
+	}
+
+	return r
+}
+
+func TestPreflight(t *testing.T) {
+	h, calls := newTestHandler()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, preflight(spa, http.MethodPost, "Authorization, content-type"))
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status: got %d, want %d", w.Code, http.StatusNoContent)
+	}
+	for header, want := range map[string]string{
+		"Access-Control-Allow-Origin":      spa,
+		"Access-Control-Allow-Methods":     "POST",
+		"Access-Control-Allow-Headers":     "Authorization, Content-Type",
+		"Access-Control-Max-Age":           "600",
+		"Access-Control-Allow-Credentials": "true",
+	} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("%s: got %q, want %q", header, got, want)
+		}
+	}
+	if *calls != 0 {
+		t.Fatal("preflight passed to the handler")
+	}
+}
+
+func TestPreflightDenied(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     *http.Request
+		allowed bool
+	}{
+		{"other origin", preflight("https://evil.example.com", http.MethodPost, ""), false},
+		{"method not allowed", preflight(spa, http.MethodDelete, ""), true},
+		{"header not allowed", preflight(spa, http.MethodPost, "X-Custom"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, calls := newTestHandler()
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, tt.req)
+
+			if w.Code != http.StatusNoContent {
+				t.Fatalf("status: got %d, want %d", w.Code, http.StatusNoContent)
+			}
+			// Without the allow headers the browser doesn't send the request.
+			if got := w.Header().Get("Access-Control-Allow-Methods"); got != "" {
+				t.Fatalf("Access-Control-Allow-Methods: got %q, want none", got)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin") != ""; got != tt.allowed {
+				t.Fatalf("Access-Control-Allow-Origin set: %t, want %t", got, tt.allowed)
+			}
+			if *calls != 0 {
+				t.Fatal("preflight passed to the handler")
+			}
+		})
+	}
+}
+
+func TestActualRequest(t *testing.T) {
+	h, calls := newTestHandler()
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/login", nil)
+	r.Header.Set("Origin", spa)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK || *calls != 1 {
+		t.Fatalf("status %d, handler called %d times, want 200 and once", w.Code, *calls)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != spa {
+		t.Errorf("Access-Control-Allow-Origin: got %q, want %q", got, spa)
+	}
+	if got := w.Header().Get("Access-Control-Expose-Headers"); got != "X-Request-Id" {
+		t.Errorf("Access-Control-Expose-Headers: got %q, want X-Request-Id", got)
+	}
+	if got := w.Header().Get("Vary"); got != "Origin" {
+		t.Errorf("Vary: got %q, want Origin", got)
+	}
+}
+
+func TestActualRequestOfOtherOrigin(t *testing.T) {
+	h, calls := newTestHandler()
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/login", nil)
+	r.Header.Set("Origin", "https://evil.example.com")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	// The request is served, but the browser hides the response from the page.
+	if *calls != 1 {
+		t.Fatalf("handler called %d times, want once", *calls)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("Access-Control-Allow-Origin: got %q, want none", got)
+	}
+}
+
+func TestAnyOrigin(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := Handler(Config{AllowedOrigins: []string{AnyOrigin}, AllowedMethods: []string{http.MethodPost}}, next)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, preflight("https://any.example.com", http.MethodPost, ""))
+
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://any.example.com" {
+		t.Fatalf("Access-Control-Allow-Origin: got %q, want the origin", got)
+	}
+}
@@ -0,0 +1,191 @@
+// Package gateway exposes a part of the gRPC API as HTTP/JSON endpoints,
+// for clients that can't speak gRPC.
+package gateway
+
+import (
+	"context"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	ssov1 "github.com/roxxxiey/protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"net/textproto"
+	"sso/internal/grps/interceptors"
+	"strconv"
+	"time"
+)
+
+// maxBodySize bounds request bodies, requests of the exposed methods are small.
+const maxBodySize = 64 << 10
+
+// Config holds settings of the gateway.
+type Config struct {
+	// TokenCookie sets the access token of Login as a cookie, see TokenCookie.
+	TokenCookie TokenCookie
+}
+
+// TokenCookie is the cookie Login sets the access token in, so pages don't have
+// to keep the token where scripts can read it. The cookie is always HttpOnly
+// and expires with the token. Empty Name disables it.
+type TokenCookie struct {
+	Name   string
+	Domain string
+	Path   string
+	Secure bool
+	// SameSite is http.SameSiteDefaultMode if unset.
+	SameSite http.SameSite
+	// OmitBody removes the token from the JSON body, so it is only sent in the cookie.
+	OmitBody bool
+}
+
+// New returns a handler serving:
+//
+//	POST /v1/register              Register
+//	POST /v1/login                 Login
+//	GET  /v1/users/{user_id}/admin IsAdmin
+//
+// Request and response bodies are the JSON forms of the gRPC messages with field names
+// as in the proto files, e.g. {"email": "...", "password": "...", "app_id": 1} for login.
+// Responses include fields with zero values, e.g. "is_admin": false.
+// Calls are made through conn, so they pass the same interceptors as gRPC calls,
+// e.g. IsAdmin needs an access token of the user or of an admin in the Authorization header.
+// Errors are JSON statuses with the HTTP code matching the gRPC one,
+// e.g. 401 for UNAUTHENTICATED and 429 for RESOURCE_EXHAUSTED.
+func New(conn grpc.ClientConnInterface, cfg Config) (http.Handler, error) {
+	client := ssov1.NewAuthClient(conn)
+
+	var opts []runtime.ServeMuxOption
+	if cfg.TokenCookie.Name != "" {
+		opts = append(opts, runtime.WithForwardResponseOption(cfg.TokenCookie.set))
+	}
+
+	mux := runtime.NewServeMux(append(opts,
+		runtime.WithIncomingHeaderMatcher(headerMatcher),
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.JSONPb{
+			MarshalOptions: protojson.MarshalOptions{
+				UseProtoNames:   true,
+				EmitUnpopulated: true,
+			},
+		}),
+	)...)
+
+	routes := []struct {
+		method  string
+		pattern string
+		handler runtime.HandlerFunc
+	}{
+		{http.MethodPost, "/v1/register", handle(mux, "Register", "/v1/register",
+			func(ctx context.Context, req *ssov1.RegisterRequest, _ map[string]string, opts ...grpc.CallOption) (proto.Message, error) {
+				return client.Register(ctx, req, opts...)
+			})},
+		{http.MethodPost, "/v1/login", handle(mux, "Login", "/v1/login",
+			func(ctx context.Context, req *ssov1.LoginRequest, _ map[string]string, opts ...grpc.CallOption) (proto.Message, error) {
+				return client.Login(ctx, req, opts...)
+			})},
+		{http.MethodGet, "/v1/users/{user_id}/admin", handle(mux, "IsAdmin", "/v1/users/{user_id}/admin",
+			func(ctx context.Context, req *ssov1.IsAdminRequest, params map[string]string, opts ...grpc.CallOption) (proto.Message, error) {
+				userID, err := strconv.ParseInt(params["user_id"], 10, 64)
+				if err != nil {
+					return nil, status.Error(codes.InvalidArgument, "user_id must be an integer")
+				}
+				req.UserId = userID
+
+				return client.IsAdmin(ctx, req, opts...)
+			})},
+	}
+
+	for _, route := range routes {
+		if err := mux.HandlePath(route.method, route.pattern, route.handler); err != nil {
+			return nil, err
+		}
+	}
+
+	return mux, nil
+}
+
+// call makes the gRPC call of a route, params are variables of its path.
+type call[Req any] func(ctx context.Context, req *Req, params map[string]string, opts ...grpc.CallOption) (proto.Message, error)
+
+// handle decodes the request of the Auth method from the body, unless it is a GET request,
+// makes the call and writes its response.
+func handle[Req any, PReq interface {
+	*Req
+	proto.Message
+}](mux *runtime.ServeMux, method string, pattern string, fn call[Req]) runtime.HandlerFunc {
+	fullMethod := "/" + ssov1.Auth_ServiceDesc.ServiceName + "/" + method
+
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		inbound, outbound := runtime.MarshalerForRequest(mux, r)
+
+		ctx, err := runtime.AnnotateContext(r.Context(), mux, r, fullMethod, runtime.WithHTTPPathPattern(pattern))
+		if err != nil {
+			runtime.HTTPError(r.Context(), mux, outbound, w, r, err)
+			return
+		}
+
+		req := PReq(new(Req))
+
+		if r.Method != http.MethodGet {
+			body := http.MaxBytesReader(w, r.Body, maxBodySize)
+			if err := inbound.NewDecoder(body).Decode(req); err != nil {
+				runtime.HTTPError(ctx, mux, outbound, w, r, status.Errorf(codes.InvalidArgument, "invalid request body: %v", err))
+				return
+			}
+		}
+
+		// Headers and trailers of the call, e.g. the request id, are sent back to the client.
+		var md runtime.ServerMetadata
+		resp, err := fn(ctx, (*Req)(req), params, grpc.Header(&md.HeaderMD), grpc.Trailer(&md.TrailerMD))
+		ctx = runtime.NewServerMetadataContext(ctx, md)
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, r, err)
+			return
+		}
+
+		runtime.ForwardResponseMessage(ctx, mux, outbound, w, r, resp, mux.GetForwardResponseOptions()...)
+	}
+}
+
+// set sets the cookie from a login response, other responses are left as they are.
+// It runs before the response is written, so the token can still be removed from it.
+func (c TokenCookie) set(_ context.Context, w http.ResponseWriter, msg proto.Message) error {
+	resp, ok := msg.(*ssov1.LoginResponse)
+	if !ok || resp.GetToken() == "" {
+		return nil
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.Name,
+		Value:    resp.GetToken(),
+		Domain:   c.Domain,
+		Path:     c.Path,
+		Expires:  time.Unix(resp.GetExpiresAt(), 0),
+		MaxAge:   int(time.Until(time.Unix(resp.GetExpiresAt(), 0)).Seconds()),
+		Secure:   c.Secure,
+		HttpOnly: true,
+		SameSite: c.SameSite,
+	})
+
+	if c.OmitBody {
+		resp.Token = ""
+	}
+
+	return nil
+}
+
+// headerMatcher forwards only the headers the gRPC server uses. Other headers,
+// including Grpc-Metadata-* ones, are dropped, so clients can't pass arbitrary metadata.
+// The authorization header is always forwarded by the gateway.
+func headerMatcher(key string) (string, bool) {
+	switch textproto.CanonicalMIMEHeaderKey(key) {
+	case "X-Request-Id":
+		return interceptors.RequestIDHeader, true
+	case "User-Agent":
+		return runtime.MetadataPrefix + "user-agent", true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,47 @@
+package jwks
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sso/internal/lib/jwt"
+)
+
+// Path is the well-known location of the key set document.
+const Path = "/.well-known/jwks.json"
+
+// KeyProvider returns public keys tokens can be verified with, by kid.
+type KeyProvider interface {
+	PublicKeys() map[string]*rsa.PublicKey
+}
+
+// Register registers the JWKS handler on mux.
+//
+// keys may be nil, in which case an empty key set is served.
+func Register(mux *http.ServeMux, log *slog.Logger, keys KeyProvider) {
+	mux.Handle("GET "+Path, New(log, keys))
+}
+
+// New returns a handler serving the current public keys as a JWKS document.
+//
+// Keys are read on every request, so rotated keys are served without restart.
+func New(log *slog.Logger, keys KeyProvider) http.Handler {
+	const op = "http.jwks"
+
+	log = log.With(slog.String("op", op))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var publicKeys map[string]*rsa.PublicKey
+		if keys != nil {
+			publicKeys = keys.PublicKeys()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+
+		if err := json.NewEncoder(w).Encode(jwt.NewJWKS(publicKeys)); err != nil {
+			log.Error("failed to write jwks", "error", err)
+		}
+	})
+}
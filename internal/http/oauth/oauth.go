@@ -0,0 +1,280 @@
+// Package oauth serves sign ins with identity providers, e.g. Google, to browsers.
+//
+// The browser is sent to the provider by the start endpoint and comes back to the callback,
+// which logs the user in and returns tokens the same way Login of the gateway does.
+package oauth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"sso/internal/services/auth"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Paths of the endpoints, provider is the name of the identity provider, e.g. "google".
+const (
+	StartPath    = "/oauth/{provider}/start"
+	LinkPath     = "/oauth/{provider}/link"
+	CallbackPath = "/oauth/{provider}/callback"
+)
+
+// StateCookie binds the state to the browser that started the sign in, so a callback
+// URL of somebody else's sign in can't be used to log the browser in to their account.
+const StateCookie = "sso_oauth_state"
+
+// Auth starts and finishes sign ins with identity providers, see auth.Auth.
+type Auth interface {
+	StartOAuth(ctx context.Context, provider string, appID int, linkUserID int64) (authURL string, state string, err error)
+	FinishOAuth(ctx context.Context, provider string, state string, code string) (
+		token string,
+		refreshToken string,
+		userID int64,
+		expiresAt time.Time,
+		err error,
+	)
+	ValidateToken(ctx context.Context, token string, expectedAppID int) (userID int64, appID int, expiresAt time.Time, err error)
+}
+
+// MaintenanceMode is whether maintenance is on, see maintenance.Mode.
+type MaintenanceMode interface {
+	Enabled() bool
+}
+
+type Config struct {
+	// StateTTL is how long the state cookie lives, it should match the state TTL of the service.
+	StateTTL time.Duration
+	// Insecure lets the state cookie be sent over plain HTTP, for local development.
+	Insecure bool
+	// Maintenance refuses sign ins while it is on, it may be nil.
+	Maintenance MaintenanceMode
+	// RetryAfter is sent in the Retry-After header of sign ins refused during maintenance.
+	RetryAfter time.Duration
+}
+
+type loginResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	UserID       int64  `json:"user_id"`
+	ExpiresAt    int64  `json:"expires_at"`
+}
+
+type linkResponse struct {
+	URL string `json:"url"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+	// Reason is set for errors clients are expected to handle, e.g. ACCOUNT_LINK_REQUIRED.
+	Reason string `json:"reason,omitempty"`
+}
+
+// reasons of errors of sign ins clients should switch on rather than on messages.
+var reasons = map[*auth.Error]string{
+	auth.ErrAccountLinkRequired:      "ACCOUNT_LINK_REQUIRED",
+	auth.ErrIdentityLinked:           "IDENTITY_LINKED",
+	auth.ErrIdentityEmailNotVerified: "IDENTITY_EMAIL_NOT_VERIFIED",
+	auth.ErrEmailNotVerified:         "EMAIL_NOT_VERIFIED",
+	auth.ErrInvalidOAuthState:        "INVALID_STATE",
+}
+
+type handler struct {
+	log  *slog.Logger
+	auth Auth
+	cfg  Config
+}
+
+// Register registers the sign in endpoints on mux:
+//
+//   - GET StartPath?app_id=N redirects the browser to the provider to sign in to the app.
+//   - POST LinkPath with the access token of a user in the Authorization header returns
+//     the URL of the provider to send the browser to, to link the account there to the user.
+//   - GET CallbackPath is where the provider redirects back to, it responds with tokens.
+func Register(mux *http.ServeMux, log *slog.Logger, a Auth, cfg Config) {
+	h := &handler{
+		log:  log.With(slog.String("op", "http.oauth")),
+		auth: a,
+		cfg:  cfg,
+	}
+
+	mux.HandleFunc("GET "+StartPath, h.start)
+	mux.HandleFunc("POST "+LinkPath, h.link)
+	mux.HandleFunc("GET "+CallbackPath, h.callback)
+}
+
+func (h *handler) start(w http.ResponseWriter, r *http.Request) {
+	if h.refuseInMaintenance(w) {
+		return
+	}
+
+	appID, err := strconv.Atoi(r.URL.Query().Get("app_id"))
+	if err != nil || appID <= 0 {
+		h.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "app_id is required"})
+		return
+	}
+
+	authURL, state, err := h.auth.StartOAuth(r.Context(), r.PathValue("provider"), appID, 0)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.setStateCookie(w, state)
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (h *handler) link(w http.ResponseWriter, r *http.Request) {
+	if h.refuseInMaintenance(w) {
+		return
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		h.writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "access token is required"})
+		return
+	}
+
+	userID, appID, _, err := h.auth.ValidateToken(r.Context(), token, 0)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	authURL, state, err := h.auth.StartOAuth(r.Context(), r.PathValue("provider"), appID, userID)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.setStateCookie(w, state)
+
+	h.writeJSON(w, http.StatusOK, linkResponse{URL: authURL})
+}
+
+func (h *handler) callback(w http.ResponseWriter, r *http.Request) {
+	if h.refuseInMaintenance(w) {
+		return
+	}
+
+	query := r.URL.Query()
+
+	// The provider redirects back with an error, e.g. access_denied, if the user cancels.
+	if providerErr := query.Get("error"); providerErr != "" {
+		h.clearStateCookie(w)
+		h.writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "sign in failed: " + providerErr})
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(StateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		h.log.Warn("state doesn't match state cookie")
+
+		h.writeJSON(w, http.StatusBadRequest, errorResponse{
+			Error:  auth.ErrInvalidOAuthState.Error(),
+			Reason: reasons[auth.ErrInvalidOAuthState],
+		})
+		return
+	}
+
+	// The state is consumed by the service whatever the outcome, so the cookie is of no use anymore.
+	h.clearStateCookie(w)
+
+	token, refreshToken, userID, expiresAt, err := h.auth.FinishOAuth(r.Context(), r.PathValue("provider"), state, query.Get("code"))
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	h.writeJSON(w, http.StatusOK, loginResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		UserID:       userID,
+		ExpiresAt:    expiresAt.Unix(),
+	})
+}
+
+func (h *handler) refuseInMaintenance(w http.ResponseWriter) bool {
+	if h.cfg.Maintenance == nil || !h.cfg.Maintenance.Enabled() {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(h.cfg.RetryAfter.Seconds()))))
+	h.writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: "service is under maintenance, try again shortly"})
+
+	return true
+}
+
+func (h *handler) setStateCookie(w http.ResponseWriter, state string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     StateCookie,
+		Value:    state,
+		Path:     "/oauth/",
+		MaxAge:   int(h.cfg.StateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   !h.cfg.Insecure,
+		// Lax, as the callback is a cross site redirect from the provider.
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (h *handler) clearStateCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     StateCookie,
+		Path:     "/oauth/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   !h.cfg.Insecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// writeError responds with the status of the kind of the service error,
+// internal errors are not disclosed.
+func (h *handler) writeError(w http.ResponseWriter, err error) {
+	var e *auth.Error
+	if !errors.As(err, &e) || e.Kind() == auth.KindInternal {
+		h.log.Error("failed to sign in", "error", err)
+
+		h.writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "internal error"})
+		return
+	}
+
+	h.writeJSON(w, statusOf(e.Kind()), errorResponse{Error: e.Error(), Reason: reasons[e]})
+}
+
+// statusOf returns the HTTP status of errors of kind, the same the gateway returns for the gRPC code.
+func statusOf(kind auth.Kind) int {
+	switch kind {
+	case auth.KindInvalidArgument, auth.KindFailedPrecondition:
+		return http.StatusBadRequest
+	case auth.KindNotFound:
+		return http.StatusNotFound
+	case auth.KindAlreadyExists:
+		return http.StatusConflict
+	case auth.KindUnauthenticated:
+		return http.StatusUnauthorized
+	case auth.KindPermissionDenied:
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (h *handler) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.log.Error("failed to write response", "error", err)
+	}
+}
@@ -0,0 +1,152 @@
+// Package backoff slows down repeated failures, e.g. guessing passwords.
+package backoff
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// Base is the delay after the first failure.
+	Base time.Duration
+	// Max bounds the delay however many failures there were, 0 means no bound.
+	Max time.Duration
+	// Factor is how many times the delay grows with every next failure.
+	Factor float64
+	// ResetAfter is how long failures are remembered after the last one.
+	ResetAfter time.Duration
+}
+
+// Backoff delays attempts per key after consecutive failures.
+//
+// The delay after n failures in a row is Base * Factor^(n-1), at most Max if it is set,
+// with jitter, so attempts of many clients don't line up. Keys without
+// failures are not delayed at all. It is safe for concurrent use.
+type Backoff struct {
+	mu       sync.Mutex
+	cfg      Config
+	failures map[string]failures
+	// lastSweep is when forgotten failures were last removed.
+	lastSweep time.Time
+
+	// Now returns current time. It can be replaced in tests.
+	Now func() time.Time
+	// Sleep waits for d or until ctx is done. It can be replaced in tests.
+	Sleep func(ctx context.Context, d time.Duration) error
+}
+
+type failures struct {
+	count int
+	last  time.Time
+}
+
+func New(cfg Config) *Backoff {
+	return &Backoff{
+		cfg:      cfg,
+		failures: make(map[string]failures),
+		Now:      time.Now,
+		Sleep:    sleep,
+	}
+}
+
+// Wait delays the attempt for key by its current delay.
+// It returns ctx.Err() if ctx is done before the delay is over.
+func (b *Backoff) Wait(ctx context.Context, key string) error {
+	d := b.Delay(key)
+	if d <= 0 {
+		return nil
+	}
+
+	// Jitter keeps at least half of the delay, so it still slows guessing down.
+	return b.Sleep(ctx, d/2+rand.N(d/2+1))
+}
+
+// Delay returns the delay of the next attempt for key, without jitter.
+func (b *Backoff) Delay(key string) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	f, ok := b.get(key)
+	if !ok {
+		return 0
+	}
+
+	d := float64(b.cfg.Base) * math.Pow(b.cfg.Factor, float64(f.count-1))
+	if b.cfg.Max > 0 && d >= float64(b.cfg.Max) {
+		return b.cfg.Max
+	}
+	// Without a bound the delay still must fit a Duration.
+	if d >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+
+	return time.Duration(d)
+}
+
+// Fail records a failed attempt for key, increasing its delay.
+func (b *Backoff) Fail(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.sweep()
+
+	f, _ := b.get(key)
+	f.count++
+	f.last = b.Now()
+	b.failures[key] = f
+}
+
+// Reset forgets failures of key, so its next attempt isn't delayed.
+func (b *Backoff) Reset(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.failures, key)
+}
+
+// get returns failures of key unless they are forgotten already.
+func (b *Backoff) get(key string) (failures, bool) {
+	f, ok := b.failures[key]
+	if !ok {
+		return failures{}, false
+	}
+
+	if b.Now().Sub(f.last) >= b.cfg.ResetAfter {
+		delete(b.failures, key)
+
+		return failures{}, false
+	}
+
+	return f, true
+}
+
+// sweep removes forgotten failures, so keys that are never seen again don't pile up.
+func (b *Backoff) sweep() {
+	now := b.Now()
+	if now.Sub(b.lastSweep) < b.cfg.ResetAfter {
+		return
+	}
+
+	for key, f := range b.failures {
+		if now.Sub(f.last) >= b.cfg.ResetAfter {
+			delete(b.failures, key)
+		}
+	}
+
+	b.lastSweep = now
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
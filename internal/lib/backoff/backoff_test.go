@@ -0,0 +1,186 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// newTestBackoff returns a backoff whose time is *now and which records
+// delays it sleeps for instead of sleeping.
+func newTestBackoff(cfg Config, now *time.Time) (*Backoff, *[]time.Duration) {
+	b := New(cfg)
+	b.Now = func() time.Time { return *now }
+
+	var slept []time.Duration
+	b.Sleep = func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+
+		return ctx.Err()
+	}
+
+	return b, &slept
+}
+
+func TestDelay(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		// want is the delay after 0, 1, 2... failures in a row.
+		want []time.Duration
+	}{
+		{
+			name: "grows by factor",
+			cfg:  Config{Base: 100 * time.Millisecond, Max: time.Hour, Factor: 2, ResetAfter: time.Hour},
+			want: []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond},
+		},
+		{
+			name: "fractional factor",
+			cfg:  Config{Base: time.Second, Max: time.Hour, Factor: 1.5, ResetAfter: time.Hour},
+			want: []time.Duration{0, time.Second, 1500 * time.Millisecond, 2250 * time.Millisecond},
+		},
+		{
+			name: "capped by max",
+			cfg:  Config{Base: time.Second, Max: 3 * time.Second, Factor: 2, ResetAfter: time.Hour},
+			want: []time.Duration{0, time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second},
+		},
+		{
+			name: "zero max is no cap",
+			cfg:  Config{Base: time.Second, Factor: 10, ResetAfter: time.Hour},
+			want: []time.Duration{0, time.Second, 10 * time.Second, 100 * time.Second},
+		},
+		{
+			name: "factor of one is constant",
+			cfg:  Config{Base: time.Second, Max: time.Minute, Factor: 1, ResetAfter: time.Hour},
+			want: []time.Duration{0, time.Second, time.Second, time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			b, _ := newTestBackoff(tt.cfg, &now)
+
+			for n, want := range tt.want {
+				if n > 0 {
+					b.Fail("key")
+				}
+
+				if got := b.Delay("key"); got != want {
+					t.Fatalf("delay after %d failures: got %s, want %s", n, got, want)
+				}
+			}
+
+			if got := b.Delay("other"); got != 0 {
+				t.Fatalf("failures of one key delay another by %s", got)
+			}
+		})
+	}
+}
+
+func TestDelayWithoutCapFitsDuration(t *testing.T) {
+	now := time.Now()
+	b, _ := newTestBackoff(Config{Base: time.Second, Factor: 1000, ResetAfter: time.Hour}, &now)
+
+	for i := 0; i < 10; i++ {
+		b.Fail("key")
+	}
+
+	if got := b.Delay("key"); got != math.MaxInt64 {
+		t.Fatalf("got %s, want the longest Duration", got)
+	}
+}
+
+func TestReset(t *testing.T) {
+	now := time.Now()
+	b, _ := newTestBackoff(Config{Base: time.Second, Max: time.Minute, Factor: 2, ResetAfter: time.Hour}, &now)
+
+	b.Fail("key")
+	b.Fail("key")
+	b.Reset("key")
+
+	if got := b.Delay("key"); got != 0 {
+		t.Fatalf("delay after reset: got %s, want 0", got)
+	}
+
+	// Counting starts over.
+	b.Fail("key")
+	if got := b.Delay("key"); got != time.Second {
+		t.Fatalf("delay after a failure following reset: got %s, want 1s", got)
+	}
+}
+
+func TestForgetsFailuresAfterResetAfter(t *testing.T) {
+	now := time.Now()
+	b, _ := newTestBackoff(Config{Base: time.Second, Max: time.Minute, Factor: 2, ResetAfter: time.Minute}, &now)
+
+	b.Fail("key")
+	now = now.Add(30 * time.Second)
+	b.Fail("key")
+
+	// ResetAfter counts from the last failure.
+	now = now.Add(59 * time.Second)
+	if got := b.Delay("key"); got != 2*time.Second {
+		t.Fatalf("delay within ResetAfter: got %s, want 2s", got)
+	}
+
+	now = now.Add(time.Second)
+	if got := b.Delay("key"); got != 0 {
+		t.Fatalf("delay after ResetAfter: got %s, want 0", got)
+	}
+}
+
+func TestSweepRemovesForgottenFailures(t *testing.T) {
+	now := time.Now()
+	b, _ := newTestBackoff(Config{Base: time.Second, Max: time.Minute, Factor: 2, ResetAfter: time.Minute}, &now)
+
+	b.Fail("a")
+	now = now.Add(time.Minute)
+	b.Fail("b")
+
+	if _, ok := b.failures["a"]; ok {
+		t.Fatal("forgotten failures of a are kept")
+	}
+	if f, ok := b.failures["b"]; !ok || f.count != 1 {
+		t.Fatalf("failures of b are %+v, %t, want 1", f, ok)
+	}
+}
+
+func TestWait(t *testing.T) {
+	now := time.Now()
+	b, slept := newTestBackoff(Config{Base: time.Second, Max: time.Minute, Factor: 2, ResetAfter: time.Hour}, &now)
+
+	if err := b.Wait(context.Background(), "key"); err != nil {
+		t.Fatalf("wait without failures: %v", err)
+	}
+	if len(*slept) != 0 {
+		t.Fatalf("slept %v without failures", *slept)
+	}
+
+	b.Fail("key")
+	b.Fail("key")
+
+	if err := b.Wait(context.Background(), "key"); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+
+	// Jitter keeps between half and all of the 2s delay.
+	if len(*slept) != 1 || (*slept)[0] < time.Second || (*slept)[0] > 2*time.Second {
+		t.Fatalf("slept %v, want once between 1s and 2s", *slept)
+	}
+}
+
+func TestWaitCancelled(t *testing.T) {
+	b := New(Config{Base: time.Hour, Max: time.Hour, Factor: 2, ResetAfter: time.Hour})
+	b.Fail("key")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The real sleep is used, so a wait ignoring ctx would hang for an hour.
+	if err := b.Wait(ctx, "key"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+}
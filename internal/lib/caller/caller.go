@@ -0,0 +1,28 @@
+// Package caller carries the authenticated user of a request in its context.
+package caller
+
+import "context"
+
+// Caller is the user whose access token was presented with the request.
+type Caller struct {
+	UserID int64
+	// AppID is the app the token was issued for.
+	AppID int
+	// Roles of the user in the app.
+	Roles []string
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying c.
+func NewContext(ctx context.Context, c Caller) context.Context {
+	return context.WithValue(ctx, ctxKey{}, c)
+}
+
+// FromContext returns the caller carried by ctx,
+// ok is false if the request wasn't authenticated.
+func FromContext(ctx context.Context) (c Caller, ok bool) {
+	c, ok = ctx.Value(ctxKey{}).(Caller)
+
+	return c, ok
+}
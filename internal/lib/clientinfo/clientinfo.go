@@ -0,0 +1,26 @@
+// Package clientinfo carries information about the client of a request in its context.
+package clientinfo
+
+import "context"
+
+// Info describes the client of a request.
+type Info struct {
+	// IP is the address of the client, empty if it is unknown,
+	// e.g. when the client connected over a unix socket.
+	IP        string
+	UserAgent string
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying info.
+func NewContext(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, ctxKey{}, info)
+}
+
+// FromContext returns the client info carried by ctx, zero Info if there is none.
+func FromContext(ctx context.Context) Info {
+	info, _ := ctx.Value(ctxKey{}).(Info)
+
+	return info
+}
@@ -0,0 +1,17 @@
+// Package clock tells the time. Code reading it through a Clock can be tested
+// with a clock that is set by the test, instead of sleeping.
+package clock
+
+import "time"
+
+// Clock returns the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// Real is the clock of the system.
+type Real struct{}
+
+func (Real) Now() time.Time {
+	return time.Now()
+}
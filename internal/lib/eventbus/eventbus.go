@@ -0,0 +1,145 @@
+// Package eventbus publishes events to NATS, so other services can subscribe to them.
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"log/slog"
+	"sso/internal/domain/models"
+	"strconv"
+	"time"
+)
+
+// Version is the version of the message format, it is sent in HeaderVersion
+// and in the version field of every message. Fields are only added within a version,
+// removing or changing the meaning of a field bumps it.
+const Version = 1
+
+// HeaderVersion carries Version, so consumers can pick a decoder before parsing the body.
+const HeaderVersion = "Sso-Event-Version"
+
+// message is the wire format of an event, see Version.
+type message struct {
+	Version int    `json:"version"`
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	UserID  int64  `json:"user_id"`
+	AppID   int    `json:"app_id"`
+	// Time is in RFC 3339 format with nanoseconds, always in UTC.
+	Time time.Time `json:"time"`
+}
+
+// Encode returns the message of the event as it is published.
+func Encode(event models.Event) ([]byte, error) {
+	return json.Marshal(message{
+		Version: Version,
+		ID:      event.ID,
+		Type:    event.Type,
+		UserID:  event.UserID,
+		AppID:   event.AppID,
+		Time:    event.Time.UTC(),
+	})
+}
+
+// Subject returns the subject an event of given type is published to,
+// e.g. "sso.events.user.registered" for prefix "sso.events".
+// Consumers subscribe to "<prefix>.>" for all events.
+func Subject(prefix string, eventType string) string {
+	return prefix + "." + eventType
+}
+
+type Config struct {
+	URL string
+	// Subject is the prefix of subjects of events, see Subject.
+	Subject string
+	// BufferSize is how many bytes of events are kept while the broker is unreachable.
+	BufferSize int
+}
+
+// Publisher publishes events to NATS.
+//
+// Publishing doesn't wait for the broker: messages are buffered by the client,
+// including while it reconnects after the broker became unreachable,
+// and are dropped with an error only when the buffer is full.
+// Every message has its event id in the Nats-Msg-Id header, so JetStream
+// streams deduplicate events published again after a reconnect.
+type Publisher struct {
+	conn    conn
+	subject string
+}
+
+// conn is the part of *nats.Conn the publisher uses, it is faked in tests.
+type conn interface {
+	PublishMsg(msg *nats.Msg) error
+	IsConnected() bool
+	FlushWithContext(ctx context.Context) error
+	Close()
+}
+
+// New connects to the broker. The service starts even if the broker is down,
+// the client keeps reconnecting in the background.
+func New(log *slog.Logger, cfg Config) (*Publisher, error) {
+	const op = "eventbus.New"
+
+	nc, err := nats.Connect(cfg.URL,
+		nats.Name("sso"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.ReconnectBufSize(cfg.BufferSize),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log.Warn("disconnected from event bus", slog.String("error", err.Error()))
+			}
+		}),
+		nats.ReconnectHandler(func(*nats.Conn) {
+			log.Info("reconnected to event bus")
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Publisher{
+		conn:    nc,
+		subject: cfg.Subject,
+	}, nil
+}
+
+// Publish buffers the event for publishing.
+func (p *Publisher) Publish(_ context.Context, event models.Event) error {
+	const op = "eventbus.Publish"
+
+	data, err := Encode(event)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	msg := nats.NewMsg(Subject(p.subject, event.Type))
+	msg.Data = data
+	msg.Header.Set(nats.MsgIdHdr, event.ID)
+	msg.Header.Set(HeaderVersion, strconv.Itoa(Version))
+
+	if err := p.conn.PublishMsg(msg); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Close sends buffered events to the broker and disconnects.
+// Events still buffered when ctx is done are dropped.
+func (p *Publisher) Close(ctx context.Context) error {
+	defer p.conn.Close()
+
+	if !p.conn.IsConnected() {
+		return fmt.Errorf("eventbus.Close: %w, buffered events are dropped", nats.ErrDisconnected)
+	}
+
+	if err := p.conn.FlushWithContext(ctx); err != nil {
+		return fmt.Errorf("eventbus.Close: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/nats-io/nats.go"
+	"sso/internal/domain/models"
+	"testing"
+	"time"
+)
+
+// fakeConn captures published messages instead of sending them to a broker.
+type fakeConn struct {
+	msgs      []*nats.Msg
+	err       error
+	connected bool
+	flushed   bool
+	closed    bool
+}
+
+func (c *fakeConn) PublishMsg(msg *nats.Msg) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	c.msgs = append(c.msgs, msg)
+
+	return nil
+}
+
+func (c *fakeConn) IsConnected() bool { return c.connected }
+
+func (c *fakeConn) FlushWithContext(context.Context) error {
+	c.flushed = true
+
+	return nil
+}
+
+func (c *fakeConn) Close() { c.closed = true }
+
+func TestPublish(t *testing.T) {
+	conn := &fakeConn{}
+	p := &Publisher{conn: conn, subject: "sso.events"}
+
+	event := models.Event{
+		ID:     "5f0c7a3e-1d1b-4c1e-9a57-2f6f0c1e8b11",
+		Type:   models.EventUserRegistered,
+		UserID: 42,
+		AppID:  7,
+		Time:   time.Date(2024, 5, 1, 12, 30, 0, 123, time.FixedZone("CEST", 2*60*60)),
+	}
+
+	if err := p.Publish(context.Background(), event); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	if len(conn.msgs) != 1 {
+		t.Fatalf("published %d messages, want 1", len(conn.msgs))
+	}
+	msg := conn.msgs[0]
+
+	if want := "sso.events." + models.EventUserRegistered; msg.Subject != want {
+		t.Errorf("subject: got %q, want %q", msg.Subject, want)
+	}
+	if got := msg.Header.Get(nats.MsgIdHdr); got != event.ID {
+		t.Errorf("message id header: got %q, want the event id", got)
+	}
+	if got := msg.Header.Get(HeaderVersion); got != "1" {
+		t.Errorf("version header: got %q, want 1", got)
+	}
+
+	// The format is a contract with consumers, it must not change within a version.
+	want := `{"version":1,"id":"5f0c7a3e-1d1b-4c1e-9a57-2f6f0c1e8b11","type":"` + models.EventUserRegistered +
+		`","user_id":42,"app_id":7,"time":"2024-05-01T10:30:00.000000123Z"}`
+	if string(msg.Data) != want {
+		t.Errorf("data:\ngot  %s\nwant %s", msg.Data, want)
+	}
+	if !json.Valid(msg.Data) {
+		t.Errorf("data is not JSON: %s", msg.Data)
+	}
+}
+
+func TestPublishError(t *testing.T) {
+	p := &Publisher{conn: &fakeConn{err: nats.ErrReconnectBufExceeded}, subject: "sso.events"}
+
+	err := p.Publish(context.Background(), models.Event{ID: "1", Type: models.EventUserDeleted})
+	if !errors.Is(err, nats.ErrReconnectBufExceeded) {
+		t.Fatalf("got %v, want the error of the connection", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	conn := &fakeConn{connected: true}
+	p := &Publisher{conn: conn, subject: "sso.events"}
+
+	if err := p.Close(context.Background()); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if !conn.flushed || !conn.closed {
+		t.Fatalf("flushed: %t, closed: %t, want both", conn.flushed, conn.closed)
+	}
+
+	// Buffered events can't be sent while disconnected, the caller is told they are dropped.
+	conn = &fakeConn{}
+	p = &Publisher{conn: conn, subject: "sso.events"}
+
+	if err := p.Close(context.Background()); !errors.Is(err, nats.ErrDisconnected) {
+		t.Fatalf("close while disconnected: got %v, want ErrDisconnected", err)
+	}
+	if !conn.closed {
+		t.Fatal("connection left open")
+	}
+}
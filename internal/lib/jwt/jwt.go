@@ -1,19 +1,115 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/golang-jwt/jwt/v5"
+	"slices"
 	"sso/internal/domain/models"
+	"sso/internal/lib/clock"
+	"strconv"
+	"strings"
 	"time"
 )
 
-func NewToken(user models.User, app models.App, duration time.Duration) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// Claims is the parsed payload of a token issued by NewToken.
+type Claims struct {
+	ID     string
+	Issuer string
+	UID    int64
+	Email  string
+	AppID  int
+	// Roles of the user in the app, from the "roles" claim.
+	Roles []string
+	// Scopes granted to the token, from the space-delimited "scope" claim, see HasScope.
+	Scopes []string
+	// TokenVersion is the token version of the user when the token was issued,
+	// from the "ver" claim.
+	TokenVersion int64
+	// Actor is the id of the app that got the token by exchanging a token of the user,
+	// from the "act" claim. It is 0 for tokens issued to the user directly.
+	Actor     int
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// Signing algorithms supported by the service.
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+)
+
+// Validation holds claims Parse checks in addition to the signature and expiry.
+type Validation struct {
+	// Issuer is the expected iss claim, it is not checked if empty.
+	Issuer string
+	// Audience is the expected aud claim, see Audience. It is not checked if empty,
+	// so a token of any app is accepted.
+	Audience string
+	// Leeway tolerates clock drift between servers when checking exp, nbf and iat.
+	Leeway time.Duration
+	// Clock is what exp, nbf and iat are checked against. If nil, the system clock is used.
+	Clock clock.Clock
+}
+
+// Audience returns the aud claim of tokens issued for the app with given id.
+func Audience(appID int) string {
+	return strconv.Itoa(appID)
+}
+
+// Keys resolves keys used to verify token signatures.
+// Only tokens signed with an algorithm having a key are accepted.
+type Keys struct {
+	// Secret returns the HS256 secret of the app with given id, nil rejects HS256 tokens.
+	Secret func(appID int) (string, error)
+	// PublicKey returns the RS256 public key with given key id, nil rejects RS256 tokens.
+	PublicKey func(kid string) (*rsa.PublicKey, error)
+}
 
-	claims := token.Claims.(jwt.MapClaims)
-	claims["uid"] = user.ID
-	claims["email"] = user.Email
-	claims["ekp"] = time.Now().Add(duration).Unix()
-	claims["app_id"] = app.ID
+// NewToken creates a new token signed with HS256 using the app secret,
+// the token expires at expiresAt.
+//
+// The "iss" claim is set to issuer and the "aud" claim to the id of the app,
+// so the token is rejected by other environments and other apps.
+// The "iat" and "nbf" claims are set to issuedAt, the current time of the caller.
+// The "ver" claim is the token version of the user, so all tokens of the user
+// can be invalidated at once by bumping it.
+//
+// roles of the user in the app are put in the "roles" claim as an array of strings,
+// e.g. "roles": ["admin", "support"], so resource servers can authorize requests
+// without calling back. The claim is omitted if there are no roles.
+//
+// scopes granted to the token are put in the "scope" claim delimited by spaces,
+// e.g. "scope": "profile orders:read", following RFC 8693. The claim is omitted
+// if there are no scopes, such tokens have none.
+//
+// actor is the id of the app the token is exchanged by, put in the "act" claim
+// as {"sub": "<app id>"} following RFC 8693. It is 0 for tokens issued to the user,
+// the claim is omitted then.
+func NewToken(
+	issuer string,
+	user models.User,
+	app models.App,
+	roles []string,
+	scopes []string,
+	actor int,
+	issuedAt time.Time,
+	expiresAt time.Time,
+) (string, error) {
+	claims, err := newClaims(issuer, user, app, roles, scopes, actor, issuedAt, expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	tokenString, err := token.SignedString([]byte(app.Secret))
 	if err != nil {
@@ -21,3 +117,235 @@ func NewToken(user models.User, app models.App, duration time.Duration) (string,
 	}
 	return tokenString, nil
 }
+
+// NewTokenRSA creates a new token signed with RS256 using privateKey.
+// Claims are the same as in NewToken.
+//
+// The kid header is set to the KeyID of the public key,
+// so resource servers can pick the right key to verify the token with.
+func NewTokenRSA(
+	issuer string,
+	user models.User,
+	app models.App,
+	roles []string,
+	scopes []string,
+	actor int,
+	issuedAt time.Time,
+	expiresAt time.Time,
+	privateKey *rsa.PrivateKey,
+) (string, error) {
+	claims, err := newClaims(issuer, user, app, roles, scopes, actor, issuedAt, expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = KeyID(&privateKey.PublicKey)
+
+	tokenString, err := token.SignedString(privateKey)
+	if err != nil {
+		return "", err
+	}
+	return tokenString, nil
+}
+
+// Parse verifies the token signature and returns its claims.
+//
+// HS256 tokens are verified with the secret of the app they were issued for,
+// RS256 tokens with the public key matching their kid header. Tokens signed
+// with an algorithm keys has no key for are rejected.
+// The iss and aud claims must match v, tokens issued in the future
+// or not valid yet are rejected, within the leeway of v.
+// If the signature is valid but the token has expired, Parse returns
+// the claims together with ErrTokenExpired.
+func Parse(tokenString string, keys Keys, v Validation) (Claims, error) {
+	const op = "jwt.Parse"
+
+	var methods []string
+	if keys.Secret != nil {
+		methods = append(methods, AlgHS256)
+	}
+	if keys.PublicKey != nil {
+		methods = append(methods, AlgRS256)
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(v.Leeway),
+	}
+	if v.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.Issuer))
+	}
+	if v.Audience != "" {
+		opts = append(opts, jwt.WithAudience(v.Audience))
+	}
+	if v.Clock != nil {
+		opts = append(opts, jwt.WithTimeFunc(v.Clock.Now))
+	}
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.Alg() {
+		case AlgRS256:
+			kid, ok := token.Header["kid"].(string)
+			if !ok || keys.PublicKey == nil {
+				return nil, ErrInvalidToken
+			}
+
+			return keys.PublicKey(kid)
+		default:
+			claims := token.Claims.(jwt.MapClaims)
+
+			appID, ok := claims["app_id"].(float64)
+			if !ok || keys.Secret == nil {
+				return nil, ErrInvalidToken
+			}
+
+			key, err := keys.Secret(int(appID))
+			if err != nil {
+				return nil, err
+			}
+
+			return []byte(key), nil
+		}
+	}, opts...)
+
+	if err != nil && !errors.Is(err, jwt.ErrTokenExpired) {
+		return Claims{}, fmt.Errorf("%s: %w: %w", op, ErrInvalidToken, err)
+	}
+
+	claims, ok := claimsFromMap(token.Claims.(jwt.MapClaims))
+	if !ok {
+		return Claims{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	if err != nil {
+		return claims, fmt.Errorf("%s: %w", op, ErrTokenExpired)
+	}
+
+	return claims, nil
+}
+
+// HasScope reports whether the token with claims has been granted scope.
+// claims must come from Parse, so the token is verified, tokens without
+// the "scope" claim have no scopes.
+func HasScope(claims Claims, scope string) bool {
+	return slices.Contains(claims.Scopes, scope)
+}
+
+// ValidScope reports whether s can be a scope: printable ASCII without spaces,
+// double quotes and backslashes, following RFC 6749.
+func ValidScope(s string) bool {
+	return s != "" && !strings.ContainsFunc(s, func(r rune) bool {
+		return r <= ' ' || r > '~' || r == '"' || r == '\\'
+	})
+}
+
+func newClaims(
+	issuer string,
+	user models.User,
+	app models.App,
+	roles []string,
+	scopes []string,
+	actor int,
+	issuedAt time.Time,
+	expiresAt time.Time,
+) (jwt.MapClaims, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := issuedAt.Unix()
+
+	claims := jwt.MapClaims{
+		"jti":    jti,
+		"iss":    issuer,
+		"aud":    Audience(app.ID),
+		"uid":    user.ID,
+		"email":  user.Email,
+		"iat":    now,
+		"nbf":    now,
+		"exp":    expiresAt.Unix(),
+		"app_id": app.ID,
+		"ver":    user.TokenVersion,
+	}
+
+	if len(roles) > 0 {
+		claims["roles"] = roles
+	}
+
+	if len(scopes) > 0 {
+		claims["scope"] = strings.Join(scopes, " ")
+	}
+
+	if actor != 0 {
+		claims["act"] = map[string]string{"sub": Audience(actor)}
+	}
+
+	return claims, nil
+}
+
+func claimsFromMap(m jwt.MapClaims) (Claims, bool) {
+	jti, ok := m["jti"].(string)
+	if !ok || jti == "" {
+		return Claims{}, false
+	}
+
+	uid, _ := m["uid"].(float64)
+	appID, _ := m["app_id"].(float64)
+	email, _ := m["email"].(string)
+	issuer, _ := m["iss"].(string)
+	// Tokens issued before versions were introduced have version 0.
+	version, _ := m["ver"].(float64)
+
+	var roles []string
+	if list, ok := m["roles"].([]any); ok {
+		for _, r := range list {
+			if role, ok := r.(string); ok {
+				roles = append(roles, role)
+			}
+		}
+	}
+
+	scope, _ := m["scope"].(string)
+
+	var actor int
+	if act, ok := m["act"].(map[string]any); ok {
+		sub, _ := act["sub"].(string)
+		actor, _ = strconv.Atoi(sub)
+	}
+
+	exp, err := m.GetExpirationTime()
+	if err != nil || exp == nil {
+		return Claims{}, false
+	}
+
+	var issuedAt time.Time
+	if iat, err := m.GetIssuedAt(); err == nil && iat != nil {
+		issuedAt = iat.Time
+	}
+
+	return Claims{
+		ID:           jti,
+		Issuer:       issuer,
+		UID:          int64(uid),
+		Email:        email,
+		AppID:        int(appID),
+		Roles:        roles,
+		Scopes:       strings.Fields(scope),
+		TokenVersion: int64(version),
+		Actor:        actor,
+		IssuedAt:     issuedAt,
+		ExpiresAt:    exp.Time,
+	}, true
+}
+
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,75 @@
+package jwt
+
+import (
+	"errors"
+	"sso/internal/domain/models"
+	"testing"
+	"time"
+)
+
+// secretKeys returns keys with the HS256 secret of every app set to "secret".
+func secretKeys() Keys {
+	return Keys{Secret: func(int) (string, error) { return "secret", nil }}
+}
+
+// signHS256 returns a token of the app issued at issuedAt and expiring in an hour.
+func signHS256(t *testing.T, issuer string, appID int, issuedAt time.Time) string {
+	t.Helper()
+
+	user := models.User{ID: 1, Email: "user@example.com"}
+	app := models.App{ID: appID, Secret: "secret"}
+
+	token, err := NewToken(issuer, user, app, nil, nil, 0, issuedAt, issuedAt.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+
+	return token
+}
+
+func TestParseRejectsTokenOfOtherApp(t *testing.T) {
+	token := signHS256(t, "test", 1, time.Now())
+
+	if _, err := Parse(token, secretKeys(), Validation{Issuer: "test", Audience: Audience(1)}); err != nil {
+		t.Fatalf("token of the app rejected: %v", err)
+	}
+
+	_, err := Parse(token, secretKeys(), Validation{Issuer: "test", Audience: Audience(2)})
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("token of app 1 presented to app 2: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestParseRejectsTokenOfOtherIssuer(t *testing.T) {
+	token := signHS256(t, "staging", 1, time.Now())
+
+	_, err := Parse(token, secretKeys(), Validation{Issuer: "prod", Audience: Audience(1)})
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("token of another issuer: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestParseRejectsTokenFromFuture(t *testing.T) {
+	now := time.Now()
+	token := signHS256(t, "test", 1, now.Add(time.Minute))
+
+	v := Validation{Audience: Audience(1), Leeway: 30 * time.Second, Clock: &testClock{now: now}}
+	if _, err := Parse(token, secretKeys(), v); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("token issued a minute ahead: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestParseAcceptsTokenFromFutureWithinLeeway(t *testing.T) {
+	now := time.Now()
+	token := signHS256(t, "test", 1, now.Add(20*time.Second))
+
+	v := Validation{Audience: Audience(1), Leeway: 30 * time.Second, Clock: &testClock{now: now}}
+	if _, err := Parse(token, secretKeys(), v); err != nil {
+		t.Fatalf("token issued 20s ahead with 30s leeway: %v", err)
+	}
+
+	v.Leeway = 0
+	if _, err := Parse(token, secretKeys(), v); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("token issued 20s ahead without leeway: got %v, want ErrInvalidToken", err)
+	}
+}
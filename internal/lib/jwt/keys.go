@@ -0,0 +1,59 @@
+package jwt
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// LoadRSAPrivateKey reads a PEM encoded RSA private key (PKCS#1 or PKCS#8) from provider.
+func LoadRSAPrivateKey(ctx context.Context, provider SecretProvider) (*rsa.PrivateKey, error) {
+	const op = "jwt.LoadRSAPrivateKey"
+
+	data, err := provider.Secret(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", op)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, errors.New("key is not an RSA private key"))
+	}
+
+	return rsaKey, nil
+}
+
+// KeyID returns the RFC 7638 JWK thumbprint of the public key.
+//
+// It is used as the kid header of RS256 tokens.
+func KeyID(key *rsa.PublicKey) string {
+	// Members must be in lexicographic order and without whitespace.
+	thumbprint := fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`,
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+	)
+
+	sum := sha256.Sum256([]byte(thumbprint))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,123 @@
+package jwt
+
+import (
+	"crypto/rsa"
+	"sso/internal/lib/clock"
+	"sync"
+	"time"
+)
+
+// KeySet holds RSA keys used to sign and verify RS256 tokens.
+//
+// New tokens are signed with the current key, while tokens signed with
+// any key in the set can still be verified. It is safe for concurrent use.
+//
+// Keys replaced by Rotate are retired: they keep verifying tokens for the
+// retention period, by then every token signed with them has expired,
+// and after it they are dropped from the set.
+type KeySet struct {
+	mu        sync.RWMutex
+	current   string
+	keys      map[string]*rsa.PrivateKey
+	retiredAt map[string]time.Time
+	retention time.Duration
+
+	// Clock tells when keys are retired and expire. It can be replaced in tests.
+	Clock clock.Clock
+}
+
+// NewKeySet returns a key set with key as the current signing key.
+//
+// retention must be at least the longest lifetime of issued tokens,
+// 0 keeps retired keys forever.
+func NewKeySet(key *rsa.PrivateKey, retention time.Duration) *KeySet {
+	kid := KeyID(&key.PublicKey)
+
+	return &KeySet{
+		current:   kid,
+		keys:      map[string]*rsa.PrivateKey{kid: key},
+		retiredAt: map[string]time.Time{},
+		retention: retention,
+		Clock:     clock.Real{},
+	}
+}
+
+// Rotate makes key the current signing key.
+//
+// The previous key is retired, tokens signed with it keep verifying
+// until the retention period elapses. Keys retired longer ago are dropped.
+func (ks *KeySet) Rotate(key *rsa.PrivateKey) {
+	kid := KeyID(&key.PublicKey)
+	now := ks.Clock.Now()
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if kid == ks.current {
+		return
+	}
+
+	ks.retiredAt[ks.current] = now
+	delete(ks.retiredAt, kid)
+
+	ks.keys[kid] = key
+	ks.current = kid
+
+	for retired := range ks.retiredAt {
+		if ks.expired(retired, now) {
+			delete(ks.keys, retired)
+			delete(ks.retiredAt, retired)
+		}
+	}
+}
+
+// Current returns the key new tokens are signed with.
+func (ks *KeySet) Current() *rsa.PrivateKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	return ks.keys[ks.current]
+}
+
+// PublicKey returns the public key with given kid.
+// Keys retired longer than the retention period ago are not returned.
+func (ks *KeySet) PublicKey(kid string) (*rsa.PublicKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	key, ok := ks.keys[kid]
+	if !ok || ks.expired(kid, ks.Clock.Now()) {
+		return nil, ErrInvalidToken
+	}
+
+	return &key.PublicKey, nil
+}
+
+// PublicKeys returns all active public keys in the set by kid.
+func (ks *KeySet) PublicKeys() map[string]*rsa.PublicKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	now := ks.Clock.Now()
+
+	keys := make(map[string]*rsa.PublicKey, len(ks.keys))
+	for kid, key := range ks.keys {
+		if ks.expired(kid, now) {
+			continue
+		}
+
+		keys[kid] = &key.PublicKey
+	}
+
+	return keys
+}
+
+// expired reports whether the key was retired longer than the retention period ago.
+func (ks *KeySet) expired(kid string, now time.Time) bool {
+	retiredAt, ok := ks.retiredAt[kid]
+	if !ok || ks.retention == 0 {
+		return false
+	}
+
+	return now.Sub(retiredAt) > ks.retention
+}
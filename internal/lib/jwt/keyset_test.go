@@ -0,0 +1,96 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"sso/internal/domain/models"
+	"testing"
+	"time"
+)
+
+// testClock is a clock set by the test.
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func newTestKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	return key
+}
+
+// signRSA returns a token of the app expiring in a day signed with key.
+func signRSA(t *testing.T, key *rsa.PrivateKey, now time.Time) string {
+	t.Helper()
+
+	user := models.User{ID: 1, Email: "user@example.com"}
+	app := models.App{ID: 1}
+
+	token, err := NewTokenRSA("test", user, app, nil, nil, 0, now, now.Add(24*time.Hour), key)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+
+	return token
+}
+
+func TestKeySetVerifiesTokensOfRetiredKey(t *testing.T) {
+	clk := &testClock{now: time.Now()}
+	old := newTestKey(t)
+
+	ks := NewKeySet(old, 48*time.Hour)
+	ks.Clock = clk
+
+	token := signRSA(t, ks.Current(), clk.now)
+
+	ks.Rotate(newTestKey(t))
+	if ks.Current() == old {
+		t.Fatal("rotate kept the old key current")
+	}
+
+	clk.now = clk.now.Add(time.Hour)
+
+	v := Validation{Issuer: "test", Audience: Audience(1), Clock: clk}
+	claims, err := Parse(token, Keys{PublicKey: ks.PublicKey}, v)
+	if err != nil {
+		t.Fatalf("token of retired key rejected: %v", err)
+	}
+	if claims.UID != 1 {
+		t.Fatalf("got uid %d, want 1", claims.UID)
+	}
+
+	// Tokens of the new key verify too.
+	if _, err := Parse(signRSA(t, ks.Current(), clk.now), Keys{PublicKey: ks.PublicKey}, v); err != nil {
+		t.Fatalf("token of current key rejected: %v", err)
+	}
+}
+
+func TestKeySetDropsKeysAfterRetention(t *testing.T) {
+	clk := &testClock{now: time.Now()}
+	old := newTestKey(t)
+
+	ks := NewKeySet(old, time.Hour)
+	ks.Clock = clk
+
+	kid := KeyID(&old.PublicKey)
+	ks.Rotate(newTestKey(t))
+
+	clk.now = clk.now.Add(time.Hour + time.Second)
+
+	if _, err := ks.PublicKey(kid); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("key retired past the retention: got %v, want ErrInvalidToken", err)
+	}
+	if _, ok := ks.PublicKeys()[kid]; ok {
+		t.Fatal("key retired past the retention is published")
+	}
+}
@@ -0,0 +1,62 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// SecretProvider returns the PEM encoded private key tokens are signed with.
+//
+// The key is fetched again on every reload, so a provider backed by
+// a secrets manager picks up rotated keys without restarting the service.
+type SecretProvider interface {
+	Secret(ctx context.Context) ([]byte, error)
+}
+
+// SecretProviderFunc adapts a function to SecretProvider,
+// e.g. a call to a secrets manager client.
+type SecretProviderFunc func(ctx context.Context) ([]byte, error)
+
+func (f SecretProviderFunc) Secret(ctx context.Context) ([]byte, error) {
+	return f(ctx)
+}
+
+// Sources of the signing key.
+const (
+	SecretSourceFile = "file"
+	SecretSourceEnv  = "env"
+)
+
+// NewSecretProvider returns the provider for source,
+// ref is the path of the file or the name of the environment variable.
+func NewSecretProvider(source string, ref string) (SecretProvider, error) {
+	switch source {
+	case SecretSourceFile:
+		return FileSecret(ref), nil
+	case SecretSourceEnv:
+		return EnvSecret(ref), nil
+	default:
+		return nil, fmt.Errorf("unsupported secret source: %q", source)
+	}
+}
+
+// FileSecret reads the key from the file at path.
+func FileSecret(path string) SecretProvider {
+	return SecretProviderFunc(func(context.Context) ([]byte, error) {
+		return os.ReadFile(path)
+	})
+}
+
+// EnvSecret reads the key from the environment variable name.
+func EnvSecret(name string) SecretProvider {
+	return SecretProviderFunc(func(context.Context) ([]byte, error) {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			return nil, errors.New("environment variable " + name + " is not set")
+		}
+
+		return []byte(value), nil
+	})
+}
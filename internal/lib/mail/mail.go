@@ -0,0 +1,131 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// MaxAddressLength is the longest address in bytes SMTP allows.
+const MaxAddressLength = 254
+
+type Config struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	// VerifyURL is the link sent in verification emails, %s is replaced with the token.
+	VerifyURL string
+	// ResetURL is the link sent in password reset emails, %s is replaced with the token.
+	ResetURL string
+	// MagicLinkURL is the link sent in magic link emails, %s is replaced with the token.
+	MagicLinkURL string
+}
+
+// Sender sends emails with one-time tokens through SMTP.
+//
+// If SMTP host is not configured, emails are only logged,
+// which is handy for local development.
+type Sender struct {
+	log *slog.Logger
+	cfg Config
+}
+
+func New(log *slog.Logger, cfg Config) *Sender {
+	return &Sender{
+		log: log,
+		cfg: cfg,
+	}
+}
+
+// SendVerification sends email verification link to email.
+func (s *Sender) SendVerification(ctx context.Context, email string, token string) error {
+	const op = "mail.SendVerification"
+
+	body := "Please confirm your email address by following the link:\r\n\r\n" +
+		link(s.cfg.VerifyURL, token) + "\r\n"
+
+	if err := s.send(ctx, email, "Confirm your email", body); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SendPasswordReset sends password reset link to email.
+func (s *Sender) SendPasswordReset(ctx context.Context, email string, token string) error {
+	const op = "mail.SendPasswordReset"
+
+	body := "Someone requested a password reset for your account.\r\n" +
+		"If it was you, follow the link to set a new password:\r\n\r\n" +
+		link(s.cfg.ResetURL, token) + "\r\n\r\n" +
+		"Otherwise just ignore this email.\r\n"
+
+	if err := s.send(ctx, email, "Reset your password", body); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SendMagicLink sends a link to log in without a password to email.
+func (s *Sender) SendMagicLink(ctx context.Context, email string, token string) error {
+	const op = "mail.SendMagicLink"
+
+	body := "Follow the link to log in to your account:\r\n\r\n" +
+		link(s.cfg.MagicLinkURL, token) + "\r\n\r\n" +
+		"The link can be used once and expires shortly.\r\n" +
+		"If you didn't try to log in, just ignore this email.\r\n"
+
+	if err := s.send(ctx, email, "Your login link", body); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Sender) send(_ context.Context, to string, subject string, body string) error {
+	if s.cfg.Host == "" {
+		s.log.Info("smtp is not configured, email is not sent",
+			slog.String("to", to),
+			slog.String("subject", subject),
+			slog.String("body", body),
+		)
+
+		return nil
+	}
+
+	msg := strings.Join([]string{
+		"From: " + s.cfg.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)
+	}
+
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+
+	return smtp.SendMail(addr, auth, s.cfg.From, []string{to}, []byte(msg))
+}
+
+// link puts token into the URL template, or returns the bare token if there is no template.
+func link(template string, token string) string {
+	if template == "" {
+		return token
+	}
+
+	return strings.ReplaceAll(template, "%s", url.QueryEscape(token))
+}
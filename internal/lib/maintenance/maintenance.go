@@ -0,0 +1,52 @@
+// Package maintenance holds the maintenance mode of the service, in which
+// new logins and registrations are refused while existing tokens keep working.
+package maintenance
+
+import (
+	"sync"
+)
+
+// Mode is whether maintenance is on. It is safe for concurrent use.
+type Mode struct {
+	mu      sync.Mutex
+	enabled bool
+	// watchers are told about every change, see Watch.
+	watchers []func(enabled bool)
+}
+
+func New(enabled bool) *Mode {
+	return &Mode{enabled: enabled}
+}
+
+func (m *Mode) Enabled() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.enabled
+}
+
+// Set turns maintenance on or off and reports whether it was on before.
+func (m *Mode) Set(enabled bool) (was bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	was = m.enabled
+	m.enabled = enabled
+
+	if was != enabled {
+		for _, fn := range m.watchers {
+			fn(enabled)
+		}
+	}
+
+	return was
+}
+
+// Watch calls fn on every change of the mode, e.g. to update health status.
+// fn must not call methods of m.
+func (m *Mode) Watch(fn func(enabled bool)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.watchers = append(m.watchers, fn)
+}
@@ -0,0 +1,31 @@
+package maintenance
+
+import "testing"
+
+func TestMode(t *testing.T) {
+	m := New(false)
+
+	var changes []bool
+	m.Watch(func(enabled bool) { changes = append(changes, enabled) })
+
+	if was := m.Set(true); was {
+		t.Fatal("Set reported maintenance was on")
+	}
+	if !m.Enabled() {
+		t.Fatal("maintenance is off after enabling it")
+	}
+
+	// Setting the same mode again doesn't notify watchers.
+	if was := m.Set(true); !was {
+		t.Fatal("Set reported maintenance was off")
+	}
+
+	m.Set(false)
+	if m.Enabled() {
+		t.Fatal("maintenance is on after disabling it")
+	}
+
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Fatalf("watcher got %v, want [true false]", changes)
+	}
+}
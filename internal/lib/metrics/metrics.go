@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+// Path is where metrics are served.
+const Path = "/metrics"
+
+const namespace = "sso"
+
+// Metrics holds Prometheus collectors of the service.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	// RequestDuration is the latency of gRPC requests by method and code.
+	RequestDuration *prometheus.HistogramVec
+	// Logins counts login attempts by result.
+	Logins *prometheus.CounterVec
+	// Registrations counts registration attempts by result.
+	Registrations *prometheus.CounterVec
+	// TokenValidations counts token validations by result.
+	TokenValidations *prometheus.CounterVec
+	// AppCache counts app lookups by result, hit or miss,
+	// the hit ratio is hits divided by all lookups.
+	AppCache *prometheus.CounterVec
+	// RoleCache counts lookups of admins and roles by result, hit or miss.
+	RoleCache *prometheus.CounterVec
+	// AppRequests counts gRPC requests by app and method, for billing and abuse detection.
+	AppRequests *prometheus.CounterVec
+	// AppRequestSize is the size of gRPC requests in bytes by app and method.
+	AppRequestSize *prometheus.HistogramVec
+}
+
+// New creates metrics registered in their own registry,
+// together with the standard Go runtime and process collectors.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		RequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "grpc",
+			Name:      "request_duration_seconds",
+			Help:      "Duration of gRPC requests by method and code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "code"}),
+		Logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "logins_total",
+			Help:      "Login attempts by result.",
+		}, []string{"result"}),
+		Registrations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "registrations_total",
+			Help:      "Registration attempts by result.",
+		}, []string{"result"}),
+		TokenValidations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "token_validations_total",
+			Help:      "Token validations by result.",
+		}, []string{"result"}),
+		AppCache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "app_cache_requests_total",
+			Help:      "App cache lookups by result, hit or miss.",
+		}, []string{"result"}),
+		RoleCache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "role_cache_requests_total",
+			Help:      "Role cache lookups by result, hit or miss.",
+		}, []string{"result"}),
+		AppRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "grpc",
+			Name:      "app_requests_total",
+			Help:      "gRPC requests by app and method.",
+		}, []string{"app", "method"}),
+		AppRequestSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "grpc",
+			Name:      "app_request_size_bytes",
+			Help:      "Size of gRPC requests in bytes by app and method.",
+			Buckets:   prometheus.ExponentialBuckets(64, 4, 6),
+		}, []string{"app", "method"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.RequestDuration,
+		m.Logins,
+		m.Registrations,
+		m.TokenValidations,
+		m.AppCache,
+		m.RoleCache,
+		m.AppRequests,
+		m.AppRequestSize,
+	)
+
+	return m
+}
+
+// Handler serves the metrics in Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Register adds the metrics handler to mux.
+func (m *Metrics) Register(mux *http.ServeMux) {
+	mux.Handle("GET "+Path, m.Handler())
+}
@@ -0,0 +1,141 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+	"net/http"
+	"sso/internal/domain/models"
+	"strconv"
+)
+
+const githubAPIURL = "https://api.github.com"
+
+var ErrNoGitHubUser = errors.New("github returned no user")
+
+type GitHubConfig struct {
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback of the service GitHub redirects back to,
+	// it must be the callback URL of the OAuth app.
+	RedirectURL string
+	// Scopes are requested from GitHub, they must allow reading emails of the user, e.g. user:email.
+	Scopes []string
+}
+
+// GitHub signs users in with their GitHub accounts.
+//
+// GitHub doesn't support OpenID Connect for users, so there is no ID token:
+// the account is read from the API with the access token instead.
+// Its email is the primary email of the account, it is verified only if GitHub verified it.
+type GitHub struct {
+	oauth  *oauth2.Config
+	client *http.Client
+	apiURL string
+}
+
+func NewGitHub(cfg GitHubConfig) *GitHub {
+	return &GitHub{
+		oauth: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     github.Endpoint,
+			Scopes:       cfg.Scopes,
+		},
+		client: &http.Client{Timeout: exchangeTimeout},
+		apiURL: githubAPIURL,
+	}
+}
+
+// AuthCodeURL returns the GitHub sign in page. verifier is sent as an S256 PKCE challenge,
+// there is no nonce without an ID token.
+func (g *GitHub) AuthCodeURL(_ context.Context, state string, _ string, verifier string) (string, error) {
+	return g.oauth.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier)), nil
+}
+
+// Identity exchanges the code for an access token and returns the account it is issued for.
+// redirectURI replaces RedirectURL if the code was issued for another one,
+// verifier is skipped if empty.
+func (g *GitHub) Identity(ctx context.Context, code string, redirectURI string, verifier string, _ string) (models.Identity, error) {
+	const op = "oauth.GitHub.Identity"
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, g.client)
+
+	oauth := g.oauth
+	if redirectURI != "" {
+		cp := *oauth
+		cp.RedirectURL = redirectURI
+		oauth = &cp
+	}
+
+	var opts []oauth2.AuthCodeOption
+	if verifier != "" {
+		opts = append(opts, oauth2.VerifierOption(verifier))
+	}
+
+	token, err := oauth.Exchange(ctx, code, opts...)
+	if err != nil {
+		return models.Identity{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var user struct {
+		ID int64 `json:"id"`
+	}
+	if err := g.get(ctx, token, "/user", &user); err != nil {
+		return models.Identity{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if user.ID == 0 {
+		return models.Identity{}, fmt.Errorf("%s: %w", op, ErrNoGitHubUser)
+	}
+
+	// The email of /user is the public one, which may be unset or unverified,
+	// only emails of /user/emails tell whether they are verified.
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := g.get(ctx, token, "/user/emails", &emails); err != nil {
+		return models.Identity{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	identity := models.Identity{
+		Subject: strconv.FormatInt(user.ID, 10),
+	}
+	for _, e := range emails {
+		if e.Primary {
+			identity.Email = e.Email
+			identity.EmailVerified = e.Verified
+		}
+	}
+
+	return identity, nil
+}
+
+// get reads the API resource at path into v with the access token.
+func (g *GitHub) get(ctx context.Context, token *oauth2.Token, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.apiURL+path, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	token.SetAuthHeader(req)
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get %s: unexpected status %s", path, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
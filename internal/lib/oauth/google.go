@@ -0,0 +1,53 @@
+package oauth
+
+import (
+	"context"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"net/http"
+)
+
+const (
+	googleIssuer   = "https://accounts.google.com"
+	googleKeysURL  = "https://www.googleapis.com/oauth2/v3/certs"
+	googleAuthURL  = "https://accounts.google.com/o/oauth2/v2/auth"
+	googleTokenURL = "https://oauth2.googleapis.com/token"
+)
+
+type GoogleConfig struct {
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback of the service Google redirects back to,
+	// it must be registered for the client.
+	RedirectURL string
+}
+
+// NewGoogle returns the Google identity provider. Its endpoints are known,
+// so unlike other providers it isn't discovered.
+func NewGoogle(cfg GoogleConfig) *OIDC {
+	client := &http.Client{Timeout: exchangeTimeout}
+
+	keys := oidc.NewRemoteKeySet(oidc.ClientContext(context.Background(), client), googleKeysURL)
+
+	return &OIDC{
+		cfg: OIDCConfig{
+			Issuer:       googleIssuer,
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+		},
+		client: client,
+		oauth: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:   googleAuthURL,
+				TokenURL:  googleTokenURL,
+				AuthStyle: oauth2.AuthStyleInParams,
+			},
+			Scopes: []string{oidc.ScopeOpenID, "email", "profile"},
+		},
+		verifier: oidc.NewVerifier(googleIssuer, keys, &oidc.Config{ClientID: cfg.ClientID}),
+	}
+}
@@ -0,0 +1,170 @@
+// Package oauth signs users in with their accounts at identity providers.
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"net/http"
+	"sso/internal/domain/models"
+	"sync"
+	"time"
+)
+
+// exchangeTimeout bounds requests to providers, so a slow provider doesn't hold sign ins forever.
+const exchangeTimeout = 10 * time.Second
+
+var (
+	ErrNoIDToken     = errors.New("no id token in token response")
+	ErrNonceMismatch = errors.New("id token is issued for another nonce")
+)
+
+type OIDCConfig struct {
+	// Issuer is the URL the provider is discovered at, its configuration is served
+	// at Issuer + "/.well-known/openid-configuration".
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback of the service the provider redirects back to,
+	// it must be registered for the client.
+	RedirectURL string
+	// Scopes are requested from the provider, they must include openid.
+	Scopes []string
+}
+
+// OIDC signs users in with their accounts at an OpenID Connect provider, e.g. Okta.
+//
+// Endpoints of the provider are discovered on first use and discovery is retried
+// until it succeeds, so an unavailable provider doesn't prevent the service from starting.
+// Signing keys of the provider are cached until a token signed with an unknown key comes.
+type OIDC struct {
+	cfg    OIDCConfig
+	client *http.Client
+
+	mu sync.Mutex
+	// oauth and verifier are nil until the provider is discovered.
+	oauth    *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+func NewOIDC(cfg OIDCConfig) *OIDC {
+	return &OIDC{
+		cfg:    cfg,
+		client: &http.Client{Timeout: exchangeTimeout},
+	}
+}
+
+// AuthCodeURL returns the sign in page of the provider. verifier is sent as an S256 PKCE challenge.
+func (p *OIDC) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error) {
+	const op = "oauth.OIDC.AuthCodeURL"
+
+	oauth, _, err := p.discover(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return oauth.AuthCodeURL(state,
+		oidc.Nonce(nonce),
+		oauth2.S256ChallengeOption(verifier),
+		oauth2.SetAuthURLParam("prompt", "select_account"),
+	), nil
+}
+
+// Identity exchanges the code for an ID token and returns the account it is issued for.
+// redirectURI replaces RedirectURL if the code was issued for another one,
+// verifier and nonce are skipped if empty.
+func (p *OIDC) Identity(ctx context.Context, code string, redirectURI string, verifier string, nonce string) (models.Identity, error) {
+	const op = "oauth.OIDC.Identity"
+
+	ctx = oidc.ClientContext(ctx, p.client)
+
+	oauth, verifierOfTokens, err := p.discover(ctx)
+	if err != nil {
+		return models.Identity{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if redirectURI != "" {
+		cp := *oauth
+		cp.RedirectURL = redirectURI
+		oauth = &cp
+	}
+
+	var opts []oauth2.AuthCodeOption
+	if verifier != "" {
+		opts = append(opts, oauth2.VerifierOption(verifier))
+	}
+
+	token, err := oauth.Exchange(ctx, code, opts...)
+	if err != nil {
+		return models.Identity{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return models.Identity{}, fmt.Errorf("%s: %w", op, ErrNoIDToken)
+	}
+
+	identity, err := verifyIDToken(ctx, verifierOfTokens, rawIDToken, nonce)
+	if err != nil {
+		return models.Identity{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return identity, nil
+}
+
+// discover returns the client and the ID token verifier of the provider,
+// fetching its configuration on first use.
+func (p *OIDC) discover(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.oauth != nil {
+		return p.oauth, p.verifier, nil
+	}
+
+	// The key set of the provider outlives ctx, only the client is taken from it.
+	provider, err := oidc.NewProvider(oidc.ClientContext(ctx, p.client), p.cfg.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("discover %s: %w", p.cfg.Issuer, err)
+	}
+
+	p.oauth = &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       p.cfg.Scopes,
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.cfg.ClientID})
+
+	return p.oauth, p.verifier, nil
+}
+
+// verifyIDToken verifies the ID token was signed by the provider for the nonce
+// and returns the account it is issued for. The nonce isn't checked if empty.
+func verifyIDToken(ctx context.Context, verifier *oidc.IDTokenVerifier, rawIDToken string, nonce string) (models.Identity, error) {
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return models.Identity{}, err
+	}
+
+	if nonce != "" && idToken.Nonce != nonce {
+		return models.Identity{}, ErrNonceMismatch
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return models.Identity{}, err
+	}
+
+	return models.Identity{
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+	}, nil
+}
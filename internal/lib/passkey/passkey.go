@@ -0,0 +1,270 @@
+// Package passkey registers passkeys of users and verifies logins with them
+// following WebAuthn, the service being the relying party.
+package passkey
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"sso/internal/domain/models"
+	"time"
+)
+
+var ErrInvalidUserHandle = errors.New("user handle is not a user id")
+
+type Config struct {
+	// RPID is the domain passkeys are bound to, e.g. example.com. Passkeys work on it
+	// and its subdomains, it can't be changed without losing every registered passkey.
+	RPID string
+	// RPDisplayName is shown by authenticators, e.g. "Example".
+	RPDisplayName string
+	// RPOrigins are origins of pages passkeys are used on, e.g. https://login.example.com.
+	RPOrigins []string
+	// Timeout is how long the user has to answer the authenticator.
+	Timeout time.Duration
+}
+
+// RelyingParty runs registrations and logins with passkeys.
+//
+// Passkeys are discoverable credentials: logins don't ask for the user, the authenticator
+// offers the passkeys it keeps for RPID and returns the user handle with the assertion.
+// The user handle is the id of the user, it holds no personal data.
+type RelyingParty struct {
+	webauthn *webauthn.WebAuthn
+}
+
+func New(cfg Config) (*RelyingParty, error) {
+	const op = "passkey.New"
+
+	timeout := webauthn.TimeoutConfig{
+		Enforce:    true,
+		Timeout:    cfg.Timeout,
+		TimeoutUVD: cfg.Timeout,
+	}
+
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: cfg.RPDisplayName,
+		RPOrigins:     cfg.RPOrigins,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        timeout,
+			Registration: timeout,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &RelyingParty{webauthn: w}, nil
+}
+
+// BeginRegistration returns the options to create a passkey of the user with
+// and the session to finish the registration with.
+func (rp *RelyingParty) BeginRegistration(user models.User, passkeys []models.Passkey) ([]byte, []byte, error) {
+	const op = "passkey.BeginRegistration"
+
+	u := newUser(user, passkeys)
+
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(u.credentials))
+	for _, c := range u.credentials {
+		exclusions = append(exclusions, c.Descriptor())
+	}
+
+	creation, session, err := rp.webauthn.BeginRegistration(u,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return marshal(op, creation, session)
+}
+
+// FinishRegistration verifies the response of the authenticator and returns the passkey it created.
+func (rp *RelyingParty) FinishRegistration(user models.User, session []byte, response []byte) (models.Passkey, error) {
+	const op = "passkey.FinishRegistration"
+
+	var s webauthn.SessionData
+	if err := json.Unmarshal(session, &s); err != nil {
+		return models.Passkey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return models.Passkey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	credential, err := rp.webauthn.CreateCredential(newUser(user, nil), s, parsed)
+	if err != nil {
+		return models.Passkey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	transports := make([]string, 0, len(credential.Transport))
+	for _, t := range credential.Transport {
+		transports = append(transports, string(t))
+	}
+
+	return models.Passkey{
+		ID:              credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      transports,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	}, nil
+}
+
+// BeginLogin returns the options to get an assertion of any passkey with
+// and the session to finish the login with.
+func (rp *RelyingParty) BeginLogin() ([]byte, []byte, error) {
+	const op = "passkey.BeginLogin"
+
+	assertion, session, err := rp.webauthn.BeginDiscoverableLogin()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return marshal(op, assertion, session)
+}
+
+// FinishLogin verifies the assertion of the authenticator with the passkey of the user
+// of its user handle, passkeys returns passkeys of the user.
+//
+// The sign count isn't checked, the caller compares SignCount of the result
+// to the stored one of the passkey.
+func (rp *RelyingParty) FinishLogin(
+	session []byte,
+	response []byte,
+	passkeys func(userID int64) ([]models.Passkey, error),
+) (models.PasskeyAssertion, error) {
+	const op = "passkey.FinishLogin"
+
+	var s webauthn.SessionData
+	if err := json.Unmarshal(session, &s); err != nil {
+		return models.PasskeyAssertion{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return models.PasskeyAssertion{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var u *user
+	handler := func(_, userHandle []byte) (webauthn.User, error) {
+		if len(userHandle) != 8 {
+			return nil, ErrInvalidUserHandle
+		}
+
+		userID := int64(binary.BigEndian.Uint64(userHandle))
+
+		found, err := passkeys(userID)
+		if err != nil {
+			return nil, err
+		}
+
+		u = newUser(models.User{ID: userID}, found)
+
+		return u, nil
+	}
+
+	if _, err := rp.webauthn.ValidateDiscoverableLogin(handler, s, parsed); err != nil {
+		return models.PasskeyAssertion{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// The credential returned above already has the sign count applied,
+	// the passkey is taken as stored instead.
+	for _, p := range u.passkeys {
+		if bytes.Equal(p.ID, parsed.RawID) {
+			return models.PasskeyAssertion{
+				Passkey:     p,
+				SignCount:   parsed.Response.AuthenticatorData.Counter,
+				BackupState: parsed.Response.AuthenticatorData.Flags.HasBackupState(),
+			}, nil
+		}
+	}
+
+	return models.PasskeyAssertion{}, fmt.Errorf("%s: credential of assertion not found", op)
+}
+
+// marshal encodes options and the session of a ceremony to JSON.
+func marshal(op string, options any, session *webauthn.SessionData) ([]byte, []byte, error) {
+	o, err := json.Marshal(options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	s, err := json.Marshal(session)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return o, s, nil
+}
+
+// user is a user of the service as WebAuthn sees them.
+type user struct {
+	id          int64
+	email       string
+	passkeys    []models.Passkey
+	credentials []webauthn.Credential
+}
+
+func newUser(u models.User, passkeys []models.Passkey) *user {
+	credentials := make([]webauthn.Credential, 0, len(passkeys))
+	for _, p := range passkeys {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(p.Transports))
+		for _, t := range p.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+
+		credentials = append(credentials, webauthn.Credential{
+			ID:              p.ID,
+			PublicKey:       p.PublicKey,
+			AttestationType: p.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: p.BackupEligible,
+				BackupState:    p.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    p.AAGUID,
+				SignCount: p.SignCount,
+			},
+		})
+	}
+
+	return &user{
+		id:          u.ID,
+		email:       u.Email,
+		passkeys:    passkeys,
+		credentials: credentials,
+	}
+}
+
+// WebAuthnID returns the user handle, the id of the user as 8 big endian bytes.
+func (u *user) WebAuthnID() []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(u.id))
+}
+
+func (u *user) WebAuthnName() string {
+	return u.email
+}
+
+func (u *user) WebAuthnDisplayName() string {
+	return u.email
+}
+
+func (u *user) WebAuthnIcon() string {
+	return ""
+}
+
+func (u *user) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
@@ -0,0 +1,231 @@
+package password
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"time"
+)
+
+// Hashing algorithms supported by Hasher.
+const (
+	AlgBcrypt   = "bcrypt"
+	AlgArgon2id = "argon2id"
+)
+
+var (
+	ErrMismatchedPassword = errors.New("password doesn't match hash")
+	ErrUnknownHash        = errors.New("unknown hash format")
+	ErrPasswordTooLong    = fmt.Errorf("password is longer than %d bytes", MaxBcryptLength)
+)
+
+// MaxBcryptLength is the number of bytes of a password bcrypt uses, the rest is ignored.
+const MaxBcryptLength = 72
+
+// Argon2idParams are parameters of new Argon2id hashes.
+type Argon2idParams struct {
+	// Memory in KiB.
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+const (
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// Hasher hashes new passwords with the configured algorithm and verifies
+// hashes of every supported algorithm, so users keep logging in while
+// their hashes are migrated from one algorithm to another.
+//
+// Hashes are self-describing: bcrypt hashes are in the modular crypt format
+// ($2a$10$...), Argon2id hashes are PHC strings ($argon2id$v=19$m=...,t=...,p=...$salt$hash).
+type Hasher struct {
+	algorithm  string
+	bcryptCost int
+	argon2id   Argon2idParams
+}
+
+// NewHasher returns a Hasher creating hashes with algorithm.
+func NewHasher(algorithm string, bcryptCost int, argon2id Argon2idParams) (*Hasher, error) {
+	switch algorithm {
+	case AlgBcrypt:
+		if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be between %d and %d, got %d",
+				bcrypt.MinCost, bcrypt.MaxCost, bcryptCost)
+		}
+	case AlgArgon2id:
+		if argon2id.Memory == 0 || argon2id.Time == 0 || argon2id.Threads == 0 {
+			return nil, fmt.Errorf("argon2id memory, time and threads must be positive")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported password hashing algorithm: %q", algorithm)
+	}
+
+	return &Hasher{
+		algorithm:  algorithm,
+		bcryptCost: bcryptCost,
+		argon2id:   argon2id,
+	}, nil
+}
+
+// CalibrateCost returns the bcrypt cost at which hashing a password takes about target
+// on the current hardware. Every step of the cost doubles the time, the cost closer
+// to target is chosen. It never returns less than bcrypt.DefaultCost,
+// so slow hardware doesn't end up with weak hashes.
+//
+// Calibration hashes a few passwords, so it takes up to about twice target.
+func CalibrateCost(target time.Duration) int {
+	cost := bcrypt.DefaultCost
+
+	for cost < bcrypt.MaxCost {
+		start := time.Now()
+		_, _ = bcrypt.GenerateFromPassword([]byte("calibration password"), cost)
+		elapsed := time.Since(start)
+
+		// The next cost takes twice as long, which is farther from target
+		// once this one takes at least two thirds of it.
+		if elapsed*3 >= target*2 {
+			break
+		}
+
+		cost++
+	}
+
+	return cost
+}
+
+// Hash hashes password with the configured algorithm.
+//
+// Returns ErrPasswordTooLong if the algorithm is bcrypt and password is longer
+// than MaxBcryptLength bytes, as bcrypt would hash only its beginning.
+func (h *Hasher) Hash(password string) ([]byte, error) {
+	if h.algorithm == AlgArgon2id {
+		return hashArgon2id(password, h.argon2id)
+	}
+
+	if len(password) > MaxBcryptLength {
+		return nil, ErrPasswordTooLong
+	}
+
+	return bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+}
+
+// Compare checks password against hash of any supported algorithm.
+//
+// Returns ErrMismatchedPassword if the password is wrong.
+// Passwords longer than MaxBcryptLength bytes never match bcrypt hashes:
+// bcrypt would compare only their first bytes, so a password sharing them
+// with the real one would match. Hash refuses to make hashes of such passwords.
+func (h *Hasher) Compare(hash []byte, password string) error {
+	switch {
+	case bytes.HasPrefix(hash, []byte("$argon2id$")):
+		return compareArgon2id(hash, password)
+	case bytes.HasPrefix(hash, []byte("$2")):
+		if len(password) > MaxBcryptLength {
+			return ErrMismatchedPassword
+		}
+
+		err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrMismatchedPassword
+		}
+
+		return err
+	default:
+		return ErrUnknownHash
+	}
+}
+
+// NeedsRehash reports whether hash was made with another algorithm
+// or weaker parameters than the ones configured now.
+func (h *Hasher) NeedsRehash(hash []byte) bool {
+	switch {
+	case bytes.HasPrefix(hash, []byte("$argon2id$")):
+		if h.algorithm != AlgArgon2id {
+			return true
+		}
+
+		p, _, _, err := parseArgon2id(string(hash))
+
+		return err != nil || p != h.argon2id
+	case bytes.HasPrefix(hash, []byte("$2")):
+		if h.algorithm != AlgBcrypt {
+			return true
+		}
+
+		// Only lower costs are upgraded, so instances with different calibrated
+		// costs don't keep rehashing each other's hashes, see CalibrateCost.
+		cost, err := bcrypt.Cost(hash)
+
+		return err != nil || cost < h.bcryptCost
+	default:
+		return true
+	}
+}
+
+func hashArgon2id(password string, p Argon2idParams) ([]byte, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, argon2KeyLen)
+
+	return []byte(fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)), nil
+}
+
+func compareArgon2id(hash []byte, password string) error {
+	p, salt, key, err := parseArgon2id(string(hash))
+	if err != nil {
+		return err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrMismatchedPassword
+	}
+
+	return nil
+}
+
+// parseArgon2id parses PHC string $argon2id$v=19$m=65536,t=3,p=4$salt$hash.
+func parseArgon2id(hash string) (Argon2idParams, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return Argon2idParams{}, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2idParams{}, nil, nil, ErrUnknownHash
+	}
+
+	var p Argon2idParams
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return Argon2idParams{}, nil, nil, ErrUnknownHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2idParams{}, nil, nil, ErrUnknownHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Argon2idParams{}, nil, nil, ErrUnknownHash
+	}
+
+	return p, salt, key, nil
+}
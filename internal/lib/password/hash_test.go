@@ -0,0 +1,44 @@
+package password
+
+import (
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+)
+
+func TestBcryptRejectsPasswordsSharingTruncatedPrefix(t *testing.T) {
+	h, err := NewHasher(AlgBcrypt, bcrypt.MinCost, Argon2idParams{})
+	if err != nil {
+		t.Fatalf("create hasher: %v", err)
+	}
+
+	prefix := strings.Repeat("a", MaxBcryptLength)
+	first := prefix + "first"
+	second := prefix + "second"
+
+	for _, p := range []string{first, second} {
+		if _, err := h.Hash(p); !errors.Is(err, ErrPasswordTooLong) {
+			t.Fatalf("hash of %d bytes: got %v, want ErrPasswordTooLong", len(p), err)
+		}
+	}
+
+	// bcrypt itself would accept the second password for a hash of the first.
+	raw, err := bcrypt.GenerateFromPassword([]byte(prefix), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("generate hash: %v", err)
+	}
+	if err := bcrypt.CompareHashAndPassword(raw, []byte(second)); err != nil {
+		t.Fatalf("bcrypt no longer truncates passwords: %v", err)
+	}
+
+	for _, p := range []string{first, second} {
+		if err := h.Compare(raw, p); !errors.Is(err, ErrMismatchedPassword) {
+			t.Errorf("compare of %d bytes with hash of the prefix: got %v, want ErrMismatchedPassword", len(p), err)
+		}
+	}
+
+	if err := h.Compare(raw, prefix); err != nil {
+		t.Errorf("compare of the prefix itself: %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package password
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var ErrWeakPassword = errors.New("weak password")
+
+// PolicyError describes why a password doesn't satisfy the policy.
+type PolicyError struct {
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return "weak password: " + e.Reason
+}
+
+func (e *PolicyError) Unwrap() error {
+	return ErrWeakPassword
+}
+
+// Policy is a set of password strength rules.
+type Policy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// RejectCommon rejects passwords from the embedded list of commonly used passwords.
+	RejectCommon bool
+}
+
+//go:embed common.txt
+var commonList string
+
+var common = parseCommon(commonList)
+
+// Validate returns *PolicyError if password doesn't satisfy the policy.
+func (p Policy) Validate(password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return &PolicyError{Reason: fmt.Sprintf("must be at least %d characters long", p.MinLength)}
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			hasSymbol = true
+		}
+	}
+
+	if p.RequireUpper && !hasUpper {
+		return &PolicyError{Reason: "must contain an uppercase letter"}
+	}
+	if p.RequireLower && !hasLower {
+		return &PolicyError{Reason: "must contain a lowercase letter"}
+	}
+	if p.RequireDigit && !hasDigit {
+		return &PolicyError{Reason: "must contain a digit"}
+	}
+	if p.RequireSymbol && !hasSymbol {
+		return &PolicyError{Reason: "must contain a symbol"}
+	}
+
+	if p.RejectCommon {
+		if _, ok := common[strings.ToLower(password)]; ok {
+			return &PolicyError{Reason: "is too common"}
+		}
+	}
+
+	return nil
+}
+
+func parseCommon(list string) map[string]struct{} {
+	passwords := make(map[string]struct{})
+
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			passwords[strings.ToLower(line)] = struct{}{}
+		}
+	}
+
+	return passwords
+}
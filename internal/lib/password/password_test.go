@@ -0,0 +1,62 @@
+package password
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPolicyValidate(t *testing.T) {
+	strict := Policy{
+		MinLength:     8,
+		RequireUpper:  true,
+		RequireLower:  true,
+		RequireDigit:  true,
+		RequireSymbol: true,
+		RejectCommon:  true,
+	}
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		password string
+		reason   string
+	}{
+		{"satisfies every rule", strict, "Passw0rd!x", ""},
+		{"too short", strict, "Pa0!x", "must be at least 8 characters long"},
+		{"length counts characters, not bytes", Policy{MinLength: 4}, "пароль", ""},
+		{"multibyte too short", Policy{MinLength: 8}, "пароль", "must be at least 8 characters long"},
+		{"no uppercase letter", strict, "passw0rd!x", "must contain an uppercase letter"},
+		{"no lowercase letter", strict, "PASSW0RD!X", "must contain a lowercase letter"},
+		{"no digit", strict, "Password!x", "must contain a digit"},
+		{"no symbol", strict, "Passw0rdxx", "must contain a symbol"},
+		{"common password", strict, "P@ssw0rd", "is too common"},
+		{"common password in other case", Policy{RejectCommon: true}, "QWERTY", "is too common"},
+		{"common password allowed", Policy{}, "qwerty", ""},
+		{"empty policy allows anything", Policy{}, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate(tt.password)
+
+			if tt.reason == "" {
+				if err != nil {
+					t.Fatalf("got %v, want nil", err)
+				}
+
+				return
+			}
+
+			var pe *PolicyError
+			if !errors.As(err, &pe) {
+				t.Fatalf("got %v, want *PolicyError", err)
+			}
+			if pe.Reason != tt.reason {
+				t.Fatalf("got reason %q, want %q", pe.Reason, tt.reason)
+			}
+			if !errors.Is(err, ErrWeakPassword) {
+				t.Fatal("error doesn't wrap ErrWeakPassword")
+			}
+		})
+	}
+}
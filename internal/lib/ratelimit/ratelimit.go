@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter counts failed attempts per key in a fixed time window.
+//
+// Once a key has failed the configured number of attempts, Allow returns false
+// until the window that started with the first failure is over.
+// It is safe for concurrent use.
+type Limiter struct {
+	mu       sync.Mutex
+	attempts int
+	window   time.Duration
+	counters map[string]counter
+	// lastSweep is when expired counters were last removed.
+	lastSweep time.Time
+
+	// Now returns current time. It can be replaced in tests.
+	Now func() time.Time
+}
+
+type counter struct {
+	failures int
+	start    time.Time
+}
+
+// New returns a limiter allowing up to attempts failures per key within window.
+func New(attempts int, window time.Duration) *Limiter {
+	return &Limiter{
+		attempts: attempts,
+		window:   window,
+		counters: make(map[string]counter),
+		Now:      time.Now,
+	}
+}
+
+// Allow reports whether another attempt for key is allowed.
+func (l *Limiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c, ok := l.counter(key)
+	if !ok {
+		return true
+	}
+
+	return c.failures < l.attempts
+}
+
+// Fail records a failed attempt for key.
+func (l *Limiter) Fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep()
+
+	c, ok := l.counter(key)
+	if !ok {
+		c = counter{start: l.Now()}
+	}
+
+	c.failures++
+	l.counters[key] = c
+}
+
+// Reset forgets failed attempts for key.
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.counters, key)
+}
+
+// counter returns the counter of key if its window is not over yet.
+func (l *Limiter) counter(key string) (counter, bool) {
+	c, ok := l.counters[key]
+	if !ok {
+		return counter{}, false
+	}
+
+	if l.Now().Sub(c.start) >= l.window {
+		delete(l.counters, key)
+
+		return counter{}, false
+	}
+
+	return c, true
+}
+
+// sweep removes expired counters, so keys that are never seen again don't pile up.
+func (l *Limiter) sweep() {
+	now := l.Now()
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+
+	for key, c := range l.counters {
+		if now.Sub(c.start) >= l.window {
+			delete(l.counters, key)
+		}
+	}
+
+	l.lastSweep = now
+}
@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestLimiter returns a limiter whose time is *now, so the test moves it.
+func newTestLimiter(attempts int, window time.Duration, now *time.Time) *Limiter {
+	l := New(attempts, window)
+	l.Now = func() time.Time { return *now }
+
+	return l
+}
+
+func TestLimiterBlocksAfterAttempts(t *testing.T) {
+	now := time.Now()
+	l := newTestLimiter(3, time.Minute, &now)
+
+	for i := 0; i < 3; i++ {
+		if !l.Allow("key") {
+			t.Fatalf("attempt %d not allowed", i+1)
+		}
+		l.Fail("key")
+	}
+
+	if l.Allow("key") {
+		t.Fatal("attempt allowed after 3 failures")
+	}
+	if !l.Allow("other") {
+		t.Fatal("failures of one key blocked another")
+	}
+}
+
+func TestLimiterWindow(t *testing.T) {
+	now := time.Now()
+	l := newTestLimiter(2, time.Minute, &now)
+
+	l.Fail("key")
+	now = now.Add(30 * time.Second)
+	l.Fail("key")
+
+	// The window started with the first failure, not the last one.
+	now = now.Add(29 * time.Second)
+	if l.Allow("key") {
+		t.Fatal("attempt allowed within the window")
+	}
+
+	now = now.Add(time.Second)
+	if !l.Allow("key") {
+		t.Fatal("attempt not allowed once the window is over")
+	}
+
+	// Failures of the previous window don't count anymore.
+	l.Fail("key")
+	if !l.Allow("key") {
+		t.Fatal("attempt not allowed after a single failure of a new window")
+	}
+}
+
+func TestLimiterReset(t *testing.T) {
+	now := time.Now()
+	l := newTestLimiter(1, time.Minute, &now)
+
+	l.Fail("key")
+	if l.Allow("key") {
+		t.Fatal("attempt allowed after a failure")
+	}
+
+	l.Reset("key")
+	if !l.Allow("key") {
+		t.Fatal("attempt not allowed after reset")
+	}
+}
+
+func TestLimiterSweepsExpiredCounters(t *testing.T) {
+	now := time.Now()
+	l := newTestLimiter(5, time.Minute, &now)
+
+	l.Fail("a")
+	l.Fail("b")
+
+	now = now.Add(time.Minute)
+	l.Fail("c")
+
+	if _, ok := l.counters["a"]; ok {
+		t.Fatal("expired counter of a is kept")
+	}
+	if _, ok := l.counters["b"]; ok {
+		t.Fatal("expired counter of b is kept")
+	}
+	if c, ok := l.counters["c"]; !ok || c.failures != 1 {
+		t.Fatalf("counter of c is %+v, %t, want 1 failure", c, ok)
+	}
+}
@@ -0,0 +1,46 @@
+// Package requestid carries the correlation ID of a request through its context,
+// so every log line written while handling the request can be tied to it.
+package requestid
+
+import (
+	"context"
+	"github.com/google/uuid"
+)
+
+// maxLength limits IDs sent by clients, longer ones are replaced.
+const maxLength = 128
+
+type ctxKey struct{}
+
+// New generates a new random ID.
+func New() string {
+	return uuid.NewString()
+}
+
+// Valid reports whether an ID sent by a client can be used as is:
+// it must be non-empty, reasonably short and printable ASCII, so it can't break log lines.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NewContext returns a copy of ctx carrying id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns the ID carried by ctx, or an empty string.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+
+	return id
+}
@@ -0,0 +1,32 @@
+// Package secret holds types for values which must never be logged.
+package secret
+
+import "log/slog"
+
+// Redacted is printed instead of a secret.
+const Redacted = "[REDACTED]"
+
+// Password is a plaintext password. It prints as Redacted with fmt and slog,
+// so passing it to a logger by mistake doesn't leak it, the plaintext
+// is only returned by Reveal.
+type Password string
+
+// Reveal returns the plaintext password, e.g. to hash it.
+func (p Password) Reveal() string {
+	return string(p)
+}
+
+// String implements fmt.Stringer.
+func (p Password) String() string {
+	return Redacted
+}
+
+// GoString implements fmt.GoStringer, so %#v is redacted as well.
+func (p Password) GoString() string {
+	return Redacted
+}
+
+// LogValue implements slog.LogValuer.
+func (p Password) LogValue() slog.Value {
+	return slog.StringValue(Redacted)
+}
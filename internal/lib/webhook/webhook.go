@@ -0,0 +1,237 @@
+// Package webhook delivers events to an HTTP endpoint.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"sso/internal/domain/models"
+	"sync"
+	"time"
+)
+
+// Headers of webhook requests.
+const (
+	// HeaderEvent is the type of the event.
+	HeaderEvent = "X-SSO-Event"
+	// HeaderSignature is "sha256=" followed by the hex HMAC-SHA256 of the body
+	// keyed with the secret, it is only set if the secret is configured.
+	HeaderSignature = "X-SSO-Signature"
+)
+
+// ErrQueueFull is returned by Publish when events are published faster than they are delivered.
+var ErrQueueFull = errors.New("webhook queue is full")
+
+// ErrClosed is returned by Publish after Close.
+var ErrClosed = errors.New("webhook publisher is closed")
+
+type Config struct {
+	URL string
+	// Secret signs request bodies, see HeaderSignature. Empty sends them unsigned.
+	Secret string
+	// Timeout bounds a single delivery attempt.
+	Timeout time.Duration
+	// Attempts is how many times an event is sent in total before it is dropped.
+	Attempts int
+	// Backoff is the delay before the first retry, it doubles before every next one.
+	Backoff time.Duration
+	// QueueSize is how many events may wait for delivery.
+	QueueSize int
+}
+
+// Publisher posts events as JSON to the configured URL.
+//
+// Events are queued by Publish and delivered in the background one by one,
+// failed deliveries are retried with exponential backoff and dropped with
+// an error log after the last attempt. Delivery is at least once,
+// receivers drop duplicates by event id.
+type Publisher struct {
+	log    *slog.Logger
+	cfg    Config
+	client *http.Client
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan models.Event
+	// done is closed once the queue is drained after Close.
+	done chan struct{}
+	// stop is closed when Close gives up waiting, to abort retries.
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// New starts a publisher, it must be closed with Close to deliver queued events.
+func New(log *slog.Logger, cfg Config) *Publisher {
+	p := &Publisher{
+		log:    log,
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		queue:  make(chan models.Event, cfg.QueueSize),
+		done:   make(chan struct{}),
+		stop:   make(chan struct{}),
+	}
+
+	go p.run()
+
+	return p
+}
+
+// Publish queues the event for delivery, it never waits for the endpoint.
+func (p *Publisher) Publish(_ context.Context, event models.Event) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrClosed
+	}
+
+	select {
+	case p.queue <- event:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close stops accepting events and waits until queued ones are delivered.
+// If ctx is done first, pending retries are aborted and the rest is dropped.
+func (p *Publisher) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		p.stopOnce.Do(func() { close(p.stop) })
+		<-p.done
+
+		return fmt.Errorf("webhook.Close: %d events not delivered: %w", len(p.queue), ctx.Err())
+	}
+}
+
+func (p *Publisher) run() {
+	defer close(p.done)
+
+	for event := range p.queue {
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+
+		p.deliver(event)
+	}
+}
+
+// deliver sends the event until it is accepted or attempts run out.
+func (p *Publisher) deliver(event models.Event) {
+	log := p.log.With(
+		slog.String("event_id", event.ID),
+		slog.String("event_type", event.Type),
+	)
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Error("failed to encode event", slog.String("error", err.Error()))
+
+		return
+	}
+
+	backoff := p.cfg.Backoff
+
+	for attempt := 1; ; attempt++ {
+		err := p.send(event.Type, body)
+		if err == nil {
+			log.Debug("event delivered", slog.Int("attempt", attempt))
+
+			return
+		}
+
+		var perm permanentError
+		if errors.As(err, &perm) || attempt >= p.cfg.Attempts {
+			log.Error("failed to deliver event, dropping it",
+				slog.Int("attempt", attempt),
+				slog.String("error", err.Error()),
+			)
+
+			return
+		}
+
+		log.Warn("failed to deliver event, retrying",
+			slog.Int("attempt", attempt),
+			slog.String("error", err.Error()),
+		)
+
+		// Jitter spreads retries of instances that failed at the same time.
+		delay := backoff/2 + rand.N(backoff/2+1)
+
+		select {
+		case <-time.After(delay):
+		case <-p.stop:
+			return
+		}
+
+		backoff *= 2
+	}
+}
+
+// permanentError is a failure that won't go away on retry, e.g. a rejection of the event.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (p *Publisher) send(eventType string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, p.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, eventType)
+
+	if p.cfg.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(p.cfg.Secret, body))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusRequestTimeout,
+		resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("status %d", resp.StatusCode)
+	default:
+		return permanentError{fmt.Errorf("rejected with status %d", resp.StatusCode)}
+	}
+}
+
+// Sign returns the HeaderSignature value of body, receivers compute it
+// the same way and compare in constant time.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -4,20 +4,77 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"golang.org/x/crypto/bcrypt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"log/slog"
 	"sso/internal/domain/models"
+	"sso/internal/lib/clock"
 	"sso/internal/lib/jwt"
+	"sso/internal/lib/password"
+	"sso/internal/lib/requestid"
+	"sso/internal/lib/secret"
 	"sso/internal/storage"
 	"time"
 )
 
 type Auth struct {
-	log         *slog.Logger
-	usrSave     UserSaver
-	usrProvider UserProvider
-	appProvider AppProvider
-	tokenTTl    time.Duration
+	log               *slog.Logger
+	usrSave           UserSaver
+	usrProvider       UserProvider
+	appProvider       AppProvider
+	appSaver          AppSaver
+	tokenRevoker      TokenRevoker
+	refreshStore      RefreshTokenStore
+	sessions          SessionStore
+	oneTimeTokens     OneTimeTokenStore
+	identities        IdentityStore
+	oauthStates       OAuthStateStore
+	passkeys          PasskeyStore
+	passkeyChallenges PasskeyChallengeStore
+	roleProvider      RoleProvider
+	tx                Transactor
+	sender            Sender
+	events            EventPublisher
+	tokenTTl          time.Duration
+	issuer            string
+	leeway            time.Duration
+	refreshTTL        time.Duration
+	keys              *jwt.KeySet
+	lockout           Lockout
+	loginBackoff      LoginBackoff
+	passwordPolicy    password.Policy
+	// passwordHistory is how many last passwords of a user can't be reused.
+	passwordHistory int
+	// passwordMaxAge is how long a password is valid, 0 means forever.
+	passwordMaxAge        time.Duration
+	blockExpiredPasswords bool
+
+	requireVerification bool
+	verificationTTL     time.Duration
+	passwordResetTTL    time.Duration
+	magicLinkTTL        time.Duration
+	softDelete          bool
+
+	caseSensitiveLocalPart bool
+
+	defaultRole DefaultRole
+
+	identityProviders map[string]IdentityProvider
+	oauthStateTTL     time.Duration
+	oidcLogins        map[string]OIDCLogin
+
+	relyingParty   RelyingParty
+	passkeyTimeout time.Duration
+
+	tokenExchange map[int][]int
+
+	scopes     map[int]AppScopes
+	denyScopes bool
+
+	hasher    PasswordHasher
+	dummyHash func() []byte
+
+	clock clock.Clock
 }
 
 type UserSaver interface {
@@ -25,145 +82,652 @@ type UserSaver interface {
 		ctx context.Context,
 		email string,
 		passHash []byte,
+		verified bool,
+		passwordChangedAt time.Time,
 	) (uid int64, err error)
+	// UpdatePassword replaces the password hash, the password counts as changed at changedAt.
+	UpdatePassword(ctx context.Context, userID int64, passHash []byte, changedAt time.Time) error
+	// ReplacePasswordHash replaces password hash of the user only if it is still oldHash.
+	ReplacePasswordHash(ctx context.Context, userID int64, oldHash []byte, newHash []byte) error
+	MarkEmailVerified(ctx context.Context, userID int64) error
+	// UpdateEmail returns storage.ErrUserExists if email is taken by another user.
+	UpdateEmail(ctx context.Context, userID int64, email string) error
+	DeleteUser(ctx context.Context, userID int64) error
+	// SoftDeleteUser marks the user as deleted at deletedAt.
+	SoftDeleteUser(ctx context.Context, userID int64, deletedAt time.Time) error
+	// IncrementTokenVersion bumps the token version of the user, revoking
+	// all access tokens issued so far. It returns storage.ErrUserNotFound
+	// if the user doesn't exist.
+	IncrementTokenVersion(ctx context.Context, userID int64) error
+	// PasswordHistory returns up to limit previous password hashes of the user, the newest first.
+	PasswordHistory(ctx context.Context, userID int64, limit int) ([][]byte, error)
+	// AddPasswordHistory remembers a previous password hash of the user
+	// and prunes all but the newest keep hashes of the user.
+	AddPasswordHistory(ctx context.Context, userID int64, passHash []byte, keep int, addedAt time.Time) error
 }
 
 type UserProvider interface {
 	User(ctx context.Context, email string) (models.User, error)
+	UserByID(ctx context.Context, userID int64) (models.User, error)
 	IsAdmin(ctx context.Context, userID int64) (bool, error)
+	// AreAdmins returns whether each of the users is an admin,
+	// users that don't exist are absent from the result.
+	AreAdmins(ctx context.Context, userIDs []int64) (map[int64]bool, error)
+	// ListUsers returns a page of users ordered by id, without password hashes.
+	ListUsers(ctx context.Context, limit int, offset int) ([]models.User, error)
+	CountUsers(ctx context.Context) (int, error)
+	// IncrementFailedLogin counts a failed login of the user and, once the user
+	// has failed lockAfter times in a row, locks the account until lockUntil.
+	IncrementFailedLogin(ctx context.Context, userID int64, lockAfter int, lockUntil time.Time) error
+	// ResetFailedLogin clears failed logins of the user and lifts the lock.
+	ResetFailedLogin(ctx context.Context, userID int64) error
 }
 
 type AppProvider interface {
 	App(ctx context.Context, appID int) (models.App, error)
 }
 
-var (
-	ErrInvalidCredentials = errors.New("invalid credentials")
-	ErrInvalidAppID       = errors.New("invalid app id")
-	ErrUserExists         = errors.New("user already exists")
-)
+type AppSaver interface {
+	SaveApp(ctx context.Context, app models.App) (int, error)
+	// DeleteApp deletes the app with refresh tokens and roles issued for it.
+	DeleteApp(ctx context.Context, appID int) error
+}
+
+type TokenRevoker interface {
+	RevokeToken(ctx context.Context, tokenID string, userID int64, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, tokenID string) (bool, error)
+}
+
+type RefreshTokenStore interface {
+	SaveRefreshToken(ctx context.Context, token models.RefreshToken) error
+	RefreshToken(ctx context.Context, tokenHash string) (models.RefreshToken, error)
+	DeleteRefreshToken(ctx context.Context, tokenHash string) error
+}
+
+// SessionStore keeps logins of users, see models.Session.
+type SessionStore interface {
+	SaveSession(ctx context.Context, session models.Session) (int64, error)
+	// TouchSession marks the session as used at usedAt and extends it until expiresAt,
+	// returns storage.ErrSessionNotFound if the session has been deleted.
+	TouchSession(ctx context.Context, sessionID int64, usedAt time.Time, expiresAt time.Time) error
+	// Sessions returns sessions of the user that haven't expired at now.
+	Sessions(ctx context.Context, userID int64, now time.Time) ([]models.Session, error)
+	// DeleteSession deletes the session of the user together with its refresh tokens.
+	DeleteSession(ctx context.Context, userID int64, sessionID int64) error
+	// DeleteUserSessions deletes all sessions and refresh tokens of the user.
+	DeleteUserSessions(ctx context.Context, userID int64) error
+}
+
+type OneTimeTokenStore interface {
+	SaveOneTimeToken(ctx context.Context, token models.OneTimeToken) error
+	// OneTimeToken returns the token with given hash and purpose without consuming it.
+	OneTimeToken(ctx context.Context, tokenHash string, purpose string) (models.OneTimeToken, error)
+	// ConsumeOneTimeToken deletes the token with given hash and purpose and returns it,
+	// so every token can be used only once.
+	ConsumeOneTimeToken(ctx context.Context, tokenHash string, purpose string) (models.OneTimeToken, error)
+}
+
+// PasswordHasher hashes passwords and checks them against stored hashes.
+type PasswordHasher interface {
+	Hash(password string) ([]byte, error)
+	// Compare returns an error if password doesn't match hash.
+	Compare(hash []byte, password string) error
+	// NeedsRehash reports whether hash is outdated, e.g. made with a lower cost.
+	NeedsRehash(hash []byte) bool
+}
+
+// RoleProvider returns roles of users, roles are scoped per app.
+type RoleProvider interface {
+	UserRoles(ctx context.Context, userID int64, appID int) ([]string, error)
+	// AssignRole gives the user the role in the app, it does nothing if the user already has it.
+	AssignRole(ctx context.Context, userID int64, appID int, role string) error
+}
+
+// Transactor runs fn in a transaction, which is committed if fn returns nil
+// and rolled back otherwise. Storage calls made with the ctx passed to fn
+// are part of the transaction.
+type Transactor interface {
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// DefaultRole is the role every new user gets in an app.
+type DefaultRole struct {
+	AppID int
+	// Role is the name of the role, no role is assigned if it is empty.
+	Role string
+}
+
+// Sender delivers one-time tokens to users, e.g. by email.
+type Sender interface {
+	SendVerification(ctx context.Context, email string, token string) error
+	SendPasswordReset(ctx context.Context, email string, token string) error
+	SendMagicLink(ctx context.Context, email string, token string) error
+}
+
+// Config holds settings of the Auth service.
+type Config struct {
+	TokenTTL time.Duration
+	// Issuer is the iss claim of issued tokens, tokens of other issuers are rejected.
+	Issuer string
+	// Leeway tolerates clock drift between servers when validating token times.
+	Leeway time.Duration
+	// RefreshTTL is the lifetime of refresh tokens, 0 disables them.
+	RefreshTTL time.Duration
+	// Keys signs tokens with RS256.
+	// If nil, tokens are signed with HS256 using the app secret.
+	Keys    *jwt.KeySet
+	Lockout Lockout
+	// LoginBackoff delays logins of emails with failed logins.
+	// If nil, logins are not delayed.
+	LoginBackoff   LoginBackoff
+	PasswordPolicy password.Policy
+	// PasswordHistory is how many last passwords of a user, the current one included,
+	// can't be set again by ChangePassword and ResetPassword. 0 allows any reuse.
+	PasswordHistory int
+	// PasswordMaxAge is how long a password is valid before it must be changed, 0 means forever.
+	PasswordMaxAge time.Duration
+	// BlockExpiredPasswords makes Login refuse users with an expired password,
+	// otherwise they are logged in and told to change it.
+	BlockExpiredPasswords bool
+	// RequireVerification makes Login refuse users who haven't verified their email.
+	RequireVerification bool
+	// VerificationTTL is the lifetime of email verification tokens.
+	VerificationTTL time.Duration
+	// PasswordResetTTL is the lifetime of password reset tokens.
+	PasswordResetTTL time.Duration
+	// MagicLinkTTL is the lifetime of magic link tokens, it should be short.
+	MagicLinkTTL time.Duration
+	// SoftDelete makes DeleteUser only mark users as deleted instead of removing their data.
+	SoftDelete bool
+	// CaseSensitiveLocalPart keeps case of the part of emails before @, see NormalizeEmail.
+	CaseSensitiveLocalPart bool
+	// DefaultRole is assigned to users by RegisterNewUser.
+	DefaultRole DefaultRole
+	// Hasher hashes passwords, a cheap one makes tests faster.
+	Hasher PasswordHasher
+	// Events is told about registrations, logins and deletions of users.
+	// If nil, events are not published.
+	Events EventPublisher
+	// IdentityProviders users can sign in with by name, e.g. "google", see StartOAuth.
+	IdentityProviders map[string]IdentityProvider
+	// OAuthStateTTL is how long a sign in with an identity provider may take.
+	OAuthStateTTL time.Duration
+	// OIDCLogins are identity providers LoginWithOIDC accepts codes of, by name.
+	OIDCLogins map[string]OIDCLogin
+	// RelyingParty registers and verifies passkeys, see BeginRegistration and BeginLogin.
+	// If nil, passkeys are disabled.
+	RelyingParty RelyingParty
+	// PasskeyTimeout is how long a registration or login with a passkey may take.
+	PasskeyTimeout time.Duration
+	// TokenExchange lists, by app id, the apps tokens of each app can be exchanged
+	// for by ExchangeToken. Tokens of apps absent from it can't be exchanged.
+	TokenExchange map[int][]int
+	// Scopes users can request at Login, by app id. Apps absent from it grant no scopes.
+	Scopes map[int]AppScopes
+	// DenyScopes makes Login fail with ErrScopeNotAllowed when a requested scope
+	// can't be granted, otherwise the scope is left out of the token.
+	DenyScopes bool
+	// Clock tells expiries of tokens, sessions and locks. If nil, the system clock is used.
+	Clock clock.Clock
+}
+
+// Stores are where the service keeps its data. Usually every field is the same
+// storage, e.g. *sqlite.Storage, they are split by what the service needs from it.
+type Stores struct {
+	UserSaver         UserSaver
+	UserProvider      UserProvider
+	AppProvider       AppProvider
+	AppSaver          AppSaver
+	TokenRevoker      TokenRevoker
+	RefreshTokens     RefreshTokenStore
+	Sessions          SessionStore
+	OneTimeTokens     OneTimeTokenStore
+	Identities        IdentityStore
+	OAuthStates       OAuthStateStore
+	Passkeys          PasskeyStore
+	PasskeyChallenges PasskeyChallengeStore
+	Roles             RoleProvider
+	Tx                Transactor
+}
 
 // New returns a new instance of thr Auth service
 func New(
 	log *slog.Logger,
-	userSaver UserSaver,
-	userProvider UserProvider,
-	appProvider AppProvider,
-	tokenTTl time.Duration,
+	stores Stores,
+	sender Sender,
+	cfg Config,
 ) *Auth {
+	events := cfg.Events
+	if events == nil {
+		events = nopPublisher{}
+	}
+
+	loginBackoff := cfg.LoginBackoff
+	if loginBackoff == nil {
+		loginBackoff = nopBackoff{}
+	}
+
+	clk := cfg.Clock
+	if clk == nil {
+		clk = clock.Real{}
+	}
 
 	return &Auth{
-		usrSave:     userSaver,
-		usrProvider: userProvider,
-		log:         log,
-		appProvider: appProvider,
-		tokenTTl:    tokenTTl,
+		usrSave:             stores.UserSaver,
+		usrProvider:         stores.UserProvider,
+		log:                 log,
+		appProvider:         stores.AppProvider,
+		appSaver:            stores.AppSaver,
+		tokenRevoker:        stores.TokenRevoker,
+		refreshStore:        stores.RefreshTokens,
+		sessions:            stores.Sessions,
+		oneTimeTokens:       stores.OneTimeTokens,
+		identities:          stores.Identities,
+		oauthStates:         stores.OAuthStates,
+		passkeys:            stores.Passkeys,
+		passkeyChallenges:   stores.PasskeyChallenges,
+		roleProvider:        stores.Roles,
+		tx:                  stores.Tx,
+		sender:              sender,
+		events:              events,
+		tokenTTl:            cfg.TokenTTL,
+		issuer:              cfg.Issuer,
+		leeway:              cfg.Leeway,
+		refreshTTL:          cfg.RefreshTTL,
+		keys:                cfg.Keys,
+		lockout:             cfg.Lockout,
+		loginBackoff:        loginBackoff,
+		passwordPolicy:      cfg.PasswordPolicy,
+		passwordHistory:     cfg.PasswordHistory,
+		passwordMaxAge:      cfg.PasswordMaxAge,
+		requireVerification: cfg.RequireVerification,
+		verificationTTL:     cfg.VerificationTTL,
+		passwordResetTTL:    cfg.PasswordResetTTL,
+		magicLinkTTL:        cfg.MagicLinkTTL,
+		softDelete:          cfg.SoftDelete,
+
+		caseSensitiveLocalPart: cfg.CaseSensitiveLocalPart,
+		blockExpiredPasswords:  cfg.BlockExpiredPasswords,
+
+		defaultRole: cfg.DefaultRole,
+
+		identityProviders: cfg.IdentityProviders,
+		oauthStateTTL:     cfg.OAuthStateTTL,
+		oidcLogins:        cfg.OIDCLogins,
+
+		relyingParty:   cfg.RelyingParty,
+		passkeyTimeout: cfg.PasskeyTimeout,
+
+		tokenExchange: cfg.TokenExchange,
+
+		scopes:     cfg.Scopes,
+		denyScopes: cfg.DenyScopes,
+
+		hasher:    cfg.Hasher,
+		dummyHash: newDummyHash(cfg.Hasher),
+
+		clock: clk,
 	}
 }
 
+// LoginResult is what a successful Login returns.
+type LoginResult struct {
+	Token string
+	// RefreshToken is empty if refresh tokens are disabled.
+	RefreshToken string
+	// UserID and ExpiresAt are returned so clients don't have to parse the token.
+	UserID    int64
+	ExpiresAt time.Time
+	// PasswordExpired is set if the client should make the user change the password.
+	PasswordExpired bool
+	// Scopes are the ones granted, refreshed tokens keep them.
+	Scopes []string
+}
+
 // Login checks if user with given credentials exists in the system
 //
 // if user existst, but password is incorrect, returns error
 // if user doesn't exist, returns error
+// if refresh tokens are enabled, also returns a refresh token, otherwise it is empty
+// every successful login starts a session, see ListSessions
+//
+// if the password is older than the password max age, PasswordExpired is set and the client
+// should make the user change it, or ErrPasswordExpired is returned if expired passwords are blocked
+//
+// appSecret must be the secret of the app, otherwise ErrInvalidAppSecret is returned
+// and the user's credentials are not checked at all.
+//
+// after failed logins for the email, the next ones are delayed, see Config.LoginBackoff;
+// the delay ends early with the error of ctx if the request is cancelled.
+//
+// requestedScopes are put in the token if the user may have them in the app, see Config.Scopes,
+// the others are left out or make Login fail with ErrScopeNotAllowed, see Config.DenyScopes.
 func (a *Auth) Login(
 	ctx context.Context,
 	email string,
-	password string,
+	password secret.Password,
 	appID int,
-) (string, error) {
+	appSecret string,
+	requestedScopes []string,
+) (res LoginResult, err error) {
 	const op = "Auth.Login"
 
-	log := a.log.With(
+	email = a.normalizeEmail(email)
+
+	ctx, span := tracer.Start(ctx, "Auth.Login", trace.WithAttributes(
+		attribute.Int("app_id", appID),
+	))
+	defer func() { endSpan(span, err) }()
+
+	log := a.logger(ctx).With(
 		slog.String("op", op),
 		slog.String("username", email),
 	)
 
 	log.Info("Attempting to login")
 
+	app, err := a.authenticateApp(ctx, appID, appSecret)
+	if err != nil {
+		log.Warn("app authentication failed", "error", err)
+
+		return LoginResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Unknown emails are delayed the same as existing ones, so delays don't tell them apart.
+	if err := a.loginBackoff.Wait(ctx, email); err != nil {
+		log.Warn("request cancelled during login backoff", "error", err)
+
+		return LoginResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
 	user, err := a.usrProvider.User(ctx, email)
 	if err != nil {
 		if errors.Is(err, storage.ErrUserNotFound) {
-			a.log.Warn("User not found", err.Error())
+			log.Warn("User not found", "error", err)
+
+			a.compareDummy(password)
+			a.loginBackoff.Fail(email)
+
+			return LoginResult{}, fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		}
+
+		log.Error("Failed to login", "error", err)
+
+		return LoginResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkLockout(ctx, &user); err != nil {
+		if errors.Is(err, ErrAccountLocked) {
+			log.Warn("account is locked", slog.Time("locked_until", user.LockedUntil))
+
+			return LoginResult{}, fmt.Errorf("%s: %w", op, ErrAccountLocked)
+		}
+
+		log.Error("failed to check lockout", "error", err)
+
+		return LoginResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// A cancelled request must not be counted as a failed attempt.
+	if err := ctx.Err(); err != nil {
+		log.Warn("request cancelled", "error", err)
+
+		return LoginResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.hasher.Compare(user.PassHash, password.Reveal()); err != nil {
+		log.Error("Failed to login", "error", err)
 
-			return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		a.loginBackoff.Fail(email)
+		if err := a.registerFailedLogin(ctx, user); err != nil {
+			log.Error("failed to register failed login", "error", err)
 		}
 
-		a.log.Error("Failed to login", "error", err)
+		return LoginResult{}, fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
 
-		return "", fmt.Errorf("%s: %w", op, err)
+	a.loginBackoff.Reset(email)
+	if err := a.resetFailedLogins(ctx, user); err != nil {
+		log.Error("failed to reset failed logins", "error", err)
 	}
 
-	if err := bcrypt.CompareHashAndPassword(user.PassHash, []byte(password)); err != nil {
-		a.log.Error("Failed to login", "error", err)
+	a.rehashPassword(ctx, log, user, password)
+
+	if a.requireVerification && !user.Verified {
+		log.Warn("email is not verified")
 
-		return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		return LoginResult{}, fmt.Errorf("%s: %w", op, ErrEmailNotVerified)
 	}
 
-	app, err := a.appProvider.App(ctx, appID)
+	passwordExpired := a.passwordExpired(user)
+	if passwordExpired {
+		if a.blockExpiredPasswords {
+			log.Warn("password is expired", slog.Time("password_changed_at", user.PasswordChangedAt))
+
+			return LoginResult{}, fmt.Errorf("%s: %w", op, ErrPasswordExpired)
+		}
+
+		log.Info("password is expired, it must be changed", slog.Time("password_changed_at", user.PasswordChangedAt))
+	}
+
+	scopes, denied, err := a.grantScopes(ctx, user.ID, app.ID, requestedScopes)
 	if err != nil {
-		return "", fmt.Errorf("%s: %w", op, err)
+		log.Error("failed to grant scopes", "error", err)
+
+		return LoginResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if len(denied) > 0 {
+		if a.denyScopes {
+			log.Warn("scopes are not allowed", slog.Any("scopes", denied))
+
+			return LoginResult{}, fmt.Errorf("%s: %w", op, ErrScopeNotAllowed)
+		}
+
+		log.Info("scopes are not allowed, they are dropped", slog.Any("scopes", denied))
 	}
 
 	log.Info("Successfully logged in")
 
-	token, err := jwt.NewToken(user, app, a.tokenTTl)
+	token, refreshToken, expiresAt, err := a.issueTokens(ctx, user, app, scopes)
 	if err != nil {
-		a.log.Error("Failed to login", "error", err)
-		return "", fmt.Errorf("%s: %w", op, err)
+		log.Error("Failed to login", "error", err)
+		return LoginResult{}, fmt.Errorf("%s: %w", op, err)
 	}
-	return token, nil
+
+	a.publish(ctx, log, models.EventUserLoggedIn, user.ID, app.ID)
+
+	return LoginResult{
+		Token:           token,
+		RefreshToken:    refreshToken,
+		UserID:          user.ID,
+		ExpiresAt:       expiresAt,
+		PasswordExpired: passwordExpired,
+		Scopes:          scopes,
+	}, nil
 }
 
+// RegisterNewUser creates a user with given credentials.
+//
+// If the email is taken, ErrUserExists is returned, unless idempotent is set and
+// password is the password of the existing user: then its id is returned with existed set,
+// so a repeated signup succeeds. Such password checks count as failed logins
+// when the password doesn't match, the same as in Login.
+//
+// New users get the default role, see Config.DefaultRole. The user is created
+// only if the role is assigned as well.
+//
+// Returns ErrPasswordTooLong if the password hasher can't hash the whole password,
+// e.g. bcrypt takes at most 72 bytes.
 func (a *Auth) RegisterNewUser(
 	ctx context.Context,
 	email string,
-	password string,
-) (user uint64, err error) {
+	password secret.Password,
+	idempotent bool,
+) (user uint64, existed bool, err error) {
 	const op = "auth.RegisterNewUser"
 
-	log := a.log.With(
+	email = a.normalizeEmail(email)
+
+	ctx, span := tracer.Start(ctx, "Auth.RegisterNewUser")
+	defer func() { endSpan(span, err) }()
+
+	log := a.logger(ctx).With(
 		slog.String("op", op),
 		slog.String("email", email),
 	)
 	log.Info("register new user")
 
-	passHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err := a.passwordPolicy.Validate(password.Reveal()); err != nil {
+		log.Info("weak password", "error", err)
+
+		return 0, false, fmt.Errorf("%s: %w: %w", op, ErrWeakPassword, err)
+	}
+
+	passHash, err := a.hashPassword(ctx, password.Reveal())
 	if err != nil {
-		log.Error("failed to hash password", err.Error())
+		if errors.Is(err, ErrPasswordTooLong) {
+			log.Info("password is too long")
+
+			return 0, false, fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Error("failed to hash password", "error", err)
 
-		return 0, fmt.Errorf("%s: %w", op, err)
+		return 0, false, fmt.Errorf("%s: %w", op, err)
 	}
 
-	id, err := a.usrSave.SaveUser(ctx, email, passHash)
+	id, err := a.saveUser(ctx, email, passHash, !a.requireVerification)
 	if err != nil {
 		if errors.Is(err, storage.ErrUserExists) {
-			log.Warn("User already exists", err.Error())
+			if idempotent {
+				userID, err := a.existingUser(ctx, log, email, password)
+				if err != nil {
+					return 0, false, fmt.Errorf("%s: %w", op, err)
+				}
 
-			return 0, fmt.Errorf("%s: %w", op, ErrUserExists)
+				return uint64(userID), true, nil
+			}
+
+			log.Warn("User already exists", "error", err)
+
+			return 0, false, fmt.Errorf("%s: %w", op, ErrUserExists)
 		}
-		log.Error("failed to save user", err.Error())
-		return 0, fmt.Errorf("%s: %w", op, err)
+		log.Error("failed to save user", "error", err)
+		return 0, false, fmt.Errorf("%s: %w", op, err)
 	}
 
 	log.Info("user created")
 
-	return uint64(id), nil
+	if a.requireVerification {
+		if err := a.sendVerification(ctx, id, email); err != nil {
+			// The user is already created, so registration succeeds;
+			// verification email can be requested again.
+			log.Error("failed to send verification", "error", err)
+		}
+	}
+
+	a.publish(ctx, log, models.EventUserRegistered, id, 0)
+
+	return uint64(id), false, nil
+}
+
+// saveUser saves the user and assigns them the default role in one transaction,
+// so a failed assignment doesn't leave a user without the role behind.
+func (a *Auth) saveUser(ctx context.Context, email string, passHash []byte, verified bool) (int64, error) {
+	var id int64
+
+	err := a.tx.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+
+		id, err = a.usrSave.SaveUser(ctx, email, passHash, verified, a.clock.Now())
+		if err != nil {
+			return err
+		}
+
+		if a.defaultRole.Role == "" {
+			return nil
+		}
+
+		return a.roleProvider.AssignRole(ctx, id, a.defaultRole.AppID, a.defaultRole.Role)
+	})
+
+	return id, err
+}
+
+// existingUser returns the id of the user with given email if password is the password
+// of the user, ErrUserExists otherwise, so nothing is revealed about the existing user.
+func (a *Auth) existingUser(ctx context.Context, log *slog.Logger, email string, password secret.Password) (int64, error) {
+	user, err := a.usrProvider.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			// The email belongs to a soft deleted user.
+			log.Warn("user already exists and is deleted")
+
+			return 0, ErrUserExists
+		}
+
+		log.Error("failed to get user", "error", err)
+
+		return 0, err
+	}
+
+	if err := a.checkLockout(ctx, &user); err != nil {
+		if errors.Is(err, ErrAccountLocked) {
+			log.Warn("user already exists and is locked", slog.Time("locked_until", user.LockedUntil))
+
+			return 0, ErrUserExists
+		}
+
+		log.Error("failed to check lockout", "error", err)
+
+		return 0, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if err := a.hasher.Compare(user.PassHash, password.Reveal()); err != nil {
+		log.Warn("user already exists with another password")
+
+		if err := a.registerFailedLogin(ctx, user); err != nil {
+			log.Error("failed to register failed login", "error", err)
+		}
+
+		return 0, ErrUserExists
+	}
+
+	log.Info("user already exists with the same password", slog.Int64("user_id", user.ID))
+
+	return user.ID, nil
 }
 
-func (a *Auth) IsAdmin(ctx context.Context, userID uint64) (bool, error) {
+// IsAdmin checks if user is an admin.
+//
+// Returns ErrUserNotFound if there is no such user.
+func (a *Auth) IsAdmin(ctx context.Context, userID uint64) (isAdmin bool, err error) {
 	const op = "auth.IsAdmin"
-	log := a.log.With(
+
+	ctx, span := tracer.Start(ctx, "Auth.IsAdmin", trace.WithAttributes(
+		attribute.Int64("user_id", int64(userID)),
+	))
+	defer func() {
+		span.SetAttributes(attribute.Bool("is_admin", isAdmin))
+		endSpan(span, err)
+	}()
+
+	log := a.logger(ctx).With(
 		slog.String("op", op),
 		slog.Int64("user_id", int64(userID)),
 	)
 
 	log.Info("Checking if user is admin")
 
-	isAdmin, err := a.usrProvider.IsAdmin(ctx, int64(userID))
+	isAdmin, err = a.usrProvider.IsAdmin(ctx, int64(userID))
 	if err != nil {
-		if errors.Is(err, storage.ErrAppNotFound) {
-			log.Warn("User not found", err.Error())
-			return false, fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("User not found", "error", err)
+			return false, fmt.Errorf("%s: %w", op, ErrUserNotFound)
 		}
 		return false, fmt.Errorf("%s: %w", op, err)
 	}
@@ -171,3 +735,240 @@ func (a *Auth) IsAdmin(ctx context.Context, userID uint64) (bool, error) {
 	log.Info("Checking if user is admin", slog.Bool("isAdmin", isAdmin))
 	return isAdmin, nil
 }
+
+// AreAdmins checks which of the users are admins in a single storage call.
+//
+// Users that don't exist are absent from the result instead of failing the whole batch.
+func (a *Auth) AreAdmins(ctx context.Context, userIDs []int64) (map[int64]bool, error) {
+	const op = "auth.AreAdmins"
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.Int("users", len(userIDs)),
+	)
+
+	log.Info("checking if users are admins")
+
+	admins, err := a.usrProvider.AreAdmins(ctx, userIDs)
+	if err != nil {
+		log.Error("failed to check admins", "error", err)
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return admins, nil
+}
+
+// Logout revokes the given token so it can no longer be used.
+//
+// If token has already expired, there is nothing to revoke and no error is returned.
+func (a *Auth) Logout(ctx context.Context, token string) error {
+	const op = "auth.Logout"
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+	)
+
+	log.Info("Attempting to logout")
+
+	v := jwt.Validation{Issuer: a.issuer, Leeway: a.leeway, Clock: a.clock}
+
+	claims, err := jwt.Parse(token, a.verificationKeys(ctx), v)
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			log.Info("token already expired")
+
+			return nil
+		}
+
+		log.Warn("invalid token", "error", err)
+
+		return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	if err := a.tokenRevoker.RevokeToken(ctx, claims.ID, claims.UID, claims.ExpiresAt); err != nil {
+		log.Error("failed to revoke token", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("token revoked", slog.Int64("user_id", claims.UID))
+
+	return nil
+}
+
+// ValidateToken checks token signature, expiry, issuer, that it hasn't been revoked
+// and that its user still exists.
+//
+// If expectedAppID is not 0, the token must have been issued for that app,
+// so a token of one app can't be used to access another.
+//
+// Returns ErrInvalidToken if token can't be used.
+func (a *Auth) ValidateToken(
+	ctx context.Context,
+	token string,
+	expectedAppID int,
+) (userID int64, appID int, expiresAt time.Time, err error) {
+	const op = "auth.ValidateToken"
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+	)
+
+	v := jwt.Validation{Issuer: a.issuer, Leeway: a.leeway, Clock: a.clock}
+	if expectedAppID != 0 {
+		v.Audience = jwt.Audience(expectedAppID)
+	}
+
+	claims, err := jwt.Parse(token, a.verificationKeys(ctx), v)
+	if err != nil {
+		log.Info("invalid token", "error", err)
+
+		return 0, 0, time.Time{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	revoked, err := a.tokenRevoker.IsRevoked(ctx, claims.ID)
+	if err != nil {
+		log.Error("failed to check token revocation", "error", err)
+
+		return 0, 0, time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if revoked {
+		log.Info("token revoked", slog.Int64("user_id", claims.UID))
+
+		return 0, 0, time.Time{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	// Tokens of deleted users stay signed until they expire.
+	user, err := a.usrProvider.UserByID(ctx, claims.UID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user of the token not found", slog.Int64("user_id", claims.UID))
+
+			return 0, 0, time.Time{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+
+		log.Error("failed to get user", "error", err)
+
+		return 0, 0, time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Tokens issued before the last LogoutAll of the user are revoked.
+	if claims.TokenVersion != user.TokenVersion {
+		log.Info("token version is stale",
+			slog.Int64("user_id", claims.UID),
+			slog.Int64("token_version", claims.TokenVersion),
+			slog.Int64("user_token_version", user.TokenVersion),
+		)
+
+		return 0, 0, time.Time{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	return claims.UID, claims.AppID, claims.ExpiresAt, nil
+}
+
+// newToken creates an access token for user signed with the configured algorithm
+// and returns it with its expiry. The token carries roles of the user in the app
+// and scopes, which must have been granted by grantScopes.
+func (a *Auth) newToken(ctx context.Context, user models.User, app models.App, scopes []string) (string, time.Time, error) {
+	roles, err := a.roleProvider.UserRoles(ctx, user.ID, app.ID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	// exp claim has second precision.
+	expiresAt := a.clock.Now().Add(a.tokenTTL(app)).Truncate(time.Second)
+
+	token, err := a.signToken(user, app, roles, scopes, 0, expiresAt)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiresAt, nil
+}
+
+// signToken signs an access token with the configured algorithm, see jwt.NewToken.
+func (a *Auth) signToken(
+	user models.User,
+	app models.App,
+	roles []string,
+	scopes []string,
+	actor int,
+	expiresAt time.Time,
+) (string, error) {
+	if a.keys != nil {
+		return jwt.NewTokenRSA(a.issuer, user, app, roles, scopes, actor, a.clock.Now(), expiresAt, a.keys.Current())
+	}
+
+	return jwt.NewToken(a.issuer, user, app, roles, scopes, actor, a.clock.Now(), expiresAt)
+}
+
+// issueTokens issues an access token of the user for app with granted scopes and starts a session,
+// the refresh token is empty if refresh tokens are disabled.
+func (a *Auth) issueTokens(
+	ctx context.Context,
+	user models.User,
+	app models.App,
+	scopes []string,
+) (token string, refreshToken string, expiresAt time.Time, err error) {
+	token, expiresAt, err = a.newToken(ctx, user, app, scopes)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	sessionID, err := a.startSession(ctx, user.ID, app.ID, expiresAt)
+	if err != nil {
+		return "", "", time.Time{}, fmt.Errorf("start session: %w", err)
+	}
+
+	if a.refreshTTL != 0 {
+		refreshToken, err = a.issueRefreshToken(ctx, user.ID, app.ID, sessionID, scopes)
+		if err != nil {
+			return "", "", time.Time{}, fmt.Errorf("issue refresh token: %w", err)
+		}
+	}
+
+	return token, refreshToken, expiresAt, nil
+}
+
+// tokenTTL returns the lifetime of access tokens issued for app:
+// its own TokenTTL if set, the service default otherwise.
+func (a *Auth) tokenTTL(app models.App) time.Duration {
+	if app.TokenTTL > 0 {
+		return app.TokenTTL
+	}
+
+	return a.tokenTTl
+}
+
+// verificationKeys returns keys to verify tokens issued by the service.
+//
+// With RS256 only RS256 tokens are accepted: apps know their secrets,
+// so an app could sign an HS256 token for any user, admins included.
+func (a *Auth) verificationKeys(ctx context.Context) jwt.Keys {
+	if a.keys != nil {
+		return jwt.Keys{PublicKey: a.keys.PublicKey}
+	}
+
+	return jwt.Keys{
+		Secret: func(appID int) (string, error) {
+			app, err := a.appProvider.App(ctx, appID)
+			if err != nil {
+				return "", err
+			}
+
+			return app.Secret, nil
+		},
+	}
+}
+
+// logger returns the logger of the service with the correlation ID of the request
+// carried by ctx, so all lines logged while handling a request can be found by it.
+func (a *Auth) logger(ctx context.Context) *slog.Logger {
+	if id := requestid.FromContext(ctx); id != "" {
+		return a.log.With(slog.String("request_id", id))
+	}
+
+	return a.log
+}
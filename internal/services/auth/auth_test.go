@@ -0,0 +1,211 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/services/auth"
+	"sso/internal/storage"
+	"sso/internal/storage/sqlite"
+	"testing"
+	"time"
+)
+
+func TestValidateTokenRejectsHS256WithRS256Keys(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	s := newSuite(t, auth.Config{Keys: jwt.NewKeySet(key, time.Hour)})
+	ctx := context.Background()
+
+	appID := s.createApp(t, "app")
+	userID := s.register(t, "user@example.com")
+
+	token, err := s.login(t, "user@example.com", testPassword, appID)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	if _, _, _, err := s.auth.ValidateToken(ctx, token, appID); err != nil {
+		t.Fatalf("RS256 token of login rejected: %v", err)
+	}
+
+	// The app knows its signing key, it must not be able to mint tokens.
+	app, err := s.storage.App(ctx, appID)
+	if err != nil {
+		t.Fatalf("get app: %v", err)
+	}
+	user, err := s.storage.UserByID(ctx, userID)
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+
+	now := s.clock.Now()
+	forged, err := jwt.NewToken("test", user, app, nil, nil, 0, now, now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("sign HS256 token: %v", err)
+	}
+
+	_, _, _, err = s.auth.ValidateToken(ctx, forged, appID)
+	if !errors.Is(err, auth.ErrInvalidToken) {
+		t.Fatalf("HS256 token with RS256 keys: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestValidateTokenAcceptsHS256WithoutRS256Keys(t *testing.T) {
+	s := newSuite(t, auth.Config{})
+
+	appID := s.createApp(t, "app")
+	s.register(t, "user@example.com")
+
+	token, err := s.login(t, "user@example.com", testPassword, appID)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	if _, _, _, err := s.auth.ValidateToken(context.Background(), token, appID); err != nil {
+		t.Fatalf("HS256 token rejected: %v", err)
+	}
+}
+
+func TestLoginUsesTokenTTLOfApp(t *testing.T) {
+	s := newSuite(t, auth.Config{TokenTTL: time.Hour})
+	ctx := context.Background()
+
+	defaultApp := s.createApp(t, "default")
+
+	secretHash, err := newTestHasher(t).Hash("secret")
+	if err != nil {
+		t.Fatalf("hash secret: %v", err)
+	}
+	shortApp, err := s.storage.SaveApp(ctx, models.App{
+		Name:       "short",
+		Secret:     "signing-key",
+		SecretHash: secretHash,
+		TokenTTL:   5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("save app: %v", err)
+	}
+
+	s.register(t, "user@example.com")
+	now := s.clock.Now()
+
+	tokens := make(map[int]string)
+	for appID, want := range map[int]time.Duration{defaultApp: time.Hour, shortApp: 5 * time.Minute} {
+		res, err := s.auth.Login(ctx, "user@example.com", testPassword, appID, "secret", nil)
+		if err != nil {
+			t.Fatalf("login to app %d: %v", appID, err)
+		}
+
+		if got := res.ExpiresAt.Sub(now); got != want {
+			t.Errorf("token of app %d expires in %s, want %s", appID, got, want)
+		}
+
+		tokens[appID] = res.Token
+	}
+
+	// Past the TTL of the app, but within the global one.
+	s.clock.Advance(10 * time.Minute)
+
+	if _, _, _, err := s.auth.ValidateToken(ctx, tokens[shortApp], shortApp); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("token of app with short TTL: got %v, want ErrInvalidToken", err)
+	}
+	if _, _, _, err := s.auth.ValidateToken(ctx, tokens[defaultApp], defaultApp); err != nil {
+		t.Errorf("token of app with default TTL: %v", err)
+	}
+}
+
+func TestIsAdminUserNotFound(t *testing.T) {
+	s := newSuite(t, auth.Config{})
+
+	if _, err := s.auth.IsAdmin(context.Background(), 42); !errors.Is(err, auth.ErrUserNotFound) {
+		t.Fatalf("got %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestValidateTokenRejectsTokenOfOtherApp(t *testing.T) {
+	s := newSuite(t, auth.Config{})
+
+	appA := s.createApp(t, "a")
+	appB := s.createApp(t, "b")
+	s.register(t, "user@example.com")
+
+	token, err := s.login(t, "user@example.com", testPassword, appA)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	if _, _, _, err := s.auth.ValidateToken(context.Background(), token, appB); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Fatalf("token of app A presented to app B: got %v, want ErrInvalidToken", err)
+	}
+}
+
+// failingRoles fails to assign roles after the user has been saved in the transaction.
+type failingRoles struct {
+	*sqlite.Storage
+}
+
+func (failingRoles) AssignRole(context.Context, int64, int, string) error {
+	return errors.New("connection reset")
+}
+
+func TestRegisterNewUserRollsBackOnFailedDefaultRole(t *testing.T) {
+	s := newSuite(t, auth.Config{})
+	ctx := context.Background()
+
+	appID := s.createApp(t, "app")
+
+	cfg := auth.Config{
+		Clock:       s.clock,
+		TokenTTL:    time.Hour,
+		Issuer:      "test",
+		Hasher:      newTestHasher(t),
+		DefaultRole: auth.DefaultRole{AppID: appID, Role: "member"},
+	}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	st := s.storage
+	stores := storesOf(st)
+	stores.Roles = failingRoles{st}
+	a := auth.New(log, stores, s.sender, cfg)
+
+	if _, _, err := a.RegisterNewUser(ctx, "user@example.com", testPassword, false); err == nil {
+		t.Fatal("registration succeeded without the default role")
+	}
+
+	if _, err := st.User(ctx, "user@example.com"); !errors.Is(err, storage.ErrUserNotFound) {
+		t.Fatalf("user after failed registration: got %v, want ErrUserNotFound", err)
+	}
+
+	// Nothing is left behind to block registering again.
+	s.register(t, "user@example.com")
+}
+
+func TestRefreshTokenUnknownApp(t *testing.T) {
+	s := newSuite(t, auth.Config{RefreshTTL: time.Hour})
+	ctx := context.Background()
+
+	appID := s.createApp(t, "app")
+	s.register(t, "user@example.com")
+
+	res, err := s.auth.Login(ctx, "user@example.com", testPassword, appID, "secret", nil)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	if _, _, err := s.auth.RefreshToken(ctx, res.RefreshToken, appID+1); !errors.Is(err, auth.ErrInvalidAppID) {
+		t.Fatalf("refresh with unknown app: got %v, want ErrInvalidAppID", err)
+	}
+
+	// The wrong app id didn't burn the token.
+	if _, _, err := s.auth.RefreshToken(ctx, res.RefreshToken, appID); err != nil {
+		t.Fatalf("refresh after unknown app: %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/storage"
+)
+
+// DeleteUser deletes the user with their roles, refresh tokens and revocation entries.
+//
+// In soft delete mode the user is only marked as deleted and their sessions are ended,
+// the account can't be used anymore but its data is kept.
+// Access tokens already issued to the user stop passing ValidateToken either way.
+//
+// Returns ErrUserNotFound if there is no such user.
+func (a *Auth) DeleteUser(ctx context.Context, userID int64) error {
+	const op = "auth.DeleteUser"
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+		slog.Bool("soft", a.softDelete),
+	)
+
+	log.Info("deleting user")
+
+	var err error
+	if a.softDelete {
+		err = a.usrSave.SoftDeleteUser(ctx, userID, a.clock.Now())
+	} else {
+		err = a.usrSave.DeleteUser(ctx, userID)
+	}
+
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", "error", err)
+
+			return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+
+		log.Error("failed to delete user", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user deleted")
+
+	a.publish(ctx, log, models.EventUserDeleted, userID, 0)
+
+	return nil
+}
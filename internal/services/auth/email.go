@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/storage"
+	"strings"
+)
+
+// NormalizeEmail trims whitespace around email and lowercases its domain,
+// which is case-insensitive.
+//
+// The local part is lowercased too unless caseSensitiveLocal is set:
+// RFC 5321 allows mailboxes that differ only by case, but almost no
+// mail server treats them differently, and users don't expect it.
+func NormalizeEmail(email string, caseSensitiveLocal bool) string {
+	email = strings.TrimSpace(email)
+
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		if caseSensitiveLocal {
+			return email
+		}
+
+		return strings.ToLower(email)
+	}
+
+	local, domain := email[:at], email[at+1:]
+	if !caseSensitiveLocal {
+		local = strings.ToLower(local)
+	}
+
+	return local + "@" + strings.ToLower(domain)
+}
+
+func (a *Auth) normalizeEmail(email string) string {
+	return NormalizeEmail(email, a.caseSensitiveLocalPart)
+}
+
+// ChangeEmail replaces email of the user with newEmail.
+//
+// If email verification is required, the account keeps the old email and
+// a verification token is sent to the new one, the email is changed once
+// the token is passed to VerifyEmail. pending reports whether that is the case.
+//
+// Returns ErrUserExists if newEmail is taken by another user
+// and ErrUserNotFound if there is no such user.
+func (a *Auth) ChangeEmail(ctx context.Context, userID int64, newEmail string) (pending bool, err error) {
+	const op = "auth.ChangeEmail"
+
+	newEmail = a.normalizeEmail(newEmail)
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+	)
+
+	log.Info("changing email")
+
+	user, err := a.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", "error", err)
+
+			return false, fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+
+		log.Error("failed to get user", "error", err)
+
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if user.Email == newEmail {
+		return false, nil
+	}
+
+	// Checked up front, so no token is sent for an email that can't be used.
+	// A user registering with it in the meantime is caught by UpdateEmail.
+	if _, err := a.usrProvider.User(ctx, newEmail); err == nil {
+		log.Warn("email is taken")
+
+		return false, fmt.Errorf("%s: %w", op, ErrUserExists)
+	} else if !errors.Is(err, storage.ErrUserNotFound) {
+		log.Error("failed to check email", "error", err)
+
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if a.requireVerification {
+		if err := a.sendEmailChange(ctx, userID, newEmail); err != nil {
+			log.Error("failed to send email change verification", "error", err)
+
+			return false, fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Info("email change is pending verification")
+
+		return true, nil
+	}
+
+	if err := a.updateEmail(ctx, userID, newEmail); err != nil {
+		log.Error("failed to update email", "error", err)
+
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("email changed")
+
+	return false, nil
+}
+
+// sendEmailChange issues a token confirming newEmail and sends it there.
+func (a *Auth) sendEmailChange(ctx context.Context, userID int64, newEmail string) error {
+	token, tokenHash, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	err = a.oneTimeTokens.SaveOneTimeToken(ctx, models.OneTimeToken{
+		TokenHash: tokenHash,
+		UserID:    userID,
+		Purpose:   models.PurposeEmailChange,
+		Email:     newEmail,
+		ExpiresAt: a.clock.Now().Add(a.verificationTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.sender.SendVerification(ctx, newEmail, token)
+}
+
+func (a *Auth) updateEmail(ctx context.Context, userID int64, email string) error {
+	if err := a.usrSave.UpdateEmail(ctx, userID, email); err != nil {
+		if errors.Is(err, storage.ErrUserExists) {
+			return ErrUserExists
+		}
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return ErrUserNotFound
+		}
+
+		return err
+	}
+
+	return nil
+}
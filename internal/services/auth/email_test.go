@@ -0,0 +1,80 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"sso/internal/services/auth"
+	"testing"
+	"time"
+)
+
+func TestChangeEmailTaken(t *testing.T) {
+	s := newSuite(t, auth.Config{})
+
+	userID := s.register(t, "user@example.com")
+	s.register(t, "other@example.com")
+
+	_, err := s.auth.ChangeEmail(context.Background(), userID, "Other@example.com")
+	if !errors.Is(err, auth.ErrUserExists) {
+		t.Fatalf("got %v, want ErrUserExists", err)
+	}
+}
+
+func TestChangeEmailPendingVerification(t *testing.T) {
+	s := newSuite(t, auth.Config{RequireVerification: true, VerificationTTL: time.Hour})
+	ctx := context.Background()
+
+	userID := s.register(t, "user@example.com")
+
+	pending, err := s.auth.ChangeEmail(ctx, userID, "new@example.com")
+	if err != nil {
+		t.Fatalf("change email: %v", err)
+	}
+	if !pending {
+		t.Fatal("change isn't pending verification")
+	}
+
+	// The account keeps the old email until the new one is verified.
+	user, err := s.storage.UserByID(ctx, userID)
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+	if user.Email != "user@example.com" {
+		t.Fatalf("email changed to %s before verification", user.Email)
+	}
+
+	if err := s.auth.VerifyEmail(ctx, s.sender.verification); err != nil {
+		t.Fatalf("verify email: %v", err)
+	}
+
+	user, err = s.storage.UserByID(ctx, userID)
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+	if user.Email != "new@example.com" {
+		t.Fatalf("email is %s after verification, want new@example.com", user.Email)
+	}
+}
+
+func TestChangeEmailWithoutVerification(t *testing.T) {
+	s := newSuite(t, auth.Config{})
+	ctx := context.Background()
+
+	userID := s.register(t, "user@example.com")
+
+	pending, err := s.auth.ChangeEmail(ctx, userID, "new@example.com")
+	if err != nil {
+		t.Fatalf("change email: %v", err)
+	}
+	if pending {
+		t.Fatal("change is pending without verification required")
+	}
+
+	user, err := s.storage.UserByID(ctx, userID)
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+	if user.Email != "new@example.com" {
+		t.Fatalf("email is %s, want new@example.com", user.Email)
+	}
+}
@@ -0,0 +1,111 @@
+package auth
+
+import "errors"
+
+// Kind classifies errors of the service, so callers can decide how to react,
+// e.g. which gRPC code to return, without knowing every error.
+type Kind int
+
+const (
+	// KindInternal is the kind of errors not caused by the caller,
+	// e.g. storage failures. It is the kind of all errors that aren't *Error.
+	KindInternal Kind = iota
+	KindInvalidArgument
+	KindNotFound
+	KindAlreadyExists
+	KindUnauthenticated
+	KindPermissionDenied
+	KindFailedPrecondition
+)
+
+var kindNames = map[Kind]string{
+	KindInternal:           "internal",
+	KindInvalidArgument:    "invalid argument",
+	KindNotFound:           "not found",
+	KindAlreadyExists:      "already exists",
+	KindUnauthenticated:    "unauthenticated",
+	KindPermissionDenied:   "permission denied",
+	KindFailedPrecondition: "failed precondition",
+}
+
+func (k Kind) String() string {
+	if name, ok := kindNames[k]; ok {
+		return name
+	}
+
+	return "unknown"
+}
+
+// Error is an error the service returns on purpose, every one is a sentinel
+// below, so it can be checked with errors.Is as well as by its kind.
+//
+// Errors are still wrapped with the operation, e.g. "auth.Login: invalid credentials",
+// which is what gets logged.
+type Error struct {
+	kind Kind
+	msg  string
+}
+
+func newError(kind Kind, msg string) *Error {
+	return &Error{kind: kind, msg: msg}
+}
+
+func (e *Error) Error() string {
+	return e.msg
+}
+
+// Kind returns the kind of the error.
+func (e *Error) Kind() Kind {
+	return e.kind
+}
+
+// KindOf returns the kind of the first *Error in the chain of err,
+// or KindInternal if there is none.
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.kind
+	}
+
+	return KindInternal
+}
+
+var (
+	ErrInvalidCredentials  = newError(KindUnauthenticated, "invalid credentials")
+	ErrInvalidAppID        = newError(KindInvalidArgument, "invalid app id")
+	ErrInvalidAppSecret    = newError(KindUnauthenticated, "invalid app secret")
+	ErrUserExists          = newError(KindAlreadyExists, "user already exists")
+	ErrAppExists           = newError(KindAlreadyExists, "app already exists")
+	ErrUserNotFound        = newError(KindNotFound, "user not found")
+	ErrAppNotFound         = newError(KindNotFound, "app not found")
+	ErrInvalidToken        = newError(KindUnauthenticated, "invalid token")
+	ErrInvalidRefresh      = newError(KindUnauthenticated, "invalid refresh token")
+	ErrAccountLocked       = newError(KindPermissionDenied, "account is locked")
+	ErrWeakPassword        = newError(KindInvalidArgument, "weak password")
+	ErrPasswordTooLong     = newError(KindInvalidArgument, "password is too long")
+	ErrSecretTooLong       = newError(KindInvalidArgument, "secret is too long")
+	ErrPasswordReused      = newError(KindInvalidArgument, "password was used recently")
+	ErrPasswordExpired     = newError(KindFailedPrecondition, "password is expired")
+	ErrEmailNotVerified    = newError(KindFailedPrecondition, "email is not verified")
+	ErrInvalidOneTimeToken = newError(KindInvalidArgument, "invalid or expired token")
+	ErrSessionNotFound     = newError(KindNotFound, "session not found")
+
+	ErrUnknownProvider          = newError(KindInvalidArgument, "unknown identity provider")
+	ErrInvalidOAuthState        = newError(KindInvalidArgument, "invalid or expired state")
+	ErrInvalidOAuthCode         = newError(KindUnauthenticated, "identity provider rejected the sign in")
+	ErrInvalidRedirectURI       = newError(KindInvalidArgument, "redirect uri is not allowed")
+	ErrIdentityEmailNotVerified = newError(KindFailedPrecondition, "email of the account is not verified by the identity provider")
+	ErrAccountLinkRequired      = newError(KindFailedPrecondition, "a user with the email exists, sign in with the password and link the account")
+	ErrIdentityLinked           = newError(KindAlreadyExists, "account is linked to another user")
+
+	ErrPasskeysDisabled        = newError(KindFailedPrecondition, "passkeys are not enabled")
+	ErrInvalidPasskeyChallenge = newError(KindInvalidArgument, "invalid or expired passkey challenge")
+	ErrInvalidPasskey          = newError(KindUnauthenticated, "passkey verification failed")
+	ErrPasskeyExists           = newError(KindAlreadyExists, "passkey is already registered")
+	ErrPasskeyCloned           = newError(KindPermissionDenied, "passkey may have been cloned")
+
+	ErrInvalidAudience    = newError(KindInvalidArgument, "invalid audience")
+	ErrExchangeNotAllowed = newError(KindPermissionDenied, "token exchange is not allowed")
+
+	ErrScopeNotAllowed = newError(KindPermissionDenied, "scope is not allowed")
+)
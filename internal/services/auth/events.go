@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"log/slog"
+	"sso/internal/domain/models"
+)
+
+// EventPublisher delivers events to other services, see models.Event.
+//
+// Publish must not block on delivery: the event is queued and retried
+// out of band, an error means it couldn't even be queued.
+type EventPublisher interface {
+	Publish(ctx context.Context, event models.Event) error
+}
+
+// nopPublisher drops events, it is used when Config.Events is nil.
+type nopPublisher struct{}
+
+func (nopPublisher) Publish(context.Context, models.Event) error {
+	return nil
+}
+
+// publish publishes an event of the operation that has just succeeded.
+// Failures are only logged, the operation succeeds either way.
+func (a *Auth) publish(ctx context.Context, log *slog.Logger, eventType string, userID int64, appID int) {
+	event := models.Event{
+		ID:     uuid.NewString(),
+		Type:   eventType,
+		UserID: userID,
+		AppID:  appID,
+		Time:   a.clock.Now().UTC(),
+	}
+
+	if err := a.events.Publish(ctx, event); err != nil {
+		log.Error("failed to publish event",
+			slog.String("event_id", event.ID),
+			slog.String("event_type", eventType),
+			slog.String("error", err.Error()),
+		)
+	}
+}
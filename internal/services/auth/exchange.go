@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sso/internal/lib/jwt"
+	"sso/internal/storage"
+	"strconv"
+	"time"
+)
+
+// ExchangeToken exchanges a token of the user for a token of the same user issued
+// for the app audience is the aud claim of, see jwt.Audience, following RFC 8693.
+// It lets a service call another one on behalf of the user with a token
+// the other service accepts, instead of forwarding the token of the user.
+//
+// The caller authenticates as the app with clientID by its secret, like at Login,
+// so holding a token of the user is not enough to exchange it.
+// The subject token is validated like by ValidateToken. Only the app it was issued for
+// can exchange it, and only for audiences listed for the app in Config.TokenExchange,
+// otherwise ErrExchangeNotAllowed is returned.
+// The new token carries roles of the user in the target app, so it grants nothing
+// the user doesn't have there, and the app that exchanged it in the "act" claim.
+// It expires with the subject token at the latest.
+//
+// Returns ErrInvalidAppID or ErrInvalidAppSecret if the client can't be authenticated,
+// ErrInvalidToken if the subject token can't be used
+// and ErrInvalidAudience if audience is not the id of an app.
+func (a *Auth) ExchangeToken(
+	ctx context.Context,
+	clientID int,
+	clientSecret string,
+	subjectToken string,
+	audience string,
+) (string, error) {
+	const op = "auth.ExchangeToken"
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.Int("client_app_id", clientID),
+		slog.String("audience", audience),
+	)
+
+	client, err := a.authenticateApp(ctx, clientID, clientSecret)
+	if err != nil {
+		log.Warn("app authentication failed", "error", err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	allowed, ok := a.tokenExchange[client.ID]
+	if !ok {
+		log.Warn("app is not allowed to exchange tokens")
+
+		return "", fmt.Errorf("%s: %w", op, ErrExchangeNotAllowed)
+	}
+
+	userID, subjectAppID, subjectExpiresAt, err := a.ValidateToken(ctx, subjectToken, 0)
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			log.Warn("invalid subject token")
+
+			return "", fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+
+		log.Error("failed to validate subject token", "error", err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", userID))
+
+	if subjectAppID != client.ID {
+		log.Warn("subject token was issued for another app", slog.Int("subject_app_id", subjectAppID))
+
+		return "", fmt.Errorf("%s: %w", op, ErrExchangeNotAllowed)
+	}
+
+	// Only the canonical form is accepted, as it is what resource servers compare aud with.
+	appID, err := strconv.Atoi(audience)
+	if err != nil || appID <= 0 || jwt.Audience(appID) != audience {
+		log.Warn("audience is not an app id")
+
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidAudience)
+	}
+
+	if !slices.Contains(allowed, appID) {
+		log.Warn("audience is not allowed for the app")
+
+		return "", fmt.Errorf("%s: %w", op, ErrExchangeNotAllowed)
+	}
+
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app of the audience not found")
+
+			return "", fmt.Errorf("%s: %w", op, ErrInvalidAudience)
+		}
+
+		log.Error("failed to get app", "error", err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user of the token not found")
+
+			return "", fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+
+		log.Error("failed to get user", "error", err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	roles, err := a.roleProvider.UserRoles(ctx, user.ID, app.ID)
+	if err != nil {
+		log.Error("failed to get roles", "error", err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Exchanging mustn't extend the access the subject token gives.
+	expiresAt := a.clock.Now().Add(a.tokenTTL(app)).Truncate(time.Second)
+	if subjectExpiresAt.Before(expiresAt) {
+		expiresAt = subjectExpiresAt
+	}
+
+	token, err := a.signToken(user, app, roles, nil, client.ID, expiresAt)
+	if err != nil {
+		log.Error("failed to generate token", "error", err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("token exchanged")
+
+	return token, nil
+}
@@ -0,0 +1,107 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"sso/internal/lib/jwt"
+	"sso/internal/services/auth"
+	"testing"
+	"time"
+)
+
+func TestExchangeToken(t *testing.T) {
+	s := newSuite(t, auth.Config{})
+	ctx := context.Background()
+
+	client := s.createApp(t, "client")
+	target := s.createApp(t, "target")
+	userID := s.register(t, "user@example.com")
+
+	// Tokens of the client can be exchanged for the target only.
+	s.configure(t, auth.Config{TokenTTL: 2 * time.Hour, TokenExchange: map[int][]int{client: {target}}})
+
+	subject, err := s.login(t, "user@example.com", testPassword, client)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	_, _, subjectExpiresAt, err := s.auth.ValidateToken(ctx, subject, client)
+	if err != nil {
+		t.Fatalf("validate subject token: %v", err)
+	}
+
+	s.clock.Advance(time.Hour)
+
+	token, err := s.auth.ExchangeToken(ctx, client, "secret", subject, jwt.Audience(target))
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+
+	gotUser, gotApp, expiresAt, err := s.auth.ValidateToken(ctx, token, target)
+	if err != nil {
+		t.Fatalf("exchanged token rejected by the target: %v", err)
+	}
+	if gotUser != userID || gotApp != target {
+		t.Fatalf("exchanged token of user %d and app %d, want %d and %d", gotUser, gotApp, userID, target)
+	}
+	// The target TTL would outlive the subject token, exchanging must not extend access.
+	if expiresAt.After(subjectExpiresAt) {
+		t.Fatalf("exchanged token expires at %s, after the subject token at %s", expiresAt, subjectExpiresAt)
+	}
+
+	if _, _, _, err := s.auth.ValidateToken(ctx, token, client); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Fatalf("exchanged token accepted by the client: %v", err)
+	}
+}
+
+func TestExchangeTokenDenied(t *testing.T) {
+	s := newSuite(t, auth.Config{})
+	ctx := context.Background()
+
+	client := s.createApp(t, "client")
+	target := s.createApp(t, "target")
+	other := s.createApp(t, "other")
+	s.register(t, "user@example.com")
+
+	// Tokens of other can be exchanged too, but only tokens issued for other.
+	s.configure(t, auth.Config{TokenExchange: map[int][]int{client: {target}, other: {target}}})
+
+	tokens := make(map[int]string)
+	for _, appID := range []int{client, target} {
+		token, err := s.login(t, "user@example.com", testPassword, appID)
+		if err != nil {
+			t.Fatalf("login: %v", err)
+		}
+		tokens[appID] = token
+	}
+
+	tests := []struct {
+		name     string
+		clientID int
+		secret   string
+		token    string
+		audience string
+		want     error
+	}{
+		{"caller holding only the subject token", client, "", tokens[client], jwt.Audience(target), auth.ErrInvalidAppSecret},
+		{"wrong client secret", client, "guess", tokens[client], jwt.Audience(target), auth.ErrInvalidAppSecret},
+		{"unknown client", other + 1, "secret", tokens[client], jwt.Audience(target), auth.ErrInvalidAppID},
+		{"token of another allowed app", other, "secret", tokens[client], jwt.Audience(target), auth.ErrExchangeNotAllowed},
+		{"audience not listed for the client", client, "secret", tokens[client], jwt.Audience(other), auth.ErrExchangeNotAllowed},
+		{"app not allowed to exchange", target, "secret", tokens[target], jwt.Audience(client), auth.ErrExchangeNotAllowed},
+		{"audience not an app id", client, "secret", tokens[client], "orders", auth.ErrInvalidAudience},
+		{"audience not canonical", client, "secret", tokens[client], "0" + jwt.Audience(target), auth.ErrInvalidAudience},
+		{"invalid subject token", client, "secret", "not a token", jwt.Audience(target), auth.ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := s.auth.ExchangeToken(ctx, tt.clientID, tt.secret, tt.token, tt.audience)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if token != "" {
+				t.Fatal("got a token with the error")
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/storage"
+	"time"
+)
+
+// Lockout locks an account for Duration after Attempts consecutive failed logins.
+// Lockout is disabled when Attempts is 0.
+type Lockout struct {
+	Attempts int
+	Duration time.Duration
+}
+
+// LoginBackoff delays logins per email after consecutive failures, see backoff.Backoff.
+// Unlike Lockout it doesn't refuse logins, it only makes guessing slow.
+type LoginBackoff interface {
+	// Wait delays the login, it returns an error if ctx is done first.
+	Wait(ctx context.Context, email string) error
+	Fail(email string)
+	Reset(email string)
+}
+
+// nopBackoff doesn't delay logins, it is used when backoff is disabled.
+type nopBackoff struct{}
+
+func (nopBackoff) Wait(context.Context, string) error { return nil }
+func (nopBackoff) Fail(string)                        {}
+func (nopBackoff) Reset(string)                       {}
+
+// checkLockout returns ErrAccountLocked if user is locked out.
+//
+// If the lock has already expired, failed logins of the user are cleared,
+// so the user gets a fresh set of attempts.
+func (a *Auth) checkLockout(ctx context.Context, user *models.User) error {
+	if a.lockout.Attempts == 0 || user.LockedUntil.IsZero() {
+		return nil
+	}
+
+	if a.clock.Now().Before(user.LockedUntil) {
+		return ErrAccountLocked
+	}
+
+	if err := a.usrProvider.ResetFailedLogin(ctx, user.ID); err != nil {
+		return err
+	}
+
+	user.FailedLogins = 0
+	user.LockedUntil = time.Time{}
+
+	return nil
+}
+
+// clearExpiredLock zeroes LockedUntil of the user if the lock has expired,
+// so callers can tell whether the account is locked without a clock of their own.
+func (a *Auth) clearExpiredLock(user *models.User) {
+	if !a.clock.Now().Before(user.LockedUntil) {
+		user.LockedUntil = time.Time{}
+	}
+}
+
+// registerFailedLogin counts a failed login of user and locks the account
+// once the configured number of attempts is reached.
+func (a *Auth) registerFailedLogin(ctx context.Context, user models.User) error {
+	if a.lockout.Attempts == 0 {
+		return nil
+	}
+
+	return a.usrProvider.IncrementFailedLogin(
+		ctx,
+		user.ID,
+		a.lockout.Attempts,
+		a.clock.Now().Add(a.lockout.Duration),
+	)
+}
+
+// resetFailedLogins clears failed logins of user after a successful login.
+func (a *Auth) resetFailedLogins(ctx context.Context, user models.User) error {
+	if a.lockout.Attempts == 0 || user.FailedLogins == 0 {
+		return nil
+	}
+
+	return a.usrProvider.ResetFailedLogin(ctx, user.ID)
+}
+
+// UnlockUser clears failed logins of the user and lifts the lock, if any.
+// Logins of the user are no longer delayed either, see Config.LoginBackoff.
+//
+// Returns ErrUserNotFound if the user doesn't exist.
+func (a *Auth) UnlockUser(ctx context.Context, userID int64) error {
+	const op = "auth.UnlockUser"
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+	)
+
+	user, err := a.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found")
+
+			return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+
+		log.Error("failed to get user", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.usrProvider.ResetFailedLogin(ctx, userID); err != nil {
+		log.Error("failed to unlock user", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.loginBackoff.Reset(user.Email)
+
+	log.Info("user unlocked")
+
+	return nil
+}
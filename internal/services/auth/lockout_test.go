@@ -0,0 +1,110 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"sso/internal/services/auth"
+	"testing"
+	"time"
+)
+
+func TestLockoutLocksAfterFailedLogins(t *testing.T) {
+	s := newSuite(t, auth.Config{Lockout: auth.Lockout{Attempts: 3, Duration: time.Hour}})
+
+	appID := s.createApp(t, "app")
+	s.register(t, "user@example.com")
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.login(t, "user@example.com", "Wr0ng!pass", appID); !errors.Is(err, auth.ErrInvalidCredentials) {
+			t.Fatalf("failed login %d: got %v, want ErrInvalidCredentials", i+1, err)
+		}
+	}
+
+	// The right password doesn't help while the account is locked.
+	if _, err := s.login(t, "user@example.com", testPassword, appID); !errors.Is(err, auth.ErrAccountLocked) {
+		t.Fatalf("login of locked account: got %v, want ErrAccountLocked", err)
+	}
+
+	s.clock.Advance(time.Hour)
+
+	if _, err := s.login(t, "user@example.com", testPassword, appID); err != nil {
+		t.Fatalf("login after the lock expired: %v", err)
+	}
+}
+
+func TestUnlockUser(t *testing.T) {
+	s := newSuite(t, auth.Config{Lockout: auth.Lockout{Attempts: 2, Duration: time.Hour}})
+	ctx := context.Background()
+
+	appID := s.createApp(t, "app")
+	userID := s.register(t, "user@example.com")
+
+	for i := 0; i < 2; i++ {
+		_, _ = s.login(t, "user@example.com", "Wr0ng!pass", appID)
+	}
+
+	if _, err := s.login(t, "user@example.com", testPassword, appID); !errors.Is(err, auth.ErrAccountLocked) {
+		t.Fatalf("login of locked account: got %v, want ErrAccountLocked", err)
+	}
+
+	if err := s.auth.UnlockUser(ctx, userID); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+
+	if _, err := s.login(t, "user@example.com", testPassword, appID); err != nil {
+		t.Fatalf("login after unlock: %v", err)
+	}
+
+	// The unlock cleared the failed logins, so one more failure doesn't lock the account again.
+	_, _ = s.login(t, "user@example.com", "Wr0ng!pass", appID)
+
+	if _, err := s.login(t, "user@example.com", testPassword, appID); err != nil {
+		t.Fatalf("login after a single failure: %v", err)
+	}
+}
+
+func TestUnlockUserNotFound(t *testing.T) {
+	s := newSuite(t, auth.Config{Lockout: auth.Lockout{Attempts: 2, Duration: time.Hour}})
+
+	if err := s.auth.UnlockUser(context.Background(), 42); !errors.Is(err, auth.ErrUserNotFound) {
+		t.Fatalf("got %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestGetUserReportsLockByClock(t *testing.T) {
+	s := newSuite(t, auth.Config{Lockout: auth.Lockout{Attempts: 2, Duration: time.Hour}})
+	ctx := context.Background()
+
+	appID := s.createApp(t, "app")
+	userID := s.register(t, "user@example.com")
+
+	for i := 0; i < 2; i++ {
+		_, _ = s.login(t, "user@example.com", "Wr0ng!pass", appID)
+	}
+
+	user, err := s.auth.GetUser(ctx, userID)
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+	if want := s.clock.Now().Add(time.Hour); !user.LockedUntil.Equal(want) {
+		t.Fatalf("locked until %v, want %v", user.LockedUntil, want)
+	}
+
+	s.clock.Advance(time.Hour)
+
+	user, err = s.auth.GetUser(ctx, userID)
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+	if !user.LockedUntil.IsZero() {
+		t.Fatalf("expired lock reported as locked until %v", user.LockedUntil)
+	}
+
+	users, err := s.auth.ListUsers(ctx, 10, 0)
+	if err != nil {
+		t.Fatalf("list users: %v", err)
+	}
+	if len(users) != 1 || !users[0].LockedUntil.IsZero() {
+		t.Fatalf("listed users %+v, want one without a lock", users)
+	}
+}
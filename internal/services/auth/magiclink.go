@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/storage"
+)
+
+// SendMagicLink sends a link to log in without a password to the user with given email,
+// see LoginWithMagicLink.
+//
+// If there is no such user, nothing is sent but no error is returned either,
+// so callers can't find out which emails are registered.
+func (a *Auth) SendMagicLink(ctx context.Context, email string) error {
+	const op = "auth.SendMagicLink"
+
+	email = a.normalizeEmail(email)
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.String("email", email),
+	)
+
+	log.Info("sending magic link")
+
+	user, err := a.usrProvider.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found, magic link is not sent")
+
+			return nil
+		}
+
+		log.Error("failed to get user", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	token, tokenHash, err := newOpaqueToken()
+	if err != nil {
+		log.Error("failed to generate magic link token", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = a.oneTimeTokens.SaveOneTimeToken(ctx, models.OneTimeToken{
+		TokenHash: tokenHash,
+		UserID:    user.ID,
+		Purpose:   models.PurposeMagicLink,
+		Email:     user.Email,
+		ExpiresAt: a.clock.Now().Add(a.magicLinkTTL),
+	})
+	if err != nil {
+		log.Error("failed to save magic link token", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.sender.SendMagicLink(ctx, user.Email, token); err != nil {
+		log.Error("failed to send magic link", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("magic link sent")
+
+	return nil
+}
+
+// LoginWithMagicLink logs the user the magic link token was sent to in to the app
+// and returns an access token, there is no refresh token: the session ends with it.
+//
+// Returns ErrInvalidOneTimeToken if the token doesn't exist, has expired or has already been used,
+// or if the email of the user has changed since it was sent.
+// Following the link proves the user controls the email, so it is marked verified.
+func (a *Auth) LoginWithMagicLink(ctx context.Context, token string, appID int) (string, error) {
+	const op = "auth.LoginWithMagicLink"
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+	)
+
+	log.Info("logging in with magic link")
+
+	// The app is checked first, so a wrong app id doesn't burn the token.
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found")
+
+			return "", fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+		}
+
+		log.Error("failed to get app", "error", err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	stored, err := a.consumeOneTimeToken(ctx, token, models.PurposeMagicLink)
+	if err != nil {
+		if errors.Is(err, ErrInvalidOneTimeToken) {
+			log.Warn("invalid magic link token")
+
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Error("failed to consume magic link token", "error", err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", stored.UserID))
+
+	user, err := a.usrProvider.UserByID(ctx, stored.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", "error", err)
+
+			return "", fmt.Errorf("%s: %w", op, ErrInvalidOneTimeToken)
+		}
+
+		log.Error("failed to get user", "error", err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	// The link was sent to the old email, whoever controls it mustn't log in anymore.
+	if user.Email != stored.Email {
+		log.Warn("email has changed since the magic link was sent")
+
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidOneTimeToken)
+	}
+
+	if err := a.checkLockout(ctx, &user); err != nil {
+		if errors.Is(err, ErrAccountLocked) {
+			log.Warn("account is locked", slog.Time("locked_until", user.LockedUntil))
+
+			return "", fmt.Errorf("%s: %w", op, ErrAccountLocked)
+		}
+
+		log.Error("failed to check lockout", "error", err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !user.Verified {
+		if err := a.usrSave.MarkEmailVerified(ctx, user.ID); err != nil {
+			log.Error("failed to mark email verified", "error", err)
+
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Info("email verified")
+	}
+
+	accessToken, expiresAt, err := a.newToken(ctx, user, app, nil)
+	if err != nil {
+		log.Error("failed to generate token", "error", err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := a.startSession(ctx, user.ID, app.ID, expiresAt); err != nil {
+		log.Error("failed to start session", "error", err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("logged in with magic link")
+
+	a.publish(ctx, log, models.EventUserLoggedIn, user.ID, app.ID)
+
+	return accessToken, nil
+}
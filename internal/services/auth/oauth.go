@@ -0,0 +1,426 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/storage"
+	"time"
+)
+
+// IdentityProvider signs users in with their account at an external provider, e.g. Google,
+// using the OAuth2 authorization code flow with PKCE.
+type IdentityProvider interface {
+	// AuthCodeURL returns the page of the provider the user is sent to sign in,
+	// it redirects back with a code and the state.
+	AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error)
+	// Identity exchanges the code for the account of the user. It fails if the code
+	// wasn't issued for the nonce. Provider of the returned identity is not set.
+	//
+	// redirectURI is the one the code was issued for, empty for the one of the provider.
+	// verifier and nonce are empty for codes of sign ins not started by StartOAuth.
+	Identity(ctx context.Context, code string, redirectURI string, verifier string, nonce string) (models.Identity, error)
+}
+
+// OIDCLogin lets clients that sign users in with a provider themselves, e.g. mobile apps,
+// log users in with LoginWithOIDC.
+type OIDCLogin struct {
+	// AppID is the app tokens are issued for.
+	AppID int
+	// RedirectURIs are the redirect URIs of the clients, codes for others are rejected.
+	RedirectURIs []string
+}
+
+// IdentityStore keeps which accounts at identity providers belong to which users.
+type IdentityStore interface {
+	// UserByIdentity returns storage.ErrUserNotFound if the identity isn't linked to a user.
+	UserByIdentity(ctx context.Context, provider string, subject string) (models.User, error)
+	// LinkIdentity returns storage.ErrIdentityExists if the identity is linked already.
+	LinkIdentity(ctx context.Context, userID int64, identity models.Identity, linkedAt time.Time) error
+}
+
+// OAuthStateStore keeps sign ins with identity providers that have been started.
+type OAuthStateStore interface {
+	// SaveOAuthState also deletes states expired before now.
+	SaveOAuthState(ctx context.Context, state models.OAuthState, now time.Time) error
+	// ConsumeOAuthState deletes the state and returns it,
+	// storage.ErrOAuthStateNotFound if there is no such state.
+	ConsumeOAuthState(ctx context.Context, stateHash string) (models.OAuthState, error)
+}
+
+// StartOAuth starts a sign in with the identity provider for the app and returns
+// the page of the provider to send the user to, and the state it redirects back with.
+//
+// If linkUserID is set, the account at the provider is linked to that user
+// instead, see FinishOAuth. The caller must have authenticated the user.
+func (a *Auth) StartOAuth(ctx context.Context, provider string, appID int, linkUserID int64) (authURL string, state string, err error) {
+	const op = "auth.StartOAuth"
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.String("provider", provider),
+		slog.Int("app_id", appID),
+	)
+
+	p, ok := a.identityProviders[provider]
+	if !ok {
+		log.Warn("unknown identity provider")
+
+		return "", "", fmt.Errorf("%s: %w", op, ErrUnknownProvider)
+	}
+
+	if _, err := a.appProvider.App(ctx, appID); err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found")
+
+			return "", "", fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+		}
+
+		log.Error("failed to get app", "error", err)
+
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	state, stateHash, err := newOpaqueToken()
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Random 32 bytes encode to 43 URL safe characters, a valid PKCE verifier.
+	nonce, _, err := newOpaqueToken()
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	verifier, _, err := newOpaqueToken()
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	authURL, err = p.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		log.Error("failed to get sign in page of provider", "error", err)
+
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	now := a.clock.Now()
+
+	err = a.oauthStates.SaveOAuthState(ctx, models.OAuthState{
+		StateHash: stateHash,
+		Provider:  provider,
+		AppID:     appID,
+		UserID:    linkUserID,
+		Nonce:     nonce,
+		Verifier:  verifier,
+		ExpiresAt: now.Add(a.oauthStateTTL),
+	}, now)
+	if err != nil {
+		log.Error("failed to save state", "error", err)
+
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("sign in with identity provider started", slog.Bool("link", linkUserID != 0))
+
+	return authURL, state, nil
+}
+
+// FinishOAuth finishes the sign in started by StartOAuth with the code and state
+// the provider redirected back with, and logs the user in to the app of the sign in.
+//
+// The user is the one the account at the provider is linked to. An account that isn't
+// linked yet is linked to the user StartOAuth was given, or else to the user with
+// the verified email of the account, who is created if there is none.
+// Users who have a password are never linked by email, as whoever controls
+// the email at the provider would take the account over: ErrAccountLinkRequired
+// is returned and the user has to sign in with the password and link the account.
+//
+// Every state can be used once, ErrInvalidOAuthState is returned for unknown,
+// used and expired states.
+func (a *Auth) FinishOAuth(ctx context.Context, provider string, state string, code string) (
+	token string,
+	refreshToken string,
+	userID int64,
+	expiresAt time.Time,
+	err error,
+) {
+	const op = "auth.FinishOAuth"
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.String("provider", provider),
+	)
+
+	p, ok := a.identityProviders[provider]
+	if !ok {
+		log.Warn("unknown identity provider")
+
+		return "", "", 0, time.Time{}, fmt.Errorf("%s: %w", op, ErrUnknownProvider)
+	}
+
+	stored, err := a.oauthStates.ConsumeOAuthState(ctx, hashToken(state))
+	if err != nil {
+		if errors.Is(err, storage.ErrOAuthStateNotFound) {
+			log.Warn("state not found")
+
+			return "", "", 0, time.Time{}, fmt.Errorf("%s: %w", op, ErrInvalidOAuthState)
+		}
+
+		log.Error("failed to consume state", "error", err)
+
+		return "", "", 0, time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if stored.Provider != provider || a.clock.Now().After(stored.ExpiresAt) {
+		log.Warn("state is expired or of another provider", slog.String("state_provider", stored.Provider))
+
+		return "", "", 0, time.Time{}, fmt.Errorf("%s: %w", op, ErrInvalidOAuthState)
+	}
+
+	log = log.With(slog.Int("app_id", stored.AppID))
+
+	user, app, err := a.signIn(ctx, log, provider, p, code, "", stored)
+	if err != nil {
+		return "", "", 0, time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	token, refreshToken, expiresAt, err = a.issueTokens(ctx, user, app, nil)
+	if err != nil {
+		log.Error("failed to issue tokens", "error", err)
+
+		return "", "", 0, time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("signed in with identity provider", slog.Int64("user_id", user.ID))
+
+	a.publish(ctx, log, models.EventUserLoggedIn, user.ID, app.ID)
+
+	return token, refreshToken, user.ID, expiresAt, nil
+}
+
+// LoginWithOIDC logs the user in with a code a client got from the provider itself,
+// e.g. a mobile app, for redirectURI it registered at the provider.
+// The token is issued for the app of the provider in OIDCLogins, users are
+// linked and created like by FinishOAuth.
+//
+// The client must start the sign in with PKCE and check the nonce itself, as
+// the service doesn't know them. The code can be exchanged once, with the client secret
+// of the service, so it can't be replayed.
+func (a *Auth) LoginWithOIDC(ctx context.Context, provider string, code string, redirectURI string) (token string, err error) {
+	const op = "auth.LoginWithOIDC"
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.String("provider", provider),
+	)
+
+	p, ok := a.identityProviders[provider]
+	login, loginOK := a.oidcLogins[provider]
+	if !ok || !loginOK {
+		log.Warn("unknown identity provider")
+
+		return "", fmt.Errorf("%s: %w", op, ErrUnknownProvider)
+	}
+
+	if !slices.Contains(login.RedirectURIs, redirectURI) {
+		log.Warn("redirect uri is not allowed", slog.String("redirect_uri", redirectURI))
+
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidRedirectURI)
+	}
+
+	log = log.With(slog.Int("app_id", login.AppID))
+
+	user, app, err := a.signIn(ctx, log, provider, p, code, redirectURI, models.OAuthState{AppID: login.AppID})
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	// The client gets no refresh token, the session ends with the token.
+	token, expiresAt, err := a.newToken(ctx, user, app, nil)
+	if err != nil {
+		log.Error("failed to generate token", "error", err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := a.startSession(ctx, user.ID, app.ID, expiresAt); err != nil {
+		log.Error("failed to start session", "error", err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("logged in with identity provider", slog.Int64("user_id", user.ID))
+
+	a.publish(ctx, log, models.EventUserLoggedIn, user.ID, app.ID)
+
+	return token, nil
+}
+
+// signIn exchanges the code for the identity at the provider and returns the user
+// it belongs to, see FinishOAuth, and the app of the sign in described by state.
+func (a *Auth) signIn(
+	ctx context.Context,
+	log *slog.Logger,
+	provider string,
+	p IdentityProvider,
+	code string,
+	redirectURI string,
+	state models.OAuthState,
+) (models.User, models.App, error) {
+	identity, err := p.Identity(ctx, code, redirectURI, state.Verifier, state.Nonce)
+	if err != nil {
+		log.Warn("failed to get identity", "error", err)
+
+		return models.User{}, models.App{}, fmt.Errorf("%w: %w", ErrInvalidOAuthCode, err)
+	}
+
+	identity.Provider = provider
+	identity.Email = a.normalizeEmail(identity.Email)
+
+	log = log.With(slog.String("subject", identity.Subject))
+
+	user, created, err := a.identityUser(ctx, log, identity, state.UserID)
+	if err != nil {
+		return models.User{}, models.App{}, err
+	}
+
+	if created {
+		a.publish(ctx, log, models.EventUserRegistered, user.ID, 0)
+	}
+
+	if a.requireVerification && !user.Verified {
+		log.Warn("email is not verified")
+
+		return models.User{}, models.App{}, ErrEmailNotVerified
+	}
+
+	app, err := a.appProvider.App(ctx, state.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found")
+
+			return models.User{}, models.App{}, ErrInvalidAppID
+		}
+
+		log.Error("failed to get app", "error", err)
+
+		return models.User{}, models.App{}, err
+	}
+
+	return user, app, nil
+}
+
+// identityUser returns the user the identity belongs to, linking or creating
+// one as described in FinishOAuth. created is set for new users.
+func (a *Auth) identityUser(ctx context.Context, log *slog.Logger, identity models.Identity, linkUserID int64) (user models.User, created bool, err error) {
+	user, err = a.identities.UserByIdentity(ctx, identity.Provider, identity.Subject)
+	switch {
+	case err == nil:
+		if linkUserID != 0 && user.ID != linkUserID {
+			log.Warn("identity is linked to another user", slog.Int64("user_id", user.ID))
+
+			return models.User{}, false, ErrIdentityLinked
+		}
+
+		return user, false, nil
+	case !errors.Is(err, storage.ErrUserNotFound):
+		log.Error("failed to get user by identity", "error", err)
+
+		return models.User{}, false, err
+	}
+
+	if linkUserID != 0 {
+		user, err = a.usrProvider.UserByID(ctx, linkUserID)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				log.Warn("user to link to not found", slog.Int64("user_id", linkUserID))
+
+				return models.User{}, false, ErrUserNotFound
+			}
+
+			log.Error("failed to get user", "error", err)
+
+			return models.User{}, false, err
+		}
+
+		return user, false, a.linkIdentity(ctx, log, user.ID, identity)
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		log.Warn("email of identity is not verified")
+
+		return models.User{}, false, ErrIdentityEmailNotVerified
+	}
+
+	user, err = a.usrProvider.User(ctx, identity.Email)
+	if err == nil {
+		if len(user.PassHash) > 0 {
+			log.Warn("user with the email has a password, identity must be linked explicitly", slog.Int64("user_id", user.ID))
+
+			return models.User{}, false, ErrAccountLinkRequired
+		}
+
+		return user, false, a.linkIdentity(ctx, log, user.ID, identity)
+	}
+	if !errors.Is(err, storage.ErrUserNotFound) {
+		log.Error("failed to get user", "error", err)
+
+		return models.User{}, false, err
+	}
+
+	// The user and the link are saved together, so a failed link leaves
+	// no user without a way to sign in behind.
+	err = a.tx.WithTx(ctx, func(ctx context.Context) error {
+		// Users signing in with a provider have no password, they may set one by resetting it.
+		id, err := a.saveUser(ctx, identity.Email, []byte{}, true)
+		if err != nil {
+			return err
+		}
+
+		user, err = a.usrProvider.UserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		return a.identities.LinkIdentity(ctx, id, identity, a.clock.Now())
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrUserExists) {
+			// The email belongs to a soft deleted user or the user has just been created.
+			log.Warn("user already exists", "error", err)
+
+			return models.User{}, false, ErrUserExists
+		}
+
+		log.Error("failed to create user", "error", err)
+
+		return models.User{}, false, err
+	}
+
+	log.Info("user created for identity", slog.Int64("user_id", user.ID))
+
+	return user, true, nil
+}
+
+func (a *Auth) linkIdentity(ctx context.Context, log *slog.Logger, userID int64, identity models.Identity) error {
+	if err := a.identities.LinkIdentity(ctx, userID, identity, a.clock.Now()); err != nil {
+		if errors.Is(err, storage.ErrIdentityExists) {
+			// Linked by a concurrent sign in in the meantime.
+			log.Warn("identity is linked already", "error", err)
+
+			return ErrIdentityLinked
+		}
+
+		log.Error("failed to link identity", "error", err)
+
+		return err
+	}
+
+	log.Info("identity linked", slog.Int64("user_id", userID))
+
+	return nil
+}
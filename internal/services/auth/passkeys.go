@@ -0,0 +1,412 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/storage"
+	"time"
+)
+
+// RelyingParty runs WebAuthn ceremonies of passkeys, see passkey.RelyingParty.
+//
+// Options are the JSON the client passes to navigator.credentials, responses are the JSON
+// of the credential the browser returns. Sessions are the state of a ceremony,
+// the service keeps them between its begin and finish.
+type RelyingParty interface {
+	// BeginRegistration starts registering a passkey for the user, passkeys are
+	// the ones the user has, the authenticator refuses to register them again.
+	BeginRegistration(user models.User, passkeys []models.Passkey) (options []byte, session []byte, err error)
+	// FinishRegistration verifies the response of the authenticator and returns the new passkey,
+	// its UserID and CreatedAt are not set.
+	FinishRegistration(user models.User, session []byte, response []byte) (models.Passkey, error)
+	// BeginLogin starts a login with a passkey of any user, the authenticator picks the passkey.
+	BeginLogin() (options []byte, session []byte, err error)
+	// FinishLogin verifies the assertion of the authenticator with the passkey it was signed by,
+	// passkeys returns passkeys of the user the assertion claims to be of.
+	// The sign count isn't checked, see FinishLogin of Auth.
+	FinishLogin(session []byte, response []byte, passkeys func(userID int64) ([]models.Passkey, error)) (models.PasskeyAssertion, error)
+}
+
+// PasskeyStore keeps passkeys of users.
+type PasskeyStore interface {
+	// SavePasskey returns storage.ErrPasskeyExists if the passkey is registered already.
+	SavePasskey(ctx context.Context, passkey models.Passkey) error
+	Passkeys(ctx context.Context, userID int64) ([]models.Passkey, error)
+	// TouchPasskey records a login with the passkey, returns storage.ErrPasskeyNotFound
+	// if the passkey has been deleted.
+	TouchPasskey(ctx context.Context, id []byte, signCount uint32, backupState bool, usedAt time.Time) error
+}
+
+// PasskeyChallengeStore keeps registrations and logins with passkeys that have been started.
+type PasskeyChallengeStore interface {
+	// SavePasskeyChallenge also deletes challenges expired before now.
+	SavePasskeyChallenge(ctx context.Context, challenge models.PasskeyChallenge, now time.Time) error
+	// ConsumePasskeyChallenge deletes the challenge and returns it,
+	// storage.ErrPasskeyChallengeNotFound if there is no such challenge.
+	ConsumePasskeyChallenge(ctx context.Context, idHash string, purpose string) (models.PasskeyChallenge, error)
+}
+
+// BeginRegistration starts registering a passkey for the user and returns the options
+// to create it with and the id of the challenge to pass to FinishRegistration.
+// The caller must have authenticated the user.
+func (a *Auth) BeginRegistration(ctx context.Context, userID int64) (options []byte, challengeID string, err error) {
+	const op = "auth.BeginRegistration"
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+	)
+
+	if a.relyingParty == nil {
+		return nil, "", fmt.Errorf("%s: %w", op, ErrPasskeysDisabled)
+	}
+
+	user, err := a.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found")
+
+			return nil, "", fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+
+		log.Error("failed to get user", "error", err)
+
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	passkeys, err := a.passkeys.Passkeys(ctx, user.ID)
+	if err != nil {
+		log.Error("failed to get passkeys", "error", err)
+
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	options, session, err := a.relyingParty.BeginRegistration(user, passkeys)
+	if err != nil {
+		log.Error("failed to begin registration", "error", err)
+
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	challengeID, err = a.savePasskeyChallenge(ctx, models.PasskeyChallenge{
+		Purpose: models.PurposePasskeyRegistration,
+		UserID:  user.ID,
+		Session: session,
+	})
+	if err != nil {
+		log.Error("failed to save challenge", "error", err)
+
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("passkey registration started")
+
+	return options, challengeID, nil
+}
+
+// FinishRegistration verifies the response of the authenticator to the challenge
+// of BeginRegistration and saves the new passkey of the user.
+//
+// Every challenge can be answered once, ErrInvalidPasskeyChallenge is returned for unknown,
+// used and expired challenges and for challenges of other users.
+func (a *Auth) FinishRegistration(ctx context.Context, userID int64, challengeID string, response []byte) error {
+	const op = "auth.FinishRegistration"
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+	)
+
+	if a.relyingParty == nil {
+		return fmt.Errorf("%s: %w", op, ErrPasskeysDisabled)
+	}
+
+	challenge, err := a.consumePasskeyChallenge(ctx, challengeID, models.PurposePasskeyRegistration)
+	if err != nil {
+		if errors.Is(err, ErrInvalidPasskeyChallenge) {
+			log.Warn("invalid passkey challenge")
+
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Error("failed to consume challenge", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if challenge.UserID != userID {
+		log.Warn("challenge was issued to another user", slog.Int64("challenge_user_id", challenge.UserID))
+
+		return fmt.Errorf("%s: %w", op, ErrInvalidPasskeyChallenge)
+	}
+
+	user, err := a.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found")
+
+			return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+
+		log.Error("failed to get user", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	passkey, err := a.relyingParty.FinishRegistration(user, challenge.Session, response)
+	if err != nil {
+		log.Warn("passkey registration rejected", "error", err)
+
+		return fmt.Errorf("%s: %w", op, ErrInvalidPasskey)
+	}
+
+	passkey.UserID = user.ID
+	passkey.CreatedAt = a.clock.Now()
+
+	if err := a.passkeys.SavePasskey(ctx, passkey); err != nil {
+		if errors.Is(err, storage.ErrPasskeyExists) {
+			log.Warn("passkey is already registered")
+
+			return fmt.Errorf("%s: %w", op, ErrPasskeyExists)
+		}
+
+		log.Error("failed to save passkey", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("passkey registered", slog.Bool("backup_eligible", passkey.BackupEligible))
+
+	return nil
+}
+
+// BeginLogin starts a login with a passkey to the app and returns the options to get
+// the assertion with and the id of the challenge to pass to FinishLogin.
+// The user isn't asked for, the authenticator offers the passkeys it has for the service,
+// so nobody can find out which emails are registered.
+func (a *Auth) BeginLogin(ctx context.Context, appID int) (options []byte, challengeID string, err error) {
+	const op = "auth.BeginLogin"
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+	)
+
+	if a.relyingParty == nil {
+		return nil, "", fmt.Errorf("%s: %w", op, ErrPasskeysDisabled)
+	}
+
+	if _, err := a.appProvider.App(ctx, appID); err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found")
+
+			return nil, "", fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+		}
+
+		log.Error("failed to get app", "error", err)
+
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	options, session, err := a.relyingParty.BeginLogin()
+	if err != nil {
+		log.Error("failed to begin login", "error", err)
+
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	challengeID, err = a.savePasskeyChallenge(ctx, models.PasskeyChallenge{
+		Purpose: models.PurposePasskeyLogin,
+		AppID:   appID,
+		Session: session,
+	})
+	if err != nil {
+		log.Error("failed to save challenge", "error", err)
+
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("passkey login started")
+
+	return options, challengeID, nil
+}
+
+// FinishLogin verifies the assertion of the authenticator for the challenge of BeginLogin,
+// logs the user of the passkey in to the app and returns an access token,
+// there is no refresh token: the session ends with it.
+//
+// Authenticators increase the sign count of a passkey with every assertion, so an assertion
+// whose count isn't above the stored one comes from a copy of the passkey: it is refused
+// with ErrPasskeyCloned and the stored count is kept. Passkeys that always report 0,
+// e.g. synced ones, don't count and aren't checked.
+func (a *Auth) FinishLogin(ctx context.Context, challengeID string, response []byte) (string, error) {
+	const op = "auth.FinishLogin"
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+	)
+
+	if a.relyingParty == nil {
+		return "", fmt.Errorf("%s: %w", op, ErrPasskeysDisabled)
+	}
+
+	challenge, err := a.consumePasskeyChallenge(ctx, challengeID, models.PurposePasskeyLogin)
+	if err != nil {
+		if errors.Is(err, ErrInvalidPasskeyChallenge) {
+			log.Warn("invalid passkey challenge")
+
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Error("failed to consume challenge", "error", err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int("app_id", challenge.AppID))
+
+	app, err := a.appProvider.App(ctx, challenge.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found")
+
+			return "", fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+		}
+
+		log.Error("failed to get app", "error", err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	// The user is looked up while the assertion is verified, storage failures
+	// are kept apart from assertions of unknown users.
+	var (
+		user      models.User
+		lookupErr error
+	)
+	assertion, err := a.relyingParty.FinishLogin(challenge.Session, response, func(userID int64) ([]models.Passkey, error) {
+		user, lookupErr = a.usrProvider.UserByID(ctx, userID)
+		if lookupErr != nil {
+			return nil, lookupErr
+		}
+
+		var passkeys []models.Passkey
+		passkeys, lookupErr = a.passkeys.Passkeys(ctx, userID)
+
+		return passkeys, lookupErr
+	})
+	if err != nil {
+		if lookupErr != nil && !errors.Is(lookupErr, storage.ErrUserNotFound) {
+			log.Error("failed to get passkeys", "error", lookupErr)
+
+			return "", fmt.Errorf("%s: %w", op, lookupErr)
+		}
+
+		log.Warn("passkey login rejected", "error", err)
+
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidPasskey)
+	}
+
+	log = log.With(slog.Int64("user_id", user.ID))
+
+	stored := assertion.Passkey
+	if (assertion.SignCount != 0 || stored.SignCount != 0) && assertion.SignCount <= stored.SignCount {
+		log.Warn("sign count of passkey went back, it may have been cloned",
+			slog.Uint64("sign_count", uint64(assertion.SignCount)),
+			slog.Uint64("stored_sign_count", uint64(stored.SignCount)),
+		)
+
+		return "", fmt.Errorf("%s: %w", op, ErrPasskeyCloned)
+	}
+
+	if err := a.checkLockout(ctx, &user); err != nil {
+		if errors.Is(err, ErrAccountLocked) {
+			log.Warn("account is locked", slog.Time("locked_until", user.LockedUntil))
+
+			return "", fmt.Errorf("%s: %w", op, ErrAccountLocked)
+		}
+
+		log.Error("failed to check lockout", "error", err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if a.requireVerification && !user.Verified {
+		log.Warn("email is not verified")
+
+		return "", fmt.Errorf("%s: %w", op, ErrEmailNotVerified)
+	}
+
+	err = a.passkeys.TouchPasskey(ctx, stored.ID, assertion.SignCount, assertion.BackupState, a.clock.Now())
+	if err != nil {
+		if errors.Is(err, storage.ErrPasskeyNotFound) {
+			log.Warn("passkey has been deleted")
+
+			return "", fmt.Errorf("%s: %w", op, ErrInvalidPasskey)
+		}
+
+		log.Error("failed to update passkey", "error", err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	accessToken, expiresAt, err := a.newToken(ctx, user, app, nil)
+	if err != nil {
+		log.Error("failed to generate token", "error", err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := a.startSession(ctx, user.ID, app.ID, expiresAt); err != nil {
+		log.Error("failed to start session", "error", err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("logged in with passkey")
+
+	a.publish(ctx, log, models.EventUserLoggedIn, user.ID, app.ID)
+
+	return accessToken, nil
+}
+
+// savePasskeyChallenge saves the challenge under a new id and returns the id.
+func (a *Auth) savePasskeyChallenge(ctx context.Context, challenge models.PasskeyChallenge) (string, error) {
+	id, idHash, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := a.clock.Now()
+
+	challenge.IDHash = idHash
+	challenge.ExpiresAt = now.Add(a.passkeyTimeout)
+
+	if err := a.passkeyChallenges.SavePasskeyChallenge(ctx, challenge, now); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// consumePasskeyChallenge returns the challenge with given id and purpose and uses it up,
+// ErrInvalidPasskeyChallenge if it doesn't exist or has expired.
+func (a *Auth) consumePasskeyChallenge(ctx context.Context, id string, purpose string) (models.PasskeyChallenge, error) {
+	challenge, err := a.passkeyChallenges.ConsumePasskeyChallenge(ctx, hashToken(id), purpose)
+	if err != nil {
+		if errors.Is(err, storage.ErrPasskeyChallengeNotFound) {
+			return models.PasskeyChallenge{}, ErrInvalidPasskeyChallenge
+		}
+
+		return models.PasskeyChallenge{}, err
+	}
+
+	if a.clock.Now().After(challenge.ExpiresAt) {
+		return models.PasskeyChallenge{}, ErrInvalidPasskeyChallenge
+	}
+
+	return challenge, nil
+}
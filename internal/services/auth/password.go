@@ -0,0 +1,333 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/password"
+	"sso/internal/lib/secret"
+	"sso/internal/storage"
+	"sync"
+)
+
+// ChangePassword replaces the password of the user after verifying the old one.
+//
+// The new password must satisfy the password policy, must not be too long
+// for the password hasher, see ErrPasswordTooLong,
+// and must not be one of the last passwords of the user, see Config.PasswordHistory.
+// On success all sessions of the user are revoked, so other devices
+// have to log in again once their access tokens expire.
+//
+// Wrong old passwords are delayed and count towards lockout like failed logins,
+// see Config.LoginBackoff and Config.Lockout.
+func (a *Auth) ChangePassword(
+	ctx context.Context,
+	email string,
+	oldPassword secret.Password,
+	newPassword secret.Password,
+) error {
+	const op = "auth.ChangePassword"
+
+	email = a.normalizeEmail(email)
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.String("email", email),
+	)
+
+	log.Info("changing password")
+
+	// The old password can be guessed here as well as in Login, so it is delayed the same.
+	if err := a.loginBackoff.Wait(ctx, email); err != nil {
+		log.Warn("request cancelled during login backoff", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.usrProvider.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", "error", err)
+
+			a.compareDummy(oldPassword)
+			a.loginBackoff.Fail(email)
+
+			return fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		}
+
+		log.Error("failed to get user", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkLockout(ctx, &user); err != nil {
+		if errors.Is(err, ErrAccountLocked) {
+			log.Warn("account is locked", slog.Time("locked_until", user.LockedUntil))
+
+			return fmt.Errorf("%s: %w", op, ErrAccountLocked)
+		}
+
+		log.Error("failed to check lockout", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		log.Warn("request cancelled", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.hasher.Compare(user.PassHash, oldPassword.Reveal()); err != nil {
+		log.Warn("invalid old password")
+
+		a.loginBackoff.Fail(email)
+		if err := a.registerFailedLogin(ctx, user); err != nil {
+			log.Error("failed to register failed login", "error", err)
+		}
+
+		return fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+
+	a.loginBackoff.Reset(email)
+
+	if err := a.passwordPolicy.Validate(newPassword.Reveal()); err != nil {
+		log.Info("weak password", "error", err)
+
+		return fmt.Errorf("%s: %w: %w", op, ErrWeakPassword, err)
+	}
+
+	if err := a.checkPasswordReuse(ctx, user, newPassword); err != nil {
+		if errors.Is(err, ErrPasswordReused) {
+			log.Info("password reused")
+
+			return fmt.Errorf("%s: %w", op, ErrPasswordReused)
+		}
+
+		log.Error("failed to check password history", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	passHash, err := a.hashPassword(ctx, newPassword.Reveal())
+	if err != nil {
+		if errors.Is(err, ErrPasswordTooLong) {
+			log.Info("password is too long")
+
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Error("failed to hash password", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.usrSave.UpdatePassword(ctx, user.ID, passHash, a.clock.Now()); err != nil {
+		log.Error("failed to update password", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.rememberPassword(ctx, user); err != nil {
+		log.Error("failed to save password history", "error", err)
+	}
+
+	if err := a.resetFailedLogins(ctx, user); err != nil {
+		log.Error("failed to reset failed logins", "error", err)
+	}
+
+	if err := a.sessions.DeleteUserSessions(ctx, user.ID); err != nil {
+		log.Error("failed to revoke sessions", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("password changed")
+
+	return nil
+}
+
+// VerifyPassword reports whether password is the password of the user with given email,
+// without issuing a token. Unknown emails take as long as wrong passwords, see compareDummy,
+// and wrong passwords count towards lockout like failed logins.
+//
+// Returns ErrAccountLocked if the account is locked, whatever the password.
+func (a *Auth) VerifyPassword(ctx context.Context, email string, password secret.Password) (bool, error) {
+	const op = "auth.VerifyPassword"
+
+	email = a.normalizeEmail(email)
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.String("email", email),
+	)
+
+	user, err := a.usrProvider.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", "error", err)
+
+			a.compareDummy(password)
+
+			return false, nil
+		}
+
+		log.Error("failed to get user", "error", err)
+
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkLockout(ctx, &user); err != nil {
+		if errors.Is(err, ErrAccountLocked) {
+			log.Warn("account is locked", slog.Time("locked_until", user.LockedUntil))
+
+			return false, fmt.Errorf("%s: %w", op, ErrAccountLocked)
+		}
+
+		log.Error("failed to check lockout", "error", err)
+
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// A cancelled request must not be counted as a failed attempt.
+	if err := ctx.Err(); err != nil {
+		log.Warn("request cancelled", "error", err)
+
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.hasher.Compare(user.PassHash, password.Reveal()); err != nil {
+		log.Warn("invalid password")
+
+		if err := a.registerFailedLogin(ctx, user); err != nil {
+			log.Error("failed to register failed login", "error", err)
+		}
+
+		return false, nil
+	}
+
+	if err := a.resetFailedLogins(ctx, user); err != nil {
+		log.Error("failed to reset failed logins", "error", err)
+	}
+
+	log.Info("password verified")
+
+	return true, nil
+}
+
+// checkPasswordReuse returns ErrPasswordReused if password is the current password
+// of the user or one of the previous ones kept in the password history.
+func (a *Auth) checkPasswordReuse(ctx context.Context, user models.User, password secret.Password) error {
+	if a.passwordHistory <= 0 {
+		return nil
+	}
+
+	hashes := [][]byte{user.PassHash}
+
+	if a.passwordHistory > 1 {
+		previous, err := a.usrSave.PasswordHistory(ctx, user.ID, a.passwordHistory-1)
+		if err != nil {
+			return err
+		}
+
+		hashes = append(hashes, previous...)
+	}
+
+	for _, hash := range hashes {
+		if err := a.hasher.Compare(hash, password.Reveal()); err == nil {
+			return ErrPasswordReused
+		}
+	}
+
+	return nil
+}
+
+// rememberPassword adds the password hash user had before it was replaced
+// to the password history, the current password is checked separately,
+// so the history keeps one hash less than the configured depth.
+func (a *Auth) rememberPassword(ctx context.Context, user models.User) error {
+	if a.passwordHistory <= 1 {
+		return nil
+	}
+
+	return a.usrSave.AddPasswordHistory(ctx, user.ID, user.PassHash, a.passwordHistory-1, a.clock.Now())
+}
+
+// passwordExpired reports whether the password of the user is older than the password max age.
+func (a *Auth) passwordExpired(user models.User) bool {
+	return a.passwordMaxAge > 0 && a.clock.Now().Sub(user.PasswordChangedAt) > a.passwordMaxAge
+}
+
+// hashPassword hashes the password unless ctx is already done,
+// hashing is expensive and nobody waits for the result of a cancelled request.
+//
+// Returns ErrPasswordTooLong if the hasher can't hash the whole password.
+func (a *Auth) hashPassword(ctx context.Context, plain string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	hash, err := a.hasher.Hash(plain)
+	if errors.Is(err, password.ErrPasswordTooLong) {
+		return nil, ErrPasswordTooLong
+	}
+
+	return hash, err
+}
+
+// rehashPassword upgrades the stored hash of the user if it was made with
+// an outdated algorithm or cost. It is called after the password has been
+// verified, as it is the only time the plain password is known.
+//
+// Failures are only logged, the user is logged in either way.
+func (a *Auth) rehashPassword(ctx context.Context, log *slog.Logger, user models.User, password secret.Password) {
+	if !a.hasher.NeedsRehash(user.PassHash) {
+		return
+	}
+
+	passHash, err := a.hashPassword(ctx, password.Reveal())
+	if err != nil {
+		// The password was set with a hasher taking longer passwords, it stays as it is.
+		if errors.Is(err, ErrPasswordTooLong) {
+			log.Info("password is too long to rehash")
+
+			return
+		}
+
+		log.Error("failed to rehash password", "error", err)
+
+		return
+	}
+
+	// The hash is only replaced if it hasn't changed since it was read,
+	// so a concurrent password change is never overwritten with the old password.
+	if err := a.usrSave.ReplacePasswordHash(ctx, user.ID, user.PassHash, passHash); err != nil {
+		log.Error("failed to save rehashed password", "error", err)
+
+		return
+	}
+
+	log.Info("password rehashed")
+}
+
+// newDummyHash returns a lazily computed hash which is compared against
+// when the user doesn't exist, so unknown emails take about as long
+// to check as known ones and response timing doesn't reveal which
+// emails are registered. It is made by the same hasher as real hashes.
+func newDummyHash(hasher PasswordHasher) func() []byte {
+	return sync.OnceValue(func() []byte {
+		hash, err := hasher.Hash("dummy password")
+		if err != nil {
+			panic(err)
+		}
+
+		return hash
+	})
+}
+
+// compareDummy burns the same time as checking a real password.
+func (a *Auth) compareDummy(password secret.Password) {
+	_ = a.hasher.Compare(a.dummyHash(), password.Reveal())
+}
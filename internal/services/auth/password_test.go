@@ -0,0 +1,311 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"sso/internal/lib/password"
+	"sso/internal/lib/secret"
+	"sso/internal/services/auth"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingBackoff counts calls of LoginBackoff by email without delaying anything.
+type countingBackoff struct {
+	mu     sync.Mutex
+	waits  map[string]int
+	fails  map[string]int
+	resets map[string]int
+}
+
+func newCountingBackoff() *countingBackoff {
+	return &countingBackoff{
+		waits:  make(map[string]int),
+		fails:  make(map[string]int),
+		resets: make(map[string]int),
+	}
+}
+
+func (b *countingBackoff) Wait(_ context.Context, email string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.waits[email]++
+
+	return nil
+}
+
+func (b *countingBackoff) Fail(email string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.fails[email]++
+}
+
+func (b *countingBackoff) Reset(email string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.resets[email]++
+}
+
+// countingHasher counts comparisons made by the hasher it wraps.
+type countingHasher struct {
+	auth.PasswordHasher
+
+	mu       sync.Mutex
+	compares int
+}
+
+func (h *countingHasher) Compare(hash []byte, password string) error {
+	h.mu.Lock()
+	h.compares++
+	h.mu.Unlock()
+
+	return h.PasswordHasher.Compare(hash, password)
+}
+
+func (h *countingHasher) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.compares
+}
+
+func TestLoginComparesPasswordOfUnknownEmail(t *testing.T) {
+	hasher := &countingHasher{PasswordHasher: newTestHasher(t)}
+	s := newSuite(t, auth.Config{Hasher: hasher})
+
+	appID := s.createApp(t, "app")
+	s.register(t, "user@example.com")
+
+	before := hasher.count()
+	if _, err := s.login(t, "user@example.com", "Wr0ng!pass", appID); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Fatalf("wrong password: got %v, want ErrInvalidCredentials", err)
+	}
+	known := hasher.count() - before
+
+	before = hasher.count()
+	if _, err := s.login(t, "nobody@example.com", "Wr0ng!pass", appID); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Fatalf("unknown email: got %v, want ErrInvalidCredentials", err)
+	}
+	unknown := hasher.count() - before
+
+	// Both check the app secret, then the password, real or dummy.
+	if unknown != 2 || unknown != known {
+		t.Fatalf("unknown email ran %d comparisons, known email %d, want 2 each", unknown, known)
+	}
+}
+
+func TestChangePasswordIsDelayedLikeLogin(t *testing.T) {
+	backoff := newCountingBackoff()
+	s := newSuite(t, auth.Config{LoginBackoff: backoff})
+	ctx := context.Background()
+
+	s.register(t, "user@example.com")
+
+	err := s.auth.ChangePassword(ctx, "user@example.com", "Wr0ng!pass", "N3w!password")
+	if !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Fatalf("wrong old password: got %v, want ErrInvalidCredentials", err)
+	}
+
+	err = s.auth.ChangePassword(ctx, "nobody@example.com", "Wr0ng!pass", "N3w!password")
+	if !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Fatalf("unknown email: got %v, want ErrInvalidCredentials", err)
+	}
+
+	if backoff.waits["user@example.com"] != 1 || backoff.fails["user@example.com"] != 1 {
+		t.Fatalf("wrong old password: %d waits and %d failures, want 1 and 1",
+			backoff.waits["user@example.com"], backoff.fails["user@example.com"])
+	}
+	if backoff.fails["nobody@example.com"] != 1 {
+		t.Fatalf("unknown email: %d failures, want 1", backoff.fails["nobody@example.com"])
+	}
+
+	if err := s.auth.ChangePassword(ctx, "user@example.com", testPassword, "N3w!password"); err != nil {
+		t.Fatalf("change password: %v", err)
+	}
+	if backoff.resets["user@example.com"] != 1 {
+		t.Fatalf("successful change: %d resets, want 1", backoff.resets["user@example.com"])
+	}
+}
+
+func TestLoginRehashesPasswordOfOutdatedCost(t *testing.T) {
+	s := newSuite(t, auth.Config{})
+	ctx := context.Background()
+
+	appID := s.createApp(t, "app")
+	userID := s.register(t, "user@example.com")
+
+	hasher, err := password.NewHasher(password.AlgBcrypt, bcrypt.MinCost+1, password.Argon2idParams{})
+	if err != nil {
+		t.Fatalf("create hasher: %v", err)
+	}
+	s.configure(t, auth.Config{Hasher: hasher})
+
+	if _, err := s.login(t, "user@example.com", testPassword, appID); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	user, err := s.storage.UserByID(ctx, userID)
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+
+	cost, err := bcrypt.Cost(user.PassHash)
+	if err != nil {
+		t.Fatalf("cost of stored hash: %v", err)
+	}
+	if cost != bcrypt.MinCost+1 {
+		t.Fatalf("stored hash has cost %d, want %d", cost, bcrypt.MinCost+1)
+	}
+
+	// The upgraded hash still matches the password.
+	if _, err := s.login(t, "user@example.com", testPassword, appID); err != nil {
+		t.Fatalf("login after rehash: %v", err)
+	}
+}
+
+func TestChangePasswordHistory(t *testing.T) {
+	s := newSuite(t, auth.Config{PasswordHistory: 2})
+	ctx := context.Background()
+
+	s.register(t, "user@example.com")
+
+	const (
+		second secret.Password = "Passw0rd!y"
+		third  secret.Password = "Passw0rd!z"
+	)
+
+	steps := []struct {
+		from, to secret.Password
+		want     error
+	}{
+		{testPassword, testPassword, auth.ErrPasswordReused},
+		{testPassword, second, nil},
+		// The previous password is in the history of 2 with the current one.
+		{second, testPassword, auth.ErrPasswordReused},
+		{second, third, nil},
+		// It has fallen out of the history since.
+		{third, testPassword, nil},
+	}
+
+	for i, step := range steps {
+		err := s.auth.ChangePassword(ctx, "user@example.com", step.from, step.to)
+		if !errors.Is(err, step.want) {
+			t.Fatalf("step %d, %s to %s: got %v, want %v", i+1, step.from.Reveal(), step.to.Reveal(), err, step.want)
+		}
+	}
+}
+
+func TestChangePasswordWithoutHistory(t *testing.T) {
+	s := newSuite(t, auth.Config{})
+
+	s.register(t, "user@example.com")
+
+	if err := s.auth.ChangePassword(context.Background(), "user@example.com", testPassword, testPassword); err != nil {
+		t.Fatalf("reuse without history: %v", err)
+	}
+}
+
+func TestLoginPasswordExpiry(t *testing.T) {
+	const maxAge = 90 * 24 * time.Hour
+
+	for name, block := range map[string]bool{"warn": false, "block": true} {
+		t.Run(name, func(t *testing.T) {
+			s := newSuite(t, auth.Config{PasswordMaxAge: maxAge, BlockExpiredPasswords: block})
+			ctx := context.Background()
+			appID := s.createApp(t, "app")
+
+			s.register(t, "user@example.com")
+
+			// expired logs the user in and reports whether the password has expired.
+			expired := func() bool {
+				t.Helper()
+
+				res, err := s.auth.Login(ctx, "user@example.com", testPassword, appID, "secret", nil)
+				if block && errors.Is(err, auth.ErrPasswordExpired) {
+					return true
+				}
+				if err != nil {
+					t.Fatalf("login: %v", err)
+				}
+
+				return res.PasswordExpired
+			}
+
+			if expired() {
+				t.Fatal("new password is expired")
+			}
+
+			s.clock.Advance(maxAge)
+			if expired() {
+				t.Fatal("password is expired at max age")
+			}
+
+			s.clock.Advance(time.Second)
+			if !expired() {
+				t.Fatal("password isn't expired past max age")
+			}
+
+			// Changing the password starts over.
+			if err := s.auth.ChangePassword(ctx, "user@example.com", testPassword, "Passw0rd!y"); err != nil {
+				t.Fatalf("change password: %v", err)
+			}
+			if err := s.auth.ChangePassword(ctx, "user@example.com", "Passw0rd!y", testPassword); err != nil {
+				t.Fatalf("change password back: %v", err)
+			}
+
+			s.clock.Advance(maxAge)
+			if expired() {
+				t.Fatal("changed password is expired at max age")
+			}
+		})
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	hasher := &countingHasher{PasswordHasher: newTestHasher(t)}
+	s := newSuite(t, auth.Config{Hasher: hasher, Lockout: auth.Lockout{Attempts: 2, Duration: time.Hour}})
+	ctx := context.Background()
+
+	s.register(t, "user@example.com")
+
+	tests := []struct {
+		name     string
+		email    string
+		password secret.Password
+		want     bool
+	}{
+		{"correct password", "User@example.com", testPassword, true},
+		{"incorrect password", "user@example.com", "Wr0ng!pass", false},
+		{"unknown email", "nobody@example.com", testPassword, false},
+	}
+
+	for _, tt := range tests {
+		before := hasher.count()
+
+		ok, err := s.auth.VerifyPassword(ctx, tt.email, tt.password)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if ok != tt.want {
+			t.Fatalf("%s: got %t, want %t", tt.name, ok, tt.want)
+		}
+
+		// Unknown emails are compared against a dummy hash, so they take as long as known ones.
+		if compares := hasher.count() - before; compares != 1 {
+			t.Fatalf("%s: %d comparisons, want 1", tt.name, compares)
+		}
+	}
+
+	// Wrong passwords count towards lockout like failed logins.
+	if ok, err := s.auth.VerifyPassword(ctx, "user@example.com", "Wr0ng!pass"); ok || err != nil {
+		t.Fatalf("second incorrect password: got %t, %v", ok, err)
+	}
+	if _, err := s.auth.VerifyPassword(ctx, "user@example.com", testPassword); !errors.Is(err, auth.ErrAccountLocked) {
+		t.Fatalf("correct password of locked account: got %v, want ErrAccountLocked", err)
+	}
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/storage"
+)
+
+// RefreshToken exchanges a refresh token for a new access token and a new refresh token.
+//
+// The given refresh token is consumed, so it can't be used again.
+// If refresh token doesn't exist, has expired or was issued for another app, returns error.
+// Returns ErrInvalidAppID if the app doesn't exist.
+func (a *Auth) RefreshToken(
+	ctx context.Context,
+	refreshToken string,
+	appID int,
+) (string, string, error) {
+	const op = "auth.RefreshToken"
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+	)
+
+	log.Info("Attempting to refresh token")
+
+	// The app is checked first, so a wrong app id doesn't burn the token.
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found")
+
+			return "", "", fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+		}
+
+		log.Error("failed to get app", "error", err)
+
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	tokenHash := hashToken(refreshToken)
+
+	stored, err := a.refreshStore.RefreshToken(ctx, tokenHash)
+	if err != nil {
+		if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+			log.Warn("refresh token not found")
+
+			return "", "", fmt.Errorf("%s: %w", op, ErrInvalidRefresh)
+		}
+
+		log.Error("failed to get refresh token", "error", err)
+
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if stored.AppID != appID {
+		log.Warn("refresh token belongs to another app", slog.Int("token_app_id", stored.AppID))
+
+		return "", "", fmt.Errorf("%s: %w", op, ErrInvalidRefresh)
+	}
+
+	// Consume the token before issuing new ones: if two requests race with
+	// the same token, only one of them manages to delete it.
+	if err := a.refreshStore.DeleteRefreshToken(ctx, tokenHash); err != nil {
+		if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+			log.Warn("refresh token already used")
+
+			return "", "", fmt.Errorf("%s: %w", op, ErrInvalidRefresh)
+		}
+
+		log.Error("failed to delete refresh token", "error", err)
+
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if a.clock.Now().After(stored.ExpiresAt) {
+		log.Warn("refresh token expired")
+
+		return "", "", fmt.Errorf("%s: %w", op, ErrInvalidRefresh)
+	}
+
+	user, err := a.usrProvider.UserByID(ctx, stored.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", "error", err)
+
+			return "", "", fmt.Errorf("%s: %w", op, ErrInvalidRefresh)
+		}
+
+		log.Error("failed to get user", "error", err)
+
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Scopes the user has lost since the login are dropped, the client can't
+	// ask for them again without logging in.
+	scopes, denied, err := a.grantScopes(ctx, user.ID, app.ID, stored.Scopes)
+	if err != nil {
+		log.Error("failed to grant scopes", "error", err)
+
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	if len(denied) > 0 {
+		log.Info("scopes are no longer allowed, they are dropped", slog.Any("scopes", denied))
+	}
+
+	token, _, err := a.newToken(ctx, user, app, scopes)
+	if err != nil {
+		log.Error("failed to generate token", "error", err)
+
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	// The session may have been revoked after the token was read.
+	if err := a.touchSession(ctx, stored.SessionID); err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			log.Warn("session revoked", slog.Int64("session_id", stored.SessionID))
+
+			return "", "", fmt.Errorf("%s: %w", op, ErrInvalidRefresh)
+		}
+
+		log.Error("failed to touch session", "error", err)
+
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	newRefreshToken, err := a.issueRefreshToken(ctx, user.ID, app.ID, stored.SessionID, scopes)
+	if err != nil {
+		log.Error("failed to issue refresh token", "error", err)
+
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("token refreshed", slog.Int64("user_id", user.ID))
+
+	return token, newRefreshToken, nil
+}
+
+// issueRefreshToken generates a new refresh token of the session with scopes
+// and saves its hash to storage.
+func (a *Auth) issueRefreshToken(ctx context.Context, userID int64, appID int, sessionID int64, scopes []string) (string, error) {
+	token, tokenHash, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = a.refreshStore.SaveRefreshToken(ctx, models.RefreshToken{
+		TokenHash: tokenHash,
+		UserID:    userID,
+		AppID:     appID,
+		SessionID: sessionID,
+		Scopes:    scopes,
+		ExpiresAt: a.clock.Now().Add(a.refreshTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/secret"
+	"sso/internal/storage"
+)
+
+// RequestPasswordReset sends a password reset token to the user with given email.
+//
+// If there is no such user, nothing is sent but no error is returned either,
+// so callers can't find out which emails are registered.
+func (a *Auth) RequestPasswordReset(ctx context.Context, email string) error {
+	const op = "auth.RequestPasswordReset"
+
+	email = a.normalizeEmail(email)
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.String("email", email),
+	)
+
+	log.Info("requesting password reset")
+
+	user, err := a.usrProvider.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found, reset is not sent")
+
+			return nil
+		}
+
+		log.Error("failed to get user", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	token, tokenHash, err := newOpaqueToken()
+	if err != nil {
+		log.Error("failed to generate reset token", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = a.oneTimeTokens.SaveOneTimeToken(ctx, models.OneTimeToken{
+		TokenHash: tokenHash,
+		UserID:    user.ID,
+		Purpose:   models.PurposePasswordReset,
+		ExpiresAt: a.clock.Now().Add(a.passwordResetTTL),
+	})
+	if err != nil {
+		log.Error("failed to save reset token", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.sender.SendPasswordReset(ctx, user.Email, token); err != nil {
+		log.Error("failed to send reset token", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("password reset sent")
+
+	return nil
+}
+
+// ResetPassword sets a new password for the user the reset token was issued for.
+//
+// Returns ErrInvalidOneTimeToken if the token doesn't exist, has expired or has already been used,
+// ErrPasswordTooLong if the password hasher can't hash the whole new password
+// and ErrPasswordReused if the new password is one of the last passwords of the user.
+// On success the account is unlocked and all sessions of the user are revoked.
+func (a *Auth) ResetPassword(ctx context.Context, token string, newPassword secret.Password) error {
+	const op = "auth.ResetPassword"
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+	)
+
+	log.Info("resetting password")
+
+	// Check the policy first, so a weak password doesn't burn the token.
+	if err := a.passwordPolicy.Validate(newPassword.Reveal()); err != nil {
+		log.Info("weak password", "error", err)
+
+		return fmt.Errorf("%s: %w: %w", op, ErrWeakPassword, err)
+	}
+
+	// The token is only looked up until the password is known to be acceptable,
+	// so a reused password doesn't burn it either.
+	user, err := a.resetTokenUser(ctx, token)
+	if err != nil {
+		if errors.Is(err, ErrInvalidOneTimeToken) {
+			log.Warn("invalid reset token")
+
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Error("failed to get user of reset token", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", user.ID))
+
+	if err := a.checkPasswordReuse(ctx, user, newPassword); err != nil {
+		if errors.Is(err, ErrPasswordReused) {
+			log.Info("password reused")
+
+			return fmt.Errorf("%s: %w", op, ErrPasswordReused)
+		}
+
+		log.Error("failed to check password history", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	stored, err := a.consumeOneTimeToken(ctx, token, models.PurposePasswordReset)
+	if err != nil {
+		if errors.Is(err, ErrInvalidOneTimeToken) {
+			log.Warn("invalid reset token")
+
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Error("failed to consume reset token", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	passHash, err := a.hashPassword(ctx, newPassword.Reveal())
+	if err != nil {
+		if errors.Is(err, ErrPasswordTooLong) {
+			log.Info("password is too long")
+
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Error("failed to hash password", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.usrSave.UpdatePassword(ctx, stored.UserID, passHash, a.clock.Now()); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", "error", err)
+
+			return fmt.Errorf("%s: %w", op, ErrInvalidOneTimeToken)
+		}
+
+		log.Error("failed to update password", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.rememberPassword(ctx, user); err != nil {
+		log.Error("failed to save password history", "error", err)
+	}
+
+	if err := a.usrProvider.ResetFailedLogin(ctx, stored.UserID); err != nil {
+		log.Error("failed to reset failed logins", "error", err)
+	}
+
+	if err := a.sessions.DeleteUserSessions(ctx, stored.UserID); err != nil {
+		log.Error("failed to revoke sessions", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("password reset")
+
+	return nil
+}
+
+// resetTokenUser returns the user the reset token was issued for, the token stays valid.
+func (a *Auth) resetTokenUser(ctx context.Context, token string) (models.User, error) {
+	stored, err := a.peekOneTimeToken(ctx, token, models.PurposePasswordReset)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	user, err := a.usrProvider.UserByID(ctx, stored.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.User{}, ErrInvalidOneTimeToken
+		}
+
+		return models.User{}, err
+	}
+
+	return user, nil
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+)
+
+// UserRoles returns roles of the user in the app.
+//
+// Roles are scoped per app, so the same user may be an admin
+// in one app and have no roles at all in another.
+func (a *Auth) UserRoles(ctx context.Context, userID int64, appID int) ([]string, error) {
+	const op = "auth.UserRoles"
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+		slog.Int("app_id", appID),
+	)
+
+	roles, err := a.roleProvider.UserRoles(ctx, userID, appID)
+	if err != nil {
+		log.Error("failed to get user roles", "error", err)
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return roles, nil
+}
+
+// HasRole checks if the user has role in the app.
+func (a *Auth) HasRole(ctx context.Context, userID int64, appID int, role string) (bool, error) {
+	const op = "auth.HasRole"
+
+	roles, err := a.UserRoles(ctx, userID, appID)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return slices.Contains(roles, role), nil
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"context"
+	"slices"
+)
+
+// AppScopes are scopes users can be granted in an app, see Login.
+type AppScopes struct {
+	// Scopes are granted to every user of the app.
+	Scopes []string
+	// Roles grant scopes to users with the role in the app, by role name.
+	Roles map[string][]string
+}
+
+// grantScopes splits requested scopes into the ones the user may have in the app
+// and the ones they may not, both in the order requested and without duplicates.
+// Scopes follow roles of the user, so a scope granted by a role is lost with it.
+func (a *Auth) grantScopes(ctx context.Context, userID int64, appID int, requested []string) (granted []string, denied []string, err error) {
+	if len(requested) == 0 {
+		return nil, nil, nil
+	}
+
+	app := a.scopes[appID]
+
+	allowed := make(map[string]bool, len(app.Scopes))
+	for _, s := range app.Scopes {
+		allowed[s] = true
+	}
+
+	if len(app.Roles) > 0 {
+		roles, err := a.roleProvider.UserRoles(ctx, userID, appID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, role := range roles {
+			for _, s := range app.Roles[role] {
+				allowed[s] = true
+			}
+		}
+	}
+
+	for _, s := range requested {
+		if slices.Contains(granted, s) || slices.Contains(denied, s) {
+			continue
+		}
+
+		if allowed[s] {
+			granted = append(granted, s)
+		} else {
+			denied = append(denied, s)
+		}
+	}
+
+	return granted, denied, nil
+}
@@ -0,0 +1,108 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sso/internal/lib/jwt"
+	"sso/internal/services/auth"
+	"testing"
+)
+
+// scopesSuite returns a suite whose app grants profile to every user and orders:write to admins,
+// with a user with the role and a user without it.
+func scopesSuite(t *testing.T, deny bool) (s *suite, appID int) {
+	t.Helper()
+
+	s = newSuite(t, auth.Config{})
+
+	appID = s.createApp(t, "app")
+	s.register(t, "user@example.com")
+	adminID := s.register(t, "admin@example.com")
+
+	if err := s.storage.AssignRole(context.Background(), adminID, appID, "admin"); err != nil {
+		t.Fatalf("assign role: %v", err)
+	}
+
+	s.configure(t, auth.Config{
+		Scopes: map[int]auth.AppScopes{
+			appID: {
+				Scopes: []string{"profile"},
+				Roles:  map[string][]string{"admin": {"orders:write"}},
+			},
+		},
+		DenyScopes: deny,
+	})
+
+	return s, appID
+}
+
+func TestLoginFiltersScopes(t *testing.T) {
+	s, appID := scopesSuite(t, false)
+	ctx := context.Background()
+
+	requested := []string{"orders:write", "profile", "unknown", "profile"}
+
+	tests := []struct {
+		email string
+		want  []string
+	}{
+		{"user@example.com", []string{"profile"}},
+		{"admin@example.com", []string{"orders:write", "profile"}},
+	}
+
+	for _, tt := range tests {
+		res, err := s.auth.Login(ctx, tt.email, testPassword, appID, "secret", requested)
+		if err != nil {
+			t.Fatalf("login of %s: %v", tt.email, err)
+		}
+
+		if !slices.Equal(res.Scopes, tt.want) {
+			t.Fatalf("%s got scopes %v, want %v", tt.email, res.Scopes, tt.want)
+		}
+
+		app, err := s.storage.App(ctx, appID)
+		if err != nil {
+			t.Fatalf("get app: %v", err)
+		}
+		claims, err := jwt.Parse(res.Token, jwt.Keys{Secret: func(int) (string, error) { return app.Secret, nil }}, jwt.Validation{Clock: s.clock})
+		if err != nil {
+			t.Fatalf("parse token: %v", err)
+		}
+		for _, scope := range []string{"profile", "orders:write", "unknown"} {
+			if got, want := jwt.HasScope(claims, scope), slices.Contains(tt.want, scope); got != want {
+				t.Errorf("token of %s has scope %s: %t, want %t", tt.email, scope, got, want)
+			}
+		}
+	}
+}
+
+func TestLoginWithoutScopes(t *testing.T) {
+	s, appID := scopesSuite(t, false)
+
+	res, err := s.auth.Login(context.Background(), "admin@example.com", testPassword, appID, "secret", nil)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if len(res.Scopes) != 0 {
+		t.Fatalf("got scopes %v without requesting any", res.Scopes)
+	}
+}
+
+func TestLoginDeniesScopes(t *testing.T) {
+	s, appID := scopesSuite(t, true)
+	ctx := context.Background()
+
+	_, err := s.auth.Login(ctx, "user@example.com", testPassword, appID, "secret", []string{"profile", "orders:write"})
+	if !errors.Is(err, auth.ErrScopeNotAllowed) {
+		t.Fatalf("scope of a role the user doesn't have: got %v, want ErrScopeNotAllowed", err)
+	}
+
+	res, err := s.auth.Login(ctx, "admin@example.com", testPassword, appID, "secret", []string{"profile", "orders:write"})
+	if err != nil {
+		t.Fatalf("login with allowed scopes: %v", err)
+	}
+	if !slices.Equal(res.Scopes, []string{"profile", "orders:write"}) {
+		t.Fatalf("got scopes %v, want profile and orders:write", res.Scopes)
+	}
+}
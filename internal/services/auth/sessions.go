@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/clientinfo"
+	"sso/internal/storage"
+	"time"
+)
+
+// ListSessions returns sessions of the user that haven't expired,
+// the most recently used first, e.g. to show where the user is logged in.
+func (a *Auth) ListSessions(ctx context.Context, userID int64) ([]models.Session, error) {
+	const op = "auth.ListSessions"
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+	)
+
+	sessions, err := a.sessions.Sessions(ctx, userID, a.clock.Now())
+	if err != nil {
+		log.Error("failed to list sessions", "error", err)
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession ends the session of the user, its refresh tokens can no longer be used.
+// Access tokens already issued for the session stay valid until they expire.
+//
+// Returns ErrSessionNotFound if the user has no such session.
+func (a *Auth) RevokeSession(ctx context.Context, userID int64, sessionID int64) error {
+	const op = "auth.RevokeSession"
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+		slog.Int64("session_id", sessionID),
+	)
+
+	if err := a.sessions.DeleteSession(ctx, userID, sessionID); err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			log.Warn("session not found")
+
+			return fmt.Errorf("%s: %w", op, ErrSessionNotFound)
+		}
+
+		log.Error("failed to revoke session", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("session revoked")
+
+	return nil
+}
+
+// LogoutAll logs the user out everywhere: all sessions of the user are ended
+// and all access tokens issued so far are revoked by bumping the token version
+// of the user, see ValidateToken.
+//
+// Returns ErrUserNotFound if the user doesn't exist.
+func (a *Auth) LogoutAll(ctx context.Context, userID int64) error {
+	const op = "auth.LogoutAll"
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+	)
+
+	if err := a.usrSave.IncrementTokenVersion(ctx, userID); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found")
+
+			return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+
+		log.Error("failed to bump token version", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.sessions.DeleteUserSessions(ctx, userID); err != nil {
+		log.Error("failed to revoke sessions", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("logged out everywhere")
+
+	return nil
+}
+
+// startSession records a login of the user from the client of the request.
+// accessExpiresAt is the expiry of the access token issued by the login,
+// the session lasts until it if refresh tokens are disabled.
+func (a *Auth) startSession(ctx context.Context, userID int64, appID int, accessExpiresAt time.Time) (int64, error) {
+	client := clientinfo.FromContext(ctx)
+	now := a.clock.Now()
+
+	expiresAt := accessExpiresAt
+	if a.refreshTTL > 0 {
+		expiresAt = now.Add(a.refreshTTL)
+	}
+
+	return a.sessions.SaveSession(ctx, models.Session{
+		UserID:     userID,
+		AppID:      appID,
+		IP:         client.IP,
+		UserAgent:  client.UserAgent,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  expiresAt,
+	})
+}
+
+// touchSession extends the session as its refresh token is used.
+// Refresh tokens issued before sessions have no session, there is nothing to touch.
+func (a *Auth) touchSession(ctx context.Context, sessionID int64) error {
+	if sessionID == 0 {
+		return nil
+	}
+
+	now := a.clock.Now()
+
+	return a.sessions.TouchSession(ctx, sessionID, now, now.Add(a.refreshTTL))
+}
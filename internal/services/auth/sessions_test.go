@@ -0,0 +1,44 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"sso/internal/services/auth"
+	"testing"
+)
+
+func TestLogoutAllInvalidatesTokens(t *testing.T) {
+	s := newSuite(t, auth.Config{})
+	ctx := context.Background()
+
+	appID := s.createApp(t, "app")
+	userID := s.register(t, "user@example.com")
+
+	var tokens []string
+	for i := 0; i < 2; i++ {
+		token, err := s.login(t, "user@example.com", testPassword, appID)
+		if err != nil {
+			t.Fatalf("login: %v", err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	if err := s.auth.LogoutAll(ctx, userID); err != nil {
+		t.Fatalf("logout all: %v", err)
+	}
+
+	for i, token := range tokens {
+		if _, _, _, err := s.auth.ValidateToken(ctx, token, appID); !errors.Is(err, auth.ErrInvalidToken) {
+			t.Errorf("token %d after LogoutAll: got %v, want ErrInvalidToken", i+1, err)
+		}
+	}
+
+	// Tokens issued afterwards are valid.
+	token, err := s.login(t, "user@example.com", testPassword, appID)
+	if err != nil {
+		t.Fatalf("login after LogoutAll: %v", err)
+	}
+	if _, _, _, err := s.auth.ValidateToken(ctx, token, appID); err != nil {
+		t.Fatalf("token issued after LogoutAll: %v", err)
+	}
+}
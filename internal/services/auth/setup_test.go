@@ -0,0 +1,197 @@
+package auth_test
+
+import (
+	"context"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"sso/internal/lib/password"
+	"sso/internal/lib/secret"
+	"sso/internal/services/auth"
+	"sso/internal/storage"
+	"sso/internal/storage/sqlite"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testPassword satisfies the default password policy.
+const testPassword secret.Password = "Passw0rd!x"
+
+// fakeClock is a clock set by the test.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	// Far from the system clock, so times taken from it instead of the fake one show up.
+	return &fakeClock{now: time.Date(2001, time.February, 3, 4, 5, 6, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// fakeSender keeps the last token sent of every kind.
+type fakeSender struct {
+	mu           sync.Mutex
+	verification string
+	reset        string
+	magicLink    string
+}
+
+func (s *fakeSender) SendVerification(_ context.Context, _ string, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.verification = token
+
+	return nil
+}
+
+func (s *fakeSender) SendPasswordReset(_ context.Context, _ string, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reset = token
+
+	return nil
+}
+
+func (s *fakeSender) SendMagicLink(_ context.Context, _ string, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.magicLink = token
+
+	return nil
+}
+
+// suite is the auth service on a fresh sqlite database.
+type suite struct {
+	auth    *auth.Auth
+	storage *sqlite.Storage
+	clock   *fakeClock
+	sender  *fakeSender
+}
+
+// newSuite creates the service with cfg, unset fields of which get defaults
+// suitable for tests, e.g. the cheapest bcrypt cost.
+func newSuite(t *testing.T, cfg auth.Config) *suite {
+	t.Helper()
+
+	st, err := sqlite.New(filepath.Join(t.TempDir(), "sso.db"), storage.PoolConfig{MaxOpenConns: 1}, storage.RetryConfig{})
+	if err != nil {
+		t.Fatalf("open storage: %v", err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+
+	if _, err := st.Migrate(context.Background()); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	s := &suite{storage: st, clock: newFakeClock(), sender: &fakeSender{}}
+	s.configure(t, cfg)
+
+	return s
+}
+
+// configure replaces the service of the suite with one created with cfg on the same
+// storage, e.g. to check how data written with the previous config is handled.
+func (s *suite) configure(t *testing.T, cfg auth.Config) {
+	t.Helper()
+
+	if cfg.Clock == nil {
+		cfg.Clock = s.clock
+	}
+	if cfg.TokenTTL == 0 {
+		cfg.TokenTTL = time.Hour
+	}
+	if cfg.Issuer == "" {
+		cfg.Issuer = "test"
+	}
+	if cfg.Hasher == nil {
+		cfg.Hasher = newTestHasher(t)
+	}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	s.auth = auth.New(log, storesOf(s.storage), s.sender, cfg)
+}
+
+// storesOf returns stores all kept in st.
+func storesOf(st *sqlite.Storage) auth.Stores {
+	return auth.Stores{
+		UserSaver:         st,
+		UserProvider:      st,
+		AppProvider:       st,
+		AppSaver:          st,
+		TokenRevoker:      st,
+		RefreshTokens:     st,
+		Sessions:          st,
+		OneTimeTokens:     st,
+		Identities:        st,
+		OAuthStates:       st,
+		Passkeys:          st,
+		PasskeyChallenges: st,
+		Roles:             st,
+		Tx:                st,
+	}
+}
+
+// newTestHasher returns a bcrypt hasher of the lowest cost, so tests run fast.
+func newTestHasher(t *testing.T) *password.Hasher {
+	t.Helper()
+
+	hasher, err := password.NewHasher(password.AlgBcrypt, bcrypt.MinCost, password.Argon2idParams{})
+	if err != nil {
+		t.Fatalf("create hasher: %v", err)
+	}
+
+	return hasher
+}
+
+// createApp creates an app with the secret "secret" and returns its id.
+func (s *suite) createApp(t *testing.T, name string) int {
+	t.Helper()
+
+	id, err := s.auth.CreateApp(context.Background(), name, "secret")
+	if err != nil {
+		t.Fatalf("create app: %v", err)
+	}
+
+	return id
+}
+
+// register registers a user with testPassword and returns its id.
+func (s *suite) register(t *testing.T, email string) int64 {
+	t.Helper()
+
+	id, _, err := s.auth.RegisterNewUser(context.Background(), email, testPassword, false)
+	if err != nil {
+		t.Fatalf("register %s: %v", email, err)
+	}
+
+	return int64(id)
+}
+
+// login logs the user in to the app with pass and returns the access token.
+func (s *suite) login(t *testing.T, email string, pass secret.Password, appID int) (string, error) {
+	t.Helper()
+
+	res, err := s.auth.Login(context.Background(), email, pass, appID, "secret", nil)
+
+	return res.Token, err
+}
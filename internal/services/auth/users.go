@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/storage"
+)
+
+// Page sizes of ListUsers.
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 500
+)
+
+// GetUser returns the user with given id, without the password hash.
+// LockedUntil is zero unless the account is locked now.
+//
+// Returns ErrUserNotFound if there is no such user.
+func (a *Auth) GetUser(ctx context.Context, userID int64) (models.User, error) {
+	const op = "auth.GetUser"
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+	)
+
+	user, err := a.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", "error", err)
+
+			return models.User{}, fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+
+		log.Error("failed to get user", "error", err)
+
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user.PassHash = nil
+	a.clearExpiredLock(&user)
+
+	return user, nil
+}
+
+// ListUsers returns a page of users ordered by id, so paging is stable.
+//
+// limit defaults to DefaultListLimit when zero and is capped to MaxListLimit.
+// Password hashes are never returned, LockedUntil is zero unless the account is locked now.
+func (a *Auth) ListUsers(ctx context.Context, limit int, offset int) ([]models.User, error) {
+	const op = "auth.ListUsers"
+
+	switch {
+	case limit <= 0:
+		limit = DefaultListLimit
+	case limit > MaxListLimit:
+		limit = MaxListLimit
+	}
+
+	offset = max(offset, 0)
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+		slog.Int("limit", limit),
+		slog.Int("offset", offset),
+	)
+
+	log.Info("listing users")
+
+	users, err := a.usrProvider.ListUsers(ctx, limit, offset)
+	if err != nil {
+		log.Error("failed to list users", "error", err)
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for i := range users {
+		users[i].PassHash = nil
+		a.clearExpiredLock(&users[i])
+	}
+
+	return users, nil
+}
+
+// CountUsers returns the total number of users, to be shown along pages of ListUsers.
+func (a *Auth) CountUsers(ctx context.Context) (int, error) {
+	const op = "auth.CountUsers"
+
+	count, err := a.usrProvider.CountUsers(ctx)
+	if err != nil {
+		a.logger(ctx).Error("failed to count users", slog.String("op", op), "error", err)
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/storage"
+)
+
+// VerifyEmail marks email of the user the token was issued for as verified.
+// Tokens issued by ChangeEmail replace the email of the user with the verified one.
+//
+// Returns ErrInvalidOneTimeToken if the token doesn't exist, has expired or has already been used.
+// Returns ErrUserExists if the new email has been taken since the change was requested.
+func (a *Auth) VerifyEmail(ctx context.Context, token string) error {
+	const op = "auth.VerifyEmail"
+
+	log := a.logger(ctx).With(
+		slog.String("op", op),
+	)
+
+	log.Info("verifying email")
+
+	stored, err := a.consumeOneTimeToken(ctx, token, models.PurposeEmailVerification)
+	if errors.Is(err, ErrInvalidOneTimeToken) {
+		stored, err = a.consumeOneTimeToken(ctx, token, models.PurposeEmailChange)
+	}
+	if err != nil {
+		if errors.Is(err, ErrInvalidOneTimeToken) {
+			log.Warn("invalid verification token")
+
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Error("failed to consume verification token", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if stored.Purpose == models.PurposeEmailChange {
+		if err := a.updateEmail(ctx, stored.UserID, stored.Email); err != nil {
+			if errors.Is(err, ErrUserNotFound) {
+				log.Warn("user not found", "error", err)
+
+				return fmt.Errorf("%s: %w", op, ErrInvalidOneTimeToken)
+			}
+
+			log.Warn("failed to change email", "error", err)
+
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Info("email changed", slog.Int64("user_id", stored.UserID))
+	}
+
+	if err := a.usrSave.MarkEmailVerified(ctx, stored.UserID); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", "error", err)
+
+			return fmt.Errorf("%s: %w", op, ErrInvalidOneTimeToken)
+		}
+
+		log.Error("failed to mark email verified", "error", err)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("email verified", slog.Int64("user_id", stored.UserID))
+
+	return nil
+}
+
+// sendVerification issues an email verification token and sends it to the user.
+func (a *Auth) sendVerification(ctx context.Context, userID int64, email string) error {
+	token, tokenHash, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	err = a.oneTimeTokens.SaveOneTimeToken(ctx, models.OneTimeToken{
+		TokenHash: tokenHash,
+		UserID:    userID,
+		Purpose:   models.PurposeEmailVerification,
+		ExpiresAt: a.clock.Now().Add(a.verificationTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.sender.SendVerification(ctx, email, token)
+}
+
+// consumeOneTimeToken uses up the token with given purpose.
+//
+// Returns ErrInvalidOneTimeToken if the token doesn't exist, has expired or has already been used.
+func (a *Auth) consumeOneTimeToken(ctx context.Context, token string, purpose string) (models.OneTimeToken, error) {
+	stored, err := a.oneTimeTokens.ConsumeOneTimeToken(ctx, hashToken(token), purpose)
+	if err != nil {
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			return models.OneTimeToken{}, ErrInvalidOneTimeToken
+		}
+
+		return models.OneTimeToken{}, err
+	}
+
+	if a.clock.Now().After(stored.ExpiresAt) {
+		return models.OneTimeToken{}, ErrInvalidOneTimeToken
+	}
+
+	return stored, nil
+}
+
+// peekOneTimeToken checks the token like consumeOneTimeToken without using it up.
+func (a *Auth) peekOneTimeToken(ctx context.Context, token string, purpose string) (models.OneTimeToken, error) {
+	stored, err := a.oneTimeTokens.OneTimeToken(ctx, hashToken(token), purpose)
+	if err != nil {
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			return models.OneTimeToken{}, ErrInvalidOneTimeToken
+		}
+
+		return models.OneTimeToken{}, err
+	}
+
+	if a.clock.Now().After(stored.ExpiresAt) {
+		return models.OneTimeToken{}, ErrInvalidOneTimeToken
+	}
+
+	return stored, nil
+}
@@ -0,0 +1,164 @@
+package appcache
+
+import (
+	"context"
+	"sso/internal/domain/models"
+	"sso/internal/lib/metrics"
+	"sync"
+	"time"
+)
+
+// Storage keeps apps, Cache is put in front of it.
+type Storage interface {
+	App(ctx context.Context, appID int) (models.App, error)
+	SaveApp(ctx context.Context, app models.App) (int, error)
+	DeleteApp(ctx context.Context, appID int) error
+}
+
+// Cache keeps apps read from Storage in memory for a while,
+// as every login reads the app and apps rarely change.
+//
+// Apps deleted through the cache are dropped from it right away, apps deleted
+// by other instances are served until their entries expire. Apps that don't
+// exist are not cached, so requests with random app ids don't fill the cache.
+// It is safe for concurrent use.
+type Cache struct {
+	Storage
+
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[int]entry
+	// invalidations counts Invalidate calls, so an app read from the storage
+	// while it was being deleted isn't cached, see put.
+	invalidations uint64
+	metrics       *metrics.Metrics
+
+	// Now returns current time. It can be replaced in tests.
+	Now func() time.Time
+}
+
+type entry struct {
+	app       models.App
+	expiresAt time.Time
+}
+
+// New returns a cache of up to size apps from storage, which are kept for ttl.
+// Hits and misses are counted in m, it may be nil.
+func New(storage Storage, ttl time.Duration, size int, m *metrics.Metrics) *Cache {
+	return &Cache{
+		Storage: storage,
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[int]entry, size),
+		metrics: m,
+		Now:     time.Now,
+	}
+}
+
+// App returns the app with given id from the cache, or reads it from the storage.
+func (c *Cache) App(ctx context.Context, appID int) (models.App, error) {
+	app, ok, invalidations := c.get(appID)
+	if ok {
+		c.count("hit")
+
+		return app, nil
+	}
+
+	c.count("miss")
+
+	app, err := c.Storage.App(ctx, appID)
+	if err != nil {
+		return models.App{}, err
+	}
+
+	c.put(app, invalidations)
+
+	return app, nil
+}
+
+// DeleteApp deletes the app from the storage and the cache.
+func (c *Cache) DeleteApp(ctx context.Context, appID int) error {
+	// The app is dropped even if deletion fails, it may have been deleted anyway.
+	defer c.Invalidate(appID)
+
+	return c.Storage.DeleteApp(ctx, appID)
+}
+
+// Invalidate drops the app with given id from the cache, so it is read from the storage next time.
+func (c *Cache) Invalidate(appID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, appID)
+	c.invalidations++
+}
+
+// get returns the cached app and the number of invalidations so far, see put.
+func (c *Cache) get(appID int) (models.App, bool, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[appID]
+	if !ok {
+		return models.App{}, false, c.invalidations
+	}
+
+	if !c.Now().Before(e.expiresAt) {
+		delete(c.entries, appID)
+
+		return models.App{}, false, c.invalidations
+	}
+
+	return e.app, true, c.invalidations
+}
+
+// put caches the app unless the cache has been invalidated since it was read,
+// invalidations is the count get returned before the read.
+func (c *Cache) put(app models.App, invalidations uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.invalidations != invalidations {
+		return
+	}
+
+	now := c.Now()
+
+	if _, ok := c.entries[app.ID]; !ok && len(c.entries) >= c.size {
+		c.evict(now)
+	}
+
+	c.entries[app.ID] = entry{
+		app:       app,
+		expiresAt: now.Add(c.ttl),
+	}
+}
+
+// evict makes room for a new entry: it drops expired entries,
+// or a random one if none has expired.
+func (c *Cache) evict(now time.Time) {
+	for id, e := range c.entries {
+		if !now.Before(e.expiresAt) {
+			delete(c.entries, id)
+		}
+	}
+
+	if len(c.entries) < c.size {
+		return
+	}
+
+	for id := range c.entries {
+		delete(c.entries, id)
+
+		return
+	}
+}
+
+func (c *Cache) count(result string) {
+	if c.metrics == nil {
+		return
+	}
+
+	c.metrics.AppCache.WithLabelValues(result).Inc()
+}
@@ -0,0 +1,130 @@
+package appcache
+
+import (
+	"context"
+	"errors"
+	"sso/internal/domain/models"
+	"sso/internal/storage"
+	"testing"
+	"time"
+)
+
+// fakeStorage serves apps from a map and counts reads.
+type fakeStorage struct {
+	apps  map[int]models.App
+	reads int
+}
+
+func (s *fakeStorage) App(_ context.Context, appID int) (models.App, error) {
+	s.reads++
+
+	app, ok := s.apps[appID]
+	if !ok {
+		return models.App{}, storage.ErrAppNotFound
+	}
+
+	return app, nil
+}
+
+func (s *fakeStorage) SaveApp(_ context.Context, app models.App) (int, error) {
+	s.apps[app.ID] = app
+
+	return app.ID, nil
+}
+
+func (s *fakeStorage) DeleteApp(_ context.Context, appID int) error {
+	delete(s.apps, appID)
+
+	return nil
+}
+
+// newTestCache returns a cache of st keeping apps for a minute on a clock the test moves with the returned func.
+func newTestCache(st Storage) (*Cache, func(d time.Duration)) {
+	now := time.Now()
+
+	c := New(st, time.Minute, 10, nil)
+	c.Now = func() time.Time { return now }
+
+	return c, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestCacheExpiry(t *testing.T) {
+	st := &fakeStorage{apps: map[int]models.App{1: {ID: 1, Name: "app"}}}
+	c, advance := newTestCache(st)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.App(ctx, 1); err != nil {
+			t.Fatalf("app: %v", err)
+		}
+	}
+	if st.reads != 1 {
+		t.Fatalf("storage read %d times, want once", st.reads)
+	}
+
+	// Changes of other instances are seen once the entry expires.
+	st.apps[1] = models.App{ID: 1, Name: "renamed"}
+
+	advance(59 * time.Second)
+	if app, _ := c.App(ctx, 1); app.Name != "app" {
+		t.Fatalf("got %q before the entry expired, want the cached app", app.Name)
+	}
+
+	advance(time.Second)
+	if app, _ := c.App(ctx, 1); app.Name != "renamed" {
+		t.Fatalf("got %q after the entry expired, want the app from the storage", app.Name)
+	}
+	if st.reads != 2 {
+		t.Fatalf("storage read %d times, want twice", st.reads)
+	}
+}
+
+func TestCacheDeleteApp(t *testing.T) {
+	st := &fakeStorage{apps: map[int]models.App{1: {ID: 1}}}
+	c, _ := newTestCache(st)
+	ctx := context.Background()
+
+	if _, err := c.App(ctx, 1); err != nil {
+		t.Fatalf("app: %v", err)
+	}
+
+	if err := c.DeleteApp(ctx, 1); err != nil {
+		t.Fatalf("delete app: %v", err)
+	}
+
+	if _, err := c.App(ctx, 1); !errors.Is(err, storage.ErrAppNotFound) {
+		t.Fatalf("deleted app: got %v, want ErrAppNotFound", err)
+	}
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	st := &fakeStorage{apps: map[int]models.App{1: {ID: 1, Name: "app"}}}
+	c, _ := newTestCache(st)
+	ctx := context.Background()
+
+	if _, err := c.App(ctx, 1); err != nil {
+		t.Fatalf("app: %v", err)
+	}
+
+	st.apps[1] = models.App{ID: 1, Name: "renamed"}
+	c.Invalidate(1)
+
+	if app, _ := c.App(ctx, 1); app.Name != "renamed" {
+		t.Fatalf("got %q after invalidation, want the app from the storage", app.Name)
+	}
+}
+
+func TestCacheSkipsMissingApps(t *testing.T) {
+	st := &fakeStorage{apps: map[int]models.App{}}
+	c, _ := newTestCache(st)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.App(ctx, 1); !errors.Is(err, storage.ErrAppNotFound) {
+			t.Fatalf("got %v, want ErrAppNotFound", err)
+		}
+	}
+	if st.reads != 2 {
+		t.Fatalf("storage read %d times, want twice: missing apps must not be cached", st.reads)
+	}
+}
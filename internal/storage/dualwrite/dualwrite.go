@@ -0,0 +1,534 @@
+// Package dualwrite writes to two storages at once, so data can be moved
+// from one backend to another, e.g. from sqlite to postgres, without downtime.
+package dualwrite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/services/auth"
+	"sso/internal/storage"
+	"time"
+)
+
+// ErrSecondaryWrite is returned in strict mode if a write to the secondary storage failed
+// after it succeeded on the primary one.
+var ErrSecondaryWrite = errors.New("secondary storage write failed")
+
+// secondaryTimeout bounds a write to the secondary storage. Writes are detached
+// from cancellation of the request, as the primary storage already has the data.
+const secondaryTimeout = 5 * time.Second
+
+// Storage is a storage backend, see Composite.
+type Storage interface {
+	auth.UserSaver
+	auth.UserProvider
+	auth.AppProvider
+	auth.AppSaver
+	auth.TokenRevoker
+	auth.RefreshTokenStore
+	auth.SessionStore
+	auth.OneTimeTokenStore
+	auth.IdentityStore
+	auth.OAuthStateStore
+	auth.PasskeyStore
+	auth.PasskeyChallengeStore
+	auth.RoleProvider
+	auth.Transactor
+	Ping(ctx context.Context) error
+	Migrate(ctx context.Context) ([]int, error)
+	SetAdmin(ctx context.Context, userID int64, isAdmin bool) error
+	HasAdmin(ctx context.Context) (bool, error)
+	Close() error
+}
+
+// Secondary is a storage which can mirror another one: rows with ids generated
+// by the primary storage are saved with the same ids, so both storages agree on them.
+type Secondary interface {
+	Storage
+	SaveUserWithID(ctx context.Context, id int64, email string, passHash []byte, verified bool, passwordChangedAt time.Time) error
+	SaveAppWithID(ctx context.Context, app models.App) error
+	SaveSessionWithID(ctx context.Context, session models.Session) error
+}
+
+// Composite reads from the primary storage and writes to both the primary
+// and the secondary one. A write goes to the secondary storage only after
+// it has succeeded on the primary one, writes in a transaction only after
+// the transaction has been committed, see WithTx.
+//
+// Rows that are missing in the secondary storage, e.g. because it hasn't been
+// backfilled yet, are logged and skipped. Other failures of the secondary storage
+// are logged and, in strict mode, fail the request with ErrSecondaryWrite,
+// though the primary storage keeps the write.
+type Composite struct {
+	Storage
+
+	secondary Secondary
+	log       *slog.Logger
+	strict    bool
+}
+
+// New returns a storage writing to both primary and secondary.
+func New(log *slog.Logger, primary Storage, secondary Secondary, strict bool) *Composite {
+	return &Composite{
+		Storage:   primary,
+		secondary: secondary,
+		log:       log,
+		strict:    strict,
+	}
+}
+
+// queueKey keys the writes to the secondary storage queued during a transaction, see WithTx.
+type queueKey struct {
+	c *Composite
+}
+
+type queue struct {
+	writes []write
+}
+
+type write struct {
+	op string
+	fn func(ctx context.Context) error
+}
+
+// mirror runs fn, a write to the secondary storage, or queues it
+// if ctx carries a transaction of the primary storage.
+func (c *Composite) mirror(ctx context.Context, op string, fn func(ctx context.Context) error) error {
+	if q, ok := ctx.Value(queueKey{c}).(*queue); ok {
+		q.writes = append(q.writes, write{op: op, fn: fn})
+
+		return nil
+	}
+
+	return c.run(ctx, write{op: op, fn: fn})
+}
+
+// run applies w to the secondary storage, the error is returned only in strict mode.
+func (c *Composite) run(ctx context.Context, w write) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), secondaryTimeout)
+	defer cancel()
+
+	err := w.fn(ctx)
+	if err == nil {
+		return nil
+	}
+
+	log := c.log.With(
+		slog.String("op", w.op),
+		slog.String("error", err.Error()),
+	)
+
+	if isNotFound(err) {
+		log.Warn("row is missing in secondary storage, skipping write")
+
+		return nil
+	}
+
+	log.Error("failed to write to secondary storage")
+
+	if c.strict {
+		// The cause isn't wrapped, so its sentinel errors aren't mistaken
+		// for the result of the primary write.
+		return fmt.Errorf("%s: %w: %v", w.op, ErrSecondaryWrite, err)
+	}
+
+	return nil
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, storage.ErrUserNotFound) ||
+		errors.Is(err, storage.ErrAppNotFound) ||
+		errors.Is(err, storage.ErrSessionNotFound) ||
+		errors.Is(err, storage.ErrTokenNotFound) ||
+		errors.Is(err, storage.ErrRefreshTokenNotFound) ||
+		errors.Is(err, storage.ErrOAuthStateNotFound) ||
+		errors.Is(err, storage.ErrPasskeyNotFound) ||
+		errors.Is(err, storage.ErrPasskeyChallengeNotFound)
+}
+
+// WithTx runs fn in a transaction of the primary storage. Writes to the secondary
+// storage made by fn are queued and applied once the transaction is committed,
+// so a rolled back transaction leaves no trace in either storage.
+func (c *Composite) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(queueKey{c}).(*queue); ok {
+		return c.Storage.WithTx(ctx, fn)
+	}
+
+	q := &queue{}
+	if err := c.Storage.WithTx(context.WithValue(ctx, queueKey{c}, q), fn); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, w := range q.writes {
+		if err := c.run(ctx, w); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Migrate migrates both storages and returns migrations applied to the primary one.
+func (c *Composite) Migrate(ctx context.Context) ([]int, error) {
+	applied, err := c.Storage.Migrate(ctx)
+	if err != nil {
+		return applied, err
+	}
+
+	if _, err := c.secondary.Migrate(ctx); err != nil {
+		return applied, fmt.Errorf("dualwrite.Migrate: secondary: %w", err)
+	}
+
+	return applied, nil
+}
+
+// Ping checks the primary storage, and in strict mode the secondary one as well,
+// as requests fail without it.
+func (c *Composite) Ping(ctx context.Context) error {
+	if err := c.Storage.Ping(ctx); err != nil {
+		return err
+	}
+
+	if c.strict {
+		if err := c.secondary.Ping(ctx); err != nil {
+			return fmt.Errorf("dualwrite.Ping: secondary: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Close closes both storages.
+func (c *Composite) Close() error {
+	return errors.Join(c.Storage.Close(), c.secondary.Close())
+}
+
+func (c *Composite) SaveUser(ctx context.Context, email string, passHash []byte, verified bool, passwordChangedAt time.Time) (int64, error) {
+	id, err := c.Storage.SaveUser(ctx, email, passHash, verified, passwordChangedAt)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, c.mirror(ctx, "dualwrite.SaveUser", func(ctx context.Context) error {
+		return c.secondary.SaveUserWithID(ctx, id, email, passHash, verified, passwordChangedAt)
+	})
+}
+
+func (c *Composite) UpdatePassword(ctx context.Context, userID int64, passHash []byte, changedAt time.Time) error {
+	if err := c.Storage.UpdatePassword(ctx, userID, passHash, changedAt); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.UpdatePassword", func(ctx context.Context) error {
+		return c.secondary.UpdatePassword(ctx, userID, passHash, changedAt)
+	})
+}
+
+func (c *Composite) ReplacePasswordHash(ctx context.Context, userID int64, oldHash []byte, newHash []byte) error {
+	if err := c.Storage.ReplacePasswordHash(ctx, userID, oldHash, newHash); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.ReplacePasswordHash", func(ctx context.Context) error {
+		return c.secondary.ReplacePasswordHash(ctx, userID, oldHash, newHash)
+	})
+}
+
+func (c *Composite) MarkEmailVerified(ctx context.Context, userID int64) error {
+	if err := c.Storage.MarkEmailVerified(ctx, userID); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.MarkEmailVerified", func(ctx context.Context) error {
+		return c.secondary.MarkEmailVerified(ctx, userID)
+	})
+}
+
+func (c *Composite) UpdateEmail(ctx context.Context, userID int64, email string) error {
+	if err := c.Storage.UpdateEmail(ctx, userID, email); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.UpdateEmail", func(ctx context.Context) error {
+		return c.secondary.UpdateEmail(ctx, userID, email)
+	})
+}
+
+func (c *Composite) DeleteUser(ctx context.Context, userID int64) error {
+	if err := c.Storage.DeleteUser(ctx, userID); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.DeleteUser", func(ctx context.Context) error {
+		return c.secondary.DeleteUser(ctx, userID)
+	})
+}
+
+func (c *Composite) SoftDeleteUser(ctx context.Context, userID int64, deletedAt time.Time) error {
+	if err := c.Storage.SoftDeleteUser(ctx, userID, deletedAt); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.SoftDeleteUser", func(ctx context.Context) error {
+		return c.secondary.SoftDeleteUser(ctx, userID, deletedAt)
+	})
+}
+
+func (c *Composite) IncrementTokenVersion(ctx context.Context, userID int64) error {
+	if err := c.Storage.IncrementTokenVersion(ctx, userID); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.IncrementTokenVersion", func(ctx context.Context) error {
+		return c.secondary.IncrementTokenVersion(ctx, userID)
+	})
+}
+
+func (c *Composite) AddPasswordHistory(ctx context.Context, userID int64, passHash []byte, keep int, addedAt time.Time) error {
+	if err := c.Storage.AddPasswordHistory(ctx, userID, passHash, keep, addedAt); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.AddPasswordHistory", func(ctx context.Context) error {
+		return c.secondary.AddPasswordHistory(ctx, userID, passHash, keep, addedAt)
+	})
+}
+
+func (c *Composite) IncrementFailedLogin(ctx context.Context, userID int64, lockAfter int, lockUntil time.Time) error {
+	if err := c.Storage.IncrementFailedLogin(ctx, userID, lockAfter, lockUntil); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.IncrementFailedLogin", func(ctx context.Context) error {
+		return c.secondary.IncrementFailedLogin(ctx, userID, lockAfter, lockUntil)
+	})
+}
+
+func (c *Composite) ResetFailedLogin(ctx context.Context, userID int64) error {
+	if err := c.Storage.ResetFailedLogin(ctx, userID); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.ResetFailedLogin", func(ctx context.Context) error {
+		return c.secondary.ResetFailedLogin(ctx, userID)
+	})
+}
+
+func (c *Composite) SaveApp(ctx context.Context, app models.App) (int, error) {
+	id, err := c.Storage.SaveApp(ctx, app)
+	if err != nil {
+		return 0, err
+	}
+
+	app.ID = id
+
+	return id, c.mirror(ctx, "dualwrite.SaveApp", func(ctx context.Context) error {
+		return c.secondary.SaveAppWithID(ctx, app)
+	})
+}
+
+func (c *Composite) DeleteApp(ctx context.Context, appID int) error {
+	if err := c.Storage.DeleteApp(ctx, appID); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.DeleteApp", func(ctx context.Context) error {
+		return c.secondary.DeleteApp(ctx, appID)
+	})
+}
+
+func (c *Composite) RevokeToken(ctx context.Context, tokenID string, userID int64, expiresAt time.Time) error {
+	if err := c.Storage.RevokeToken(ctx, tokenID, userID, expiresAt); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.RevokeToken", func(ctx context.Context) error {
+		return c.secondary.RevokeToken(ctx, tokenID, userID, expiresAt)
+	})
+}
+
+func (c *Composite) SaveRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	if err := c.Storage.SaveRefreshToken(ctx, token); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.SaveRefreshToken", func(ctx context.Context) error {
+		return c.secondary.SaveRefreshToken(ctx, token)
+	})
+}
+
+func (c *Composite) DeleteRefreshToken(ctx context.Context, tokenHash string) error {
+	if err := c.Storage.DeleteRefreshToken(ctx, tokenHash); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.DeleteRefreshToken", func(ctx context.Context) error {
+		return c.secondary.DeleteRefreshToken(ctx, tokenHash)
+	})
+}
+
+func (c *Composite) SaveSession(ctx context.Context, session models.Session) (int64, error) {
+	id, err := c.Storage.SaveSession(ctx, session)
+	if err != nil {
+		return 0, err
+	}
+
+	session.ID = id
+
+	return id, c.mirror(ctx, "dualwrite.SaveSession", func(ctx context.Context) error {
+		return c.secondary.SaveSessionWithID(ctx, session)
+	})
+}
+
+func (c *Composite) TouchSession(ctx context.Context, sessionID int64, usedAt time.Time, expiresAt time.Time) error {
+	if err := c.Storage.TouchSession(ctx, sessionID, usedAt, expiresAt); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.TouchSession", func(ctx context.Context) error {
+		return c.secondary.TouchSession(ctx, sessionID, usedAt, expiresAt)
+	})
+}
+
+func (c *Composite) DeleteSession(ctx context.Context, userID int64, sessionID int64) error {
+	if err := c.Storage.DeleteSession(ctx, userID, sessionID); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.DeleteSession", func(ctx context.Context) error {
+		return c.secondary.DeleteSession(ctx, userID, sessionID)
+	})
+}
+
+func (c *Composite) DeleteUserSessions(ctx context.Context, userID int64) error {
+	if err := c.Storage.DeleteUserSessions(ctx, userID); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.DeleteUserSessions", func(ctx context.Context) error {
+		return c.secondary.DeleteUserSessions(ctx, userID)
+	})
+}
+
+func (c *Composite) SaveOneTimeToken(ctx context.Context, token models.OneTimeToken) error {
+	if err := c.Storage.SaveOneTimeToken(ctx, token); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.SaveOneTimeToken", func(ctx context.Context) error {
+		return c.secondary.SaveOneTimeToken(ctx, token)
+	})
+}
+
+func (c *Composite) ConsumeOneTimeToken(ctx context.Context, tokenHash string, purpose string) (models.OneTimeToken, error) {
+	token, err := c.Storage.ConsumeOneTimeToken(ctx, tokenHash, purpose)
+	if err != nil {
+		return models.OneTimeToken{}, err
+	}
+
+	return token, c.mirror(ctx, "dualwrite.ConsumeOneTimeToken", func(ctx context.Context) error {
+		_, err := c.secondary.ConsumeOneTimeToken(ctx, tokenHash, purpose)
+
+		return err
+	})
+}
+
+func (c *Composite) LinkIdentity(ctx context.Context, userID int64, identity models.Identity, linkedAt time.Time) error {
+	if err := c.Storage.LinkIdentity(ctx, userID, identity, linkedAt); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.LinkIdentity", func(ctx context.Context) error {
+		return c.secondary.LinkIdentity(ctx, userID, identity, linkedAt)
+	})
+}
+
+func (c *Composite) SaveOAuthState(ctx context.Context, state models.OAuthState, now time.Time) error {
+	if err := c.Storage.SaveOAuthState(ctx, state, now); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.SaveOAuthState", func(ctx context.Context) error {
+		return c.secondary.SaveOAuthState(ctx, state, now)
+	})
+}
+
+func (c *Composite) ConsumeOAuthState(ctx context.Context, stateHash string) (models.OAuthState, error) {
+	state, err := c.Storage.ConsumeOAuthState(ctx, stateHash)
+	if err != nil {
+		return models.OAuthState{}, err
+	}
+
+	return state, c.mirror(ctx, "dualwrite.ConsumeOAuthState", func(ctx context.Context) error {
+		_, err := c.secondary.ConsumeOAuthState(ctx, stateHash)
+
+		return err
+	})
+}
+
+func (c *Composite) SavePasskey(ctx context.Context, passkey models.Passkey) error {
+	if err := c.Storage.SavePasskey(ctx, passkey); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.SavePasskey", func(ctx context.Context) error {
+		return c.secondary.SavePasskey(ctx, passkey)
+	})
+}
+
+func (c *Composite) TouchPasskey(ctx context.Context, id []byte, signCount uint32, backupState bool, usedAt time.Time) error {
+	if err := c.Storage.TouchPasskey(ctx, id, signCount, backupState, usedAt); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.TouchPasskey", func(ctx context.Context) error {
+		return c.secondary.TouchPasskey(ctx, id, signCount, backupState, usedAt)
+	})
+}
+
+func (c *Composite) SavePasskeyChallenge(ctx context.Context, challenge models.PasskeyChallenge, now time.Time) error {
+	if err := c.Storage.SavePasskeyChallenge(ctx, challenge, now); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.SavePasskeyChallenge", func(ctx context.Context) error {
+		return c.secondary.SavePasskeyChallenge(ctx, challenge, now)
+	})
+}
+
+func (c *Composite) ConsumePasskeyChallenge(ctx context.Context, idHash string, purpose string) (models.PasskeyChallenge, error) {
+	challenge, err := c.Storage.ConsumePasskeyChallenge(ctx, idHash, purpose)
+	if err != nil {
+		return models.PasskeyChallenge{}, err
+	}
+
+	return challenge, c.mirror(ctx, "dualwrite.ConsumePasskeyChallenge", func(ctx context.Context) error {
+		_, err := c.secondary.ConsumePasskeyChallenge(ctx, idHash, purpose)
+
+		return err
+	})
+}
+
+func (c *Composite) AssignRole(ctx context.Context, userID int64, appID int, role string) error {
+	if err := c.Storage.AssignRole(ctx, userID, appID, role); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.AssignRole", func(ctx context.Context) error {
+		return c.secondary.AssignRole(ctx, userID, appID, role)
+	})
+}
+
+func (c *Composite) SetAdmin(ctx context.Context, userID int64, isAdmin bool) error {
+	if err := c.Storage.SetAdmin(ctx, userID, isAdmin); err != nil {
+		return err
+	}
+
+	return c.mirror(ctx, "dualwrite.SetAdmin", func(ctx context.Context) error {
+		return c.secondary.SetAdmin(ctx, userID, isAdmin)
+	})
+}
@@ -0,0 +1,172 @@
+package dualwrite_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"sso/internal/storage"
+	"sso/internal/storage/dualwrite"
+	"sso/internal/storage/sqlite"
+	"testing"
+	"time"
+)
+
+func newSQLite(t *testing.T, name string) *sqlite.Storage {
+	t.Helper()
+
+	st, err := sqlite.New(filepath.Join(t.TempDir(), name), storage.PoolConfig{MaxOpenConns: 1}, storage.RetryConfig{})
+	if err != nil {
+		t.Fatalf("open %s: %v", name, err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+
+	if _, err := st.Migrate(context.Background()); err != nil {
+		t.Fatalf("migrate %s: %v", name, err)
+	}
+
+	return st
+}
+
+// failingSecondary fails password updates, e.g. as if the connection dropped.
+type failingSecondary struct {
+	*sqlite.Storage
+}
+
+var errConnection = errors.New("connection reset")
+
+func (failingSecondary) UpdatePassword(context.Context, int64, []byte, time.Time) error {
+	return errConnection
+}
+
+func newComposite(t *testing.T, secondary dualwrite.Secondary, strict bool) (*dualwrite.Composite, *sqlite.Storage) {
+	t.Helper()
+
+	primary := newSQLite(t, "primary.db")
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	return dualwrite.New(log, primary, secondary, strict), primary
+}
+
+func TestCompositeMirrorsWrites(t *testing.T) {
+	secondary := newSQLite(t, "secondary.db")
+	c, primary := newComposite(t, secondary, true)
+	ctx := context.Background()
+
+	id, err := c.SaveUser(ctx, "user@example.com", []byte("hash"), false, time.Now())
+	if err != nil {
+		t.Fatalf("save user: %v", err)
+	}
+	if err := c.UpdatePassword(ctx, id, []byte("new hash"), time.Now()); err != nil {
+		t.Fatalf("update password: %v", err)
+	}
+
+	for name, st := range map[string]*sqlite.Storage{"primary": primary, "secondary": secondary} {
+		user, err := st.UserByID(ctx, id)
+		if err != nil {
+			t.Fatalf("user %d in %s: %v", id, name, err)
+		}
+		if !bytes.Equal(user.PassHash, []byte("new hash")) {
+			t.Errorf("%s has hash %q, want the updated one", name, user.PassHash)
+		}
+	}
+}
+
+func TestCompositeSecondaryFailure(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		secondary := failingSecondary{newSQLite(t, "secondary.db")}
+		c, primary := newComposite(t, secondary, strict)
+		ctx := context.Background()
+
+		id, err := c.SaveUser(ctx, "user@example.com", []byte("hash"), false, time.Now())
+		if err != nil {
+			t.Fatalf("strict %t: save user: %v", strict, err)
+		}
+
+		err = c.UpdatePassword(ctx, id, []byte("new hash"), time.Now())
+		switch {
+		case strict && !errors.Is(err, dualwrite.ErrSecondaryWrite):
+			t.Fatalf("strict: got %v, want ErrSecondaryWrite", err)
+		case !strict && err != nil:
+			t.Fatalf("not strict: got %v, want the failure only logged", err)
+		}
+		// The cause is reported, but not as the result of the primary write.
+		if errors.Is(err, errConnection) {
+			t.Fatalf("strict %t: the error of the secondary is wrapped", strict)
+		}
+
+		// Either way the primary keeps the write.
+		user, err := primary.UserByID(ctx, id)
+		if err != nil {
+			t.Fatalf("strict %t: get user: %v", strict, err)
+		}
+		if !bytes.Equal(user.PassHash, []byte("new hash")) {
+			t.Fatalf("strict %t: primary has hash %q, want the updated one", strict, user.PassHash)
+		}
+	}
+}
+
+func TestCompositeSkipsRowsMissingInSecondary(t *testing.T) {
+	secondary := newSQLite(t, "secondary.db")
+	c, primary := newComposite(t, secondary, true)
+	ctx := context.Background()
+
+	// Saved before dual writes started, so the secondary doesn't have it yet.
+	id, err := primary.SaveUser(ctx, "user@example.com", []byte("hash"), false, time.Now())
+	if err != nil {
+		t.Fatalf("save user: %v", err)
+	}
+
+	if err := c.MarkEmailVerified(ctx, id); err != nil {
+		t.Fatalf("write of a row missing in the secondary failed in strict mode: %v", err)
+	}
+}
+
+func TestCompositeWithTx(t *testing.T) {
+	secondary := newSQLite(t, "secondary.db")
+	c, primary := newComposite(t, secondary, true)
+	ctx := context.Background()
+
+	errRollback := errors.New("rollback")
+
+	err := c.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := c.SaveUser(ctx, "rolled-back@example.com", []byte("hash"), false, time.Now()); err != nil {
+			return err
+		}
+
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("got %v, want the error of fn", err)
+	}
+
+	for name, st := range map[string]*sqlite.Storage{"primary": primary, "secondary": secondary} {
+		if _, err := st.User(ctx, "rolled-back@example.com"); !errors.Is(err, storage.ErrUserNotFound) {
+			t.Errorf("user of rolled back transaction in %s: got %v, want ErrUserNotFound", name, err)
+		}
+	}
+
+	var id int64
+	err = c.WithTx(ctx, func(ctx context.Context) error {
+		id, err = c.SaveUser(ctx, "user@example.com", []byte("hash"), false, time.Now())
+		if err != nil {
+			return err
+		}
+
+		// The write to the secondary waits for the commit.
+		if _, err := secondary.UserByID(ctx, id); !errors.Is(err, storage.ErrUserNotFound) {
+			t.Errorf("user in secondary before commit: got %v, want ErrUserNotFound", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("transaction: %v", err)
+	}
+
+	if _, err := secondary.UserByID(ctx, id); err != nil {
+		t.Fatalf("user in secondary after commit: %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+// Package migrations holds versioned SQL migrations of the storages,
+// they are embedded into the binary.
+//
+// Migrations live in a directory per dialect and are named NNNN_description.sql,
+// NNNN being the version. Applied versions are recorded in the schema_migrations table,
+// so every migration runs once and running Up again is safe.
+// Applied migrations must never be edited, changes go to a new migration.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed sqlite/*.sql postgres/*.sql
+var files embed.FS
+
+// Dialect is the SQL dialect migrations are written in.
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// Migration is a single versioned migration.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// lockID is the key of the postgres advisory lock taken while migrating,
+// so instances started together don't apply the same migration twice.
+const lockID = 7_345_001
+
+// Up applies migrations of dialect that haven't been applied to db yet,
+// in order of their versions, and returns versions it applied.
+//
+// Every migration runs in its own transaction together with recording its version.
+func Up(ctx context.Context, db *sql.DB, dialect Dialect) ([]int, error) {
+	const op = "storage.migrations.Up"
+
+	migrations, err := List(dialect)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := db.ExecContext(ctx, createTable(dialect)); err != nil {
+		return nil, fmt.Errorf("%s: create schema_migrations: %w", op, err)
+	}
+
+	var applied []int
+	for _, m := range migrations {
+		ok, err := apply(ctx, db, dialect, m)
+		if err != nil {
+			return applied, fmt.Errorf("%s: migration %d_%s: %w", op, m.Version, m.Name, err)
+		}
+
+		if ok {
+			applied = append(applied, m.Version)
+		}
+	}
+
+	return applied, nil
+}
+
+// List returns migrations of dialect ordered by version.
+func List(dialect Dialect) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, string(dialect))
+	if err != nil {
+		return nil, fmt.Errorf("unknown dialect %q: %w", dialect, err)
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		prefix, name, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration name %q, want NNNN_description.sql", entry.Name())
+		}
+
+		data, err := fs.ReadFile(files, path.Join(string(dialect), entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(data)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", migrations[i].Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// apply runs m unless it has already been applied, reports whether it ran.
+func apply(ctx context.Context, db *sql.DB, dialect Dialect, m Migration) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if dialect == Postgres {
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", lockID); err != nil {
+			return false, err
+		}
+	}
+
+	var done bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM schema_migrations WHERE version = "+placeholder(dialect)+")",
+		m.Version,
+	).Scan(&done)
+	if err != nil {
+		return false, err
+	}
+
+	if done {
+		return false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+		return false, err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO schema_migrations(version) VALUES("+placeholder(dialect)+")",
+		m.Version,
+	); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func createTable(dialect Dialect) string {
+	if dialect == Postgres {
+		return `CREATE TABLE IF NOT EXISTS schema_migrations
+(
+    version    INTEGER PRIMARY KEY,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`
+	}
+
+	return `CREATE TABLE IF NOT EXISTS schema_migrations
+(
+    version    INTEGER PRIMARY KEY,
+    applied_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now'))
+)`
+}
+
+func placeholder(dialect Dialect) string {
+	if dialect == Postgres {
+		return "$1"
+	}
+
+	return "?"
+}
@@ -0,0 +1,53 @@
+-- Tables may already exist in databases created before migrations were introduced.
+CREATE TABLE IF NOT EXISTS users
+(
+    id            BIGSERIAL PRIMARY KEY,
+    email         TEXT        NOT NULL UNIQUE,
+    pass_hash     BYTEA       NOT NULL,
+    is_admin      BOOLEAN     NOT NULL DEFAULT FALSE,
+    verified      BOOLEAN     NOT NULL DEFAULT TRUE,
+    failed_logins INTEGER     NOT NULL DEFAULT 0,
+    locked_until  TIMESTAMPTZ,
+    deleted_at    TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS apps
+(
+    id          SERIAL PRIMARY KEY,
+    name        TEXT   NOT NULL UNIQUE,
+    secret      TEXT   NOT NULL UNIQUE,
+    secret_hash BYTEA  NOT NULL DEFAULT '',
+    token_ttl   BIGINT NOT NULL DEFAULT 0 -- seconds, 0 means the service default
+);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens
+(
+    jti        TEXT PRIMARY KEY,
+    user_id    BIGINT      NOT NULL DEFAULT 0,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens
+(
+    token_hash TEXT PRIMARY KEY,
+    user_id    BIGINT      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    app_id     INTEGER     NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS one_time_tokens
+(
+    token_hash TEXT PRIMARY KEY,
+    user_id    BIGINT      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    purpose    TEXT        NOT NULL,
+    email      TEXT        NOT NULL DEFAULT '',
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS roles
+(
+    user_id BIGINT  NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    app_id  INTEGER NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    role    TEXT    NOT NULL,
+    PRIMARY KEY (user_id, app_id, role)
+);
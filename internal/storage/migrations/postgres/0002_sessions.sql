@@ -0,0 +1,16 @@
+CREATE TABLE sessions
+(
+    id           BIGSERIAL PRIMARY KEY,
+    user_id      BIGINT      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    app_id       INTEGER     NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    ip           TEXT        NOT NULL DEFAULT '',
+    user_agent   TEXT        NOT NULL DEFAULT '',
+    created_at   TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ NOT NULL,
+    expires_at   TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX idx_sessions_user_id ON sessions (user_id);
+
+-- 0 for tokens issued before sessions.
+ALTER TABLE refresh_tokens ADD COLUMN session_id BIGINT NOT NULL DEFAULT 0;
+CREATE INDEX idx_refresh_tokens_session_id ON refresh_tokens (session_id);
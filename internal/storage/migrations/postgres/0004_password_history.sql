@@ -0,0 +1,9 @@
+-- Previous password hashes of users, so recent passwords can't be reused.
+CREATE TABLE password_history
+(
+    id         BIGSERIAL PRIMARY KEY,
+    user_id    BIGINT      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    pass_hash  BYTEA       NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX idx_password_history_user_id ON password_history (user_id);
@@ -0,0 +1,2 @@
+-- Existing users get a full password max age from now.
+ALTER TABLE users ADD COLUMN password_changed_at TIMESTAMPTZ NOT NULL DEFAULT now();
@@ -0,0 +1,24 @@
+-- Accounts of users at external identity providers, e.g. Google.
+CREATE TABLE user_identities
+(
+    provider   TEXT        NOT NULL,
+    subject    TEXT        NOT NULL,
+    user_id    BIGINT      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    email      TEXT        NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (provider, subject)
+);
+CREATE INDEX idx_user_identities_user_id ON user_identities (user_id);
+
+-- Sign ins with identity providers waiting for the provider to redirect back.
+CREATE TABLE oauth_states
+(
+    state_hash TEXT PRIMARY KEY,
+    provider   TEXT        NOT NULL,
+    app_id     INTEGER     NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    user_id    BIGINT      NOT NULL DEFAULT 0, -- 0 for sign ins, the user otherwise
+    nonce      TEXT        NOT NULL,
+    verifier   TEXT        NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX idx_oauth_states_expires_at ON oauth_states (expires_at);
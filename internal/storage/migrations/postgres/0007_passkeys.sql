@@ -0,0 +1,28 @@
+-- WebAuthn credentials of users.
+CREATE TABLE passkeys
+(
+    id               BYTEA PRIMARY KEY,
+    user_id          BIGINT      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    public_key       BYTEA       NOT NULL,
+    attestation_type TEXT        NOT NULL DEFAULT '',
+    transports       TEXT        NOT NULL DEFAULT '', -- comma separated
+    aaguid           BYTEA       NOT NULL DEFAULT '',
+    sign_count       BIGINT      NOT NULL DEFAULT 0,
+    backup_eligible  BOOLEAN     NOT NULL DEFAULT FALSE,
+    backup_state     BOOLEAN     NOT NULL DEFAULT FALSE,
+    created_at       TIMESTAMPTZ NOT NULL,
+    last_used_at     TIMESTAMPTZ -- NULL until the passkey is used
+);
+CREATE INDEX idx_passkeys_user_id ON passkeys (user_id);
+
+-- Registrations and logins with passkeys waiting for the response of the authenticator.
+CREATE TABLE passkey_challenges
+(
+    id_hash    TEXT PRIMARY KEY,
+    purpose    TEXT        NOT NULL,
+    user_id    BIGINT      NOT NULL DEFAULT 0, -- 0 for logins
+    app_id     INTEGER     NOT NULL DEFAULT 0, -- 0 for registrations
+    session    BYTEA       NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX idx_passkey_challenges_expires_at ON passkey_challenges (expires_at);
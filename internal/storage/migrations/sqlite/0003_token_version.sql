@@ -0,0 +1,2 @@
+-- Access tokens carry the version of their user, bumping it invalidates all of them.
+ALTER TABLE users ADD COLUMN token_version INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+-- Unix seconds, existing users get a full password max age from now.
+ALTER TABLE users ADD COLUMN password_changed_at INTEGER NOT NULL DEFAULT 0;
+UPDATE users SET password_changed_at = CAST(strftime('%s', 'now') AS INTEGER);
@@ -0,0 +1,28 @@
+-- WebAuthn credentials of users.
+CREATE TABLE passkeys
+(
+    id               BLOB PRIMARY KEY,
+    user_id          INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    public_key       BLOB    NOT NULL,
+    attestation_type TEXT    NOT NULL DEFAULT '',
+    transports       TEXT    NOT NULL DEFAULT '', -- comma separated
+    aaguid           BLOB    NOT NULL DEFAULT '',
+    sign_count       INTEGER NOT NULL DEFAULT 0,
+    backup_eligible  BOOLEAN NOT NULL DEFAULT FALSE,
+    backup_state     BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at       INTEGER NOT NULL,
+    last_used_at     INTEGER NOT NULL DEFAULT 0 -- 0 until the passkey is used
+);
+CREATE INDEX idx_passkeys_user_id ON passkeys (user_id);
+
+-- Registrations and logins with passkeys waiting for the response of the authenticator.
+CREATE TABLE passkey_challenges
+(
+    id_hash    TEXT PRIMARY KEY,
+    purpose    TEXT    NOT NULL,
+    user_id    INTEGER NOT NULL DEFAULT 0, -- 0 for logins
+    app_id     INTEGER NOT NULL DEFAULT 0, -- 0 for registrations
+    session    BLOB    NOT NULL,
+    expires_at INTEGER NOT NULL
+);
+CREATE INDEX idx_passkey_challenges_expires_at ON passkey_challenges (expires_at);
@@ -0,0 +1,2 @@
+-- Space-delimited scopes granted at login, refreshed access tokens carry them as well.
+ALTER TABLE refresh_tokens ADD COLUMN scopes TEXT NOT NULL DEFAULT '';
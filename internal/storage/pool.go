@@ -0,0 +1,25 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PoolConfig tunes the connection pool of a storage.
+type PoolConfig struct {
+	// MaxOpenConns limits connections to the database, it must be positive.
+	MaxOpenConns int
+	// MaxIdleConns is how many connections are kept open while unused,
+	// it is capped at MaxOpenConns.
+	MaxIdleConns int
+	// ConnMaxLifetime closes connections older than it, so load spreads
+	// over database replicas behind a balancer. 0 reuses connections forever.
+	ConnMaxLifetime time.Duration
+}
+
+// Apply sets the pool settings on db.
+func (c PoolConfig) Apply(db *sql.DB) {
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	db.SetMaxIdleConns(c.MaxIdleConns)
+	db.SetConnMaxLifetime(c.ConnMaxLifetime)
+}
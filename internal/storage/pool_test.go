@@ -0,0 +1,47 @@
+package storage_test
+
+import (
+	"context"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"path/filepath"
+	"sso/internal/storage"
+	"testing"
+	"time"
+)
+
+func TestPoolConfigApply(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "pool.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	storage.PoolConfig{MaxOpenConns: 3, MaxIdleConns: 1, ConnMaxLifetime: time.Minute}.Apply(db)
+
+	if got := db.Stats().MaxOpenConnections; got != 3 {
+		t.Fatalf("max open connections: got %d, want 3", got)
+	}
+
+	// Hold all connections at once, then release them, only one may stay idle.
+	ctx := context.Background()
+	conns := make([]*sql.Conn, 3)
+	for i := range conns {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			t.Fatalf("conn %d: %v", i, err)
+		}
+		conns[i] = conn
+	}
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+
+	stats := db.Stats()
+	if stats.Idle != 1 {
+		t.Fatalf("idle connections: got %d, want 1", stats.Idle)
+	}
+	if stats.MaxIdleClosed != 2 {
+		t.Fatalf("connections closed over the idle limit: got %d, want 2", stats.MaxIdleClosed)
+	}
+}
@@ -0,0 +1,148 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// recordingDriver refuses every query and counts the queries by the connection string
+// of the database they were sent to, so tests can tell the primary and the replica apart.
+type recordingDriver struct {
+	mu      sync.Mutex
+	queries map[string]int
+}
+
+var recorder = &recordingDriver{queries: make(map[string]int)}
+
+func init() {
+	sql.Register("recording", recorder)
+}
+
+var errRefused = errors.New("query refused")
+
+func (d *recordingDriver) Open(name string) (driver.Conn, error) {
+	return recordingConn{name: name, driver: d}, nil
+}
+
+func (d *recordingDriver) count(name string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.queries[name]
+}
+
+type recordingConn struct {
+	name   string
+	driver *recordingDriver
+}
+
+func (c recordingConn) Prepare(string) (driver.Stmt, error) {
+	c.driver.mu.Lock()
+	defer c.driver.mu.Unlock()
+
+	c.driver.queries[c.name]++
+
+	return nil, errRefused
+}
+
+func (c recordingConn) Close() error { return nil }
+
+func (c recordingConn) Begin() (driver.Tx, error) { return nil, errRefused }
+
+// newRecordingStorage returns a storage with a primary and a replica named after the test.
+func newRecordingStorage(t *testing.T) (s *Storage, primary string, replica string) {
+	t.Helper()
+
+	primary, replica = t.Name()+"/primary", t.Name()+"/replica"
+
+	db, err := sql.Open("recording", primary)
+	if err != nil {
+		t.Fatalf("open primary: %v", err)
+	}
+	replicaDB, err := sql.Open("recording", replica)
+	if err != nil {
+		t.Fatalf("open replica: %v", err)
+	}
+
+	s = &Storage{db: db, replica: replicaDB}
+	t.Cleanup(func() { _ = s.Close() })
+
+	return s, primary, replica
+}
+
+func TestReadsUseReplica(t *testing.T) {
+	ctx := context.Background()
+
+	reads := map[string]func(s *Storage) error{
+		"App": func(s *Storage) error {
+			_, err := s.App(ctx, 1)
+			return err
+		},
+		"IsAdmin": func(s *Storage) error {
+			_, err := s.IsAdmin(ctx, 1)
+			return err
+		},
+		"AreAdmins": func(s *Storage) error {
+			_, err := s.AreAdmins(ctx, []int64{1, 2})
+			return err
+		},
+		"ListUsers": func(s *Storage) error {
+			_, err := s.ListUsers(ctx, 10, 0)
+			return err
+		},
+		"CountUsers": func(s *Storage) error {
+			_, err := s.CountUsers(ctx)
+			return err
+		},
+	}
+
+	for name, read := range reads {
+		t.Run(name, func(t *testing.T) {
+			s, primary, replica := newRecordingStorage(t)
+
+			if err := read(s); !errors.Is(err, errRefused) {
+				t.Fatalf("got %v, want the error of the driver", err)
+			}
+
+			if recorder.count(replica) != 1 || recorder.count(primary) != 0 {
+				t.Fatalf("queries on the replica: %d, on the primary: %d, want 1 and 0",
+					recorder.count(replica), recorder.count(primary))
+			}
+		})
+	}
+}
+
+func TestUsersAreReadFromPrimary(t *testing.T) {
+	ctx := context.Background()
+
+	// Users are checked on login, a lagging replica would serve old passwords and lockouts.
+	reads := map[string]func(s *Storage) error{
+		"User": func(s *Storage) error {
+			_, err := s.User(ctx, "user@example.com")
+			return err
+		},
+		"UserByID": func(s *Storage) error {
+			_, err := s.UserByID(ctx, 1)
+			return err
+		},
+	}
+
+	for name, read := range reads {
+		t.Run(name, func(t *testing.T) {
+			s, primary, replica := newRecordingStorage(t)
+
+			if err := read(s); !errors.Is(err, errRefused) {
+				t.Fatalf("got %v, want the error of the driver", err)
+			}
+
+			if recorder.count(primary) != 1 || recorder.count(replica) != 0 {
+				t.Fatalf("queries on the primary: %d, on the replica: %d, want 1 and 0",
+					recorder.count(primary), recorder.count(replica))
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryConfig retries idempotent reads which failed with a transient error,
+// e.g. a dropped connection.
+type RetryConfig struct {
+	// Attempts is how many times a read is tried in total, 0 and 1 disable retries.
+	Attempts int
+	// Backoff is the delay before the first retry, it doubles before every next one.
+	Backoff time.Duration
+	// MaxBackoff caps the delay between retries.
+	MaxBackoff time.Duration
+}
+
+// Retry runs fn until it succeeds, fails with an error transient doesn't classify
+// as transient, or cfg.Attempts run out, and returns the result of the last run.
+//
+// Delays between runs are jittered, so clients which failed together don't retry together.
+// Retry gives up without waiting if ctx is done or its deadline would pass during the delay.
+func Retry[T any](ctx context.Context, cfg RetryConfig, transient func(error) bool, fn func() (T, error)) (T, error) {
+	backoff := cfg.Backoff
+
+	for attempt := 1; ; attempt++ {
+		res, err := fn()
+		if err == nil || attempt >= cfg.Attempts || !transient(err) {
+			return res, err
+		}
+
+		delay := backoff/2 + rand.N(backoff/2+1)
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return res, err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return res, err
+		case <-timer.C:
+		}
+
+		backoff = min(backoff*2, cfg.MaxBackoff)
+	}
+}
@@ -0,0 +1,217 @@
+package rolecache
+
+import (
+	"context"
+	"slices"
+	"sso/internal/lib/metrics"
+	"sync"
+	"time"
+)
+
+// Storage keeps users and their roles, Cache is put in front of it.
+type Storage interface {
+	IsAdmin(ctx context.Context, userID int64) (bool, error)
+	UserRoles(ctx context.Context, userID int64, appID int) ([]string, error)
+	AssignRole(ctx context.Context, userID int64, appID int, role string) error
+	DeleteUser(ctx context.Context, userID int64) error
+	SoftDeleteUser(ctx context.Context, userID int64, deletedAt time.Time) error
+}
+
+// Cache keeps whether users are admins and their roles per app read from Storage
+// in memory for a short while, as they are checked on every authorization.
+//
+// Roles changed through the cache drop the entries of the user right away, roles
+// changed by other instances or the create-admin command are served until the entries expire,
+// so the TTL bounds how stale they can be. Failed lookups, e.g. of users
+// that don't exist, are not cached.
+// It is safe for concurrent use.
+type Cache struct {
+	Storage
+
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[key]entry
+	// invalidations counts Invalidate calls, so roles read from the storage
+	// while they were being changed aren't cached, see put.
+	invalidations uint64
+	metrics       *metrics.Metrics
+
+	// Now returns current time. It can be replaced in tests.
+	Now func() time.Time
+}
+
+// key identifies an entry. Whether the user is an admin doesn't depend on the app,
+// it is kept with app 0, which no app has.
+type key struct {
+	userID int64
+	appID  int
+}
+
+type entry struct {
+	isAdmin   bool
+	roles     []string
+	expiresAt time.Time
+}
+
+// New returns a cache of up to size entries from storage, which are kept for ttl.
+// Hits and misses are counted in m, it may be nil.
+func New(storage Storage, ttl time.Duration, size int, m *metrics.Metrics) *Cache {
+	return &Cache{
+		Storage: storage,
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[key]entry, size),
+		metrics: m,
+		Now:     time.Now,
+	}
+}
+
+// IsAdmin returns whether the user is an admin from the cache, or reads it from the storage.
+func (c *Cache) IsAdmin(ctx context.Context, userID int64) (bool, error) {
+	k := key{userID: userID}
+
+	e, ok, invalidations := c.get(k)
+	if ok {
+		c.count("hit")
+
+		return e.isAdmin, nil
+	}
+
+	c.count("miss")
+
+	isAdmin, err := c.Storage.IsAdmin(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	c.put(k, entry{isAdmin: isAdmin}, invalidations)
+
+	return isAdmin, nil
+}
+
+// UserRoles returns roles of the user in the app from the cache, or reads them from the storage.
+func (c *Cache) UserRoles(ctx context.Context, userID int64, appID int) ([]string, error) {
+	k := key{userID: userID, appID: appID}
+
+	e, ok, invalidations := c.get(k)
+	if ok {
+		c.count("hit")
+
+		// Callers may modify the roles, the cached ones mustn't change with them.
+		return slices.Clone(e.roles), nil
+	}
+
+	c.count("miss")
+
+	roles, err := c.Storage.UserRoles(ctx, userID, appID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.put(k, entry{roles: slices.Clone(roles)}, invalidations)
+
+	return roles, nil
+}
+
+// AssignRole gives the user the role in the storage and drops the user from the cache.
+func (c *Cache) AssignRole(ctx context.Context, userID int64, appID int, role string) error {
+	// The user is dropped even if the write fails, it may have been applied anyway.
+	defer c.Invalidate(userID)
+
+	return c.Storage.AssignRole(ctx, userID, appID, role)
+}
+
+// DeleteUser deletes the user from the storage and the cache.
+func (c *Cache) DeleteUser(ctx context.Context, userID int64) error {
+	defer c.Invalidate(userID)
+
+	return c.Storage.DeleteUser(ctx, userID)
+}
+
+// SoftDeleteUser marks the user as deleted in the storage and drops the user from the cache.
+func (c *Cache) SoftDeleteUser(ctx context.Context, userID int64, deletedAt time.Time) error {
+	defer c.Invalidate(userID)
+
+	return c.Storage.SoftDeleteUser(ctx, userID, deletedAt)
+}
+
+// Invalidate drops all entries of the user from the cache, so they are read from the storage next time.
+func (c *Cache) Invalidate(userID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k := range c.entries {
+		if k.userID == userID {
+			delete(c.entries, k)
+		}
+	}
+	c.invalidations++
+}
+
+// get returns the cached entry and the number of invalidations so far, see put.
+func (c *Cache) get(k key) (entry, bool, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[k]
+	if !ok {
+		return entry{}, false, c.invalidations
+	}
+
+	if !c.Now().Before(e.expiresAt) {
+		delete(c.entries, k)
+
+		return entry{}, false, c.invalidations
+	}
+
+	return e, true, c.invalidations
+}
+
+// put caches the entry unless the cache has been invalidated since it was read,
+// invalidations is the count get returned before the read.
+func (c *Cache) put(k key, e entry, invalidations uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.invalidations != invalidations {
+		return
+	}
+
+	now := c.Now()
+
+	if _, ok := c.entries[k]; !ok && len(c.entries) >= c.size {
+		c.evict(now)
+	}
+
+	e.expiresAt = now.Add(c.ttl)
+	c.entries[k] = e
+}
+
+// evict makes room for a new entry: it drops expired entries,
+// or a random one if none has expired.
+func (c *Cache) evict(now time.Time) {
+	for k, e := range c.entries {
+		if !now.Before(e.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+
+	if len(c.entries) < c.size {
+		return
+	}
+
+	for k := range c.entries {
+		delete(c.entries, k)
+
+		return
+	}
+}
+
+func (c *Cache) count(result string) {
+	if c.metrics == nil {
+		return
+	}
+
+	c.metrics.RoleCache.WithLabelValues(result).Inc()
+}
@@ -0,0 +1,237 @@
+package rolecache
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStorage keeps admins and roles in memory and counts reads.
+type fakeStorage struct {
+	mu     sync.Mutex
+	admins map[int64]bool
+	roles  map[int64][]string
+	reads  int
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{admins: make(map[int64]bool), roles: make(map[int64][]string)}
+}
+
+func (s *fakeStorage) IsAdmin(_ context.Context, userID int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reads++
+
+	return s.admins[userID], nil
+}
+
+func (s *fakeStorage) UserRoles(_ context.Context, userID int64, _ int) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reads++
+
+	return slices.Clone(s.roles[userID]), nil
+}
+
+func (s *fakeStorage) AssignRole(_ context.Context, userID int64, _ int, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.roles[userID] = append(s.roles[userID], role)
+
+	return nil
+}
+
+func (s *fakeStorage) DeleteUser(_ context.Context, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.admins, userID)
+	delete(s.roles, userID)
+
+	return nil
+}
+
+func (s *fakeStorage) SoftDeleteUser(ctx context.Context, userID int64, _ time.Time) error {
+	return s.DeleteUser(ctx, userID)
+}
+
+func (s *fakeStorage) setAdmin(userID int64, isAdmin bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.admins[userID] = isAdmin
+}
+
+func (s *fakeStorage) readCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.reads
+}
+
+// newTestCache returns a cache of st with entries kept for a minute by the returned clock.
+func newTestCache(st Storage) (*Cache, *time.Time) {
+	now := time.Unix(1_700_000_000, 0)
+
+	c := New(st, time.Minute, 10, nil)
+	c.Now = func() time.Time { return now }
+
+	return c, &now
+}
+
+func TestCacheServesRolesUntilTTL(t *testing.T) {
+	st := newFakeStorage()
+	st.roles[1] = []string{"member"}
+	c, now := newTestCache(st)
+	ctx := context.Background()
+
+	if _, err := c.UserRoles(ctx, 1, 1); err != nil {
+		t.Fatalf("user roles: %v", err)
+	}
+
+	// Changed behind the cache, e.g. by another instance.
+	st.roles[1] = []string{"editor"}
+
+	*now = now.Add(time.Minute - time.Second)
+
+	roles, err := c.UserRoles(ctx, 1, 1)
+	if err != nil {
+		t.Fatalf("user roles: %v", err)
+	}
+	if !slices.Equal(roles, []string{"member"}) {
+		t.Fatalf("roles within TTL: got %v, want cached [member]", roles)
+	}
+	if got := st.readCount(); got != 1 {
+		t.Fatalf("storage read %d times within TTL, want 1", got)
+	}
+
+	*now = now.Add(time.Second)
+
+	roles, err = c.UserRoles(ctx, 1, 1)
+	if err != nil {
+		t.Fatalf("user roles: %v", err)
+	}
+	if !slices.Equal(roles, []string{"editor"}) {
+		t.Fatalf("roles after TTL: got %v, want [editor]", roles)
+	}
+}
+
+func TestCacheServesAdminUntilTTL(t *testing.T) {
+	st := newFakeStorage()
+	st.setAdmin(1, true)
+	c, now := newTestCache(st)
+	ctx := context.Background()
+
+	if _, err := c.IsAdmin(ctx, 1); err != nil {
+		t.Fatalf("is admin: %v", err)
+	}
+
+	// Revoked behind the cache, e.g. by the create-admin command.
+	st.setAdmin(1, false)
+
+	if isAdmin, err := c.IsAdmin(ctx, 1); err != nil || !isAdmin {
+		t.Fatalf("within TTL: got %v, %v, want cached true", isAdmin, err)
+	}
+
+	*now = now.Add(time.Minute)
+
+	if isAdmin, err := c.IsAdmin(ctx, 1); err != nil || isAdmin {
+		t.Fatalf("after TTL: got %v, %v, want false", isAdmin, err)
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(ctx context.Context, c *Cache, st *fakeStorage) error
+		// wantAdmin and wantRoles are read right after the change.
+		wantAdmin bool
+		wantRoles []string
+	}{
+		{
+			name: "assign role",
+			change: func(ctx context.Context, c *Cache, _ *fakeStorage) error {
+				return c.AssignRole(ctx, 1, 1, "editor")
+			},
+			wantAdmin: true,
+			wantRoles: []string{"member", "editor"},
+		},
+		{
+			name: "delete user",
+			change: func(ctx context.Context, c *Cache, _ *fakeStorage) error {
+				return c.DeleteUser(ctx, 1)
+			},
+		},
+		{
+			name: "soft delete user",
+			change: func(ctx context.Context, c *Cache, _ *fakeStorage) error {
+				return c.SoftDeleteUser(ctx, 1, c.Now())
+			},
+		},
+		{
+			name: "admin revoked",
+			change: func(_ context.Context, c *Cache, st *fakeStorage) error {
+				st.setAdmin(1, false)
+				c.Invalidate(1)
+
+				return nil
+			},
+			wantRoles: []string{"member"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newFakeStorage()
+			st.setAdmin(1, true)
+			st.roles[1] = []string{"member"}
+			st.setAdmin(2, true)
+			c, _ := newTestCache(st)
+			ctx := context.Background()
+
+			for _, userID := range []int64{1, 2} {
+				if _, err := c.IsAdmin(ctx, userID); err != nil {
+					t.Fatalf("is admin: %v", err)
+				}
+				if _, err := c.UserRoles(ctx, userID, 1); err != nil {
+					t.Fatalf("user roles: %v", err)
+				}
+			}
+
+			if err := tt.change(ctx, c, st); err != nil {
+				t.Fatalf("change: %v", err)
+			}
+
+			isAdmin, err := c.IsAdmin(ctx, 1)
+			if err != nil {
+				t.Fatalf("is admin: %v", err)
+			}
+			if isAdmin != tt.wantAdmin {
+				t.Errorf("is admin after change: got %v, want %v", isAdmin, tt.wantAdmin)
+			}
+
+			roles, err := c.UserRoles(ctx, 1, 1)
+			if err != nil {
+				t.Fatalf("user roles: %v", err)
+			}
+			if !slices.Equal(roles, tt.wantRoles) {
+				t.Errorf("roles after change: got %v, want %v", roles, tt.wantRoles)
+			}
+
+			// Entries of other users are kept.
+			reads := st.readCount()
+			if _, err := c.IsAdmin(ctx, 2); err != nil {
+				t.Fatalf("is admin: %v", err)
+			}
+			if got := st.readCount(); got != reads {
+				t.Errorf("entry of another user was dropped")
+			}
+		})
+	}
+}
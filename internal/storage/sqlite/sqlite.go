@@ -4,37 +4,114 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"github.com/XSAM/otelsql"
 	"github.com/mattn/go-sqlite3"
 	_ "github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/net/context"
 	"sso/internal/domain/models"
 	"sso/internal/storage"
+	"sso/internal/storage/migrations"
+	"strings"
+	"time"
 )
 
 type Storage struct {
-	db *sql.DB
+	db    *sql.DB
+	retry storage.RetryConfig
 }
 
-func New(storagePath string) (*Storage, error) {
+// New opens sqlite database at storagePath, tables are created by Migrate.
+// pool tunes the connection pool, retry retries reads failed with a transient error.
+func New(storagePath string, pool storage.PoolConfig, retry storage.RetryConfig) (*Storage, error) {
 	const op = "storage.sqlite.New"
 
-	db, err := sql.Open("sqlite3", storagePath)
+	db, err := otelsql.Open("sqlite3", storagePath, otelsql.WithAttributes(attribute.String("db.system", "sqlite")))
 	if err != nil {
 		return nil, fmt.Errorf("%s : %s", op, err)
 	}
-	return &Storage{db: db}, nil
+
+	pool.Apply(db)
+
+	return &Storage{db: db, retry: retry}, nil
+}
+
+// Close closes the database.
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// Migrate applies migrations that haven't been applied yet and returns their versions.
+func (s *Storage) Migrate(ctx context.Context) ([]int, error) {
+	const op = "storage.sqlite.Migrate"
+
+	applied, err := migrations.Up(ctx, s.db, migrations.SQLite)
+	if err != nil {
+		return applied, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return applied, nil
+}
+
+// Ping checks that the database is reachable by running a trivial query,
+// which, unlike a plain ping, opens the database file.
+func (s *Storage) Ping(ctx context.Context) error {
+	const op = "storage.sqlite.Ping"
+
+	var one int
+	if err := s.db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// WithTx runs fn in a transaction, see storage.WithTx.
+// Methods which run their own transaction, e.g. DeleteUser, are not part of it.
+func (s *Storage) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return storage.WithTx(ctx, s.db, fn)
+}
+
+// conn returns the transaction ctx carries, see WithTx, or the database.
+func (s *Storage) conn(ctx context.Context) storage.Querier {
+	return storage.Conn(ctx, s.db)
+}
+
+// retry runs fn, an idempotent read, retrying it while the database is busy, see storage.Retry.
+// Reads in a transaction are not retried, the transaction has to be retried as a whole.
+func retry[T any](ctx context.Context, s *Storage, fn func() (T, error)) (T, error) {
+	if storage.InTx(ctx, s.db) {
+		return fn()
+	}
+
+	return storage.Retry(ctx, s.retry, transient, fn)
+}
+
+// isDuplicate reports whether err is a violation of a unique constraint or the primary key.
+func isDuplicate(err sqlite3.Error) bool {
+	return err.ExtendedCode == sqlite3.ErrConstraintUnique || err.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+}
+
+// transient reports whether err is worth retrying: the database is locked by another writer.
+func transient(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+
+	return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
 }
 
-// SaveUser saves user to db.
-func (s *Storage) SaveUser(ctx context.Context, email string, passHash []byte) (int64, error) {
+// SaveUser saves user to db, its password counts as changed at passwordChangedAt.
+func (s *Storage) SaveUser(ctx context.Context, email string, passHash []byte, verified bool, passwordChangedAt time.Time) (int64, error) {
 	const op = "storage.sqlite.SaveUser"
 
-	stmt, err := s.db.Prepare("INSERT INTO users(email, pass_hash) VALUES(?, ?)")
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "INSERT INTO users(email, pass_hash, verified, password_changed_at) VALUES(?, ?, ?, ?)")
 	if err != nil {
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 
-	res, err := stmt.ExecContext(ctx, email, passHash)
+	res, err := stmt.ExecContext(ctx, email, passHash, verified, passwordChangedAt.Unix())
 	if err != nil {
 		var sqliteErr sqlite3.Error
 		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
@@ -52,19 +129,335 @@ func (s *Storage) SaveUser(ctx context.Context, email string, passHash []byte) (
 	return id, nil
 }
 
+// SaveUserWithID saves user to db with given id, e.g. an id generated by another storage
+// this one mirrors, see dualwrite.
+func (s *Storage) SaveUserWithID(ctx context.Context, id int64, email string, passHash []byte, verified bool, passwordChangedAt time.Time) error {
+	const op = "storage.sqlite.SaveUserWithID"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "INSERT INTO users(id, email, pass_hash, verified, password_changed_at) VALUES(?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx, id, email, passHash, verified, passwordChangedAt.Unix())
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && isDuplicate(sqliteErr) {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UpdatePassword replaces password hash of the user, the password counts as changed at changedAt.
+func (s *Storage) UpdatePassword(ctx context.Context, userID int64, passHash []byte, changedAt time.Time) error {
+	const op = "storage.sqlite.UpdatePassword"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "UPDATE users SET pass_hash = ?, password_changed_at = ? WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx, passHash, changedAt.Unix(), userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// ReplacePasswordHash replaces password hash of the user with newHash
+// only if it is still oldHash, otherwise does nothing.
+func (s *Storage) ReplacePasswordHash(ctx context.Context, userID int64, oldHash []byte, newHash []byte) error {
+	const op = "storage.sqlite.ReplacePasswordHash"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "UPDATE users SET pass_hash = ? WHERE id = ? AND pass_hash = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx, newHash, userID, oldHash)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// MarkEmailVerified marks email of the user as verified.
+func (s *Storage) MarkEmailVerified(ctx context.Context, userID int64) error {
+	const op = "storage.sqlite.MarkEmailVerified"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "UPDATE users SET verified = TRUE WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// UpdateEmail replaces email of the user.
+func (s *Storage) UpdateEmail(ctx context.Context, userID int64, email string) error {
+	const op = "storage.sqlite.UpdateEmail"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "UPDATE users SET email = ? WHERE id = ? AND deleted_at = 0")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx, email, userID)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// DeleteUser deletes the user together with their roles, tokens and revocation entries.
+func (s *Storage) DeleteUser(ctx context.Context, userID int64) error {
+	const op = "storage.sqlite.DeleteUser"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, query := range []string{
+		"DELETE FROM roles WHERE user_id = ?",
+		"DELETE FROM refresh_tokens WHERE user_id = ?",
+		"DELETE FROM sessions WHERE user_id = ?",
+		"DELETE FROM one_time_tokens WHERE user_id = ?",
+		"DELETE FROM password_history WHERE user_id = ?",
+		"DELETE FROM user_identities WHERE user_id = ?",
+		"DELETE FROM oauth_states WHERE user_id = ?",
+		"DELETE FROM passkeys WHERE user_id = ?",
+		"DELETE FROM passkey_challenges WHERE user_id = ?",
+		"DELETE FROM revoked_tokens WHERE user_id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = ?", userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SoftDeleteUser marks the user as deleted at deletedAt, so they can no longer be found,
+// and deletes their sessions, refresh and one-time tokens, linked identities and passkeys. Other data of the user is kept.
+func (s *Storage) SoftDeleteUser(ctx context.Context, userID int64, deletedAt time.Time) error {
+	const op = "storage.sqlite.SoftDeleteUser"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx, "UPDATE users SET deleted_at = ? WHERE id = ? AND deleted_at = 0", deletedAt.Unix(), userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	for _, query := range []string{
+		"DELETE FROM refresh_tokens WHERE user_id = ?",
+		"DELETE FROM sessions WHERE user_id = ?",
+		"DELETE FROM one_time_tokens WHERE user_id = ?",
+		"DELETE FROM password_history WHERE user_id = ?",
+		"DELETE FROM user_identities WHERE user_id = ?",
+		"DELETE FROM oauth_states WHERE user_id = ?",
+		"DELETE FROM passkeys WHERE user_id = ?",
+		"DELETE FROM passkey_challenges WHERE user_id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
 // User returns user by email.
 func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
+	return retry(ctx, s, func() (models.User, error) {
+		return s.user(ctx, email)
+	})
+}
+
+func (s *Storage) user(ctx context.Context, email string) (models.User, error) {
 	const op = "storage.sqlite.User"
 
-	stmt, err := s.db.Prepare("SELECT id, email, pass_hash FROM users WHERE email = ?")
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "SELECT id, email, pass_hash, verified, failed_logins, locked_until, token_version, password_changed_at FROM users WHERE email = ? AND deleted_at = 0")
 	if err != nil {
 		return models.User{}, fmt.Errorf("%s: %w", op, err)
 	}
 
 	row := stmt.QueryRowContext(ctx, email)
 
-	var user models.User
-	err = row.Scan(&user.ID, &user.Email, &user.PassHash)
+	return scanUser(row, op)
+}
+
+// UserByID returns user by id.
+func (s *Storage) UserByID(ctx context.Context, userID int64) (models.User, error) {
+	return retry(ctx, s, func() (models.User, error) {
+		return s.userByID(ctx, userID)
+	})
+}
+
+func (s *Storage) userByID(ctx context.Context, userID int64) (models.User, error) {
+	const op = "storage.sqlite.UserByID"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "SELECT id, email, pass_hash, verified, failed_logins, locked_until, token_version, password_changed_at FROM users WHERE id = ? AND deleted_at = 0")
+	if err != nil {
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	row := stmt.QueryRowContext(ctx, userID)
+
+	return scanUser(row, op)
+}
+
+// ListUsers returns a page of users ordered by id, without password hashes.
+func (s *Storage) ListUsers(ctx context.Context, limit int, offset int) ([]models.User, error) {
+	const op = "storage.sqlite.ListUsers"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, `
+		SELECT id, email, verified, failed_logins, locked_until FROM users
+		WHERE deleted_at = 0
+		ORDER BY id
+		LIMIT ? OFFSET ?`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := stmt.QueryContext(ctx, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var (
+			user        models.User
+			lockedUntil int64
+		)
+		if err := rows.Scan(&user.ID, &user.Email, &user.Verified, &user.FailedLogins, &lockedUntil); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if lockedUntil != 0 {
+			user.LockedUntil = time.Unix(lockedUntil, 0)
+		}
+
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return users, nil
+}
+
+// CountUsers returns the number of users.
+func (s *Storage) CountUsers(ctx context.Context) (int, error) {
+	const op = "storage.sqlite.CountUsers"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "SELECT COUNT(*) FROM users WHERE deleted_at = 0")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var count int
+	if err := stmt.QueryRowContext(ctx).Scan(&count); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return count, nil
+}
+
+func scanUser(row *sql.Row, op string) (models.User, error) {
+	var (
+		user              models.User
+		lockedUntil       int64
+		passwordChangedAt int64
+	)
+	err := row.Scan(
+		&user.ID, &user.Email, &user.PassHash, &user.Verified,
+		&user.FailedLogins, &lockedUntil, &user.TokenVersion, &passwordChangedAt,
+	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
@@ -73,13 +466,153 @@ func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
 		return models.User{}, fmt.Errorf("%s: %w", op, err)
 	}
 
+	if lockedUntil != 0 {
+		user.LockedUntil = time.Unix(lockedUntil, 0)
+	}
+
+	user.PasswordChangedAt = time.Unix(passwordChangedAt, 0)
+
 	return user, nil
 }
 
+// IncrementTokenVersion bumps the token version of the user.
+func (s *Storage) IncrementTokenVersion(ctx context.Context, userID int64) error {
+	const op = "storage.sqlite.IncrementTokenVersion"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "UPDATE users SET token_version = token_version + 1 WHERE id = ? AND deleted_at = 0")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// PasswordHistory returns up to limit previous password hashes of the user, the newest first.
+func (s *Storage) PasswordHistory(ctx context.Context, userID int64, limit int) ([][]byte, error) {
+	const op = "storage.sqlite.PasswordHistory"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "SELECT pass_hash FROM password_history WHERE user_id = ? ORDER BY id DESC LIMIT ?")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := stmt.QueryContext(ctx, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var hashes [][]byte
+	for rows.Next() {
+		var hash []byte
+		if err := rows.Scan(&hash); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		hashes = append(hashes, hash)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return hashes, nil
+}
+
+// AddPasswordHistory remembers a previous password hash of the user
+// and prunes all but the newest keep hashes of the user.
+func (s *Storage) AddPasswordHistory(ctx context.Context, userID int64, passHash []byte, keep int, addedAt time.Time) error {
+	const op = "storage.sqlite.AddPasswordHistory"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO password_history(user_id, pass_hash, created_at) VALUES(?, ?, ?)",
+		userID, passHash, addedAt.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM password_history
+		WHERE user_id = ? AND id NOT IN (
+			SELECT id FROM password_history WHERE user_id = ? ORDER BY id DESC LIMIT ?
+		)`,
+		userID, userID, keep,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// IncrementFailedLogin counts a failed login of the user and, once the user
+// has failed lockAfter times in a row, locks the account until lockUntil.
+func (s *Storage) IncrementFailedLogin(ctx context.Context, userID int64, lockAfter int, lockUntil time.Time) error {
+	const op = "storage.sqlite.IncrementFailedLogin"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, `
+		UPDATE users
+		SET failed_logins = failed_logins + 1,
+		    locked_until  = CASE WHEN failed_logins + 1 >= ? THEN ? ELSE locked_until END
+		WHERE id = ?`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx, lockAfter, lockUntil.Unix(), userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ResetFailedLogin clears failed logins of the user and lifts the lock.
+func (s *Storage) ResetFailedLogin(ctx context.Context, userID int64) error {
+	const op = "storage.sqlite.ResetFailedLogin"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "UPDATE users SET failed_logins = 0, locked_until = 0 WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
 //func (s *Storage) SavePermission(ctx context.Context, userID int64, permission models.Permission, appID string) error {
 //	const op = "storage.sqlite.SavePermission"
 //
-//	stmt, err := s.db.Prepare("INSERT INTO permissions(user_id, permission, app_id) VALUES(?, ?, ?)")
+//	stmt, err := s.conn(ctx).PrepareContext(ctx, "INSERT INTO permissions(user_id, permission, app_id) VALUES(?, ?, ?)")
 //	if err != nil {
 //		return fmt.Errorf("%s: %w", op, err)
 //	}
@@ -94,9 +627,15 @@ func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
 
 // App returns app by id.
 func (s *Storage) App(ctx context.Context, id int) (models.App, error) {
+	return retry(ctx, s, func() (models.App, error) {
+		return s.app(ctx, id)
+	})
+}
+
+func (s *Storage) app(ctx context.Context, id int) (models.App, error) {
 	const op = "storage.sqlite.App"
 
-	stmt, err := s.db.Prepare("SELECT id, name, secret FROM apps WHERE id = ?")
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "SELECT id, name, secret, secret_hash, token_ttl FROM apps WHERE id = ?")
 	if err != nil {
 		return models.App{}, fmt.Errorf("%s: %w", op, err)
 	}
@@ -104,7 +643,8 @@ func (s *Storage) App(ctx context.Context, id int) (models.App, error) {
 	row := stmt.QueryRowContext(ctx, id)
 
 	var app models.App
-	err = row.Scan(&app.ID, &app.Name, &app.Secret)
+	var tokenTTL int64
+	err = row.Scan(&app.ID, &app.Name, &app.Secret, &app.SecretHash, &tokenTTL)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.App{}, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
@@ -113,25 +653,123 @@ func (s *Storage) App(ctx context.Context, id int) (models.App, error) {
 		return models.App{}, fmt.Errorf("%s: %w", op, err)
 	}
 
+	app.TokenTTL = time.Duration(tokenTTL) * time.Second
+
 	return app, nil
 }
 
-func (s *Storage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
-	const op = "storage.sqlite.IsAdmin"
+// SaveApp saves app to db and returns its id.
+func (s *Storage) SaveApp(ctx context.Context, app models.App) (int, error) {
+	const op = "storage.sqlite.SaveApp"
 
-	stmt, err := s.db.Prepare("SELECT is_admin FROM users WHERE id = ?")
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "INSERT INTO apps(name, secret, secret_hash, token_ttl) VALUES(?, ?, ?, ?)")
 	if err != nil {
-		return false, fmt.Errorf("%s: %w", op, err)
+		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 
-	row := stmt.QueryRowContext(ctx, userID)
-
-	var isAdmin bool
-
-	err = row.Scan(&isAdmin)
+	res, err := stmt.ExecContext(ctx, app.Name, app.Secret, app.SecretHash, int64(app.TokenTTL/time.Second))
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return false, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrAppExists)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return int(id), nil
+}
+
+// SaveAppWithID saves app to db with app.ID, see SaveUserWithID.
+func (s *Storage) SaveAppWithID(ctx context.Context, app models.App) error {
+	const op = "storage.sqlite.SaveAppWithID"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "INSERT INTO apps(id, name, secret, secret_hash, token_ttl) VALUES(?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx, app.ID, app.Name, app.Secret, app.SecretHash, int64(app.TokenTTL/time.Second))
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && isDuplicate(sqliteErr) {
+			return fmt.Errorf("%s: %w", op, storage.ErrAppExists)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteApp deletes the app together with sessions, refresh tokens and roles issued for it.
+func (s *Storage) DeleteApp(ctx context.Context, appID int) error {
+	const op = "storage.sqlite.DeleteApp"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, query := range []string{
+		"DELETE FROM roles WHERE app_id = ?",
+		"DELETE FROM refresh_tokens WHERE app_id = ?",
+		"DELETE FROM sessions WHERE app_id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, query, appID); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM apps WHERE id = ?", appID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
+	return retry(ctx, s, func() (bool, error) {
+		return s.isAdmin(ctx, userID)
+	})
+}
+
+func (s *Storage) isAdmin(ctx context.Context, userID int64) (bool, error) {
+	const op = "storage.sqlite.IsAdmin"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "SELECT is_admin FROM users WHERE id = ? AND deleted_at = 0")
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	row := stmt.QueryRowContext(ctx, userID)
+
+	var isAdmin bool
+
+	err = row.Scan(&isAdmin)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
 		}
 
 		return false, fmt.Errorf("%s: %w", op, err)
@@ -139,3 +777,853 @@ func (s *Storage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
 
 	return isAdmin, nil
 }
+
+// SetAdmin grants or revokes admin privileges of the user.
+func (s *Storage) SetAdmin(ctx context.Context, userID int64, isAdmin bool) error {
+	const op = "storage.sqlite.SetAdmin"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "UPDATE users SET is_admin = ? WHERE id = ? AND deleted_at = 0")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx, isAdmin, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// HasAdmin reports whether there is at least one admin.
+func (s *Storage) HasAdmin(ctx context.Context) (bool, error) {
+	const op = "storage.sqlite.HasAdmin"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE is_admin AND deleted_at = 0)")
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var exists bool
+	if err := stmt.QueryRowContext(ctx).Scan(&exists); err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return exists, nil
+}
+
+// AreAdmins returns whether each of the users is an admin.
+// Users that don't exist are absent from the result.
+func (s *Storage) AreAdmins(ctx context.Context, userIDs []int64) (map[int64]bool, error) {
+	const op = "storage.sqlite.AreAdmins"
+
+	admins := make(map[int64]bool, len(userIDs))
+	if len(userIDs) == 0 {
+		return admins, nil
+	}
+
+	args := make([]any, len(userIDs))
+	for i, id := range userIDs {
+		args[i] = id
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(userIDs)), ", ")
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx,
+		"SELECT id, is_admin FROM users WHERE id IN ("+placeholders+") AND deleted_at = 0",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var isAdmin bool
+		if err := rows.Scan(&id, &isAdmin); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		admins[id] = isAdmin
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return admins, nil
+}
+
+// UserRoles returns roles of the user in the app, sorted by name.
+func (s *Storage) UserRoles(ctx context.Context, userID int64, appID int) ([]string, error) {
+	return retry(ctx, s, func() ([]string, error) {
+		return s.userRoles(ctx, userID, appID)
+	})
+}
+
+func (s *Storage) userRoles(ctx context.Context, userID int64, appID int) ([]string, error) {
+	const op = "storage.sqlite.UserRoles"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "SELECT role FROM roles WHERE user_id = ? AND app_id = ? ORDER BY role")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := stmt.QueryContext(ctx, userID, appID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var roles []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		roles = append(roles, role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return roles, nil
+}
+
+// AssignRole gives the user the role in the app, it does nothing if the user already has it.
+func (s *Storage) AssignRole(ctx context.Context, userID int64, appID int, role string) error {
+	const op = "storage.sqlite.AssignRole"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "INSERT OR IGNORE INTO roles(user_id, app_id, role) VALUES(?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx, userID, appID, role)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RevokeToken adds token id of the user to the revocation list.
+func (s *Storage) RevokeToken(ctx context.Context, tokenID string, userID int64, expiresAt time.Time) error {
+	const op = "storage.sqlite.RevokeToken"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "INSERT OR IGNORE INTO revoked_tokens(jti, user_id, expires_at) VALUES(?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx, tokenID, userID, expiresAt.Unix())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// IsRevoked reports whether token id is in the revocation list.
+func (s *Storage) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	const op = "storage.sqlite.IsRevoked"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = ?)")
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	row := stmt.QueryRowContext(ctx, tokenID)
+
+	var revoked bool
+
+	err = row.Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return revoked, nil
+}
+
+// SaveRefreshToken saves refresh token to db.
+func (s *Storage) SaveRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	const op = "storage.sqlite.SaveRefreshToken"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "INSERT INTO refresh_tokens(token_hash, user_id, app_id, session_id, expires_at, scopes) VALUES(?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx,
+		token.TokenHash,
+		token.UserID,
+		token.AppID,
+		token.SessionID,
+		token.ExpiresAt.Unix(),
+		strings.Join(token.Scopes, " "),
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RefreshToken returns refresh token by its hash.
+func (s *Storage) RefreshToken(ctx context.Context, tokenHash string) (models.RefreshToken, error) {
+	const op = "storage.sqlite.RefreshToken"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "SELECT token_hash, user_id, app_id, session_id, expires_at, scopes FROM refresh_tokens WHERE token_hash = ?")
+	if err != nil {
+		return models.RefreshToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	row := stmt.QueryRowContext(ctx, tokenHash)
+
+	var (
+		token     models.RefreshToken
+		expiresAt int64
+		scopes    string
+	)
+	err = row.Scan(&token.TokenHash, &token.UserID, &token.AppID, &token.SessionID, &expiresAt, &scopes)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.RefreshToken{}, fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenNotFound)
+		}
+
+		return models.RefreshToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	token.ExpiresAt = time.Unix(expiresAt, 0)
+	token.Scopes = strings.Fields(scopes)
+
+	return token, nil
+}
+
+// DeleteRefreshToken deletes refresh token by its hash.
+//
+// Returns storage.ErrRefreshTokenNotFound if token has already been deleted,
+// so only one of concurrent callers can consume the same token.
+func (s *Storage) DeleteRefreshToken(ctx context.Context, tokenHash string) error {
+	const op = "storage.sqlite.DeleteRefreshToken"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "DELETE FROM refresh_tokens WHERE token_hash = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx, tokenHash)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenNotFound)
+	}
+
+	return nil
+}
+
+// SaveSession saves session to db and returns its id.
+func (s *Storage) SaveSession(ctx context.Context, session models.Session) (int64, error) {
+	const op = "storage.sqlite.SaveSession"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, `
+		INSERT INTO sessions(user_id, app_id, ip, user_agent, created_at, last_used_at, expires_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?)`,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx,
+		session.UserID,
+		session.AppID,
+		session.IP,
+		session.UserAgent,
+		session.CreatedAt.Unix(),
+		session.LastUsedAt.Unix(),
+		session.ExpiresAt.Unix(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// SaveSessionWithID saves session to db with session.ID, see SaveUserWithID.
+func (s *Storage) SaveSessionWithID(ctx context.Context, session models.Session) error {
+	const op = "storage.sqlite.SaveSessionWithID"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, `
+		INSERT INTO sessions(id, user_id, app_id, ip, user_agent, created_at, last_used_at, expires_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?)`,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx,
+		session.ID,
+		session.UserID,
+		session.AppID,
+		session.IP,
+		session.UserAgent,
+		session.CreatedAt.Unix(),
+		session.LastUsedAt.Unix(),
+		session.ExpiresAt.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// TouchSession marks the session as used at usedAt and extends it until expiresAt.
+//
+// Returns storage.ErrSessionNotFound if the session has been deleted.
+func (s *Storage) TouchSession(ctx context.Context, sessionID int64, usedAt time.Time, expiresAt time.Time) error {
+	const op = "storage.sqlite.TouchSession"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "UPDATE sessions SET last_used_at = ?, expires_at = ? WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx, usedAt.Unix(), expiresAt.Unix(), sessionID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+	}
+
+	return nil
+}
+
+// Sessions returns sessions of the user that haven't expired at now,
+// the most recently used first.
+func (s *Storage) Sessions(ctx context.Context, userID int64, now time.Time) ([]models.Session, error) {
+	const op = "storage.sqlite.Sessions"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, `
+		SELECT id, user_id, app_id, ip, user_agent, created_at, last_used_at, expires_at FROM sessions
+		WHERE user_id = ? AND expires_at > ?
+		ORDER BY last_used_at DESC, id DESC`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := stmt.QueryContext(ctx, userID, now.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var (
+			session                          models.Session
+			createdAt, lastUsedAt, expiresAt int64
+		)
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.AppID,
+			&session.IP,
+			&session.UserAgent,
+			&createdAt,
+			&lastUsedAt,
+			&expiresAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		session.CreatedAt = time.Unix(createdAt, 0)
+		session.LastUsedAt = time.Unix(lastUsedAt, 0)
+		session.ExpiresAt = time.Unix(expiresAt, 0)
+
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessions, nil
+}
+
+// DeleteSession deletes the session of the user together with its refresh tokens.
+//
+// Returns storage.ErrSessionNotFound if the user has no such session.
+func (s *Storage) DeleteSession(ctx context.Context, userID int64, sessionID int64) error {
+	const op = "storage.sqlite.DeleteSession"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM sessions WHERE id = ? AND user_id = ?", sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE session_id = ?", sessionID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteUserSessions deletes all sessions and refresh tokens of the user.
+func (s *Storage) DeleteUserSessions(ctx context.Context, userID int64) error {
+	const op = "storage.sqlite.DeleteUserSessions"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, query := range []string{
+		"DELETE FROM refresh_tokens WHERE user_id = ?",
+		"DELETE FROM sessions WHERE user_id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SaveOneTimeToken saves one-time token to db.
+func (s *Storage) SaveOneTimeToken(ctx context.Context, token models.OneTimeToken) error {
+	const op = "storage.sqlite.SaveOneTimeToken"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "INSERT INTO one_time_tokens(token_hash, user_id, purpose, email, expires_at) VALUES(?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx, token.TokenHash, token.UserID, token.Purpose, token.Email, token.ExpiresAt.Unix())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// OneTimeToken returns the token with given hash and purpose without consuming it.
+func (s *Storage) OneTimeToken(ctx context.Context, tokenHash string, purpose string) (models.OneTimeToken, error) {
+	const op = "storage.sqlite.OneTimeToken"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, `
+		SELECT token_hash, user_id, purpose, email, expires_at FROM one_time_tokens
+		WHERE token_hash = ? AND purpose = ?`)
+	if err != nil {
+		return models.OneTimeToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	row := stmt.QueryRowContext(ctx, tokenHash, purpose)
+
+	var (
+		token     models.OneTimeToken
+		expiresAt int64
+	)
+	err = row.Scan(&token.TokenHash, &token.UserID, &token.Purpose, &token.Email, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.OneTimeToken{}, fmt.Errorf("%s: %w", op, storage.ErrTokenNotFound)
+		}
+
+		return models.OneTimeToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	token.ExpiresAt = time.Unix(expiresAt, 0)
+
+	return token, nil
+}
+
+// ConsumeOneTimeToken deletes the token with given hash and purpose and returns it.
+func (s *Storage) ConsumeOneTimeToken(ctx context.Context, tokenHash string, purpose string) (models.OneTimeToken, error) {
+	const op = "storage.sqlite.ConsumeOneTimeToken"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, `
+		DELETE FROM one_time_tokens
+		WHERE token_hash = ? AND purpose = ?
+		RETURNING token_hash, user_id, purpose, email, expires_at`)
+	if err != nil {
+		return models.OneTimeToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	row := stmt.QueryRowContext(ctx, tokenHash, purpose)
+
+	var (
+		token     models.OneTimeToken
+		expiresAt int64
+	)
+	err = row.Scan(&token.TokenHash, &token.UserID, &token.Purpose, &token.Email, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.OneTimeToken{}, fmt.Errorf("%s: %w", op, storage.ErrTokenNotFound)
+		}
+
+		return models.OneTimeToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	token.ExpiresAt = time.Unix(expiresAt, 0)
+
+	return token, nil
+}
+
+// UserByIdentity returns the user the identity of the provider is linked to.
+func (s *Storage) UserByIdentity(ctx context.Context, provider string, subject string) (models.User, error) {
+	return retry(ctx, s, func() (models.User, error) {
+		return s.userByIdentity(ctx, provider, subject)
+	})
+}
+
+func (s *Storage) userByIdentity(ctx context.Context, provider string, subject string) (models.User, error) {
+	const op = "storage.sqlite.UserByIdentity"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, `
+		SELECT u.id, u.email, u.pass_hash, u.verified, u.failed_logins, u.locked_until, u.token_version, u.password_changed_at
+		FROM user_identities i JOIN users u ON u.id = i.user_id
+		WHERE i.provider = ? AND i.subject = ? AND u.deleted_at = 0`)
+	if err != nil {
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	row := stmt.QueryRowContext(ctx, provider, subject)
+
+	return scanUser(row, op)
+}
+
+// LinkIdentity links the identity to the user, it returns storage.ErrIdentityExists
+// if the identity is linked to a user already.
+func (s *Storage) LinkIdentity(ctx context.Context, userID int64, identity models.Identity, linkedAt time.Time) error {
+	const op = "storage.sqlite.LinkIdentity"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, "INSERT INTO user_identities(provider, subject, user_id, email, created_at) VALUES(?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx, identity.Provider, identity.Subject, userID, identity.Email, linkedAt.Unix())
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && isDuplicate(sqliteErr) {
+			return fmt.Errorf("%s: %w", op, storage.ErrIdentityExists)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SaveOAuthState saves the state of a started sign in and deletes ones expired before now,
+// so states of sign ins that were never finished don't pile up.
+func (s *Storage) SaveOAuthState(ctx context.Context, state models.OAuthState, now time.Time) error {
+	const op = "storage.sqlite.SaveOAuthState"
+
+	if _, err := s.conn(ctx).ExecContext(ctx, "DELETE FROM oauth_states WHERE expires_at < ?", now.Unix()); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, `
+		INSERT INTO oauth_states(state_hash, provider, app_id, user_id, nonce, verifier, expires_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx,
+		state.StateHash, state.Provider, state.AppID, state.UserID, state.Nonce, state.Verifier, state.ExpiresAt.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ConsumeOAuthState deletes the state with given hash and returns it,
+// so every state can be used only once.
+func (s *Storage) ConsumeOAuthState(ctx context.Context, stateHash string) (models.OAuthState, error) {
+	const op = "storage.sqlite.ConsumeOAuthState"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, `
+		DELETE FROM oauth_states
+		WHERE state_hash = ?
+		RETURNING state_hash, provider, app_id, user_id, nonce, verifier, expires_at`)
+	if err != nil {
+		return models.OAuthState{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var (
+		state     models.OAuthState
+		expiresAt int64
+	)
+	err = stmt.QueryRowContext(ctx, stateHash).Scan(
+		&state.StateHash, &state.Provider, &state.AppID, &state.UserID, &state.Nonce, &state.Verifier, &expiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.OAuthState{}, fmt.Errorf("%s: %w", op, storage.ErrOAuthStateNotFound)
+		}
+
+		return models.OAuthState{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	state.ExpiresAt = time.Unix(expiresAt, 0)
+
+	return state, nil
+}
+
+// SavePasskey saves the passkey of the user, it returns storage.ErrPasskeyExists
+// if a passkey with its id is registered already.
+func (s *Storage) SavePasskey(ctx context.Context, passkey models.Passkey) error {
+	const op = "storage.sqlite.SavePasskey"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, `
+		INSERT INTO passkeys(id, user_id, public_key, attestation_type, transports, aaguid, sign_count,
+			backup_eligible, backup_state, created_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx,
+		passkey.ID,
+		passkey.UserID,
+		passkey.PublicKey,
+		passkey.AttestationType,
+		strings.Join(passkey.Transports, ","),
+		passkey.AAGUID,
+		passkey.SignCount,
+		passkey.BackupEligible,
+		passkey.BackupState,
+		passkey.CreatedAt.Unix(),
+	)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && isDuplicate(sqliteErr) {
+			return fmt.Errorf("%s: %w", op, storage.ErrPasskeyExists)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Passkeys returns passkeys of the user, the oldest first.
+func (s *Storage) Passkeys(ctx context.Context, userID int64) ([]models.Passkey, error) {
+	return retry(ctx, s, func() ([]models.Passkey, error) {
+		return s.passkeys(ctx, userID)
+	})
+}
+
+func (s *Storage) passkeys(ctx context.Context, userID int64) ([]models.Passkey, error) {
+	const op = "storage.sqlite.Passkeys"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, `
+		SELECT id, user_id, public_key, attestation_type, transports, aaguid, sign_count,
+			backup_eligible, backup_state, created_at, last_used_at
+		FROM passkeys
+		WHERE user_id = ?
+		ORDER BY created_at, id`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := stmt.QueryContext(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var passkeys []models.Passkey
+	for rows.Next() {
+		var (
+			passkey               models.Passkey
+			transports            string
+			createdAt, lastUsedAt int64
+		)
+		err := rows.Scan(
+			&passkey.ID,
+			&passkey.UserID,
+			&passkey.PublicKey,
+			&passkey.AttestationType,
+			&transports,
+			&passkey.AAGUID,
+			&passkey.SignCount,
+			&passkey.BackupEligible,
+			&passkey.BackupState,
+			&createdAt,
+			&lastUsedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if transports != "" {
+			passkey.Transports = strings.Split(transports, ",")
+		}
+		passkey.CreatedAt = time.Unix(createdAt, 0)
+		if lastUsedAt != 0 {
+			passkey.LastUsedAt = time.Unix(lastUsedAt, 0)
+		}
+
+		passkeys = append(passkeys, passkey)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return passkeys, nil
+}
+
+// TouchPasskey records a login with the passkey: the sign count and backup state
+// the authenticator reported and when it was used.
+//
+// Returns storage.ErrPasskeyNotFound if the passkey has been deleted.
+func (s *Storage) TouchPasskey(ctx context.Context, id []byte, signCount uint32, backupState bool, usedAt time.Time) error {
+	const op = "storage.sqlite.TouchPasskey"
+
+	res, err := s.conn(ctx).ExecContext(ctx,
+		"UPDATE passkeys SET sign_count = ?, backup_state = ?, last_used_at = ? WHERE id = ?",
+		signCount, backupState, usedAt.Unix(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrPasskeyNotFound)
+	}
+
+	return nil
+}
+
+// SavePasskeyChallenge saves the challenge of a started registration or login
+// and deletes ones expired before now, so challenges that were never answered don't pile up.
+func (s *Storage) SavePasskeyChallenge(ctx context.Context, challenge models.PasskeyChallenge, now time.Time) error {
+	const op = "storage.sqlite.SavePasskeyChallenge"
+
+	if _, err := s.conn(ctx).ExecContext(ctx, "DELETE FROM passkey_challenges WHERE expires_at < ?", now.Unix()); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, `
+		INSERT INTO passkey_challenges(id_hash, purpose, user_id, app_id, session, expires_at)
+		VALUES(?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx,
+		challenge.IDHash, challenge.Purpose, challenge.UserID, challenge.AppID, challenge.Session, challenge.ExpiresAt.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ConsumePasskeyChallenge deletes the challenge with given hash and purpose and returns it,
+// so every challenge can be answered only once.
+func (s *Storage) ConsumePasskeyChallenge(ctx context.Context, idHash string, purpose string) (models.PasskeyChallenge, error) {
+	const op = "storage.sqlite.ConsumePasskeyChallenge"
+
+	stmt, err := s.conn(ctx).PrepareContext(ctx, `
+		DELETE FROM passkey_challenges
+		WHERE id_hash = ? AND purpose = ?
+		RETURNING id_hash, purpose, user_id, app_id, session, expires_at`)
+	if err != nil {
+		return models.PasskeyChallenge{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var (
+		challenge models.PasskeyChallenge
+		expiresAt int64
+	)
+	err = stmt.QueryRowContext(ctx, idHash, purpose).Scan(
+		&challenge.IDHash, &challenge.Purpose, &challenge.UserID, &challenge.AppID, &challenge.Session, &expiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.PasskeyChallenge{}, fmt.Errorf("%s: %w", op, storage.ErrPasskeyChallengeNotFound)
+		}
+
+		return models.PasskeyChallenge{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	challenge.ExpiresAt = time.Unix(expiresAt, 0)
+
+	return challenge, nil
+}
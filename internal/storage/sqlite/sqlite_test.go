@@ -0,0 +1,46 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"sso/internal/storage"
+	"testing"
+)
+
+func newTestStorage(t *testing.T, path string) *Storage {
+	t.Helper()
+
+	s, err := New(path, storage.PoolConfig{MaxOpenConns: 1}, storage.RetryConfig{})
+	if err != nil {
+		t.Fatalf("open storage: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+
+	return s
+}
+
+func TestPing(t *testing.T) {
+	s := newTestStorage(t, filepath.Join(t.TempDir(), "sso.db"))
+
+	if err := s.Ping(context.Background()); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+}
+
+func TestPingBrokenConnection(t *testing.T) {
+	// Opening the database is lazy, so New succeeds and only Ping finds out the file can't be created.
+	s := newTestStorage(t, filepath.Join(t.TempDir(), "missing", "sso.db"))
+
+	if err := s.Ping(context.Background()); err == nil {
+		t.Fatal("ping of a database in a missing directory succeeded")
+	}
+}
+
+func TestPingClosed(t *testing.T) {
+	s := newTestStorage(t, filepath.Join(t.TempDir(), "sso.db"))
+	_ = s.Close()
+
+	if err := s.Ping(context.Background()); err == nil {
+		t.Fatal("ping of a closed database succeeded")
+	}
+}
@@ -6,4 +6,16 @@ var (
 	ErrUserExists   = errors.New("User already exists")
 	ErrUserNotFound = errors.New("User not found")
 	ErrAppNotFound  = errors.New("App not found")
+	ErrAppExists    = errors.New("App already exists")
+
+	ErrRefreshTokenNotFound = errors.New("Refresh token not found")
+	ErrTokenNotFound        = errors.New("Token not found")
+	ErrSessionNotFound      = errors.New("Session not found")
+
+	ErrIdentityExists     = errors.New("Identity already linked")
+	ErrOAuthStateNotFound = errors.New("OAuth state not found")
+
+	ErrPasskeyExists            = errors.New("Passkey already registered")
+	ErrPasskeyNotFound          = errors.New("Passkey not found")
+	ErrPasskeyChallengeNotFound = errors.New("Passkey challenge not found")
 )
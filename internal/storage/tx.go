@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Querier runs queries, it is implemented by both *sql.DB and *sql.Tx,
+// so storage methods work the same inside and outside of a transaction, see Conn.
+type Querier interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// txKey keys the transaction of db in a context, so transactions
+// of different databases don't mix.
+type txKey struct {
+	db *sql.DB
+}
+
+// WithTx runs fn in a transaction on db, which is committed if fn returns nil
+// and rolled back otherwise. The error of fn is returned as is.
+//
+// The ctx passed to fn carries the transaction, queries run on Conn with it
+// are part of the transaction. If ctx already carries a transaction of db,
+// fn joins it and the outermost WithTx commits.
+func WithTx(ctx context.Context, db *sql.DB, fn func(ctx context.Context) error) error {
+	const op = "storage.WithTx"
+
+	if InTx(ctx, db) {
+		return fn(ctx)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := fn(context.WithValue(ctx, txKey{db}, tx)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// InTx reports whether ctx carries a transaction of db, see WithTx.
+func InTx(ctx context.Context, db *sql.DB) bool {
+	_, ok := ctx.Value(txKey{db}).(*sql.Tx)
+
+	return ok
+}
+
+// Conn returns the transaction of db ctx carries, see WithTx, or db itself.
+func Conn(ctx context.Context, db *sql.DB) Querier {
+	if tx, ok := ctx.Value(txKey{db}).(*sql.Tx); ok {
+		return tx
+	}
+
+	return db
+}
@@ -0,0 +1,3 @@
+module github.com/roxxxiey/protos
+
+go 1.22
\ No newline at end of file